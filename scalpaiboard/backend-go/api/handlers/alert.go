@@ -1,6 +1,9 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -42,10 +45,14 @@ func (h *AlertHandler) CreateAlert(c *gin.Context) {
 	}
 
 	var req struct {
-		CoinID           int     `json:"coinId" binding:"required"`
-		ConditionType    string  `json:"conditionType" binding:"required"`
-		ConditionValue   float64 `json:"conditionValue" binding:"required"`
-		NotificationType string  `json:"notificationType"`
+		MarketID         string          `json:"marketId"`
+		CoinID           int             `json:"coinId"`
+		ConditionType    string          `json:"conditionType" binding:"required"`
+		ConditionValue   *float64        `json:"conditionValue"`
+		ConditionParams  json.RawMessage `json:"conditionParams"`
+		PriceSource      string          `json:"priceSource"`
+		NotificationType string          `json:"notificationType"`
+		service.AlertTriggerSettings
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -57,7 +64,12 @@ func (h *AlertHandler) CreateAlert(c *gin.Context) {
 		req.NotificationType = "in_app"
 	}
 
-	alert, err := h.alertService.CreateAlert(userID, req.CoinID, req.ConditionType, req.ConditionValue, req.NotificationType)
+	alert, err := h.alertService.CreateAlert(userID, req.MarketID, req.CoinID, req.ConditionType, req.ConditionValue,
+		req.ConditionParams, req.PriceSource, req.NotificationType, req.AlertTriggerSettings)
+	if errors.Is(err, service.ErrInvalidCondition) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create alert"})
 		return
@@ -76,9 +88,14 @@ func (h *AlertHandler) UpdateAlert(c *gin.Context) {
 	}
 
 	var req struct {
-		IsActive         *bool    `json:"isActive"`
-		ConditionValue   *float64 `json:"conditionValue"`
-		NotificationType *string  `json:"notificationType"`
+		IsActive         *bool           `json:"isActive"`
+		ConditionValue   *float64        `json:"conditionValue"`
+		ConditionParams  json.RawMessage `json:"conditionParams"`
+		PriceSource      *string         `json:"priceSource"`
+		NotificationType *string         `json:"notificationType"`
+		service.AlertTriggerSettings
+		// ExpiresAt is null to remove the expiry.
+		ExpiresAt json.RawMessage `json:"expiresAt"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -86,7 +103,25 @@ func (h *AlertHandler) UpdateAlert(c *gin.Context) {
 		return
 	}
 
-	alert, err := h.alertService.UpdateAlert(userID, alertID, req.IsActive, req.ConditionValue, req.NotificationType)
+	trigger := service.AlertTriggerUpdate{AlertTriggerSettings: req.AlertTriggerSettings}
+	if string(req.ExpiresAt) == "null" {
+		trigger.ClearExpiresAt = true
+	} else if len(req.ExpiresAt) > 0 {
+		if err := json.Unmarshal(req.ExpiresAt, &trigger.ExpiresAt); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expiresAt"})
+			return
+		}
+	}
+
+	alert, err := h.alertService.UpdateAlert(userID, alertID, req.IsActive, req.ConditionValue, req.ConditionParams, req.PriceSource, req.NotificationType, trigger)
+	if errors.Is(err, service.ErrAlertNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert not found"})
+		return
+	}
+	if errors.Is(err, service.ErrInvalidCondition) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update alert"})
 		return
@@ -129,3 +164,111 @@ func (h *AlertHandler) GetAlertHistory(c *gin.Context) {
 
 	c.JSON(http.StatusOK, history)
 }
+
+// LoadAlertMarketData fetches the 24h ticker of a marketId, plus mark and
+// index price and funding rate for perpetuals. It is the market data source
+// of the alert evaluator.
+func LoadAlertMarketData(marketID string) (service.AlertMarketData, error) {
+	exchange, marketType, symbol, ok := parseMarketID(marketID)
+	if !ok {
+		return service.AlertMarketData{}, fmt.Errorf("invalid marketId: %s", marketID)
+	}
+	switch exchange {
+	case "binance":
+		return fetchBinanceAlertMarketData(marketType, symbol)
+	case "bybit":
+		return fetchBybitAlertMarketData(marketType, symbol)
+	default:
+		return service.AlertMarketData{}, fmt.Errorf("unsupported exchange: %s", exchange)
+	}
+}
+
+func fetchBinanceAlertMarketData(marketType, symbol string) (service.AlertMarketData, error) {
+	baseURL := "https://api.binance.com/api/v3"
+	if marketType == "perp" {
+		baseURL = "https://fapi.binance.com/fapi/v1"
+	}
+
+	var ticker struct {
+		LastPrice string `json:"lastPrice"`
+		Volume    string `json:"volume"`
+	}
+	if err := getJSON(baseURL+"/ticker/24hr?symbol="+symbol, &ticker); err != nil {
+		return service.AlertMarketData{}, err
+	}
+	data := service.AlertMarketData{
+		Price:  parseFloatString(ticker.LastPrice),
+		Volume: parseFloatString(ticker.Volume),
+		Perp:   marketType == "perp",
+	}
+	if !data.Perp {
+		return data, nil
+	}
+
+	var premium struct {
+		MarkPrice       string `json:"markPrice"`
+		IndexPrice      string `json:"indexPrice"`
+		LastFundingRate string `json:"lastFundingRate"`
+	}
+	if err := getJSON(baseURL+"/premiumIndex?symbol="+symbol, &premium); err != nil {
+		return service.AlertMarketData{}, err
+	}
+	data.MarkPrice = parseFloatString(premium.MarkPrice)
+	data.IndexPrice = parseFloatString(premium.IndexPrice)
+	data.FundingRate = 100 * parseFloatString(premium.LastFundingRate)
+	return data, nil
+}
+
+func fetchBybitAlertMarketData(marketType, symbol string) (service.AlertMarketData, error) {
+	category := "spot"
+	if marketType == "perp" {
+		category = "linear"
+	}
+
+	var result struct {
+		RetCode int    `json:"retCode"`
+		RetMsg  string `json:"retMsg"`
+		Result  struct {
+			List []struct {
+				LastPrice   string `json:"lastPrice"`
+				Volume24h   string `json:"volume24h"`
+				MarkPrice   string `json:"markPrice"`
+				IndexPrice  string `json:"indexPrice"`
+				FundingRate string `json:"fundingRate"`
+			} `json:"list"`
+		} `json:"result"`
+	}
+	url := fmt.Sprintf("https://api.bybit.com/v5/market/tickers?category=%s&symbol=%s", category, symbol)
+	if err := getJSON(url, &result); err != nil {
+		return service.AlertMarketData{}, err
+	}
+	if result.RetCode != 0 {
+		return service.AlertMarketData{}, fmt.Errorf("bybit tickers: %s", result.RetMsg)
+	}
+	if len(result.Result.List) == 0 {
+		return service.AlertMarketData{}, fmt.Errorf("bybit tickers: unknown symbol %s", symbol)
+	}
+
+	t := result.Result.List[0]
+	return service.AlertMarketData{
+		Price:       parseFloatString(t.LastPrice),
+		Volume:      parseFloatString(t.Volume24h),
+		Perp:        marketType == "perp",
+		MarkPrice:   parseFloatString(t.MarkPrice),
+		IndexPrice:  parseFloatString(t.IndexPrice),
+		FundingRate: 100 * parseFloatString(t.FundingRate),
+	}, nil
+}
+
+// getJSON decodes the JSON body of a successful GET request into dst.
+func getJSON(url string, dst interface{}) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
@@ -1,25 +1,298 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/scalpaiboard/backend/service"
 )
 
-type AnalysisHandler struct{}
+type AnalysisHandler struct {
+	customIndicators *service.CustomIndicatorService
+}
 
-func NewAnalysisHandler() *AnalysisHandler {
-	return &AnalysisHandler{}
+func NewAnalysisHandler(customIndicators *service.CustomIndicatorService) *AnalysisHandler {
+	return &AnalysisHandler{customIndicators: customIndicators}
 }
 
 func (h *AnalysisHandler) GetAnalysis(c *gin.Context) {
 	symbol := c.Param("symbol")
-	interval := c.DefaultQuery("interval", "1h")
-	exchange := c.DefaultQuery("exchange", "binance")
+	q := parseCandleQuery(c)
+	opts, transform, err := parseChartOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	candles, err := q.load(symbol)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch candles"})
+		return
+	}
+
+	// Optionally run the indicators on a transformed chart (chartType=renko,
+	// heikin_ashi, ...), including the provisional bars of the forming candle.
+	lastForming := service.LastBarForming(q.interval, candles, q.asOf())
+	if transform {
+		_, bars, forming, err := buildChart(opts, q.interval, candles)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		candles = service.ChartBarsToCandles(append(bars, forming...))
+		lastForming = len(forming) > 0
+	}
+
+	closes, highs, lows := service.CandleSeries(candles)
+
+	analysis, analysisErr := service.ComputeTechnicalAnalysis(symbol, q.interval, closes, highs, lows, q.limit)
+	if analysisErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": analysisErr.Error()})
+		return
+	}
+	// Pivots come from the last completed bar, the one before a forming bar.
+	if n := len(candles); lastForming && n >= 2 {
+		analysis.SupportResistance.Pivots = service.ClassicPivotLevels(highs[n-2], lows[n-2], closes[n-2])
+	}
+
+	if err := addSupportResistanceLevels(c, q, symbol, candles, &analysis); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Saved custom indicators (custom=name1,name2) need a signed-in user.
+	if names := splitList(c.DefaultQuery("custom", "")); len(names) > 0 {
+		status, err := h.addCustomIndicators(c.GetString("user_id"), names, candles, &analysis)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, analysis)
+}
+
+func (h *AnalysisHandler) addCustomIndicators(userID string, names []string, candles []service.Candle, analysis *service.TechnicalAnalysis) (int, error) {
+	formulas, status, err := loadCustomFormulas(h.customIndicators, userID, names)
+	if err != nil {
+		return status, err
+	}
+
+	analysis.Custom = make(map[string]float64, len(formulas))
+	for name, f := range formulas {
+		v, ok, err := f.Last(candles)
+		if err != nil {
+			return http.StatusBadRequest, fmt.Errorf("%s: %v", name, err)
+		}
+		if ok {
+			analysis.Custom[name] = v
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// addSupportResistanceLevels fills the optional support/resistance extras
+// selected by the levels parameter (comma-separated):
+//
+//	clusters  - swing-cluster levels (swingWidth, default 3; maxLevels, default 8)
+//	fibonacci - retracements/extensions of the dominant swing (fibLookback bars)
+//	pivots    - pivotMethod (classic|woodie|camarilla|fibonacci) pivots from the
+//	            prior completed pivotTimeframe (1d|1w|1M) bar
+func addSupportResistanceLevels(c *gin.Context, q candleQuery, symbol string, candles []service.Candle, analysis *service.TechnicalAnalysis) error {
+	for _, level := range splitList(c.DefaultQuery("levels", "")) {
+		switch level {
+		case "clusters":
+			width, _ := strconv.Atoi(c.DefaultQuery("swingWidth", "3"))
+			if width < 1 || width > 10 {
+				return fmt.Errorf("swingWidth must be between 1 and 10")
+			}
+			maxLevels, _ := strconv.Atoi(c.DefaultQuery("maxLevels", "8"))
+			levels, err := service.SwingClusterLevels(candles, width, 0.5, maxLevels)
+			if err != nil {
+				return err
+			}
+			analysis.SupportResistance.Levels = levels
+
+		case "fibonacci":
+			lookback, _ := strconv.Atoi(c.DefaultQuery("fibLookback", "0"))
+			fib, err := service.AutoFibonacci(candles, lookback)
+			if err != nil {
+				return err
+			}
+			analysis.SupportResistance.Fibonacci = &fib
+
+		case "pivots":
+			method := c.DefaultQuery("pivotMethod", service.PivotClassic)
+			if !service.IsPivotMethod(method) {
+				return fmt.Errorf("unknown pivotMethod: %s", method)
+			}
+			timeframe := c.DefaultQuery("pivotTimeframe", "1d")
+			if timeframe != "1d" && timeframe != "1w" && timeframe != "1M" {
+				return fmt.Errorf("pivotTimeframe must be 1d, 1w or 1M")
+			}
+			bars, err := loadCandles(q.exchange, q.marketType, symbol, timeframe, 3, q.endTimeSec)
+			if err != nil || len(bars) < 2 {
+				return fmt.Errorf("failed to fetch %s candles for pivots", timeframe)
+			}
+			// Pivots use the last completed bar: the one before a forming bar.
+			bar := bars[len(bars)-1]
+			if service.LastBarForming(timeframe, bars, q.asOf()) {
+				bar = bars[len(bars)-2]
+			}
+			pivots, err := service.ComputePivotPoints(method, timeframe, bar)
+			if err != nil {
+				return err
+			}
+			analysis.SupportResistance.HigherTimeframePivots = &pivots
+
+		default:
+			return fmt.Errorf("unknown levels option: %s", level)
+		}
+	}
+	return nil
+}
+
+// GetPatterns returns candlestick pattern hits over the requested candles.
+// Optional filters: patterns (comma-separated names), lookback (only hits in
+// the last N bars) and minStrength.
+func (h *AnalysisHandler) GetPatterns(c *gin.Context) {
+	symbol := c.Param("symbol")
+	q := parseCandleQuery(c)
+
+	var names []string
+	for _, name := range strings.Split(c.DefaultQuery("patterns", ""), ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if name == "" {
+			continue
+		}
+		if !service.IsCandlestickPattern(name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown pattern: " + name})
+			return
+		}
+		names = append(names, name)
+	}
+
+	lookback, _ := strconv.Atoi(c.DefaultQuery("lookback", "0"))
+	minStrength, _ := strconv.ParseFloat(c.DefaultQuery("minStrength", "0"), 64)
+
+	candles, err := q.load(symbol)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch candles"})
+		return
+	}
+
+	fromIndex := 0
+	if lookback > 0 && lookback < len(candles) {
+		fromIndex = len(candles) - lookback
+	}
+
+	hits := service.FilterPatternHits(service.DetectCandlestickPatterns(candles), names, fromIndex)
+	if minStrength > 0 {
+		filtered := hits[:0]
+		for _, hit := range hits {
+			if hit.Strength >= minStrength {
+				filtered = append(filtered, hit)
+			}
+		}
+		hits = filtered
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"symbol":   symbol,
+		"interval": q.interval,
+		"bars":     len(candles),
+		"patterns": hits,
+	})
+}
+
+// GetStructure returns swing points, BOS/CHoCH events and chart patterns.
+// Geometry uses {time, price} anchors so it can be drawn like chart
+// drawings. The swing fractal width is set with width (default 3).
+func (h *AnalysisHandler) GetStructure(c *gin.Context) {
+	symbol := c.Param("symbol")
+	q := parseCandleQuery(c)
 
+	width, err := strconv.Atoi(c.DefaultQuery("width", "3"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid width"})
+		return
+	}
+
+	candles, err := q.load(symbol)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch candles"})
+		return
+	}
+
+	structure, err := service.AnalyzeMarketStructure(candles, width)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"symbol":    symbol,
+		"interval":  q.interval,
+		"structure": structure,
+	})
+}
+
+// GetDivergences returns regular and hidden divergences between price swings
+// and an oscillator (indicator=rsi|macd_hist|obv). pivotWidth sets the swing
+// fractal width and lookback the maximum bars between paired pivots.
+func (h *AnalysisHandler) GetDivergences(c *gin.Context) {
+	symbol := c.Param("symbol")
+	q := parseCandleQuery(c)
+
+	opts := service.DefaultDivergenceOptions()
+	opts.Indicator = c.DefaultQuery("indicator", opts.Indicator)
+	if !service.IsDivergenceIndicator(opts.Indicator) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown indicator: " + opts.Indicator})
+		return
+	}
+	if v, err := strconv.Atoi(c.DefaultQuery("pivotWidth", "")); err == nil {
+		opts.PivotWidth = v
+	}
+	if v, err := strconv.Atoi(c.DefaultQuery("lookback", "")); err == nil {
+		opts.Lookback = v
+	}
+
+	candles, err := q.load(symbol)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch candles"})
+		return
+	}
+
+	divergences, err := service.DetectDivergences(candles, opts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"symbol":      symbol,
+		"interval":    q.interval,
+		"options":     opts,
+		"divergences": divergences,
+	})
+}
+
+// candleQuery holds the candle selection parameters shared by the analysis
+// endpoints.
+type candleQuery struct {
+	exchange   string
+	marketType string
+	interval   string
+	limit      int
+	endTimeSec int64
+}
+
+func parseCandleQuery(c *gin.Context) candleQuery {
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "250"))
 	if limit <= 0 {
 		limit = 250
@@ -30,60 +303,73 @@ func (h *AnalysisHandler) GetAnalysis(c *gin.Context) {
 
 	endTimeSec, _ := strconv.ParseInt(c.DefaultQuery("endTime", "0"), 10, 64)
 
-	var raw []map[string]interface{}
-	var err error
-	switch exchange {
-	case "binance":
-		raw, err = fetchBinanceCandles(symbol, interval, limit, endTimeSec)
-	case "bybit":
-		raw, err = fetchBybitCandles(symbol, interval, limit, endTimeSec)
-	default:
-		raw, err = fetchBinanceCandles(symbol, interval, limit, endTimeSec)
+	return candleQuery{
+		exchange:   c.DefaultQuery("exchange", "binance"),
+		marketType: normalizeMarketType(c.DefaultQuery("marketType", "spot")),
+		interval:   c.DefaultQuery("interval", "1h"),
+		limit:      limit,
+		endTimeSec: endTimeSec,
 	}
+}
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch candles"})
-		return
+// asOf is the time the candles are requested at: endTime for historical
+// requests, otherwise now. Bars still open at that time are forming.
+func (q candleQuery) asOf() time.Time {
+	now := time.Now()
+	if end := time.Unix(q.endTimeSec, 0); q.endTimeSec > 0 && end.Before(now) {
+		return end
 	}
+	return now
+}
 
-	type candle struct {
-		Time   int64
-		Open   float64
-		High   float64
-		Low    float64
-		Close  float64
-		Volume float64
+func (q candleQuery) load(symbol string) ([]service.Candle, error) {
+	return loadCandles(q.exchange, q.marketType, symbol, q.interval, q.limit, q.endTimeSec)
+}
+
+// loadCandles fetches candles for a market and returns them in ascending time
+// order, dropping entries without a timestamp.
+func loadCandles(exchange, marketType, symbol, interval string, limit int, endTimeSec int64) ([]service.Candle, error) {
+	raw, err := fetchCandlesByMarket(exchange, marketType, symbol, interval, limit, endTimeSec)
+	if err != nil {
+		return nil, err
 	}
+	return candlesFromMaps(raw), nil
+}
 
-	candles := make([]candle, 0, len(raw))
+// candlesFromMaps converts fetched kline maps into candles in ascending
+// time order, dropping entries without a timestamp.
+func candlesFromMaps(raw []map[string]interface{}) []service.Candle {
+	candles := make([]service.Candle, 0, len(raw))
 	for _, m := range raw {
-		t, _ := m["time"].(int64)
-		open, _ := m["open"].(float64)
-		high, _ := m["high"].(float64)
-		low, _ := m["low"].(float64)
-		close, _ := m["close"].(float64)
-		vol, _ := m["volume"].(float64)
-		candles = append(candles, candle{Time: t, Open: open, High: high, Low: low, Close: close, Volume: vol})
+		if m == nil {
+			continue
+		}
+		t := anyToInt64(m["time"])
+		if t == 0 {
+			continue
+		}
+		candles = append(candles, service.Candle{
+			Time:   t,
+			Open:   anyToFloat64(m["open"]),
+			High:   anyToFloat64(m["high"]),
+			Low:    anyToFloat64(m["low"]),
+			Close:  anyToFloat64(m["close"]),
+			Volume: anyToFloat64(m["volume"]),
+		})
 	}
 
 	// Ensure ascending time order (some exchanges return newest-first)
 	sort.Slice(candles, func(i, j int) bool { return candles[i].Time < candles[j].Time })
+	return candles
+}
 
-	closes := make([]float64, 0, len(candles))
-	highs := make([]float64, 0, len(candles))
-	lows := make([]float64, 0, len(candles))
-
-	for _, cd := range candles {
-		closes = append(closes, cd.Close)
-		highs = append(highs, cd.High)
-		lows = append(lows, cd.Low)
-	}
-
-	analysis, analysisErr := service.ComputeTechnicalAnalysis(symbol, interval, closes, highs, lows, limit)
-	if analysisErr != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": analysisErr.Error()})
-		return
+// normalizeMarketType maps the marketType query values accepted by the API
+// onto the "spot"/"perp" values used by the exchange fetchers.
+func normalizeMarketType(marketType string) string {
+	switch strings.ToLower(strings.TrimSpace(marketType)) {
+	case "perp", "perpetual", "futures":
+		return "perp"
+	default:
+		return "spot"
 	}
-
-	c.JSON(http.StatusOK, analysis)
 }
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/scalpaiboard/backend/service"
+)
+
+// backtestPageSize is the number of klines requested per exchange call when
+// paging back through history.
+const backtestPageSize = 500
+
+type BacktestHandler struct {
+	backtests *service.BacktestService
+}
+
+func NewBacktestHandler(backtests *service.BacktestService) *BacktestHandler {
+	return &BacktestHandler{backtests: backtests}
+}
+
+// SubmitBacktest queues a backtest and returns the job; poll GetBacktest for
+// progress and the result.
+func (h *BacktestHandler) SubmitBacktest(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req service.BacktestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	for i, id := range req.Markets {
+		id = strings.ToUpper(strings.TrimSpace(id))
+		if _, _, _, ok := parseMarketID(id); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid marketId: " + id})
+			return
+		}
+		req.Markets[i] = id
+	}
+
+	job, err := h.backtests.Submit(userID, req)
+	if errors.Is(err, service.ErrInvalidStrategy) || errors.Is(err, service.ErrInvalidFormula) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start backtest"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// ListBacktests returns the user's recent backtest jobs without results
+func (h *BacktestHandler) ListBacktests(c *gin.Context) {
+	c.JSON(http.StatusOK, h.backtests.List(c.GetString("user_id")))
+}
+
+// GetBacktest returns a job, including its result once completed
+func (h *BacktestHandler) GetBacktest(c *gin.Context) {
+	job, err := h.backtests.Get(c.GetString("user_id"), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backtest not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// CancelBacktest stops a queued or running job
+func (h *BacktestHandler) CancelBacktest(c *gin.Context) {
+	if err := h.backtests.Cancel(c.GetString("user_id"), c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backtest not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "cancelling"})
+}
+
+// LoadCandleHistory pages back through a market's klines until bars candles
+// are collected or the exchange runs out of history. It is the exchange
+// CandleLoader for backtests.
+func LoadCandleHistory(ctx context.Context, marketID, interval string, bars int) ([]service.Candle, error) {
+	exchange, marketType, symbol, ok := parseMarketID(marketID)
+	if !ok {
+		return nil, fmt.Errorf("invalid marketId: %s", marketID)
+	}
+
+	var candles []service.Candle
+	var endTime int64
+	for len(candles) < bars {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		limit := bars - len(candles)
+		if limit > backtestPageSize {
+			limit = backtestPageSize
+		}
+		page, err := loadCandles(exchange, marketType, symbol, interval, limit, endTime)
+		if err != nil {
+			if len(candles) > 0 {
+				break
+			}
+			return nil, err
+		}
+		// Drop anything overlapping what we already have.
+		if len(candles) > 0 {
+			cut := len(page)
+			for cut > 0 && page[cut-1].Time >= candles[0].Time {
+				cut--
+			}
+			page = page[:cut]
+		}
+		if len(page) == 0 {
+			break
+		}
+
+		candles = append(page, candles...)
+		endTime = page[0].Time - 1
+	}
+
+	if len(candles) == 0 {
+		return nil, errors.New("no candles")
+	}
+	return candles, nil
+}
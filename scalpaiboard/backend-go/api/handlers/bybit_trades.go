@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/scalpaiboard/backend/service"
+)
+
+const (
+	bybitPingInterval = 20 * time.Second
+	// bybitReadTimeout drops a connection that stopped answering pings.
+	bybitReadTimeout = 60 * time.Second
+	bybitRetryDelay  = 3 * time.Second
+	// bybitTopicsPerRequest is the most topics Bybit spot accepts in one
+	// subscribe request.
+	bybitTopicsPerRequest = 10
+)
+
+var bybitDialer = websocket.Dialer{HandshakeTimeout: 10 * time.Second}
+
+// BybitTradeStream streams Bybit trades from the public WebSocket
+// publicTrade topics, so footprints see every trade instead of the last
+// page of REST polls. It is the footprint recorder's TradeStream for BY:
+// markets. Trades go to push; after a disconnect gap is called for every
+// streamed market, as trades may have been missed until the reconnect.
+type BybitTradeStream struct {
+	push func(marketID string, trades []service.AggTrade)
+	gap  func(marketID string)
+
+	mu    sync.Mutex
+	conns map[string]*bybitTradeConn
+}
+
+// bybitTradeConn is the connection of one category (spot or linear) and
+// the symbols it is subscribed to.
+type bybitTradeConn struct {
+	category string
+	prefix   string
+
+	mu      sync.Mutex
+	symbols map[string]bool
+	ws      *websocket.Conn
+	running bool
+
+	writeMu sync.Mutex
+}
+
+func NewBybitTradeStream(push func(marketID string, trades []service.AggTrade), gap func(marketID string)) *BybitTradeStream {
+	return &BybitTradeStream{
+		push:  push,
+		gap:   gap,
+		conns: make(map[string]*bybitTradeConn),
+	}
+}
+
+// Streams reports whether marketID is a Bybit market.
+func (s *BybitTradeStream) Streams(marketID string) bool {
+	exchange, _, _, ok := parseMarketID(marketID)
+	return ok && exchange == "bybit"
+}
+
+// Watch subscribes to a market's trades, connecting if needed.
+func (s *BybitTradeStream) Watch(marketID string) {
+	conn, symbol, ok := s.conn(marketID)
+	if !ok {
+		return
+	}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.symbols[symbol] {
+		return
+	}
+	conn.symbols[symbol] = true
+	if !conn.running {
+		conn.running = true
+		go s.run(conn)
+	} else if conn.ws != nil {
+		go conn.request("subscribe", []string{symbol})
+	}
+}
+
+// Unwatch unsubscribes from a market's trades; the connection closes with
+// its last market.
+func (s *BybitTradeStream) Unwatch(marketID string) {
+	conn, symbol, ok := s.conn(marketID)
+	if !ok {
+		return
+	}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if !conn.symbols[symbol] {
+		return
+	}
+	delete(conn.symbols, symbol)
+	if conn.ws == nil {
+		return
+	}
+	if len(conn.symbols) == 0 {
+		conn.ws.Close()
+		return
+	}
+	go conn.request("unsubscribe", []string{symbol})
+}
+
+func (s *BybitTradeStream) conn(marketID string) (*bybitTradeConn, string, bool) {
+	exchange, marketType, symbol, ok := parseMarketID(marketID)
+	if !ok || exchange != "bybit" {
+		return nil, "", false
+	}
+	category, prefix := "spot", "BY:SPOT:"
+	if marketType == "perp" {
+		category, prefix = "linear", "BY:PERP:"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conn, ok := s.conns[category]
+	if !ok {
+		conn = &bybitTradeConn{category: category, prefix: prefix, symbols: make(map[string]bool)}
+		s.conns[category] = conn
+	}
+	return conn, symbol, true
+}
+
+// run keeps the connection of a category open while it has symbols,
+// reconnecting after failures.
+func (s *BybitTradeStream) run(conn *bybitTradeConn) {
+	for {
+		conn.mu.Lock()
+		if len(conn.symbols) == 0 {
+			conn.running = false
+			conn.mu.Unlock()
+			return
+		}
+		conn.mu.Unlock()
+
+		err := s.serve(conn)
+		conn.mu.Lock()
+		conn.ws = nil
+		symbols := make([]string, 0, len(conn.symbols))
+		for symbol := range conn.symbols {
+			symbols = append(symbols, symbol)
+		}
+		conn.mu.Unlock()
+
+		if len(symbols) == 0 {
+			continue
+		}
+		log.Printf("⚠️ Bybit %s trade stream disconnected: %v", conn.category, err)
+		for _, symbol := range symbols {
+			s.gap(conn.prefix + symbol)
+		}
+		time.Sleep(bybitRetryDelay)
+	}
+}
+
+// serve connects, subscribes to the current symbols and forwards trades
+// until the connection fails or is closed.
+func (s *BybitTradeStream) serve(conn *bybitTradeConn) error {
+	ws, _, err := bybitDialer.Dial("wss://stream.bybit.com/v5/public/"+conn.category, nil)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+
+	conn.mu.Lock()
+	conn.ws = ws
+	symbols := make([]string, 0, len(conn.symbols))
+	for symbol := range conn.symbols {
+		symbols = append(symbols, symbol)
+	}
+	conn.mu.Unlock()
+	if len(symbols) == 0 {
+		return nil
+	}
+	if err := conn.request("subscribe", symbols); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(bybitPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if conn.write(map[string]string{"op": "ping"}) != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		ws.SetReadDeadline(time.Now().Add(bybitReadTimeout))
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			return err
+		}
+		s.handle(conn, message)
+	}
+}
+
+func (s *BybitTradeStream) handle(conn *bybitTradeConn, message []byte) {
+	var msg struct {
+		Topic string `json:"topic"`
+		Data  []struct {
+			ID     string `json:"i"`
+			Time   int64  `json:"T"`
+			Symbol string `json:"s"`
+			Side   string `json:"S"`
+			Size   string `json:"v"`
+			Price  string `json:"p"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(message, &msg); err != nil || msg.Topic == "" || len(msg.Data) == 0 {
+		return
+	}
+
+	bySymbol := make(map[string][]service.AggTrade)
+	for _, t := range msg.Data {
+		bySymbol[t.Symbol] = append(bySymbol[t.Symbol], service.AggTrade{
+			ID:    t.ID,
+			Time:  t.Time,
+			Price: parseFloatString(t.Price),
+			Qty:   parseFloatString(t.Size),
+			// Side is the taker side; a taker sell hits the bid.
+			BuyerMaker: t.Side == "Sell",
+		})
+	}
+	for symbol, trades := range bySymbol {
+		sort.SliceStable(trades, func(i, j int) bool { return trades[i].Time < trades[j].Time })
+		s.push(conn.prefix+symbol, trades)
+	}
+}
+
+// request subscribes to or unsubscribes from the trade topics of symbols.
+func (c *bybitTradeConn) request(op string, symbols []string) error {
+	for start := 0; start < len(symbols); start += bybitTopicsPerRequest {
+		end := start + bybitTopicsPerRequest
+		if end > len(symbols) {
+			end = len(symbols)
+		}
+		topics := make([]string, 0, end-start)
+		for _, symbol := range symbols[start:end] {
+			topics = append(topics, "publicTrade."+symbol)
+		}
+		req := map[string]interface{}{"op": op, "args": topics, "req_id": op + strconv.FormatInt(time.Now().UnixNano(), 36)}
+		if err := c.write(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *bybitTradeConn) write(v interface{}) error {
+	c.mu.Lock()
+	ws := c.ws
+	c.mu.Unlock()
+	if ws == nil {
+		return websocket.ErrCloseSent
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return ws.WriteJSON(v)
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/scalpaiboard/backend/service"
+)
+
+// parseChartOptions reads chartType, boxSize, atrPeriod and reversal. ok is
+// false when the plain candle chart was requested; err is set for malformed
+// numbers.
+func parseChartOptions(c *gin.Context) (service.ChartOptions, bool, error) {
+	opts := service.ChartOptions{Type: c.DefaultQuery("chartType", service.ChartCandles)}
+	if opts.Type == service.ChartCandles {
+		return opts, false, nil
+	}
+	var err error
+	if v, set := c.GetQuery("boxSize"); set {
+		if opts.BoxSize, err = strconv.ParseFloat(v, 64); err != nil {
+			return opts, true, errors.New("invalid boxSize")
+		}
+	}
+	if v, set := c.GetQuery("atrPeriod"); set {
+		if opts.ATRPeriod, err = strconv.Atoi(v); err != nil {
+			return opts, true, errors.New("invalid atrPeriod")
+		}
+	}
+	if v, set := c.GetQuery("reversal"); set {
+		if opts.Reversal, err = strconv.Atoi(v); err != nil {
+			return opts, true, errors.New("invalid reversal")
+		}
+	}
+	return opts, true, nil
+}
+
+// buildChart transforms ascending candles into chart bars. The last candle
+// is treated as forming unless its interval has already ended.
+func buildChart(opts service.ChartOptions, interval string, candles []service.Candle) (service.ChartOptions, []service.ChartBar, []service.ChartBar, error) {
+	closed := candles
+	var forming *service.Candle
+	if n := len(candles); service.LastBarForming(interval, candles, time.Now()) {
+		closed, forming = candles[:n-1], &candles[n-1]
+	}
+	return service.BuildChart(opts, closed, forming)
+}
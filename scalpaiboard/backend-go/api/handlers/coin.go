@@ -85,7 +85,9 @@ func (h *CoinHandler) GetCoin(c *gin.Context) {
 	})
 }
 
-// GetCandles returns OHLCV candlestick data from exchange
+// GetCandles returns OHLCV candlestick data from exchange. With chartType
+// set it returns Heikin-Ashi, Renko, range, point-and-figure or Kagi bars
+// built from those candles instead.
 func (h *CoinHandler) GetCandles(c *gin.Context) {
 	symbol := c.Param("symbol")
 	interval := c.DefaultQuery("interval", "1h")
@@ -99,8 +101,13 @@ func (h *CoinHandler) GetCandles(c *gin.Context) {
 		limit = 500
 	}
 
+	chartOpts, transform, err := parseChartOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	var candles []map[string]interface{}
-	var err error
 
 	switch exchange {
 	case "binance":
@@ -155,6 +162,24 @@ func (h *CoinHandler) GetCandles(c *gin.Context) {
 		return ti < tj
 	})
 
+	// Alternative chart types: committed bars plus the provisional bars of
+	// the forming candle.
+	if transform {
+		opts, bars, forming, err := buildChart(chartOpts, interval, candlesFromMaps(normalized))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"symbol":   symbol,
+			"interval": interval,
+			"options":  opts,
+			"candles":  bars,
+			"forming":  forming,
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"symbol":   symbol,
 		"interval": interval,
@@ -447,6 +472,8 @@ func convertToBybitInterval(interval string) string {
 	switch interval {
 	case "1m":
 		return "1"
+	case "3m":
+		return "3"
 	case "5m":
 		return "5"
 	case "15m":
@@ -455,12 +482,20 @@ func convertToBybitInterval(interval string) string {
 		return "30"
 	case "1h":
 		return "60"
+	case "2h":
+		return "120"
 	case "4h":
 		return "240"
+	case "6h":
+		return "360"
+	case "12h":
+		return "720"
 	case "1d":
 		return "D"
 	case "1w":
 		return "W"
+	case "1M":
+		return "M"
 	default:
 		return "60"
 	}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/scalpaiboard/backend/service"
+)
+
+type CustomIndicatorHandler struct {
+	customIndicators *service.CustomIndicatorService
+}
+
+func NewCustomIndicatorHandler(customIndicators *service.CustomIndicatorService) *CustomIndicatorHandler {
+	return &CustomIndicatorHandler{customIndicators: customIndicators}
+}
+
+// ListFunctions returns the series and functions formulas can use.
+func (h *CustomIndicatorHandler) ListFunctions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"series":    []string{"open", "high", "low", "close", "volume", "hl2", "hlc3", "ohlc4"},
+		"functions": service.FormulaFunctions(),
+		"operators": []string{"+", "-", "*", "/", "%", "<", "<=", ">", ">=", "==", "!=", "and", "or", "not"},
+		"maxBars":   service.FormulaMaxBars,
+		"maxLength": service.FormulaMaxLength,
+	})
+}
+
+// ListIndicators returns the current user's saved indicators
+func (h *CustomIndicatorHandler) ListIndicators(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	items, err := h.customIndicators.List(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch indicators"})
+		return
+	}
+
+	c.JSON(http.StatusOK, items)
+}
+
+// CreateIndicator saves a new formula indicator
+func (h *CustomIndicatorHandler) CreateIndicator(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		Name        string `json:"name" binding:"required"`
+		Formula     string `json:"formula" binding:"required"`
+		Description string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	ind, err := h.customIndicators.Create(userID, req.Name, req.Formula, req.Description)
+	if errors.Is(err, service.ErrInvalidFormula) || errors.Is(err, service.ErrInvalidCustomIndicator) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrCustomIndicatorExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create indicator"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, ind)
+}
+
+// UpdateIndicator changes the formula or description of an indicator
+func (h *CustomIndicatorHandler) UpdateIndicator(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid indicator ID"})
+		return
+	}
+
+	var req struct {
+		Formula     *string `json:"formula"`
+		Description *string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	ind, err := h.customIndicators.Update(userID, id, req.Formula, req.Description)
+	if errors.Is(err, service.ErrInvalidFormula) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrCustomIndicatorNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Indicator not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update indicator"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ind)
+}
+
+// DeleteIndicator deletes an indicator
+func (h *CustomIndicatorHandler) DeleteIndicator(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid indicator ID"})
+		return
+	}
+
+	err = h.customIndicators.Delete(userID, id)
+	if errors.Is(err, service.ErrCustomIndicatorNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Indicator not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete indicator"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// EvaluateFormula runs a formula over a symbol's candles and returns the
+// full series, for previewing an indicator before saving it. Accepts the
+// same candle parameters as GetAnalysis.
+func (h *CustomIndicatorHandler) EvaluateFormula(c *gin.Context) {
+	var req struct {
+		Formula string `json:"formula" binding:"required"`
+		Symbol  string `json:"symbol" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	f, err := service.ParseFormula(req.Formula)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	q := parseCandleQuery(c)
+	candles, err := q.load(req.Symbol)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch candles"})
+		return
+	}
+
+	series, err := f.Evaluate(candles)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// NaN is not valid JSON; undefined bars are returned as null.
+	points := make([]gin.H, 0, len(series))
+	for i, v := range series {
+		var value interface{}
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			value = v
+		}
+		points = append(points, gin.H{"time": candles[i].Time, "value": value})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"formula":  f.String(),
+		"interval": q.interval,
+		"data":     points,
+	})
+}
+
+// loadCustomFormulas parses the named saved indicators of a user, mapping
+// failures to an HTTP status.
+func loadCustomFormulas(customIndicators *service.CustomIndicatorService, userID string, names []string) (map[string]*service.Formula, int, error) {
+	if userID == "" {
+		return nil, http.StatusUnauthorized, errors.New("sign in to use custom indicators")
+	}
+	formulas, err := customIndicators.Formulas(userID, names)
+	switch {
+	case errors.Is(err, service.ErrCustomIndicatorNotFound):
+		return nil, http.StatusNotFound, err
+	case errors.Is(err, service.ErrInvalidFormula):
+		return nil, http.StatusBadRequest, err
+	case err != nil:
+		return nil, http.StatusInternalServerError, errors.New("failed to load custom indicators")
+	}
+	return formulas, http.StatusOK, nil
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/scalpaiboard/backend/models"
+	"github.com/scalpaiboard/backend/service"
+)
+
+type DrawingHandler struct {
+	drawings *service.DrawingService
+}
+
+func NewDrawingHandler(drawings *service.DrawingService) *DrawingHandler {
+	return &DrawingHandler{drawings: drawings}
+}
+
+// ListDrawings returns the current user's chart drawings, of one market when
+// ?marketId is set
+func (h *DrawingHandler) ListDrawings(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	items, err := h.drawings.List(userID, c.Query("marketId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch drawings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, items)
+}
+
+// CreateDrawing saves a new drawing on a market's chart
+func (h *DrawingHandler) CreateDrawing(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		MarketID string                `json:"marketId" binding:"required"`
+		Type     string                `json:"type" binding:"required"`
+		Points   []models.DrawingPoint `json:"points" binding:"required"`
+		Style    json.RawMessage       `json:"style"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	d, err := h.drawings.Create(userID, req.MarketID, req.Type, req.Points, req.Style)
+	if errors.Is(err, service.ErrInvalidDrawing) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create drawing"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, d)
+}
+
+// UpdateDrawing moves a drawing or changes its style
+func (h *DrawingHandler) UpdateDrawing(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid drawing ID"})
+		return
+	}
+
+	var req struct {
+		Points []models.DrawingPoint `json:"points"`
+		Style  json.RawMessage       `json:"style"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	d, err := h.drawings.Update(userID, id, req.Points, req.Style)
+	if errors.Is(err, service.ErrInvalidDrawing) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrDrawingNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Drawing not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update drawing"})
+		return
+	}
+
+	c.JSON(http.StatusOK, d)
+}
+
+// DeleteDrawing deletes a drawing and the alerts attached to it
+func (h *DrawingHandler) DeleteDrawing(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid drawing ID"})
+		return
+	}
+
+	err = h.drawings.Delete(userID, id)
+	if errors.Is(err, service.ErrDrawingNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Drawing not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete drawing"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/scalpaiboard/backend/service"
+)
+
+// footprintMaxBars caps the number of bars returned per request.
+const footprintMaxBars = 500
+
+type FootprintHandler struct {
+	footprints *service.FootprintService
+}
+
+func NewFootprintHandler(footprints *service.FootprintService) *FootprintHandler {
+	return &FootprintHandler{footprints: footprints}
+}
+
+// GetFootprint returns footprint bars (bid/ask volume per price bucket,
+// delta, POC and diagonal imbalances) for a recorded market. Query: interval
+// (1m..4h), from/to in Unix seconds (default: the last 60 bars), bucket
+// (price bucket, rounded to a multiple of the recorded one) and
+// imbalanceRatio (default 3).
+func (h *FootprintHandler) GetFootprint(c *gin.Context) {
+	marketID := strings.ToUpper(c.Param("marketId"))
+	if _, _, _, ok := parseMarketID(marketID); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid marketId"})
+		return
+	}
+
+	interval := c.DefaultQuery("interval", "1m")
+	intervalSec, ok := service.IntervalSeconds(interval)
+	if !ok || intervalSec < service.FootprintBaseSeconds || intervalSec > 4*3600 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported interval"})
+		return
+	}
+
+	to, _ := strconv.ParseInt(c.DefaultQuery("to", "0"), 10, 64)
+	if to <= 0 {
+		to = time.Now().Unix() + 1
+	}
+	from, _ := strconv.ParseInt(c.DefaultQuery("from", "0"), 10, 64)
+	if from <= 0 {
+		from = to - 60*intervalSec
+	}
+	from -= from % intervalSec
+	if from >= to || (to-from)/intervalSec > footprintMaxBars {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Range must cover between 1 and %d bars", footprintMaxBars)})
+		return
+	}
+
+	bucket, _ := strconv.ParseFloat(c.DefaultQuery("bucket", "0"), 64)
+	ratio, _ := strconv.ParseFloat(c.DefaultQuery("imbalanceRatio", "3"), 64)
+	if bucket < 0 || ratio < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bucket must not be negative and imbalanceRatio must be at least 1"})
+		return
+	}
+
+	bars, err := h.footprints.Bars(marketID, intervalSec, from, to, bucket, ratio)
+	if errors.Is(err, service.ErrFootprintNotTracked) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Footprint is not recorded for this market; subscribe to it over WebSocket or add it to FOOTPRINT_MARKETS"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch footprint"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"marketId": marketID,
+		"interval": interval,
+		"from":     from,
+		"to":       to,
+		"bars":     bars,
+	})
+}
+
+// tradeClient fetches trades for the footprint recorder, which polls every
+// market once a second and must not stall on one hung request.
+var tradeClient = &http.Client{Timeout: 5 * time.Second}
+
+// LoadAggTrades fetches a Binance market's aggregated trades in ascending
+// order, continuing from lastID. It is the TradeFetcher of the footprint
+// recorder; Bybit trades are streamed by BybitTradeStream instead.
+func LoadAggTrades(marketID, lastID string) ([]service.AggTrade, error) {
+	exchange, marketType, symbol, ok := parseMarketID(marketID)
+	if !ok {
+		return nil, fmt.Errorf("invalid marketId: %s", marketID)
+	}
+	if exchange != "binance" {
+		return nil, fmt.Errorf("unsupported exchange: %s", exchange)
+	}
+	url := "https://api.binance.com/api/v3/aggTrades"
+	if marketType == "perp" {
+		url = "https://fapi.binance.com/fapi/v1/aggTrades"
+	}
+	return fetchBinanceAggTrades(url, symbol, lastID)
+}
+
+func fetchBinanceAggTrades(baseURL, symbol, lastID string) ([]service.AggTrade, error) {
+	url := fmt.Sprintf("%s?symbol=%s&limit=1000", baseURL, symbol)
+	if id, err := strconv.ParseInt(lastID, 10, 64); err == nil {
+		url += fmt.Sprintf("&fromId=%d", id+1)
+	}
+
+	resp, err := tradeClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("binance aggTrades: status %d", resp.StatusCode)
+	}
+
+	var raw []struct {
+		ID         int64  `json:"a"`
+		Price      string `json:"p"`
+		Qty        string `json:"q"`
+		Time       int64  `json:"T"`
+		BuyerMaker bool   `json:"m"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	trades := make([]service.AggTrade, 0, len(raw))
+	for _, t := range raw {
+		trades = append(trades, service.AggTrade{
+			ID:         strconv.FormatInt(t.ID, 10),
+			Time:       t.Time,
+			Price:      parseFloatString(t.Price),
+			Qty:        parseFloatString(t.Qty),
+			BuyerMaker: t.BuyerMaker,
+		})
+	}
+	return trades, nil
+}
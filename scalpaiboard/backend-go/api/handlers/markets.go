@@ -2,18 +2,18 @@ package handlers
 
 import (
 	"encoding/json"
-	"math"
 	"net/http"
-	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/scalpaiboard/backend/models"
 	"github.com/scalpaiboard/backend/service"
 )
 
 type MarketHandler struct {
 	coinService *service.CoinService
+	instruments *service.InstrumentService
 }
 
 type MarketItem struct {
@@ -41,10 +41,17 @@ type MarketMetrics struct {
 	ChangeToday float64 `json:"changeTodayPct"`
 	Volume24h   float64 `json:"volume24h"`
 	Natr5m14    float64 `json:"natr5m14"`
+
+	// Annualised close-to-close volatility of the last 20 closed 5m bars
+	// and its percentile rank over the fetched history.
+	Hv5m20           float64 `json:"hv5m20"`
+	Hv5m20Percentile float64 `json:"hv5m20Percentile"`
+
+	Regime5m *service.MarketRegime `json:"regime5m,omitempty"`
 }
 
-func NewMarketHandler(coinService *service.CoinService) *MarketHandler {
-	return &MarketHandler{coinService: coinService}
+func NewMarketHandler(coinService *service.CoinService, instruments *service.InstrumentService) *MarketHandler {
+	return &MarketHandler{coinService: coinService, instruments: instruments}
 }
 
 func (h *MarketHandler) ListMarkets(c *gin.Context) {
@@ -58,6 +65,133 @@ func (h *MarketHandler) ListMarkets(c *gin.Context) {
 		return
 	}
 
+	out := buildMarketUniverse(coins, query, exchange, marketType)
+	h.applyInstruments(out)
+
+	c.JSON(http.StatusOK, gin.H{"data": out})
+}
+
+// metricsNatrCandles is the number of 5m candles the NATR of GetMetrics is
+// computed from.
+const metricsNatrCandles = 80
+
+func (h *MarketHandler) GetMetrics(c *gin.Context) {
+	marketID := c.Param("marketId")
+	exchange, marketType, symbol, ok := parseMarketID(marketID)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid marketId"})
+		return
+	}
+
+	price, change, vol, err := fetchTicker(exchange, marketType, symbol)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to fetch ticker"})
+		return
+	}
+
+	// The regime and volatility percentiles need a longer history; NATR
+	// keeps its input of the last 80 candles, as its Wilder smoothing
+	// depends on the series length.
+	candles, err := loadCandles(exchange, marketType, symbol, "5m", 200, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to fetch candles"})
+		return
+	}
+
+	natrCandles := candles
+	if len(natrCandles) > metricsNatrCandles {
+		natrCandles = natrCandles[len(natrCandles)-metricsNatrCandles:]
+	}
+	closes, highs, lows := service.CandleSeries(natrCandles)
+	natr := computeNatr14(highs, lows, closes)
+
+	metrics := MarketMetrics{
+		MarketID:    marketID,
+		Price:       price,
+		ChangeToday: change,
+		Volume24h:   vol,
+		Natr5m14:    natr,
+	}
+	// The regime and volatility use the closed bars, before the forming one.
+	if len(candles) > 1 {
+		closed := candles[:len(candles)-1]
+		if regime, err := service.ClassifyRegime(closed); err == nil {
+			metrics.Regime5m = &regime
+		}
+		if report, err := service.AnalyzeVolatility(closed, 300, []int{20}, service.VolCloseToClose, 1); err == nil {
+			metrics.Hv5m20 = report.Cone[0].Current
+			metrics.Hv5m20Percentile = report.Cone[0].Percentile
+		}
+	}
+
+	c.JSON(http.StatusOK, metrics)
+}
+
+// GetRegime classifies the trend and volatility regime of a market on one
+// interval, using closed bars only, and lists the regime changes that
+// happened on the last closed bar.
+func (h *MarketHandler) GetRegime(c *gin.Context) {
+	marketID := c.Param("marketId")
+	exchange, marketType, symbol, ok := parseMarketID(marketID)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid marketId"})
+		return
+	}
+
+	interval := c.DefaultQuery("interval", "5m")
+	if _, ok := service.IntervalSeconds(interval); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported interval"})
+		return
+	}
+
+	candles, err := loadCandles(exchange, marketType, symbol, interval, 200, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to fetch candles"})
+		return
+	}
+	if len(candles) < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not enough candles"})
+		return
+	}
+
+	regime, events, err := service.RegimeChanges(candles[:len(candles)-1])
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"marketId": marketID,
+		"interval": interval,
+		"regime":   regime,
+		"events":   events,
+	})
+}
+
+// applyInstruments replaces the default tick and lot sizes of buildMarketItem
+// with cached exchange metadata where it is available; it never waits for
+// an exchange.
+func (h *MarketHandler) applyInstruments(items []MarketItem) {
+	for i := range items {
+		m := &items[i]
+		exchange, marketType, symbol, ok := parseMarketID(m.MarketID)
+		if !ok {
+			continue
+		}
+		inst, ok := h.instruments.Cached(exchange, marketType, symbol)
+		if !ok || inst.TickSize <= 0 || inst.LotSize <= 0 {
+			continue
+		}
+		m.TickSize = strconv.FormatFloat(inst.TickSize, 'f', -1, 64)
+		m.LotSize = strconv.FormatFloat(inst.LotSize, 'f', -1, 64)
+		m.PricePrecision = inst.PricePrecision
+		m.QtyPrecision = inst.QtyPrecision
+	}
+}
+
+// buildMarketUniverse expands coins into their exchange/market-type variants,
+// filtered by an uppercase symbol query, exchange name or tag, and market type.
+func buildMarketUniverse(coins []models.Coin, query, exchange, marketType string) []MarketItem {
 	out := make([]MarketItem, 0, len(coins)*4)
 
 	for _, coin := range coins {
@@ -109,54 +243,11 @@ func (h *MarketHandler) ListMarkets(c *gin.Context) {
 		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": out})
-}
-
-func (h *MarketHandler) GetMetrics(c *gin.Context) {
-	marketID := c.Param("marketId")
-	exchange, marketType, symbol, ok := parseMarketID(marketID)
-	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid marketId"})
-		return
-	}
-
-	price, change, vol, err := fetchTicker(exchange, marketType, symbol)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to fetch ticker"})
-		return
-	}
-
-	candles, err := fetchCandlesByMarket(exchange, marketType, symbol, "5m", 80, 0)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to fetch candles"})
-		return
-	}
-
-	// candles are map[string]interface{}; ensure ascending.
-	sort.Slice(candles, func(i, j int) bool {
-		return anyToInt64(candles[i]["time"]) < anyToInt64(candles[j]["time"])
-	})
-
-	highs := make([]float64, 0, len(candles))
-	lows := make([]float64, 0, len(candles))
-	closes := make([]float64, 0, len(candles))
-	for _, cd := range candles {
-		highs = append(highs, anyToFloat64(cd["high"]))
-		lows = append(lows, anyToFloat64(cd["low"]))
-		closes = append(closes, anyToFloat64(cd["close"]))
-	}
-
-	natr := computeNatr14(highs, lows, closes)
-
-	c.JSON(http.StatusOK, MarketMetrics{
-		MarketID:    marketID,
-		Price:       price,
-		ChangeToday: change,
-		Volume24h:   vol,
-		Natr5m14:    natr,
-	})
+	return out
 }
 
+// buildMarketItem fills in default precisions; ListMarkets overrides them
+// with exchange metadata via applyInstruments.
 func buildMarketItem(exchangeTag, exchange, typeTag, marketType, contractTag string, coinID int, symbol, base, quote string, fundingIntervalSec *int) MarketItem {
 	ws := strings.ToLower(exchange) + "." + strings.ToLower(marketType) + ".ticker." + strings.ToLower(symbol)
 	marketID := exchangeTag + ":" + typeTag + ":" + symbol
@@ -359,41 +450,11 @@ func fetchBybitLinearTicker(symbol string) (float64, float64, float64, error) {
 }
 
 func computeNatr14(highs, lows, closes []float64) float64 {
-	if len(highs) < 15 || len(lows) < 15 || len(closes) < 15 {
-		return 0
-	}
-
-	period := 14
-	trs := make([]float64, 0, len(closes)-1)
-	for i := 1; i < len(closes); i++ {
-		hl := highs[i] - lows[i]
-		hc := math.Abs(highs[i] - closes[i-1])
-		lc := math.Abs(lows[i] - closes[i-1])
-		tr := math.Max(hl, math.Max(hc, lc))
-		trs = append(trs, tr)
-	}
-
-	if len(trs) < period {
-		return 0
-	}
-
-	// Wilder ATR
-	atr := 0.0
-	for i := 0; i < period; i++ {
-		atr += trs[i]
-	}
-	atr /= float64(period)
-
-	for i := period; i < len(trs); i++ {
-		atr = (atr*float64(period-1) + trs[i]) / float64(period)
-	}
-
-	lastClose := closes[len(closes)-1]
-	if lastClose == 0 {
+	natr, err := service.NATR(highs, lows, closes, 14)
+	if err != nil {
 		return 0
 	}
-
-	return 100 * atr / lastClose
+	return natr[len(natr)-1]
 }
 
 func anyToFloat64(v interface{}) float64 {
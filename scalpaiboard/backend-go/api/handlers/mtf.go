@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/scalpaiboard/backend/service"
+)
+
+const mtfMaxIntervals = 8
+
+// GetMultiTimeframe computes the selected indicators on several intervals of
+// one market in a single call. Each interval's candles are fetched once, in
+// parallel, and shared by every indicator on that interval.
+//
+// Query: intervals (default 4h,1h,15m,5m), indicators (default all), bars
+// (default 250).
+func (h *AnalysisHandler) GetMultiTimeframe(c *gin.Context) {
+	marketID := c.Param("marketId")
+	exchange, marketType, symbol, ok := parseMarketID(marketID)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid marketId"})
+		return
+	}
+
+	intervals := splitList(c.DefaultQuery("intervals", "4h,1h,15m,5m"))
+	if len(intervals) == 0 || len(intervals) > mtfMaxIntervals {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Between 1 and 8 intervals required"})
+		return
+	}
+	for _, interval := range intervals {
+		if _, ok := service.IntervalSeconds(interval); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported interval: " + interval})
+			return
+		}
+	}
+
+	indicators := service.MTFIndicators
+	if raw := c.Query("indicators"); raw != "" {
+		indicators = splitList(raw)
+		for _, ind := range indicators {
+			if !service.IsMTFIndicator(ind) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown indicator: " + ind})
+				return
+			}
+		}
+	}
+
+	bars, _ := strconv.Atoi(c.DefaultQuery("bars", "250"))
+	if bars < 50 {
+		bars = 50
+	}
+	if bars > 500 {
+		bars = 500
+	}
+
+	// Highest timeframe first.
+	sort.SliceStable(intervals, func(i, j int) bool {
+		si, _ := service.IntervalSeconds(intervals[i])
+		sj, _ := service.IntervalSeconds(intervals[j])
+		return si > sj
+	})
+
+	rows := make([]service.TimeframeIndicators, len(intervals))
+	wg := sync.WaitGroup{}
+	for i, interval := range intervals {
+		i, interval := i, interval
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			candles, err := loadCandles(exchange, marketType, symbol, interval, bars, 0)
+			if err != nil {
+				rows[i] = service.TimeframeIndicators{Interval: interval, Error: "Failed to fetch candles"}
+				return
+			}
+			row, err := service.ComputeTimeframeIndicators(interval, candles, indicators)
+			if err != nil {
+				rows[i] = service.TimeframeIndicators{Interval: interval, Error: err.Error()}
+				return
+			}
+			rows[i] = row
+		}()
+	}
+	wg.Wait()
+
+	c.JSON(http.StatusOK, gin.H{
+		"marketId": marketID,
+		"analysis": service.BuildMTFAnalysis(indicators, rows),
+	})
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/scalpaiboard/backend/service"
+)
+
+type NotificationHandler struct {
+	inbox *service.InboxService
+}
+
+func NewNotificationHandler(inbox *service.InboxService) *NotificationHandler {
+	return &NotificationHandler{inbox: inbox}
+}
+
+// ListNotifications returns a page of the current user's inbox, newest
+// first. ?before=<id> pages back from the nextBefore of the previous page;
+// ?unread=true returns unread notifications only.
+func (h *NotificationHandler) ListNotifications(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	before, _ := strconv.Atoi(c.DefaultQuery("before", "0"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	unreadOnly := c.DefaultQuery("unread", "false") == "true"
+
+	page, err := h.inbox.List(userID, before, limit, unreadOnly)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// GetUnreadCount returns the number of unread notifications
+func (h *NotificationHandler) GetUnreadCount(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	unread, err := h.inbox.UnreadCount(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch unread count"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"unread": unread})
+}
+
+// MarkRead marks the notifications in ids as read, or all of them when
+// all is set
+func (h *NotificationHandler) MarkRead(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		IDs []int `json:"ids"`
+		All bool  `json:"all"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if len(req.IDs) == 0 && !req.All {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids or all required"})
+		return
+	}
+	if req.All {
+		req.IDs = nil
+	}
+
+	unread, err := h.inbox.MarkRead(userID, req.IDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notifications read"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"unread": unread})
+}
+
+// DeleteNotification removes one notification
+func (h *NotificationHandler) DeleteNotification(c *gin.Context) {
+	userID := c.GetString("user_id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+		return
+	}
+
+	unread, err := h.inbox.Delete(userID, id)
+	if errors.Is(err, service.ErrNotificationNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete notification"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "deleted", "unread": unread})
+}
+
+// ClearNotifications removes all notifications, only the read ones with
+// ?read=true
+func (h *NotificationHandler) ClearNotifications(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	unread, err := h.inbox.Clear(userID, c.DefaultQuery("read", "false") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "cleared", "unread": unread})
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/scalpaiboard/backend/service"
+)
+
+type PaperTradingHandler struct {
+	paper *service.PaperTradingService
+}
+
+func NewPaperTradingHandler(paper *service.PaperTradingService) *PaperTradingHandler {
+	return &PaperTradingHandler{paper: paper}
+}
+
+// ListAccounts returns the user's paper accounts
+func (h *PaperTradingHandler) ListAccounts(c *gin.Context) {
+	items, err := h.paper.ListAccounts(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch accounts"})
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// CreateAccount opens a paper account
+func (h *PaperTradingHandler) CreateAccount(c *gin.Context) {
+	var req service.PaperAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	acct, err := h.paper.CreateAccount(c.GetString("user_id"), req)
+	if err != nil {
+		respondPaperError(c, err, "Failed to create account")
+		return
+	}
+	c.JSON(http.StatusCreated, acct)
+}
+
+// GetAccount returns an account with balances, margin and positions
+func (h *PaperTradingHandler) GetAccount(c *gin.Context) {
+	accountID, ok := paramID(c, "id", "account")
+	if !ok {
+		return
+	}
+	summary, err := h.paper.GetAccount(c.GetString("user_id"), accountID)
+	if err != nil {
+		respondPaperError(c, err, "Failed to fetch account")
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
+
+// ResetAccount restores the starting balance and clears all activity
+func (h *PaperTradingHandler) ResetAccount(c *gin.Context) {
+	accountID, ok := paramID(c, "id", "account")
+	if !ok {
+		return
+	}
+	acct, err := h.paper.ResetAccount(c.GetString("user_id"), accountID)
+	if err != nil {
+		respondPaperError(c, err, "Failed to reset account")
+		return
+	}
+	c.JSON(http.StatusOK, acct)
+}
+
+// DeleteAccount deletes a paper account
+func (h *PaperTradingHandler) DeleteAccount(c *gin.Context) {
+	accountID, ok := paramID(c, "id", "account")
+	if !ok {
+		return
+	}
+	if err := h.paper.DeleteAccount(c.GetString("user_id"), accountID); err != nil {
+		respondPaperError(c, err, "Failed to delete account")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// ListOrders returns an account's orders, optionally filtered by status
+func (h *PaperTradingHandler) ListOrders(c *gin.Context) {
+	accountID, ok := paramID(c, "id", "account")
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	items, err := h.paper.ListOrders(c.GetString("user_id"), accountID, c.Query("status"), limit)
+	if err != nil {
+		respondPaperError(c, err, "Failed to fetch orders")
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// PlaceOrder places an order on a paper account. The response is the order
+// after matching against the current price (filled, open or rejected).
+func (h *PaperTradingHandler) PlaceOrder(c *gin.Context) {
+	accountID, ok := paramID(c, "id", "account")
+	if !ok {
+		return
+	}
+	var req service.PaperOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if _, _, _, ok := parseMarketID(req.MarketID); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid marketId"})
+		return
+	}
+
+	order, err := h.paper.PlaceOrder(c.GetString("user_id"), accountID, req)
+	if err != nil {
+		respondPaperError(c, err, "Failed to place order")
+		return
+	}
+	c.JSON(http.StatusCreated, order)
+}
+
+// CancelOrder cancels an open order
+func (h *PaperTradingHandler) CancelOrder(c *gin.Context) {
+	accountID, ok := paramID(c, "id", "account")
+	if !ok {
+		return
+	}
+	orderID, ok := paramID(c, "orderId", "order")
+	if !ok {
+		return
+	}
+	order, err := h.paper.CancelOrder(c.GetString("user_id"), accountID, orderID)
+	if err != nil {
+		respondPaperError(c, err, "Failed to cancel order")
+		return
+	}
+	c.JSON(http.StatusOK, order)
+}
+
+// ListFills returns an account's executions
+func (h *PaperTradingHandler) ListFills(c *gin.Context) {
+	accountID, ok := paramID(c, "id", "account")
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	items, err := h.paper.ListFills(c.GetString("user_id"), accountID, limit)
+	if err != nil {
+		respondPaperError(c, err, "Failed to fetch fills")
+		return
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// LastPrice returns the last traded price of a marketId. It is the price
+// source for the paper trading engine.
+func LastPrice(marketID string) (float64, error) {
+	exchange, marketType, symbol, ok := parseMarketID(marketID)
+	if !ok {
+		return 0, fmt.Errorf("invalid marketId: %s", marketID)
+	}
+	price, _, _, err := fetchTicker(exchange, marketType, symbol)
+	return price, err
+}
+
+func respondPaperError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, service.ErrPaperAccountNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+	case errors.Is(err, service.ErrPaperOrderNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Open order not found"})
+	case errors.Is(err, service.ErrInvalidPaperOrder), errors.Is(err, service.ErrInsufficientMargin):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrPriceUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+func paramID(c *gin.Context, name, label string) (int, bool) {
+	id, err := strconv.Atoi(c.Param(name))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + label + " ID"})
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/scalpaiboard/backend/service"
+)
+
+// GetInstrument returns the exchange trading rules (tick size, lot size,
+// minimums) of a market.
+func (h *MarketHandler) GetInstrument(c *gin.Context) {
+	inst, ok := h.instrument(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, inst)
+}
+
+// GetPositionSize sizes a trade by risk for a market: quantity rounded to
+// the lot size, notional, margin at the given leverage, estimated isolated
+// liquidation price for perps, fees and R-multiples per target.
+func (h *MarketHandler) GetPositionSize(c *gin.Context) {
+	var req service.PositionSizeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	inst, ok := h.instrument(c)
+	if !ok {
+		return
+	}
+
+	size, err := service.ComputePositionSize(inst, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"marketId": c.Param("marketId"),
+		"data":     size,
+	})
+}
+
+func (h *MarketHandler) instrument(c *gin.Context) (service.Instrument, bool) {
+	exchange, marketType, symbol, ok := parseMarketID(c.Param("marketId"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid marketId"})
+		return service.Instrument{}, false
+	}
+
+	inst, err := h.instruments.Get(exchange, marketType, symbol)
+	if errors.Is(err, service.ErrInstrumentNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Market not listed on exchange"})
+		return service.Instrument{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch instrument metadata"})
+		return service.Instrument{}, false
+	}
+	return inst, true
+}
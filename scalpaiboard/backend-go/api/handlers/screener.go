@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/scalpaiboard/backend/service"
+)
+
+const (
+	screenerMaxMarkets = 200
+	screenerWorkers    = 10
+
+	// customColumnPrefix selects a saved custom indicator as a column,
+	// e.g. columns=close,custom:rsi_ema.
+	customColumnPrefix = service.ScreenerCustomColumnPrefix
+)
+
+type ScreenerHandler struct {
+	coinService      *service.CoinService
+	customIndicators *service.CustomIndicatorService
+}
+
+// ScreenerRow is one market in a screener result.
+type ScreenerRow struct {
+	MarketID   string             `json:"marketId"`
+	Symbol     string             `json:"symbol"`
+	Exchange   string             `json:"exchange"`
+	MarketType string             `json:"marketType"`
+	Values     map[string]float64 `json:"values"`
+}
+
+func NewScreenerHandler(coinService *service.CoinService, customIndicators *service.CustomIndicatorService) *ScreenerHandler {
+	return &ScreenerHandler{coinService: coinService, customIndicators: customIndicators}
+}
+
+// ListColumns returns the columns the screener can compute.
+func (h *ScreenerHandler) ListColumns(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": service.ScreenerColumns()})
+}
+
+// RunScreener computes the requested columns for a set of markets.
+//
+// Markets come from the markets parameter (comma-separated marketIds) or,
+// when absent, from the same query/exchange/type filters as ListMarkets
+// (defaulting to Binance perpetuals). Rows can be filtered with min[column]
+// and max[column] and sorted with sortBy/sortOrder, and saved indicators can
+// be added as custom:<name> columns. At most screenerMaxMarkets rows are
+// returned.
+func (h *ScreenerHandler) RunScreener(c *gin.Context) {
+	interval := c.DefaultQuery("interval", "5m")
+
+	bars, _ := strconv.Atoi(c.DefaultQuery("bars", "150"))
+	if bars < 20 {
+		bars = 20
+	}
+	if bars > 500 {
+		bars = 500
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 {
+		limit = 50
+	}
+	if limit > screenerMaxMarkets {
+		limit = screenerMaxMarkets
+	}
+
+	columns := splitList(c.DefaultQuery("columns", "close,volume,rsi14"))
+	for _, col := range columns {
+		if !isScreenerColumn(col) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown column: " + col})
+			return
+		}
+	}
+
+	minFilters, err := parseColumnBounds(c.QueryMap("min"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	maxFilters, err := parseColumnBounds(c.QueryMap("max"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Filter and sort columns are computed even if not requested for display.
+	needed := append([]string{}, columns...)
+	for key := range minFilters {
+		needed = appendUnique(needed, key)
+	}
+	for key := range maxFilters {
+		needed = appendUnique(needed, key)
+	}
+	sortBy := c.DefaultQuery("sortBy", "")
+	if sortBy != "" {
+		if !isScreenerColumn(sortBy) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown sort column: " + sortBy})
+			return
+		}
+		needed = appendUnique(needed, sortBy)
+	}
+	sortDesc := strings.ToLower(c.DefaultQuery("sortOrder", "desc")) != "asc"
+
+	var formulas map[string]*service.Formula
+	customNames := make([]string, 0)
+	for _, col := range needed {
+		if strings.HasPrefix(col, customColumnPrefix) {
+			customNames = append(customNames, strings.TrimPrefix(col, customColumnPrefix))
+		}
+	}
+	if len(customNames) > 0 {
+		var status int
+		formulas, status, err = loadCustomFormulas(h.customIndicators, c.GetString("user_id"), customNames)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	marketIDs, err := h.resolveMarkets(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch markets"})
+		return
+	}
+	if len(marketIDs) > screenerMaxMarkets {
+		marketIDs = marketIDs[:screenerMaxMarkets]
+	}
+
+	rows := scanMarkets(marketIDs, interval, bars, needed, formulas)
+
+	filtered := make([]ScreenerRow, 0, len(rows))
+	for _, row := range rows {
+		if !withinBounds(row.Values, minFilters, maxFilters) {
+			continue
+		}
+		filtered = append(filtered, row)
+	}
+
+	if sortBy != "" {
+		sort.SliceStable(filtered, func(i, j int) bool {
+			vi, okI := filtered[i].Values[sortBy]
+			vj, okJ := filtered[j].Values[sortBy]
+			if okI != okJ {
+				return okI
+			}
+			if sortDesc {
+				return vi > vj
+			}
+			return vi < vj
+		})
+	}
+
+	if len(filtered) > limit {
+		filtered = filtered[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"interval": interval,
+		"columns":  columns,
+		"scanned":  len(marketIDs),
+		"data":     filtered,
+	})
+}
+
+func (h *ScreenerHandler) resolveMarkets(c *gin.Context) ([]string, error) {
+	return h.marketUniverse(splitList(c.Query("markets")), c.DefaultQuery("query", ""),
+		c.DefaultQuery("exchange", "binance"), c.DefaultQuery("type", "perp"))
+}
+
+// marketUniverse returns the valid marketIds of markets or, when empty, the
+// markets matching the query/exchange/type filters.
+func (h *ScreenerHandler) marketUniverse(markets []string, query, exchange, marketType string) ([]string, error) {
+	if len(markets) > 0 {
+		ids := make([]string, 0)
+		for _, id := range markets {
+			if _, _, _, ok := parseMarketID(strings.ToUpper(id)); ok {
+				ids = append(ids, strings.ToUpper(id))
+			}
+		}
+		return ids, nil
+	}
+
+	query = strings.ToUpper(strings.TrimSpace(query))
+	exchange = strings.ToLower(strings.TrimSpace(exchange))
+	marketType = strings.ToLower(strings.TrimSpace(marketType))
+
+	coins, _, err := h.coinService.GetCoins(2000, 0, "symbol", "asc")
+	if err != nil {
+		return nil, err
+	}
+
+	items := buildMarketUniverse(coins, query, exchange, marketType)
+	ids := make([]string, 0, len(items))
+	for _, m := range items {
+		ids = append(ids, m.MarketID)
+	}
+	return ids, nil
+}
+
+// Scan computes columns for the markets of a screener alert's query. It is
+// the ScreenerScanFunc of the screener alerts.
+func (h *ScreenerHandler) Scan(userID string, q service.ScreenerQuery, columns []string, budget *service.ScreenerBudget) (map[string]map[string]float64, error) {
+	var formulas map[string]*service.Formula
+	names := make([]string, 0)
+	for _, col := range columns {
+		if strings.HasPrefix(col, customColumnPrefix) {
+			names = append(names, strings.TrimPrefix(col, customColumnPrefix))
+		}
+	}
+	if len(names) > 0 {
+		var err error
+		if formulas, err = h.customIndicators.Formulas(userID, names); err != nil {
+			return nil, err
+		}
+	}
+
+	marketIDs, err := h.marketUniverse(q.Markets, q.Query, q.Exchange, q.Type)
+	if err != nil {
+		return nil, err
+	}
+	if len(marketIDs) > screenerMaxMarkets {
+		marketIDs = marketIDs[:screenerMaxMarkets]
+	}
+	if !budget.Take(len(marketIDs)) {
+		return nil, service.ErrScreenerBudgetExhausted
+	}
+
+	values := make(map[string]map[string]float64, len(marketIDs))
+	for _, row := range scanMarkets(marketIDs, q.Interval, q.Bars, columns, formulas) {
+		values[row.MarketID] = row.Values
+	}
+	return values, nil
+}
+
+// scanMarkets fetches candles for each market in parallel and computes the
+// requested columns plus any custom formula columns. Markets whose candles
+// cannot be fetched are skipped.
+func scanMarkets(marketIDs []string, interval string, bars int, columns []string, formulas map[string]*service.Formula) []ScreenerRow {
+	sem := make(chan struct{}, screenerWorkers)
+	mu := sync.Mutex{}
+	rows := make([]ScreenerRow, 0, len(marketIDs))
+
+	wg := sync.WaitGroup{}
+	for _, marketID := range marketIDs {
+		marketID := marketID
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			exchange, marketType, symbol, ok := parseMarketID(marketID)
+			if !ok {
+				return
+			}
+
+			candles, err := loadCandles(exchange, marketType, symbol, interval, bars, 0)
+			if err != nil || len(candles) == 0 {
+				return
+			}
+
+			row := ScreenerRow{
+				MarketID:   marketID,
+				Symbol:     symbol,
+				Exchange:   exchange,
+				MarketType: marketType,
+				Values:     service.ComputeScreenerValues(candles, columns),
+			}
+			for name, f := range formulas {
+				if v, ok, err := f.Last(candles); err == nil && ok {
+					row.Values[customColumnPrefix+name] = v
+				}
+			}
+
+			mu.Lock()
+			rows = append(rows, row)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// Keep output deterministic regardless of completion order.
+	sort.Slice(rows, func(i, j int) bool { return rows[i].MarketID < rows[j].MarketID })
+	return rows
+}
+
+func parseColumnBounds(raw map[string]string) (map[string]float64, error) {
+	bounds := make(map[string]float64, len(raw))
+	for key, value := range raw {
+		if !isScreenerColumn(key) {
+			return nil, fmt.Errorf("Unknown column: %s", key)
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid bound for column: %s", key)
+		}
+		bounds[key] = v
+	}
+	return bounds, nil
+}
+
+// isScreenerColumn accepts built-in columns and custom:<name> columns; the
+// latter are resolved against the user's saved indicators later.
+func isScreenerColumn(key string) bool {
+	if name := strings.TrimPrefix(key, customColumnPrefix); name != key {
+		return name != ""
+	}
+	return service.IsScreenerColumn(key)
+}
+
+func withinBounds(values map[string]float64, minFilters, maxFilters map[string]float64) bool {
+	for key, min := range minFilters {
+		v, ok := values[key]
+		if !ok || v < min {
+			return false
+		}
+	}
+	for key, max := range maxFilters {
+		v, ok := values[key]
+		if !ok || v > max {
+			return false
+		}
+	}
+	return true
+}
+
+func splitList(raw string) []string {
+	out := make([]string, 0)
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func appendUnique(list []string, item string) []string {
+	for _, v := range list {
+		if v == item {
+			return list
+		}
+	}
+	return append(list, item)
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/scalpaiboard/backend/service"
+)
+
+type ScreenerAlertHandler struct {
+	screenerAlerts *service.ScreenerAlertService
+}
+
+func NewScreenerAlertHandler(screenerAlerts *service.ScreenerAlertService) *ScreenerAlertHandler {
+	return &ScreenerAlertHandler{screenerAlerts: screenerAlerts}
+}
+
+// ListScreenerAlerts returns the current user's screener alerts
+func (h *ScreenerAlertHandler) ListScreenerAlerts(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	items, err := h.screenerAlerts.List(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch screener alerts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, items)
+}
+
+// CreateScreenerAlert saves a screener query that notifies when markets
+// enter or leave its result set
+func (h *ScreenerAlertHandler) CreateScreenerAlert(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req struct {
+		Name             string                `json:"name" binding:"required"`
+		Query            service.ScreenerQuery `json:"query"`
+		NotifyOn         string                `json:"notifyOn"`
+		DedupSeconds     *int                  `json:"dedupSeconds"`
+		NotificationType string                `json:"notificationType"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.NotificationType == "" {
+		req.NotificationType = "in_app"
+	}
+
+	a, err := h.screenerAlerts.Create(userID, req.Name, req.Query, req.NotifyOn, req.DedupSeconds, req.NotificationType)
+	if errors.Is(err, service.ErrInvalidScreenerAlert) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create screener alert"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, a)
+}
+
+// UpdateScreenerAlert changes a screener alert's query or settings
+func (h *ScreenerAlertHandler) UpdateScreenerAlert(c *gin.Context) {
+	userID := c.GetString("user_id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid screener alert ID"})
+		return
+	}
+
+	var req struct {
+		Name             *string                `json:"name"`
+		Query            *service.ScreenerQuery `json:"query"`
+		NotifyOn         *string                `json:"notifyOn"`
+		DedupSeconds     *int                   `json:"dedupSeconds"`
+		NotificationType *string                `json:"notificationType"`
+		IsActive         *bool                  `json:"isActive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	a, err := h.screenerAlerts.Update(userID, id, req.Name, req.Query, req.NotifyOn, req.DedupSeconds,
+		req.NotificationType, req.IsActive)
+	if errors.Is(err, service.ErrInvalidScreenerAlert) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrScreenerAlertNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Screener alert not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update screener alert"})
+		return
+	}
+
+	c.JSON(http.StatusOK, a)
+}
+
+// DeleteScreenerAlert deletes a screener alert
+func (h *ScreenerAlertHandler) DeleteScreenerAlert(c *gin.Context) {
+	userID := c.GetString("user_id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid screener alert ID"})
+		return
+	}
+
+	if err := h.screenerAlerts.Delete(userID, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete screener alert"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// GetScreenerMatches returns the markets currently in a screener alert's
+// result set with their values
+func (h *ScreenerAlertHandler) GetScreenerMatches(c *gin.Context) {
+	userID := c.GetString("user_id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid screener alert ID"})
+		return
+	}
+
+	items, err := h.screenerAlerts.Matches(userID, id)
+	if errors.Is(err, service.ErrScreenerAlertNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Screener alert not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch matches"})
+		return
+	}
+
+	c.JSON(http.StatusOK, items)
+}
+
+// GetScreenerEvents returns a screener alert's recent entries and exits
+func (h *ScreenerAlertHandler) GetScreenerEvents(c *gin.Context) {
+	userID := c.GetString("user_id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid screener alert ID"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+
+	items, err := h.screenerAlerts.Events(userID, id, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch screener alert events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, items)
+}
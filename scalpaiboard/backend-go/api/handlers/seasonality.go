@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/scalpaiboard/backend/service"
+)
+
+// GetSeasonality returns return, volatility, volume and hit-rate statistics
+// by hour of day, weekday and day of month in the requested timezone, plus
+// Asia/London/New York session high/low timing. Candles come from the
+// exchange (paged) or, with source=stored, from the candle store.
+func (h *MarketHandler) GetSeasonality(c *gin.Context) {
+	marketID := strings.ToUpper(c.Param("marketId"))
+	if _, _, _, ok := parseMarketID(marketID); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid marketId"})
+		return
+	}
+
+	interval := c.DefaultQuery("interval", "1h")
+	barSeconds, ok := service.IntervalSeconds(interval)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported interval"})
+		return
+	}
+
+	bars, _ := strconv.Atoi(c.DefaultQuery("bars", strconv.Itoa(service.BacktestMaxBars)))
+	if bars < 24 {
+		bars = 24
+	}
+	if bars > service.BacktestMaxBars {
+		bars = service.BacktestMaxBars
+	}
+
+	loc, err := time.LoadLocation(c.DefaultQuery("tz", "UTC"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone"})
+		return
+	}
+
+	var candles []service.Candle
+	switch source := c.DefaultQuery("source", service.CandleSourceExchange); source {
+	case service.CandleSourceExchange:
+		candles, err = LoadCandleHistory(c.Request.Context(), marketID, interval, bars+1)
+	case service.CandleSourceStored:
+		if !service.IsStoredCandleMarket(marketID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Stored candles are only kept for spot markets"})
+			return
+		}
+		candles, err = h.coinService.GetStoredCandles(marketID, interval, bars+1)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown source: " + source})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to fetch candles"})
+		return
+	}
+	if len(candles) < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not enough candles"})
+		return
+	}
+
+	// The newest bar may still be forming.
+	closed := candles[:len(candles)-1]
+	report, err := service.AnalyzeSeasonality(closed, barSeconds, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"marketId": marketID,
+		"interval": interval,
+		"bars":     len(closed),
+		"data":     report,
+	})
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/scalpaiboard/backend/service"
+)
+
+const (
+	volatilityMaxWindows = 8
+	// volatilityMaxBars bounds the history a public request can page
+	// through; the backtester's limit is much higher.
+	volatilityMaxBars = 2000
+	// volatilityCacheSize bounds the reports kept until their next bar closes.
+	volatilityCacheSize = 500
+)
+
+type volatilityEntry struct {
+	bars    int
+	report  service.VolatilityReport
+	expires time.Time
+}
+
+// volatilityCache holds reports by request until the interval's next bar
+// closes, since only closed bars go into them.
+var volatilityCache = struct {
+	sync.Mutex
+	entries map[string]volatilityEntry
+}{entries: make(map[string]volatilityEntry)}
+
+func cachedVolatility(key string, now time.Time) (volatilityEntry, bool) {
+	volatilityCache.Lock()
+	defer volatilityCache.Unlock()
+	e, ok := volatilityCache.entries[key]
+	if !ok || !now.Before(e.expires) {
+		return volatilityEntry{}, false
+	}
+	return e, true
+}
+
+func storeVolatility(key string, e volatilityEntry, now time.Time) {
+	volatilityCache.Lock()
+	defer volatilityCache.Unlock()
+	if len(volatilityCache.entries) >= volatilityCacheSize {
+		for k, old := range volatilityCache.entries {
+			if !now.Before(old.expires) {
+				delete(volatilityCache.entries, k)
+			}
+		}
+		if len(volatilityCache.entries) >= volatilityCacheSize {
+			return
+		}
+	}
+	volatilityCache.entries[key] = e
+}
+
+// GetVolatility returns realized volatility for a market: close-to-close,
+// Parkinson, Garman-Klass and Yang-Zhang estimates for several windows, a
+// volatility cone with the current percentile rank, and the expected move
+// over the next horizon bars. Only closed bars are used; history beyond one
+// exchange page is fetched backwards, up to volatilityMaxBars. Reports are
+// cached until the next bar closes.
+func (h *MarketHandler) GetVolatility(c *gin.Context) {
+	marketID := strings.ToUpper(c.Param("marketId"))
+	if _, _, _, ok := parseMarketID(marketID); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid marketId"})
+		return
+	}
+
+	interval := c.DefaultQuery("interval", "1h")
+	barSeconds, ok := service.IntervalSeconds(interval)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported interval"})
+		return
+	}
+
+	bars, _ := strconv.Atoi(c.DefaultQuery("bars", "1000"))
+	if bars < 100 {
+		bars = 100
+	}
+	if bars > volatilityMaxBars {
+		bars = volatilityMaxBars
+	}
+
+	windows := make([]int, 0)
+	for _, raw := range splitList(c.DefaultQuery("windows", "10,20,30,60,90")) {
+		w, err := strconv.Atoi(raw)
+		if err != nil || w < 2 || w > bars/2 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid window: " + raw})
+			return
+		}
+		windows = append(windows, w)
+	}
+	if len(windows) == 0 || len(windows) > volatilityMaxWindows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Between 1 and 8 windows required"})
+		return
+	}
+
+	estimator := c.DefaultQuery("estimator", service.VolYangZhang)
+	if !service.IsVolEstimator(estimator) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown estimator: " + estimator})
+		return
+	}
+	horizon, _ := strconv.Atoi(c.DefaultQuery("horizon", "24"))
+
+	now := time.Now()
+	key := fmt.Sprintf("%s|%s|%d|%v|%s|%d", marketID, interval, bars, windows, estimator, horizon)
+	entry, ok := cachedVolatility(key, now)
+	if !ok {
+		var err error
+		entry, err = loadVolatility(c.Request.Context(), marketID, interval, barSeconds, bars, windows, estimator, horizon)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		entry.expires = time.Unix((now.Unix()/barSeconds+1)*barSeconds, 0)
+		storeVolatility(key, entry, now)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"marketId": marketID,
+		"interval": interval,
+		"bars":     entry.bars,
+		"data":     entry.report,
+	})
+}
+
+// loadVolatility fetches the closed bars of a market and analyzes them.
+func loadVolatility(ctx context.Context, marketID, interval string, barSeconds int64, bars int, windows []int, estimator string, horizon int) (volatilityEntry, error) {
+	candles, err := LoadCandleHistory(ctx, marketID, interval, bars+1)
+	if err != nil {
+		return volatilityEntry{}, errors.New("Failed to fetch candles")
+	}
+	if len(candles) < 2 {
+		return volatilityEntry{}, errors.New("Not enough candles")
+	}
+
+	report, err := service.AnalyzeVolatility(candles[:len(candles)-1], barSeconds, windows, estimator, horizon)
+	if err != nil {
+		return volatilityEntry{}, err
+	}
+	return volatilityEntry{bars: len(candles) - 1, report: report}, nil
+}
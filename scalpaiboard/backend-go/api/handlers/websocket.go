@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"strings"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/scalpaiboard/backend/api/middleware"
 	"github.com/scalpaiboard/backend/service"
 )
 
@@ -23,7 +25,12 @@ var upgrader = websocket.Upgrader{
 
 type WebSocketHandler struct {
 	exchangeService *service.ExchangeService
+	footprints      *service.FootprintService
 	hub             *Hub
+
+	// inbox, when set, provides the unread notification count sent to
+	// signed-in connections when they open.
+	inbox *service.InboxService
 }
 
 // Hub manages all active WebSocket connections
@@ -33,6 +40,10 @@ type Hub struct {
 	register   chan *Client
 	unregister chan *Client
 	mu         sync.RWMutex
+
+	// onPrice receives every ticker price fetched by the market data
+	// stream (e.g. the real-time alert engine and paper trading).
+	onPrice []func(marketID string, price float64)
 }
 
 // Client represents a single WebSocket connection
@@ -41,11 +52,20 @@ type Client struct {
 	conn *websocket.Conn
 	send chan []byte
 
+	// userID is set when the connection was opened with a valid ?token=,
+	// and receives that user's private events (e.g. paper trading).
+	userID string
+
 	subMu         sync.RWMutex
 	subscriptions map[string]bool
+
+	// footprints are the markets whose live footprint bars the client
+	// receives; each holds a recording reference on footprint.
+	footprint  *service.FootprintService
+	footprints map[string]bool
 }
 
-func NewWebSocketHandler(exchangeService *service.ExchangeService) *WebSocketHandler {
+func NewWebSocketHandler(exchangeService *service.ExchangeService, footprints *service.FootprintService) *WebSocketHandler {
 	hub := &Hub{
 		clients:    make(map[*Client]bool),
 		broadcast:  make(chan []byte, 256),
@@ -58,6 +78,7 @@ func NewWebSocketHandler(exchangeService *service.ExchangeService) *WebSocketHan
 
 	return &WebSocketHandler{
 		exchangeService: exchangeService,
+		footprints:      footprints,
 		hub:             hub,
 	}
 }
@@ -127,18 +148,127 @@ func (h *WebSocketHandler) HandleConnection(c *gin.Context) {
 		conn:          conn,
 		send:          make(chan []byte, 256),
 		subscriptions: make(map[string]bool),
+		footprint:     h.footprints,
+		footprints:    make(map[string]bool),
+	}
+	if token := c.Query("token"); token != "" {
+		client.userID, _ = middleware.UserIDFromToken(token)
 	}
 
 	h.hub.register <- client
+	if client.userID != "" && h.inbox != nil {
+		if unread, err := h.inbox.UnreadCount(client.userID); err == nil {
+			if payload, err := json.Marshal(inboxMessage(service.InboxEvent{Type: service.InboxEventUnread, Unread: unread})); err == nil {
+				client.send <- payload
+			}
+		}
+	}
 
 	go client.writePump()
 	go client.readPump()
 }
 
+// SendToUser delivers a message to every connection of a signed-in user.
+// Slow clients drop the message rather than block the caller.
+func (h *WebSocketHandler) SendToUser(userID string, msg interface{}) {
+	if userID == "" {
+		return
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+
+	h.hub.mu.RLock()
+	defer h.hub.mu.RUnlock()
+	for client := range h.hub.clients {
+		if client.userID != userID {
+			continue
+		}
+		select {
+		case client.send <- payload:
+		default:
+		}
+	}
+}
+
+// PublishPaperEvent pushes a paper trading event to its account owner.
+func (h *WebSocketHandler) PublishPaperEvent(userID string, event service.PaperEvent) {
+	h.SendToUser(userID, map[string]interface{}{
+		"type":      "paper",
+		"event":     event.Type,
+		"accountId": event.AccountID,
+		"data":      event.Data,
+	})
+}
+
+// UseInbox pushes new notifications and unread counts to the user's
+// sockets, and sends the unread count to signed-in sockets as they open.
+func (h *WebSocketHandler) UseInbox(inbox *service.InboxService) {
+	h.inbox = inbox
+	inbox.OnEvent(h.PublishInboxEvent)
+}
+
+// PublishInboxEvent pushes a new notification or unread count to the
+// user's sockets.
+func (h *WebSocketHandler) PublishInboxEvent(userID string, event service.InboxEvent) {
+	h.SendToUser(userID, inboxMessage(event))
+}
+
+func inboxMessage(event service.InboxEvent) map[string]interface{} {
+	msg := map[string]interface{}{
+		"type":   "notification",
+		"event":  event.Type,
+		"unread": event.Unread,
+	}
+	if event.Notification != nil {
+		msg["data"] = event.Notification
+	}
+	return msg
+}
+
+// OnPrice adds a receiver for the prices fetched by the market data
+// stream.
+func (h *WebSocketHandler) OnPrice(fn func(marketID string, price float64)) {
+	h.hub.mu.Lock()
+	h.hub.onPrice = append(h.hub.onPrice, fn)
+	h.hub.mu.Unlock()
+}
+
+// PublishFootprint pushes a live footprint bar to the clients subscribed to
+// its market's footprint.
+func (h *WebSocketHandler) PublishFootprint(update service.FootprintUpdate) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"type":     "footprint",
+		"marketId": update.MarketID,
+		"closed":   update.Closed,
+		"bar":      update.Bar,
+	})
+	if err != nil {
+		return
+	}
+
+	h.hub.mu.RLock()
+	defer h.hub.mu.RUnlock()
+	for client := range h.hub.clients {
+		client.subMu.RLock()
+		subscribed := client.footprints[update.MarketID]
+		client.subMu.RUnlock()
+		if !subscribed {
+			continue
+		}
+		select {
+		case client.send <- payload:
+		default:
+		}
+	}
+}
+
 func (c *Client) readPump() {
 	defer func() {
 		c.hub.unregister <- c
 		c.conn.Close()
+		c.releaseFootprints()
 	}()
 
 	for {
@@ -173,6 +303,8 @@ func (c *Client) readPump() {
 					c.subscriptions[key] = true
 				}
 				c.subMu.Unlock()
+			} else if msg.Type == "subscribe_footprint" || msg.Type == "unsubscribe_footprint" {
+				c.setFootprints(items, msg.Type == "subscribe_footprint")
 			} else if msg.Type == "unsubscribe" {
 				c.subMu.Lock()
 				for _, item := range items {
@@ -191,6 +323,70 @@ func (c *Client) readPump() {
 	}
 }
 
+// maxFootprintSubscriptions bounds the footprints one connection records.
+const maxFootprintSubscriptions = 5
+
+// setFootprints subscribes to or unsubscribes from live footprint bars of
+// full market IDs, starting or stopping their recording as needed. Only
+// signed-in connections may subscribe, to at most
+// maxFootprintSubscriptions markets.
+func (c *Client) setFootprints(markets []string, subscribe bool) {
+	if c.footprint == nil {
+		return
+	}
+	if subscribe && c.userID == "" {
+		c.sendError("subscribe_footprint needs a signed-in connection")
+		return
+	}
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	for _, item := range markets {
+		key := strings.ToUpper(strings.TrimSpace(item))
+		if _, _, _, ok := parseMarketID(key); !ok || c.footprints[key] == subscribe {
+			continue
+		}
+		if subscribe {
+			if len(c.footprints) >= maxFootprintSubscriptions {
+				c.sendError(fmt.Sprintf("at most %d footprint subscriptions per connection", maxFootprintSubscriptions))
+				return
+			}
+			c.footprints[key] = true
+			c.footprint.Acquire(key)
+		} else {
+			delete(c.footprints, key)
+			c.footprint.Release(key)
+		}
+	}
+}
+
+// sendError tells the client a request was refused. It never blocks and
+// tolerates a connection the hub already dropped.
+func (c *Client) sendError(message string) {
+	defer func() {
+		_ = recover()
+	}()
+	payload, err := json.Marshal(map[string]string{"type": "error", "message": message})
+	if err != nil {
+		return
+	}
+	select {
+	case c.send <- payload:
+	default:
+	}
+}
+
+func (c *Client) releaseFootprints() {
+	if c.footprint == nil {
+		return
+	}
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	for key := range c.footprints {
+		c.footprint.Release(key)
+	}
+	c.footprints = make(map[string]bool)
+}
+
 func (c *Client) writePump() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer func() {
@@ -238,6 +434,7 @@ func startMarketDataStream(hub *Hub) {
 			}
 			client.subMu.RUnlock()
 		}
+		onPrice := hub.onPrice
 		hub.mu.RUnlock()
 
 		if len(union) == 0 || len(clients) == 0 {
@@ -269,6 +466,9 @@ func startMarketDataStream(hub *Hub) {
 				if err != nil {
 					return
 				}
+				for _, fn := range onPrice {
+					fn(marketID, price)
+				}
 
 				msg := map[string]interface{}{
 					"type":       "ticker",
@@ -27,39 +27,78 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Parse and validate token
-		jwtSecret := os.Getenv("JWT_SECRET")
-		if jwtSecret == "" {
-			jwtSecret = "dev_jwt_secret"
-		}
-
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return []byte(jwtSecret), nil
-		})
-
-		if err != nil || !token.Valid {
+		if !setUserFromToken(c, tokenString) {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
-		// Extract claims
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			if userID, ok := claims["user_id"].(string); ok {
-				c.Set("user_id", userID)
-			}
-			if email, ok := claims["email"].(string); ok {
-				c.Set("email", email)
-			}
-		}
+		c.Next()
+	}
+}
 
+// OptionalAuthMiddleware sets user_id when a valid bearer token is present
+// and lets anonymous requests through, for public routes with per-user
+// extras (e.g. saved custom indicators).
+func OptionalAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if tokenString != "" && tokenString != c.GetHeader("Authorization") {
+			setUserFromToken(c, tokenString)
+		}
 		c.Next()
 	}
 }
 
+// setUserFromToken validates a JWT and copies its claims into the context.
+func setUserFromToken(c *gin.Context, tokenString string) bool {
+	claims, ok := parseToken(tokenString)
+	if !ok {
+		return false
+	}
+	if userID, ok := claims["user_id"].(string); ok {
+		c.Set("user_id", userID)
+	}
+	if email, ok := claims["email"].(string); ok {
+		c.Set("email", email)
+	}
+	return true
+}
+
+// UserIDFromToken validates a JWT and returns its user_id, for connections
+// that cannot send an Authorization header (e.g. browser WebSockets).
+func UserIDFromToken(tokenString string) (string, bool) {
+	claims, ok := parseToken(tokenString)
+	if !ok {
+		return "", false
+	}
+	userID, ok := claims["user_id"].(string)
+	return userID, ok && userID != ""
+}
+
+func parseToken(tokenString string) (jwt.MapClaims, bool) {
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		jwtSecret = "dev_jwt_secret"
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return []byte(jwtSecret), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, false
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return jwt.MapClaims{}, true
+	}
+	return claims, true
+}
+
 // RateLimitMiddleware implements simple rate limiting
 func RateLimitMiddleware(requestsPerMinute int) gin.HandlerFunc {
 	// TODO: Implement with Redis
@@ -40,31 +40,82 @@ func main() {
 	alertService := service.NewAlertService(db)
 	watchlistService := service.NewWatchlistService(db)
 	exchangeService := service.NewExchangeService(rdb)
+	instrumentService := service.NewInstrumentService()
+	customIndicatorService := service.NewCustomIndicatorService(db)
+	drawingService := service.NewDrawingService(db)
+	backtestService := service.NewBacktestService(db, handlers.LoadCandleHistory)
+	paperTradingService := service.NewPaperTradingService(db, handlers.LastPrice)
+	footprintService := service.NewFootprintService(db, handlers.LoadAggTrades)
 
 	// Initialize notification and alert evaluator for cron jobs
-	notificationService := service.NewNotificationService()
-	alertEvaluator := service.NewAlertEvaluator(db, notificationService)
-
-	// Initialize cron scheduler for background jobs
+	inboxService := service.NewInboxService(db)
+	notificationService := service.NewNotificationService(inboxService)
+	alertEvaluator := service.NewAlertEvaluator(db, notificationService, handlers.LoadCandleHistory, handlers.LoadAlertMarketData)
+	alertEngine := service.NewAlertEngine(db, alertEvaluator, handlers.LastPrice)
+	screenerHandler := handlers.NewScreenerHandler(coinService, customIndicatorService)
+	screenerAlertService := service.NewScreenerAlertService(db, customIndicatorService, notificationService, screenerHandler.Scan)
+
+	// Initialize cron scheduler for background jobs. Price alerts are
+	// evaluated by the real-time engine; the cron pass is a safety net for
+	// the rest and for markets the engine has no fresh price for.
 	cronScheduler := cron.New()
 	_, err = cronScheduler.AddFunc("@every 1m", alertEvaluator.EvaluateAllAlerts)
+	if err == nil {
+		_, err = cronScheduler.AddFunc("@every 1m", screenerAlertService.EvaluateAll)
+	}
 	if err != nil {
 		log.Printf("⚠️ Failed to schedule alert evaluation: %v", err)
 	} else {
 		cronScheduler.Start()
-		log.Println("🕐 Alert evaluation cron started (every 1 minute)")
+		log.Println("🕐 Alert and screener alert evaluation cron started (every 1 minute)")
 	}
 	defer cronScheduler.Stop()
 
 	// Initialize handlers
 	coinHandler := handlers.NewCoinHandler(coinService, exchangeService)
-	marketHandler := handlers.NewMarketHandler(coinService)
+	marketHandler := handlers.NewMarketHandler(coinService, instrumentService)
 	alertHandler := handlers.NewAlertHandler(alertService)
 	watchlistHandler := handlers.NewWatchlistHandler(watchlistService)
-	wsHandler := handlers.NewWebSocketHandler(exchangeService)
+	wsHandler := handlers.NewWebSocketHandler(exchangeService, footprintService)
 	authHandler := handlers.NewAuthHandler(db)
 	aiProviderHandler := handlers.NewAIProviderHandler(db)
 	aiChatHandler := handlers.NewAIChatHandler(db)
+	customIndicatorHandler := handlers.NewCustomIndicatorHandler(customIndicatorService)
+	drawingHandler := handlers.NewDrawingHandler(drawingService)
+	screenerAlertHandler := handlers.NewScreenerAlertHandler(screenerAlertService)
+	notificationHandler := handlers.NewNotificationHandler(inboxService)
+	backtestHandler := handlers.NewBacktestHandler(backtestService)
+	paperTradingHandler := handlers.NewPaperTradingHandler(paperTradingService)
+	footprintHandler := handlers.NewFootprintHandler(footprintService)
+
+	// Paper trading updates are pushed to the account owner's sockets, and
+	// orders are matched on the prices of the market data stream.
+	paperTradingService.OnEvent(wsHandler.PublishPaperEvent)
+	wsHandler.OnPrice(paperTradingService.OnPrice)
+	paperTradingService.Start()
+
+	// In-app notifications and unread counts are pushed to the user's
+	// sockets.
+	wsHandler.UseInbox(inboxService)
+
+	// Footprints are recorded for FOOTPRINT_MARKETS (comma-separated market
+	// IDs) and for markets with live WebSocket subscribers. Binance trades
+	// are polled; Bybit trades come from its WebSocket trade stream.
+	footprintService.UseStream(handlers.NewBybitTradeStream(footprintService.Push, footprintService.MarkGap))
+	for _, marketID := range strings.Split(os.Getenv("FOOTPRINT_MARKETS"), ",") {
+		if marketID = strings.ToUpper(strings.TrimSpace(marketID)); marketID != "" {
+			footprintService.Track(marketID)
+		}
+	}
+	footprintService.OnUpdate(wsHandler.PublishFootprint)
+	footprintService.Start()
+
+	// Alerts are evaluated on every price from the market data stream and
+	// reloaded as soon as they change.
+	alertService.OnChange(alertEngine.Reload)
+	drawingService.OnChange(alertEngine.ReloadDrawing)
+	wsHandler.OnPrice(alertEngine.OnPrice)
+	alertEngine.Start()
 
 	// Setup Gin router
 	if os.Getenv("LOG_LEVEL") != "debug" {
@@ -94,11 +145,12 @@ func main() {
 		AllowCredentials: true,
 	}))
 
-	// Health check
+	// Health check, with the metrics of the scheduled alert evaluation
 	router.GET("/api/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
-			"status":  "healthy",
-			"service": "scalpaiboard-go",
+			"status":          "healthy",
+			"service":         "scalpaiboard-go",
+			"alertEvaluation": alertEvaluator.Stats(),
 		})
 	})
 
@@ -109,13 +161,26 @@ func main() {
 	// Public routes
 	router.GET("/api/markets", marketHandler.ListMarkets)
 	router.GET("/api/markets/:marketId/metrics", marketHandler.GetMetrics)
+	router.GET("/api/markets/:marketId/regime", marketHandler.GetRegime)
+	router.GET("/api/markets/:marketId/instrument", marketHandler.GetInstrument)
+	router.GET("/api/markets/:marketId/volatility", marketHandler.GetVolatility)
+	router.GET("/api/markets/:marketId/seasonality", marketHandler.GetSeasonality)
+	router.GET("/api/markets/:marketId/footprint", footprintHandler.GetFootprint)
+	router.POST("/api/markets/:marketId/position-size", marketHandler.GetPositionSize)
 	router.GET("/api/coins", coinHandler.ListCoins)
 	router.GET("/api/coins/:symbol", coinHandler.GetCoin)
 	router.GET("/api/coins/:symbol/candles", coinHandler.GetCandles)
 	router.GET("/api/coins/:symbol/orderbook", coinHandler.GetOrderbook)
 
-	analysisHandler := handlers.NewAnalysisHandler()
-	router.GET("/api/coins/:symbol/analysis", analysisHandler.GetAnalysis)
+	analysisHandler := handlers.NewAnalysisHandler(customIndicatorService)
+	router.GET("/api/coins/:symbol/analysis", middleware.OptionalAuthMiddleware(), analysisHandler.GetAnalysis)
+	router.GET("/api/coins/:symbol/patterns", analysisHandler.GetPatterns)
+	router.GET("/api/coins/:symbol/structure", analysisHandler.GetStructure)
+	router.GET("/api/coins/:symbol/divergences", analysisHandler.GetDivergences)
+	router.GET("/api/markets/:marketId/mtf", analysisHandler.GetMultiTimeframe)
+
+	router.GET("/api/screener/columns", screenerHandler.ListColumns)
+	router.GET("/api/indicators/functions", customIndicatorHandler.ListFunctions)
 
 	// WebSocket
 	router.GET("/ws", wsHandler.HandleConnection)
@@ -128,6 +193,9 @@ func main() {
 		protected.GET("/auth/me", authHandler.GetMe)
 		protected.POST("/auth/refresh", authHandler.RefreshToken)
 
+		// Screener: each scan fetches candles for up to 200 markets.
+		protected.GET("/screener", screenerHandler.RunScreener)
+
 		// Watchlist
 		protected.GET("/watchlist", watchlistHandler.GetWatchlist)
 		protected.POST("/watchlist", watchlistHandler.AddToWatchlist)
@@ -140,6 +208,51 @@ func main() {
 		protected.DELETE("/alerts/:id", alertHandler.DeleteAlert)
 		protected.GET("/alerts/:id/history", alertHandler.GetAlertHistory)
 
+		// Notification inbox
+		protected.GET("/notifications", notificationHandler.ListNotifications)
+		protected.GET("/notifications/unread-count", notificationHandler.GetUnreadCount)
+		protected.POST("/notifications/read", notificationHandler.MarkRead)
+		protected.DELETE("/notifications", notificationHandler.ClearNotifications)
+		protected.DELETE("/notifications/:id", notificationHandler.DeleteNotification)
+
+		// Screener alerts
+		protected.GET("/screener-alerts", screenerAlertHandler.ListScreenerAlerts)
+		protected.POST("/screener-alerts", screenerAlertHandler.CreateScreenerAlert)
+		protected.PUT("/screener-alerts/:id", screenerAlertHandler.UpdateScreenerAlert)
+		protected.DELETE("/screener-alerts/:id", screenerAlertHandler.DeleteScreenerAlert)
+		protected.GET("/screener-alerts/:id/matches", screenerAlertHandler.GetScreenerMatches)
+		protected.GET("/screener-alerts/:id/events", screenerAlertHandler.GetScreenerEvents)
+
+		// Custom indicators
+		protected.GET("/indicators", customIndicatorHandler.ListIndicators)
+		protected.POST("/indicators", customIndicatorHandler.CreateIndicator)
+		protected.PUT("/indicators/:id", customIndicatorHandler.UpdateIndicator)
+		protected.DELETE("/indicators/:id", customIndicatorHandler.DeleteIndicator)
+		protected.POST("/indicators/evaluate", customIndicatorHandler.EvaluateFormula)
+
+		// Chart drawings
+		protected.GET("/drawings", drawingHandler.ListDrawings)
+		protected.POST("/drawings", drawingHandler.CreateDrawing)
+		protected.PUT("/drawings/:id", drawingHandler.UpdateDrawing)
+		protected.DELETE("/drawings/:id", drawingHandler.DeleteDrawing)
+
+		// Backtests
+		protected.GET("/backtests", backtestHandler.ListBacktests)
+		protected.POST("/backtests", backtestHandler.SubmitBacktest)
+		protected.GET("/backtests/:id", backtestHandler.GetBacktest)
+		protected.DELETE("/backtests/:id", backtestHandler.CancelBacktest)
+
+		// Paper trading
+		protected.GET("/paper/accounts", paperTradingHandler.ListAccounts)
+		protected.POST("/paper/accounts", paperTradingHandler.CreateAccount)
+		protected.GET("/paper/accounts/:id", paperTradingHandler.GetAccount)
+		protected.POST("/paper/accounts/:id/reset", paperTradingHandler.ResetAccount)
+		protected.DELETE("/paper/accounts/:id", paperTradingHandler.DeleteAccount)
+		protected.GET("/paper/accounts/:id/orders", paperTradingHandler.ListOrders)
+		protected.POST("/paper/accounts/:id/orders", paperTradingHandler.PlaceOrder)
+		protected.DELETE("/paper/accounts/:id/orders/:orderId", paperTradingHandler.CancelOrder)
+		protected.GET("/paper/accounts/:id/fills", paperTradingHandler.ListFills)
+
 		// AI Chat
 		protected.POST("/ai/chat", aiChatHandler.HandleChat)
 		protected.GET("/ai/conversations", handlers.ListConversations)
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // Coin represents a cryptocurrency trading pair
 type Coin struct {
@@ -41,18 +44,28 @@ type Candle struct {
 
 // Alert represents a user alert
 type Alert struct {
-	ID               int        `json:"id"`
-	UserID           string     `json:"userId"`
-	CoinID           int        `json:"coinId"`
-	CoinSymbol       string     `json:"coinSymbol,omitempty"`
-	ConditionType    string     `json:"conditionType"`
-	ConditionValue   float64    `json:"conditionValue"`
-	NotificationType string     `json:"notificationType"`
-	IsActive         bool       `json:"isActive"`
-	TriggeredCount   int        `json:"triggeredCount"`
-	LastTriggeredAt  *time.Time `json:"lastTriggeredAt,omitempty"`
-	CreatedAt        time.Time  `json:"createdAt"`
-	UpdatedAt        time.Time  `json:"updatedAt"`
+	ID               int             `json:"id"`
+	UserID           string          `json:"userId"`
+	MarketID         string          `json:"marketId"`
+	CoinID           *int            `json:"coinId,omitempty"`
+	CoinSymbol       string          `json:"coinSymbol,omitempty"`
+	PriceSource      string          `json:"priceSource"`
+	ConditionType    string          `json:"conditionType"`
+	ConditionValue   *float64        `json:"conditionValue,omitempty"`
+	ConditionParams  json.RawMessage `json:"conditionParams,omitempty"`
+	NotificationType string          `json:"notificationType"`
+	TriggerMode      string          `json:"triggerMode"`
+	CooldownSeconds  int             `json:"cooldownSeconds"`
+	BarInterval      string          `json:"barInterval,omitempty"`
+	RearmBand        *float64        `json:"rearmBand,omitempty"`
+	ExpiresAt        *time.Time      `json:"expiresAt,omitempty"`
+	MaxTriggers      *int            `json:"maxTriggers,omitempty"`
+	Armed            bool            `json:"armed"`
+	IsActive         bool            `json:"isActive"`
+	TriggeredCount   int             `json:"triggeredCount"`
+	LastTriggeredAt  *time.Time      `json:"lastTriggeredAt,omitempty"`
+	CreatedAt        time.Time       `json:"createdAt"`
+	UpdatedAt        time.Time       `json:"updatedAt"`
 }
 
 // AlertHistory represents a triggered alert record
@@ -63,6 +76,8 @@ type AlertHistory struct {
 	NotificationStatus  string    `json:"notificationStatus"`
 	NotificationChannel string    `json:"notificationChannel"`
 	ErrorMessage        string    `json:"errorMessage,omitempty"`
+	// Details lists the evaluated conditions with their observed values.
+	Details json.RawMessage `json:"details,omitempty"`
 }
 
 // WatchlistItem represents a coin in user's watchlist
@@ -131,3 +146,152 @@ type AIMessage struct {
 	Cost           float64   `json:"cost,omitempty"`
 	CreatedAt      time.Time `json:"createdAt"`
 }
+
+// CustomIndicator is a user-defined formula indicator
+type CustomIndicator struct {
+	ID          int       `json:"id"`
+	UserID      string    `json:"userId"`
+	Name        string    `json:"name"`
+	Formula     string    `json:"formula"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// DrawingPoint is a drawing anchor: a chart time in Unix seconds and a
+// price.
+type DrawingPoint struct {
+	Time  int64   `json:"time"`
+	Price float64 `json:"price"`
+}
+
+// Drawing is a chart drawing saved for a market
+type Drawing struct {
+	ID        int             `json:"id"`
+	UserID    string          `json:"userId"`
+	MarketID  string          `json:"marketId"`
+	Type      string          `json:"type"`
+	Points    []DrawingPoint  `json:"points"`
+	Style     json.RawMessage `json:"style,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+// ScreenerAlert is a saved screener query that notifies when markets enter
+// or leave its result set
+type ScreenerAlert struct {
+	ID               int             `json:"id"`
+	UserID           string          `json:"userId"`
+	Name             string          `json:"name"`
+	Query            json.RawMessage `json:"query"`
+	NotifyOn         string          `json:"notifyOn"` // enter, exit, both
+	DedupSeconds     int             `json:"dedupSeconds"`
+	NotificationType string          `json:"notificationType"`
+	IsActive         bool            `json:"isActive"`
+	LastRunAt        *time.Time      `json:"lastRunAt,omitempty"`
+	CreatedAt        time.Time       `json:"createdAt"`
+	UpdatedAt        time.Time       `json:"updatedAt"`
+}
+
+// ScreenerMatch is a market currently in a screener alert's result set,
+// with its column values when it entered
+type ScreenerMatch struct {
+	MarketID string             `json:"marketId"`
+	Values   map[string]float64 `json:"values"`
+	Since    time.Time          `json:"since"`
+}
+
+// ScreenerAlertEvent is a notified entry or exit of a market
+type ScreenerAlertEvent struct {
+	ID                  int                `json:"id"`
+	ScreenerAlertID     int                `json:"screenerAlertId"`
+	MarketID            string             `json:"marketId"`
+	Event               string             `json:"event"` // enter, exit
+	Values              map[string]float64 `json:"values"`
+	NotificationStatus  string             `json:"notificationStatus"`
+	NotificationChannel string             `json:"notificationChannel"`
+	ErrorMessage        string             `json:"errorMessage,omitempty"`
+	CreatedAt           time.Time          `json:"createdAt"`
+}
+
+// Notification is an in-app notification in a user's inbox
+type Notification struct {
+	ID        int             `json:"id"`
+	UserID    string          `json:"userId"`
+	Kind      string          `json:"kind"` // alert, screener
+	Title     string          `json:"title"`
+	Message   string          `json:"message"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Read      bool            `json:"read"`
+	ReadAt    *time.Time      `json:"readAt,omitempty"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// PaperAccount is a simulated trading account. Balance is the wallet
+// balance (deposits plus realized PnL, less fees and funding).
+type PaperAccount struct {
+	ID             int       `json:"id"`
+	UserID         string    `json:"userId"`
+	Name           string    `json:"name"`
+	InitialBalance float64   `json:"initialBalance"`
+	Balance        float64   `json:"balance"`
+	MakerFeePct    float64   `json:"makerFeePct"`
+	TakerFeePct    float64   `json:"takerFeePct"`
+	FundingRatePct float64   `json:"fundingRatePct"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// PaperOrder is an order on a paper account
+type PaperOrder struct {
+	ID            int       `json:"id"`
+	AccountID     int       `json:"accountId"`
+	MarketID      string    `json:"marketId"`
+	Side          string    `json:"side"` // buy, sell
+	Type          string    `json:"type"` // market, limit, stop, stop_limit
+	Quantity      float64   `json:"quantity"`
+	Price         *float64  `json:"price,omitempty"`
+	StopPrice     *float64  `json:"stopPrice,omitempty"`
+	Leverage      float64   `json:"leverage"`
+	ReduceOnly    bool      `json:"reduceOnly"`
+	TakeProfit    *float64  `json:"takeProfit,omitempty"`
+	StopLoss      *float64  `json:"stopLoss,omitempty"`
+	ParentOrderID *int      `json:"parentOrderId,omitempty"`
+	Status        string    `json:"status"` // open, filled, cancelled, rejected
+	Triggered     bool      `json:"triggered"`
+	FilledPrice   *float64  `json:"filledPrice,omitempty"`
+	RejectReason  string    `json:"rejectReason,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// PaperPosition is a net position; Quantity is negative for shorts
+type PaperPosition struct {
+	ID            int       `json:"id"`
+	AccountID     int       `json:"accountId"`
+	MarketID      string    `json:"marketId"`
+	Quantity      float64   `json:"quantity"`
+	EntryPrice    float64   `json:"entryPrice"`
+	Leverage      float64   `json:"leverage"`
+	RealizedPnL   float64   `json:"realizedPnl"`
+	FundingPaid   float64   `json:"fundingPaid"`
+	MarkPrice     float64   `json:"markPrice,omitempty"`
+	UnrealizedPnL float64   `json:"unrealizedPnl"`
+	OpenedAt      time.Time `json:"openedAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// PaperFill is an execution of a paper order
+type PaperFill struct {
+	ID          int       `json:"id"`
+	AccountID   int       `json:"accountId"`
+	OrderID     *int      `json:"orderId,omitempty"`
+	MarketID    string    `json:"marketId"`
+	Side        string    `json:"side"`
+	Quantity    float64   `json:"quantity"`
+	Price       float64   `json:"price"`
+	Fee         float64   `json:"fee"`
+	RealizedPnL float64   `json:"realizedPnl"`
+	Liquidity   string    `json:"liquidity"` // maker, taker
+	CreatedAt   time.Time `json:"createdAt"`
+}
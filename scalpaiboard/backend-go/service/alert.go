@@ -2,26 +2,49 @@ package service
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/scalpaiboard/backend/models"
 )
 
+// ErrAlertNotFound is returned when an alert does not exist or belongs to
+// another user.
+var ErrAlertNotFound = errors.New("alert not found")
+
 type AlertService struct {
 	db *sql.DB
+
+	onChange func(alertID int)
 }
 
 func NewAlertService(db *sql.DB) *AlertService {
 	return &AlertService{db: db}
 }
 
+// OnChange registers a hook called with the ID of every alert that is
+// created, updated or deleted (e.g. the real-time alert engine).
+func (s *AlertService) OnChange(fn func(alertID int)) {
+	s.onChange = fn
+}
+
+func (s *AlertService) changed(alertID int) {
+	if s.onChange != nil {
+		s.onChange(alertID)
+	}
+}
+
 // GetUserAlerts returns all alerts for a user
 func (s *AlertService) GetUserAlerts(userID string) ([]models.Alert, error) {
 	query := `
-		SELECT a.id, a.user_id, a.coin_id, c.symbol, a.condition_type, a.condition_value, 
-			   a.notification_type, a.is_active, a.triggered_count, a.last_triggered_at, 
-			   a.created_at, a.updated_at
+		SELECT a.id, a.user_id, a.market_id, a.coin_id, a.price_source, a.condition_type, a.condition_value, 
+			   a.condition_params, a.notification_type, a.trigger_mode, a.cooldown_seconds,
+			   COALESCE(a.bar_interval, ''), a.rearm_band, a.expires_at, a.max_triggers, a.armed,
+			   a.is_active, a.triggered_count, a.last_triggered_at, a.created_at, a.updated_at
 		FROM alerts a
-		JOIN coins c ON a.coin_id = c.id
 		WHERE a.user_id = $1
 		ORDER BY a.created_at DESC
 	`
@@ -34,86 +57,360 @@ func (s *AlertService) GetUserAlerts(userID string) ([]models.Alert, error) {
 	alerts := make([]models.Alert, 0)
 	for rows.Next() {
 		var a models.Alert
-		if err := rows.Scan(&a.ID, &a.UserID, &a.CoinID, &a.CoinSymbol, &a.ConditionType,
-			&a.ConditionValue, &a.NotificationType, &a.IsActive, &a.TriggeredCount,
-			&a.LastTriggeredAt, &a.CreatedAt, &a.UpdatedAt); err != nil {
+		var params []byte
+		if err := rows.Scan(&a.ID, &a.UserID, &a.MarketID, &a.CoinID, &a.PriceSource, &a.ConditionType,
+			&a.ConditionValue, &params, &a.NotificationType, &a.TriggerMode, &a.CooldownSeconds,
+			&a.BarInterval, &a.RearmBand, &a.ExpiresAt, &a.MaxTriggers, &a.Armed,
+			&a.IsActive, &a.TriggeredCount, &a.LastTriggeredAt, &a.CreatedAt, &a.UpdatedAt); err != nil {
 			continue
 		}
+		if len(params) > 0 {
+			a.ConditionParams = params
+		}
+		a.CoinSymbol = marketSymbol(a.MarketID)
 		alerts = append(alerts, a)
 	}
 	return alerts, nil
 }
 
-// CreateAlert creates a new alert
-func (s *AlertService) CreateAlert(userID string, coinID int, conditionType string, conditionValue float64, notificationType string) (*models.Alert, error) {
+// CreateAlert creates a new alert on marketID, or for legacy clients on the
+// Binance spot market of coinID. conditionParams may be nil for
+// threshold-only conditions, and conditionValue is nil (and ignored) for
+// conditions described entirely by their params. priceSource defaults to
+// the last price.
+func (s *AlertService) CreateAlert(userID, marketID string, coinID int, conditionType string, conditionValue *float64, conditionParams json.RawMessage, priceSource, notificationType string, trigger AlertTriggerSettings) (*models.Alert, error) {
+	marketID, coin, err := s.alertMarket(marketID, coinID)
+	if err != nil {
+		return nil, err
+	}
+	conditionValue, conditionParams, err = s.prepareCondition(userID, marketID, conditionType, conditionValue, conditionParams)
+	if err != nil {
+		return nil, err
+	}
+	if priceSource, err = validatePriceSource(marketID, conditionType, priceSource); err != nil {
+		return nil, err
+	}
+	if err := validateTrigger(conditionType, conditionParams, &trigger); err != nil {
+		return nil, err
+	}
+	if trigger.ExpiresAt != nil && !trigger.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: expiresAt is in the past", ErrInvalidCondition)
+	}
+
 	query := `
-		INSERT INTO alerts (user_id, coin_id, condition_type, condition_value, notification_type)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO alerts (user_id, market_id, coin_id, price_source, condition_type, condition_value, condition_params,
+			notification_type, trigger_mode, cooldown_seconds, bar_interval, rearm_band, expires_at, max_triggers)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, ''), $12, $13, $14)
 		RETURNING id, created_at, updated_at
 	`
 	var alert models.Alert
 	alert.UserID = userID
-	alert.CoinID = coinID
+	alert.MarketID = marketID
+	alert.CoinID = coin
+	alert.CoinSymbol = marketSymbol(marketID)
+	alert.PriceSource = priceSource
 	alert.ConditionType = conditionType
 	alert.ConditionValue = conditionValue
+	alert.ConditionParams = conditionParams
 	alert.NotificationType = notificationType
+	alert.TriggerMode = trigger.TriggerMode
+	alert.CooldownSeconds = *trigger.CooldownSeconds
+	alert.BarInterval = trigger.BarInterval
+	alert.RearmBand = trigger.RearmBand
+	alert.ExpiresAt = trigger.ExpiresAt
+	alert.MaxTriggers = trigger.MaxTriggers
+	alert.Armed = true
 	alert.IsActive = true
 
-	err := s.db.QueryRow(query, userID, coinID, conditionType, conditionValue, notificationType).
+	var params interface{}
+	if len(conditionParams) > 0 {
+		params = []byte(conditionParams)
+	}
+
+	err = s.db.QueryRow(query, userID, marketID, coin, priceSource, conditionType, conditionValue, params, notificationType,
+		trigger.TriggerMode, *trigger.CooldownSeconds, trigger.BarInterval, trigger.RearmBand, trigger.ExpiresAt,
+		trigger.MaxTriggers).
 		Scan(&alert.ID, &alert.CreatedAt, &alert.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
+	s.changed(alert.ID)
 
 	return &alert, nil
 }
 
-// UpdateAlert updates an alert
-func (s *AlertService) UpdateAlert(userID string, alertID int, isActive *bool, conditionValue *float64, notificationType *string) (*models.Alert, error) {
+// alertMarket returns the normalised marketId of a new alert, resolving a
+// legacy coinID to its Binance spot market, and the coin ID to store.
+func (s *AlertService) alertMarket(marketID string, coinID int) (string, *int, error) {
+	if marketID != "" {
+		marketID = strings.ToUpper(strings.TrimSpace(marketID))
+		if !isAlertMarketID(marketID) {
+			return "", nil, fmt.Errorf("%w: invalid marketId %q", ErrInvalidCondition, marketID)
+		}
+		return marketID, nil, nil
+	}
+	if coinID <= 0 {
+		return "", nil, fmt.Errorf("%w: marketId required", ErrInvalidCondition)
+	}
+	var symbol string
+	err := s.db.QueryRow("SELECT symbol FROM coins WHERE id = $1", coinID).Scan(&symbol)
+	if err == sql.ErrNoRows {
+		return "", nil, fmt.Errorf("%w: unknown coinId %d", ErrInvalidCondition, coinID)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return coinMarketID(symbol), &coinID, nil
+}
+
+// coinMarketID is the market of an alert created for a coin.
+func coinMarketID(symbol string) string {
+	return "BI:SPOT:" + symbol
+}
+
+// marketSymbol returns the symbol of a marketId.
+func marketSymbol(marketID string) string {
+	return marketID[strings.LastIndex(marketID, ":")+1:]
+}
+
+// validatePriceSource checks the price source of an alert and returns it
+// with the default filled in.
+func validatePriceSource(marketID, conditionType, priceSource string) (string, error) {
+	switch priceSource {
+	case "", PriceSourceLast:
+		return PriceSourceLast, nil
+	case PriceSourceMark:
+		if !isPerpMarket(marketID) || !IsPriceCondition(conditionType) {
+			return "", fmt.Errorf("%w: the mark price is only available for price conditions on PERP markets", ErrInvalidCondition)
+		}
+		return PriceSourceMark, nil
+	default:
+		return "", fmt.Errorf("%w: unknown priceSource %q", ErrInvalidCondition, priceSource)
+	}
+}
+
+// prepareCondition resolves and validates a condition of an alert on
+// marketID. It returns the value and params to store.
+func (s *AlertService) prepareCondition(userID, marketID, conditionType string, conditionValue *float64, conditionParams json.RawMessage) (*float64, json.RawMessage, error) {
+	if string(conditionParams) == "null" {
+		conditionParams = nil
+	}
+	if !IsAlertCondition(conditionType) {
+		return nil, nil, fmt.Errorf("%w: unknown conditionType %q", ErrInvalidCondition, conditionType)
+	}
+	if IsPerpCondition(conditionType) && !isPerpMarket(marketID) {
+		return nil, nil, fmt.Errorf("%w: %s needs a PERP market", ErrInvalidCondition, conditionType)
+	}
+	if !ConditionTakesValue(conditionType) {
+		conditionValue = nil
+	} else if conditionValue == nil {
+		return nil, nil, fmt.Errorf("%w: conditionValue required", ErrInvalidCondition)
+	}
+	switch conditionType {
+	case ConditionCustomFormula:
+		resolved, err := s.resolveCustomIndicator(userID, conditionParams)
+		if err != nil {
+			return nil, nil, err
+		}
+		conditionParams = resolved
+	case ConditionCompound:
+		resolved, err := s.resolveCompound(userID, marketID, conditionParams)
+		if err != nil {
+			return nil, nil, err
+		}
+		conditionParams = resolved
+	case ConditionDrawingCross, ConditionDrawingZone:
+		if err := s.checkDrawing(userID, marketID, conditionType, conditionParams); err != nil {
+			return nil, nil, err
+		}
+	}
+	value := 0.0
+	if conditionValue != nil {
+		value = *conditionValue
+	}
+	if err := ValidateAlertCondition(conditionType, value, conditionParams); err != nil {
+		return nil, nil, err
+	}
+	return conditionValue, conditionParams, nil
+}
+
+// UpdateAlert updates an alert. A new conditionValue or conditionParams is
+// validated against the alert's condition type. Changing the condition or
+// how it triggers, or reactivating the alert, rearms it and forgets the
+// last evaluation, so crosses are detected afresh.
+func (s *AlertService) UpdateAlert(userID string, alertID int, isActive *bool, conditionValue *float64, conditionParams json.RawMessage, priceSource, notificationType *string, trigger AlertTriggerUpdate) (*models.Alert, error) {
+	var (
+		marketID       string
+		storedSource   string
+		conditionType  string
+		storedValue    sql.NullFloat64
+		storedParams   []byte
+		stored         AlertTriggerSettings
+		cooldown       int
+		rearmBand      sql.NullFloat64
+		expiresAt      sql.NullTime
+		maxTriggers    sql.NullInt64
+		triggeredCount int
+	)
+	err := s.db.QueryRow(`
+		SELECT market_id, price_source, condition_type, condition_value, condition_params, trigger_mode, cooldown_seconds,
+			   COALESCE(bar_interval, ''), rearm_band, expires_at, max_triggers, triggered_count
+		FROM alerts
+		WHERE id = $1 AND user_id = $2
+	`, alertID, userID).Scan(&marketID, &storedSource, &conditionType, &storedValue, &storedParams, &stored.TriggerMode, &cooldown,
+		&stored.BarInterval, &rearmBand, &expiresAt, &maxTriggers, &triggeredCount)
+	if err == sql.ErrNoRows {
+		return nil, ErrAlertNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conditionChanged := conditionValue != nil || len(conditionParams) > 0
+	if conditionChanged {
+		if conditionValue == nil && storedValue.Valid {
+			conditionValue = &storedValue.Float64
+		}
+		if len(conditionParams) == 0 {
+			conditionParams = storedParams
+		}
+		conditionValue, conditionParams, err = s.prepareCondition(userID, marketID, conditionType, conditionValue, conditionParams)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if priceSource != nil {
+		source, err := validatePriceSource(marketID, conditionType, *priceSource)
+		if err != nil {
+			return nil, err
+		}
+		conditionChanged = conditionChanged || source != storedSource
+		priceSource = &source
+	}
+	triggerParams := conditionParams
+	if !conditionChanged {
+		triggerParams = storedParams
+	}
+
+	settings := stored
+	settings.CooldownSeconds = &cooldown
+	if rearmBand.Valid {
+		settings.RearmBand = &rearmBand.Float64
+	}
+	if expiresAt.Valid {
+		settings.ExpiresAt = &expiresAt.Time
+	}
+	if maxTriggers.Valid {
+		n := int(maxTriggers.Int64)
+		settings.MaxTriggers = &n
+	}
+	if trigger.TriggerMode != "" {
+		settings.TriggerMode = trigger.TriggerMode
+	}
+	if trigger.CooldownSeconds != nil {
+		settings.CooldownSeconds = trigger.CooldownSeconds
+	}
+	if trigger.BarInterval != "" {
+		settings.BarInterval = trigger.BarInterval
+	}
+	if trigger.RearmBand != nil {
+		settings.RearmBand = trigger.RearmBand
+	}
+	if trigger.MaxTriggers != nil {
+		settings.MaxTriggers = trigger.MaxTriggers
+	}
+	if trigger.ClearExpiresAt {
+		settings.ExpiresAt = nil
+	} else if trigger.ExpiresAt != nil {
+		if !trigger.ExpiresAt.After(time.Now()) {
+			return nil, fmt.Errorf("%w: expiresAt is in the past", ErrInvalidCondition)
+		}
+		settings.ExpiresAt = trigger.ExpiresAt
+	}
+	if err := validateTrigger(conditionType, triggerParams, &settings); err != nil {
+		return nil, err
+	}
+
+	reactivated := isActive != nil && *isActive
+	if reactivated {
+		if settings.ExpiresAt != nil && !settings.ExpiresAt.After(time.Now()) {
+			return nil, fmt.Errorf("%w: alert expired; set a later expiresAt to reactivate it", ErrInvalidCondition)
+		}
+		if settings.MaxTriggers != nil && triggeredCount >= *settings.MaxTriggers {
+			return nil, fmt.Errorf("%w: alert reached maxTriggers; raise it to reactivate the alert", ErrInvalidCondition)
+		}
+	}
+
 	// Build dynamic update query
 	query := `UPDATE alerts SET updated_at = NOW()`
 	args := []interface{}{}
-	argIndex := 1
+	set := func(column string, value interface{}) {
+		args = append(args, value)
+		query += fmt.Sprintf(", %s = $%d", column, len(args))
+	}
 
 	if isActive != nil {
-		query += ", is_active = $" + string(rune('0'+argIndex))
-		args = append(args, *isActive)
-		argIndex++
+		set("is_active", *isActive)
 	}
 	if conditionValue != nil {
-		query += ", condition_value = $" + string(rune('0'+argIndex))
-		args = append(args, *conditionValue)
-		argIndex++
+		set("condition_value", *conditionValue)
+	}
+	if len(conditionParams) > 0 {
+		set("condition_params", []byte(conditionParams))
+	}
+	if priceSource != nil {
+		set("price_source", *priceSource)
 	}
 	if notificationType != nil {
-		query += ", notification_type = $" + string(rune('0'+argIndex))
-		args = append(args, *notificationType)
-		argIndex++
+		set("notification_type", *notificationType)
+	}
+	set("trigger_mode", settings.TriggerMode)
+	set("cooldown_seconds", *settings.CooldownSeconds)
+	set("bar_interval", sql.NullString{String: settings.BarInterval, Valid: settings.BarInterval != ""})
+	set("rearm_band", settings.RearmBand)
+	set("expires_at", settings.ExpiresAt)
+	set("max_triggers", settings.MaxTriggers)
+	triggerChanged := settings.TriggerMode != stored.TriggerMode || !sameFloat(settings.RearmBand, rearmBand)
+	if conditionChanged || triggerChanged || reactivated {
+		query += ", armed = TRUE, last_met = NULL"
 	}
 
-	query += " WHERE id = $" + string(rune('0'+argIndex)) + " AND user_id = $" + string(rune('0'+argIndex+1))
+	query += fmt.Sprintf(" WHERE id = $%d AND user_id = $%d", len(args)+1, len(args)+2)
 	args = append(args, alertID, userID)
 
-	_, err := s.db.Exec(query, args...)
+	_, err = s.db.Exec(query, args...)
 	if err != nil {
 		return nil, err
 	}
+	s.changed(alertID)
 
 	// Return updated alert
 	return &models.Alert{ID: alertID}, nil
 }
 
+// sameFloat reports whether an optional value equals a stored one.
+func sameFloat(v *float64, stored sql.NullFloat64) bool {
+	if v == nil {
+		return !stored.Valid
+	}
+	return stored.Valid && *v == stored.Float64
+}
+
 // DeleteAlert removes an alert
 func (s *AlertService) DeleteAlert(userID string, alertID int) error {
 	query := "DELETE FROM alerts WHERE id = $1 AND user_id = $2"
-	_, err := s.db.Exec(query, alertID, userID)
-	return err
+	if _, err := s.db.Exec(query, alertID, userID); err != nil {
+		return err
+	}
+	s.changed(alertID)
+	return nil
 }
 
 // GetAlertHistory returns trigger history for an alert
 func (s *AlertService) GetAlertHistory(userID string, alertID int) ([]models.AlertHistory, error) {
 	query := `
-		SELECT ah.id, ah.alert_id, ah.triggered_at, ah.notification_status, ah.notification_channel, COALESCE(ah.error_message, '')
+		SELECT ah.id, ah.alert_id, ah.triggered_at, ah.notification_status, ah.notification_channel, COALESCE(ah.error_message, ''),
+			   ah.details
 		FROM alert_history ah
 		JOIN alerts a ON ah.alert_id = a.id
 		WHERE ah.alert_id = $1 AND a.user_id = $2
@@ -129,10 +426,75 @@ func (s *AlertService) GetAlertHistory(userID string, alertID int) ([]models.Ale
 	history := make([]models.AlertHistory, 0)
 	for rows.Next() {
 		var h models.AlertHistory
-		if err := rows.Scan(&h.ID, &h.AlertID, &h.TriggeredAt, &h.NotificationStatus, &h.NotificationChannel, &h.ErrorMessage); err != nil {
+		var details []byte
+		if err := rows.Scan(&h.ID, &h.AlertID, &h.TriggeredAt, &h.NotificationStatus, &h.NotificationChannel, &h.ErrorMessage,
+			&details); err != nil {
 			continue
 		}
+		if len(details) > 0 {
+			h.Details = details
+		}
 		history = append(history, h)
 	}
 	return history, nil
 }
+
+// resolveCustomIndicator snapshots the formula of a saved indicator named in
+// custom_formula params, so later edits to the indicator do not change the
+// alert.
+func (s *AlertService) resolveCustomIndicator(userID string, params json.RawMessage) (json.RawMessage, error) {
+	var p CustomFormulaConditionParams
+	if err := decodeConditionParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Indicator == "" || p.Formula != "" {
+		return params, nil
+	}
+
+	err := s.db.QueryRow("SELECT formula FROM custom_indicators WHERE user_id = $1 AND name = $2", userID, p.Indicator).Scan(&p.Formula)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: unknown indicator %q", ErrInvalidCondition, p.Indicator)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(p)
+}
+
+// resolveCompound resolves the saved indicators of the custom_formula
+// leaves of a compound condition on marketID and returns it validated and
+// normalised.
+func (s *AlertService) resolveCompound(userID, marketID string, params json.RawMessage) (json.RawMessage, error) {
+	var x AlertExpression
+	if err := decodeConditionParams(params, &x); err != nil {
+		return nil, err
+	}
+	err := x.leaves(func(leaf *AlertExpression) error {
+		if leaf.ConditionType != ConditionCustomFormula {
+			return nil
+		}
+		resolved, err := s.resolveCustomIndicator(userID, leaf.ConditionParams)
+		leaf.ConditionParams = resolved
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(x)
+	if err != nil {
+		return nil, err
+	}
+	if x, err = ParseAlertExpression(raw); err != nil {
+		return nil, err
+	}
+	err = x.leaves(func(leaf *AlertExpression) error {
+		if IsPerpCondition(leaf.ConditionType) && leaf.MarketID == "" && !isPerpMarket(marketID) {
+			return fmt.Errorf("%w: %s needs a PERP marketId", ErrInvalidCondition, leaf.ConditionType)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(x)
+}
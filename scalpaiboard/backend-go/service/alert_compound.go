@@ -0,0 +1,180 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ConditionCompound fires when a boolean expression over other conditions
+// holds; its conditionParams is an AlertExpression.
+const ConditionCompound = "compound"
+
+// Operators of compound alert expressions.
+const (
+	ExpressionAnd = "and"
+	ExpressionOr  = "or"
+	ExpressionNot = "not"
+)
+
+// Limits on the size of compound expressions.
+const (
+	expressionMaxDepth  = 4
+	expressionMaxLeaves = 16
+)
+
+// AlertExpression is a node of a compound alert. An operator node sets Op
+// and Conditions (exactly one for not); a leaf sets ConditionType with its
+// value and params, like a standalone alert, and optionally the MarketID it
+// is evaluated on (default: the alert's own market). For example "BTC and
+// ETH 5m RSI below 25":
+//
+//	{"op":"and","conditions":[
+//	  {"marketId":"BI:SPOT:BTCUSDT","conditionType":"indicator","conditionParams":{...}},
+//	  {"marketId":"BI:SPOT:ETHUSDT","conditionType":"indicator","conditionParams":{...}}]}
+type AlertExpression struct {
+	Op         string            `json:"op,omitempty"`
+	Conditions []AlertExpression `json:"conditions,omitempty"`
+
+	MarketID        string          `json:"marketId,omitempty"`
+	ConditionType   string          `json:"conditionType,omitempty"`
+	ConditionValue  *float64        `json:"conditionValue,omitempty"`
+	ConditionParams json.RawMessage `json:"conditionParams,omitempty"`
+}
+
+// ParseAlertExpression decodes and validates compound conditionParams.
+func ParseAlertExpression(params json.RawMessage) (AlertExpression, error) {
+	var x AlertExpression
+	if err := decodeConditionParams(params, &x); err != nil {
+		return x, err
+	}
+	leaves := 0
+	if err := x.validate(1, &leaves); err != nil {
+		return x, err
+	}
+	return x, nil
+}
+
+func (x *AlertExpression) validate(depth int, leaves *int) error {
+	if depth > expressionMaxDepth {
+		return fmt.Errorf("%w: expression nested deeper than %d levels", ErrInvalidCondition, expressionMaxDepth)
+	}
+	if x.Op == "" {
+		return x.validateLeaf(leaves)
+	}
+	if x.ConditionType != "" || x.MarketID != "" {
+		return fmt.Errorf("%w: operator nodes take only op and conditions", ErrInvalidCondition)
+	}
+	switch x.Op {
+	case ExpressionAnd, ExpressionOr:
+		if len(x.Conditions) < 2 {
+			return fmt.Errorf("%w: %s needs at least two conditions", ErrInvalidCondition, x.Op)
+		}
+	case ExpressionNot:
+		if len(x.Conditions) != 1 {
+			return fmt.Errorf("%w: not takes exactly one condition", ErrInvalidCondition)
+		}
+	default:
+		return fmt.Errorf("%w: unknown operator %q", ErrInvalidCondition, x.Op)
+	}
+	for i := range x.Conditions {
+		if err := x.Conditions[i].validate(depth+1, leaves); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *AlertExpression) validateLeaf(leaves *int) error {
+	*leaves++
+	if *leaves > expressionMaxLeaves {
+		return fmt.Errorf("%w: more than %d conditions", ErrInvalidCondition, expressionMaxLeaves)
+	}
+	if len(x.Conditions) > 0 {
+		return fmt.Errorf("%w: conditions without op", ErrInvalidCondition)
+	}
+	if x.ConditionType == "" || x.ConditionType == ConditionCompound {
+		return fmt.Errorf("%w: leaf needs a conditionType other than compound", ErrInvalidCondition)
+	}
+	if !IsAlertCondition(x.ConditionType) {
+		return fmt.Errorf("%w: unknown conditionType %q", ErrInvalidCondition, x.ConditionType)
+	}
+	if IsWindowCondition(x.ConditionType) || IsCrossCondition(x.ConditionType) {
+		return fmt.Errorf("%w: %s is only supported as a standalone alert", ErrInvalidCondition, x.ConditionType)
+	}
+	if x.MarketID != "" {
+		x.MarketID = strings.ToUpper(strings.TrimSpace(x.MarketID))
+		if !isAlertMarketID(x.MarketID) {
+			return fmt.Errorf("%w: invalid marketId %q", ErrInvalidCondition, x.MarketID)
+		}
+	}
+	if IsPerpCondition(x.ConditionType) && x.MarketID != "" && !isPerpMarket(x.MarketID) {
+		return fmt.Errorf("%w: %s needs a PERP marketId", ErrInvalidCondition, x.ConditionType)
+	}
+	value := 0.0
+	if ConditionTakesValue(x.ConditionType) {
+		if x.ConditionValue == nil {
+			return fmt.Errorf("%w: %s needs a conditionValue", ErrInvalidCondition, x.ConditionType)
+		}
+		value = *x.ConditionValue
+	} else {
+		x.ConditionValue = nil
+	}
+	return ValidateAlertCondition(x.ConditionType, value, x.ConditionParams)
+}
+
+// leaves calls fn for every leaf, in order, and stops at the first error.
+func (x *AlertExpression) leaves(fn func(leaf *AlertExpression) error) error {
+	if x.Op == "" {
+		return fn(x)
+	}
+	for i := range x.Conditions {
+		if err := x.Conditions[i].leaves(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Evaluate evaluates the expression with leaf, short-circuiting and/or. It
+// returns the results of the leaves that were evaluated, in order.
+func (x AlertExpression) Evaluate(leaf func(AlertExpression) (ConditionResult, error)) (bool, []ConditionResult, error) {
+	results := make([]ConditionResult, 0)
+	met, err := x.evaluate(leaf, &results)
+	return met, results, err
+}
+
+func (x AlertExpression) evaluate(leaf func(AlertExpression) (ConditionResult, error), results *[]ConditionResult) (bool, error) {
+	switch x.Op {
+	case "":
+		r, err := leaf(x)
+		if err != nil {
+			return false, err
+		}
+		*results = append(*results, r)
+		return r.Met, nil
+	case ExpressionNot:
+		met, err := x.Conditions[0].evaluate(leaf, results)
+		return !met, err
+	default:
+		for _, c := range x.Conditions {
+			met, err := c.evaluate(leaf, results)
+			if err != nil {
+				return false, err
+			}
+			if met == (x.Op == ExpressionOr) {
+				return met, nil
+			}
+		}
+		return x.Op == ExpressionAnd, nil
+	}
+}
+
+// isAlertMarketID checks the shape of a marketId such as BI:SPOT:BTCUSDT.
+func isAlertMarketID(marketID string) bool {
+	parts := strings.Split(marketID, ":")
+	if len(parts) != 3 || parts[2] == "" {
+		return false
+	}
+	return (parts[0] == "BI" || parts[0] == "BY") && (parts[1] == "SPOT" || parts[1] == "PERP")
+}
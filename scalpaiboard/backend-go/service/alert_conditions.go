@@ -0,0 +1,491 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Alert condition types evaluated on the ticker of a perpetual. Values are
+// percentages: the funding rate per funding period, and the basis of the
+// mark price over the index price.
+const (
+	ConditionFundingAbove = "funding_above"
+	ConditionFundingBelow = "funding_below"
+	ConditionBasisAbove   = "basis_above"
+	ConditionBasisBelow   = "basis_below"
+)
+
+// Price sources of price conditions. Alerts on perpetuals may trigger on the
+// mark price instead of the last traded price.
+const (
+	PriceSourceLast = "last"
+	PriceSourceMark = "mark"
+)
+
+// Alert condition types evaluated on candles rather than the 24h ticker.
+const (
+	// ConditionCandlePattern fires when a candlestick pattern with strength
+	// >= condition_value forms on the last closed bar.
+	ConditionCandlePattern = "candle_pattern"
+	// ConditionDivergence fires when a price/oscillator divergence with
+	// strength >= condition_value is confirmed on the last closed bar.
+	ConditionDivergence = "divergence"
+	// ConditionTechnicalRating fires when the technical rating of the last
+	// closed bar changes to the configured rating.
+	ConditionTechnicalRating = "technical_rating"
+	// ConditionRegimeChange fires when a regime event (e.g.
+	// squeeze_released) occurs on the last closed bar.
+	ConditionRegimeChange = "regime_change"
+	// ConditionCustomFormula fires on a user formula, see
+	// CustomFormulaConditionParams.
+	ConditionCustomFormula = "custom_formula"
+	// ConditionIndicator compares an indicator with a value or another
+	// indicator, see IndicatorConditionParams. It has no condition_value.
+	ConditionIndicator = "indicator"
+)
+
+// Triggers for custom_formula alerts.
+const (
+	// FormulaTriggerBecomesTrue fires when the formula turns non-zero.
+	FormulaTriggerBecomesTrue = "becomes_true"
+	// FormulaTriggerCrossesAbove fires when the formula crosses above
+	// condition_value.
+	FormulaTriggerCrossesAbove = "crosses_above"
+	// FormulaTriggerCrossesBelow fires when the formula crosses below
+	// condition_value.
+	FormulaTriggerCrossesBelow = "crosses_below"
+)
+
+// ErrInvalidCondition is returned when an alert condition or its params
+// fail validation.
+var ErrInvalidCondition = errors.New("invalid alert condition")
+
+// PatternConditionParams configures a candle_pattern alert. Pattern and
+// Direction are optional filters; when both are empty any pattern matches.
+type PatternConditionParams struct {
+	Interval  string `json:"interval"`
+	Pattern   string `json:"pattern,omitempty"`
+	Direction string `json:"direction,omitempty"`
+}
+
+// DivergenceConditionParams configures a divergence alert. Type and
+// Direction are optional filters; PivotWidth and Lookback default to
+// DefaultDivergenceOptions.
+type DivergenceConditionParams struct {
+	Interval   string `json:"interval"`
+	Indicator  string `json:"indicator"`
+	Type       string `json:"type,omitempty"`
+	Direction  string `json:"direction,omitempty"`
+	PivotWidth int    `json:"pivotWidth,omitempty"`
+	Lookback   int    `json:"lookback,omitempty"`
+}
+
+// RatingConditionParams configures a technical_rating alert.
+type RatingConditionParams struct {
+	Interval string `json:"interval"`
+	Rating   string `json:"rating"`
+}
+
+// RegimeConditionParams configures a regime_change alert. To optionally
+// restricts the regime changed into (e.g. "expanding").
+type RegimeConditionParams struct {
+	Interval string `json:"interval"`
+	Event    string `json:"event"`
+	To       string `json:"to,omitempty"`
+}
+
+// CustomFormulaConditionParams configures a custom_formula alert. Formula
+// is the expression evaluated; when an alert is created from a saved
+// indicator, Indicator holds its name and Formula a snapshot of its source.
+// Trigger defaults to becomes_true.
+type CustomFormulaConditionParams struct {
+	Interval  string `json:"interval"`
+	Formula   string `json:"formula"`
+	Indicator string `json:"indicator,omitempty"`
+	Trigger   string `json:"trigger,omitempty"`
+}
+
+func (p DivergenceConditionParams) options() DivergenceOptions {
+	opts := DefaultDivergenceOptions()
+	if p.Indicator != "" {
+		opts.Indicator = p.Indicator
+	}
+	if p.PivotWidth > 0 {
+		opts.PivotWidth = p.PivotWidth
+	}
+	if p.Lookback > 0 {
+		opts.Lookback = p.Lookback
+	}
+	return opts
+}
+
+// IsCandleCondition reports whether conditionType is evaluated on candles.
+func IsCandleCondition(conditionType string) bool {
+	switch conditionType {
+	case ConditionCandlePattern, ConditionDivergence, ConditionTechnicalRating, ConditionRegimeChange,
+		ConditionCustomFormula, ConditionIndicator:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsAlertCondition reports whether conditionType is a known condition.
+func IsAlertCondition(conditionType string) bool {
+	switch conditionType {
+	case "price_above", "price_below", "volume_above", "volume_below", ConditionCompound,
+		ConditionPriceCrossesAbove, ConditionPriceCrossesBelow:
+		return true
+	}
+	return IsPerpCondition(conditionType) || IsCandleCondition(conditionType) || IsWindowCondition(conditionType) ||
+		IsDrawingCondition(conditionType)
+}
+
+// IsPerpCondition reports whether conditionType needs a perpetual market.
+func IsPerpCondition(conditionType string) bool {
+	switch conditionType {
+	case ConditionFundingAbove, ConditionFundingBelow, ConditionBasisAbove, ConditionBasisBelow:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsPriceCondition reports whether conditionType compares the price with
+// condition_value, and so can use the mark price.
+func IsPriceCondition(conditionType string) bool {
+	switch conditionType {
+	case "price_above", "price_below", ConditionPriceCrossesAbove, ConditionPriceCrossesBelow:
+		return true
+	default:
+		return false
+	}
+}
+
+// ConditionTakesValue reports whether conditionType reads condition_value.
+// The others are described entirely by their conditionParams.
+func ConditionTakesValue(conditionType string) bool {
+	return conditionType != ConditionIndicator && conditionType != ConditionCompound && !IsDrawingCondition(conditionType)
+}
+
+// ValidateAlertCondition checks the params of conditions that take them.
+// Threshold-only condition types are accepted as-is.
+func ValidateAlertCondition(conditionType string, value float64, params json.RawMessage) error {
+	switch conditionType {
+	case ConditionCandlePattern:
+		p, err := parsePatternParams(params)
+		if err != nil {
+			return err
+		}
+		if value < 0 || value > 1 {
+			return fmt.Errorf("%w: pattern strength must be between 0 and 1", ErrInvalidCondition)
+		}
+		if !isAlertInterval(p.Interval) {
+			return fmt.Errorf("%w: unsupported interval %q", ErrInvalidCondition, p.Interval)
+		}
+		if p.Pattern != "" && !IsCandlestickPattern(p.Pattern) {
+			return fmt.Errorf("%w: unknown pattern %q", ErrInvalidCondition, p.Pattern)
+		}
+		switch p.Direction {
+		case "", DirectionBullish, DirectionBearish, DirectionNeutral:
+		default:
+			return fmt.Errorf("%w: unknown direction %q", ErrInvalidCondition, p.Direction)
+		}
+		return nil
+	case ConditionDivergence:
+		var p DivergenceConditionParams
+		if err := decodeConditionParams(params, &p); err != nil {
+			return err
+		}
+		if value < 0 || value > 1 {
+			return fmt.Errorf("%w: divergence strength must be between 0 and 1", ErrInvalidCondition)
+		}
+		if !isAlertInterval(p.Interval) {
+			return fmt.Errorf("%w: unsupported interval %q", ErrInvalidCondition, p.Interval)
+		}
+		opts := p.options()
+		if !IsDivergenceIndicator(opts.Indicator) {
+			return fmt.Errorf("%w: unknown indicator %q", ErrInvalidCondition, p.Indicator)
+		}
+		if opts.PivotWidth > structureMaxSwingWidth || opts.Lookback <= opts.PivotWidth {
+			return fmt.Errorf("%w: invalid pivotWidth/lookback", ErrInvalidCondition)
+		}
+		switch p.Type {
+		case "", DivergenceRegular, DivergenceHidden:
+		default:
+			return fmt.Errorf("%w: unknown divergence type %q", ErrInvalidCondition, p.Type)
+		}
+		switch p.Direction {
+		case "", DirectionBullish, DirectionBearish:
+		default:
+			return fmt.Errorf("%w: unknown direction %q", ErrInvalidCondition, p.Direction)
+		}
+		return nil
+	case ConditionTechnicalRating:
+		var p RatingConditionParams
+		if err := decodeConditionParams(params, &p); err != nil {
+			return err
+		}
+		if !isAlertInterval(p.Interval) {
+			return fmt.Errorf("%w: unsupported interval %q", ErrInvalidCondition, p.Interval)
+		}
+		if !IsRating(p.Rating) {
+			return fmt.Errorf("%w: unknown rating %q", ErrInvalidCondition, p.Rating)
+		}
+		return nil
+	case ConditionRegimeChange:
+		var p RegimeConditionParams
+		if err := decodeConditionParams(params, &p); err != nil {
+			return err
+		}
+		if !isAlertInterval(p.Interval) {
+			return fmt.Errorf("%w: unsupported interval %q", ErrInvalidCondition, p.Interval)
+		}
+		if !IsRegimeEvent(p.Event) {
+			return fmt.Errorf("%w: unknown regime event %q", ErrInvalidCondition, p.Event)
+		}
+		return nil
+	case ConditionCustomFormula:
+		var p CustomFormulaConditionParams
+		if err := decodeConditionParams(params, &p); err != nil {
+			return err
+		}
+		if !isAlertInterval(p.Interval) {
+			return fmt.Errorf("%w: unsupported interval %q", ErrInvalidCondition, p.Interval)
+		}
+		if _, err := ParseFormula(p.Formula); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidCondition, err)
+		}
+		switch p.Trigger {
+		case "", FormulaTriggerBecomesTrue, FormulaTriggerCrossesAbove, FormulaTriggerCrossesBelow:
+		default:
+			return fmt.Errorf("%w: unknown trigger %q", ErrInvalidCondition, p.Trigger)
+		}
+		return nil
+	case ConditionIndicator:
+		var p IndicatorConditionParams
+		if err := decodeConditionParams(params, &p); err != nil {
+			return err
+		}
+		return p.validate()
+	case ConditionCompound:
+		_, err := ParseAlertExpression(params)
+		return err
+	case ConditionPriceChange, ConditionVolumeSpike:
+		var p WindowConditionParams
+		if err := decodeConditionParams(params, &p); err != nil {
+			return err
+		}
+		return validateWindowCondition(conditionType, value, p)
+	case ConditionDrawingCross, ConditionDrawingZone:
+		var p DrawingConditionParams
+		if err := decodeConditionParams(params, &p); err != nil {
+			return err
+		}
+		return validateDrawingCondition(conditionType, p)
+	default:
+		return nil
+	}
+}
+
+// CandleConditionInterval returns the candle interval a candle-based
+// condition is evaluated on.
+func CandleConditionInterval(conditionType string, params json.RawMessage) (string, error) {
+	switch conditionType {
+	case ConditionCandlePattern:
+		p, err := parsePatternParams(params)
+		return p.Interval, err
+	case ConditionDivergence:
+		var p DivergenceConditionParams
+		err := decodeConditionParams(params, &p)
+		return p.Interval, err
+	case ConditionTechnicalRating:
+		var p RatingConditionParams
+		err := decodeConditionParams(params, &p)
+		return p.Interval, err
+	case ConditionRegimeChange:
+		var p RegimeConditionParams
+		err := decodeConditionParams(params, &p)
+		return p.Interval, err
+	case ConditionCustomFormula:
+		var p CustomFormulaConditionParams
+		err := decodeConditionParams(params, &p)
+		return p.Interval, err
+	case ConditionIndicator:
+		var p IndicatorConditionParams
+		err := decodeConditionParams(params, &p)
+		return p.Interval, err
+	default:
+		return "", fmt.Errorf("%w: %q is not a candle condition", ErrInvalidCondition, conditionType)
+	}
+}
+
+// CandleConditionBars returns how many candles a candle-based condition
+// needs, including the forming bar.
+func CandleConditionBars(conditionType string, params json.RawMessage) int {
+	if conditionType == ConditionIndicator {
+		var p IndicatorConditionParams
+		if decodeConditionParams(params, &p) == nil {
+			return p.bars() + 1
+		}
+	}
+	return 200
+}
+
+// CandleConditionIntrabar reports whether a candle-based condition is
+// evaluated on the forming bar rather than on the last closed one.
+func CandleConditionIntrabar(conditionType string, params json.RawMessage) bool {
+	if conditionType != ConditionIndicator {
+		return false
+	}
+	var p IndicatorConditionParams
+	return decodeConditionParams(params, &p) == nil && p.Evaluate == EvaluateIntrabar
+}
+
+// CandleConditionMet evaluates a candle-based condition against closed
+// candles (ascending, the forming bar already removed; intrabar conditions
+// get it as the last candle). Only signals on the last bar count, so each
+// bar can fire at most once.
+func CandleConditionMet(conditionType string, value float64, params json.RawMessage, closed []Candle) (bool, error) {
+	if len(closed) == 0 {
+		return false, nil
+	}
+	lastIdx := len(closed) - 1
+
+	switch conditionType {
+	case ConditionCandlePattern:
+		p, err := parsePatternParams(params)
+		if err != nil {
+			return false, err
+		}
+		hits := FilterPatternHits(DetectCandlestickPatterns(closed), nil, lastIdx)
+		for _, hit := range hits {
+			if p.Pattern != "" && hit.Pattern != p.Pattern {
+				continue
+			}
+			if p.Direction != "" && hit.Direction != p.Direction {
+				continue
+			}
+			if hit.Strength >= value {
+				return true, nil
+			}
+		}
+		return false, nil
+	case ConditionDivergence:
+		var p DivergenceConditionParams
+		if err := decodeConditionParams(params, &p); err != nil {
+			return false, err
+		}
+		divs, err := DetectDivergences(closed, p.options())
+		if err != nil {
+			return false, err
+		}
+		for _, d := range divs {
+			if d.DetectedIndex != lastIdx {
+				continue
+			}
+			if p.Type != "" && d.Type != p.Type {
+				continue
+			}
+			if p.Direction != "" && d.Direction != p.Direction {
+				continue
+			}
+			if d.Strength >= value {
+				return true, nil
+			}
+		}
+		return false, nil
+	case ConditionTechnicalRating:
+		var p RatingConditionParams
+		if err := decodeConditionParams(params, &p); err != nil {
+			return false, err
+		}
+		if len(closed) < 2 {
+			return false, nil
+		}
+		current, err := RatingOf(closed)
+		if err != nil {
+			return false, err
+		}
+		if current.Rating != p.Rating {
+			return false, nil
+		}
+		previous, err := RatingOf(closed[:lastIdx])
+		if err != nil {
+			return false, err
+		}
+		return previous.Rating != p.Rating, nil
+	case ConditionRegimeChange:
+		var p RegimeConditionParams
+		if err := decodeConditionParams(params, &p); err != nil {
+			return false, err
+		}
+		_, events, err := RegimeChanges(closed)
+		if err != nil {
+			return false, err
+		}
+		for _, ev := range events {
+			if ev.Type == p.Event && (p.To == "" || ev.To == p.To) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case ConditionCustomFormula:
+		var p CustomFormulaConditionParams
+		if err := decodeConditionParams(params, &p); err != nil {
+			return false, err
+		}
+		f, err := ParseFormula(p.Formula)
+		if err != nil {
+			return false, err
+		}
+		series, err := f.Evaluate(closed)
+		if err != nil || len(series) < 2 {
+			return false, err
+		}
+		prev, cur := series[lastIdx-1], series[lastIdx]
+		if math.IsNaN(prev) || math.IsNaN(cur) {
+			return false, nil
+		}
+		switch p.Trigger {
+		case FormulaTriggerCrossesAbove:
+			return prev <= value && cur > value, nil
+		case FormulaTriggerCrossesBelow:
+			return prev >= value && cur < value, nil
+		default:
+			return prev == 0 && cur != 0, nil
+		}
+	case ConditionIndicator:
+		var p IndicatorConditionParams
+		if err := decodeConditionParams(params, &p); err != nil {
+			return false, err
+		}
+		return IndicatorConditionMet(p, closed), nil
+	default:
+		return false, fmt.Errorf("%w: %q is not a candle condition", ErrInvalidCondition, conditionType)
+	}
+}
+
+// isAlertInterval accepts every kline interval up to one week.
+func isAlertInterval(interval string) bool {
+	_, ok := IntervalSeconds(interval)
+	return ok && interval != "1M"
+}
+
+func parsePatternParams(params json.RawMessage) (PatternConditionParams, error) {
+	var p PatternConditionParams
+	err := decodeConditionParams(params, &p)
+	return p, err
+}
+
+func decodeConditionParams(params json.RawMessage, dst interface{}) error {
+	if len(params) == 0 {
+		return fmt.Errorf("%w: conditionParams required", ErrInvalidCondition)
+	}
+	if err := json.Unmarshal(params, dst); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCondition, err)
+	}
+	return nil
+}
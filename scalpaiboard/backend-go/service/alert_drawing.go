@@ -0,0 +1,119 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/scalpaiboard/backend/models"
+)
+
+// Alert conditions on a chart drawing of the alert's market, evaluated by
+// the real-time engine against the drawing's value at the current time.
+// Both fire on the move only: the side of the drawing that price was on at
+// the previous evaluation is persisted like for price crosses.
+const (
+	// ConditionDrawingCross fires when price crosses a line drawing.
+	ConditionDrawingCross = "drawing_cross"
+	// ConditionDrawingZone fires when price enters or exits a zone drawing.
+	ConditionDrawingZone = "drawing_zone"
+)
+
+// Directions of drawing_cross alerts and events of drawing_zone alerts.
+const (
+	CrossAbove = "above"
+	CrossBelow = "below"
+
+	ZoneEnter = "enter"
+	ZoneExit  = "exit"
+)
+
+// DrawingConditionParams attaches an alert to a drawing, e.g. "price crosses
+// below this trendline" is {"drawingId":12,"direction":"below"} and "price
+// enters this zone" is {"drawingId":13}. Direction (drawing_cross, default
+// above) and Event (drawing_zone, default enter) apply to their condition
+// only.
+type DrawingConditionParams struct {
+	DrawingID int    `json:"drawingId"`
+	Direction string `json:"direction,omitempty"`
+	Event     string `json:"event,omitempty"`
+}
+
+// IsDrawingCondition reports whether conditionType is attached to a drawing.
+func IsDrawingCondition(conditionType string) bool {
+	return conditionType == ConditionDrawingCross || conditionType == ConditionDrawingZone
+}
+
+func validateDrawingCondition(conditionType string, p DrawingConditionParams) error {
+	if p.DrawingID <= 0 {
+		return fmt.Errorf("%w: drawingId required", ErrInvalidCondition)
+	}
+	if conditionType == ConditionDrawingCross {
+		switch p.Direction {
+		case "", CrossAbove, CrossBelow:
+		default:
+			return fmt.Errorf("%w: unknown direction %q", ErrInvalidCondition, p.Direction)
+		}
+		return nil
+	}
+	switch p.Event {
+	case "", ZoneEnter, ZoneExit:
+	default:
+		return fmt.Errorf("%w: unknown event %q", ErrInvalidCondition, p.Event)
+	}
+	return nil
+}
+
+// checkDrawing verifies that the drawing of a drawing condition belongs to
+// the user, is on the alert's market and has the right shape.
+func (s *AlertService) checkDrawing(userID, marketID, conditionType string, params []byte) error {
+	var p DrawingConditionParams
+	if err := decodeConditionParams(params, &p); err != nil {
+		return err
+	}
+	var drawingMarket, drawingType string
+	err := s.db.QueryRow("SELECT market_id, type FROM drawings WHERE id = $1 AND user_id = $2", p.DrawingID, userID).
+		Scan(&drawingMarket, &drawingType)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: unknown drawing %d", ErrInvalidCondition, p.DrawingID)
+	}
+	if err != nil {
+		return err
+	}
+	if drawingMarket != marketID {
+		return fmt.Errorf("%w: drawing %d is on %s, not %s", ErrInvalidCondition, p.DrawingID, drawingMarket, marketID)
+	}
+	if conditionType == ConditionDrawingCross && !IsLineDrawing(drawingType) {
+		return fmt.Errorf("%w: drawing_cross needs a line drawing, got %s", ErrInvalidCondition, drawingType)
+	}
+	if conditionType == ConditionDrawingZone && !IsZoneDrawing(drawingType) {
+		return fmt.Errorf("%w: drawing_zone needs a rect or channel drawing, got %s", ErrInvalidCondition, drawingType)
+	}
+	return nil
+}
+
+// drawingCondition evaluates a drawing condition at price and time now. It
+// returns whether price is on the firing side (above/below the line, inside
+// or outside the zone) and the drawing level nearest to price; ok is false
+// where the drawing does not extend.
+func drawingCondition(conditionType string, p DrawingConditionParams, d models.Drawing, price float64, now time.Time) (met bool, level float64, ok bool) {
+	t := now.Unix()
+	if conditionType == ConditionDrawingCross {
+		level, ok = DrawingLineAt(d, t)
+		if p.Direction == CrossBelow {
+			return price <= level, level, ok
+		}
+		return price >= level, level, ok
+	}
+	low, high, ok := DrawingZoneAt(d, t)
+	inside := price >= low && price <= high
+	level = low
+	if math.Abs(price-high) < math.Abs(price-low) {
+		level = high
+	}
+	if p.Event == ZoneExit {
+		return !inside, level, ok
+	}
+	return inside, level, ok
+}
@@ -0,0 +1,705 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scalpaiboard/backend/models"
+)
+
+const (
+	// alertEnginePollInterval is how often the engine checks for stale
+	// feeds and due kline loads.
+	alertEnginePollInterval = time.Second
+	// alertEngineFallbackInterval is how often a feed that the WebSocket
+	// stream has not updated in that time is fetched over REST. It stays
+	// under alertEngineStaleAfter so polled feeds remain covered.
+	alertEngineFallbackInterval = 5 * time.Second
+	// alertEngineReloadInterval is how often all alerts are reloaded, to
+	// pick up changes made outside this process.
+	alertEngineReloadInterval = 5 * time.Minute
+	// alertEngineStaleAfter is how long a market may go without a price
+	// before the cron evaluator takes its alerts back.
+	alertEngineStaleAfter = 10 * time.Second
+	alertEngineWorkers    = 10
+	// alertEngineKlineDelay is how long after a minute closes its 1m kline
+	// is fetched for the window volumes.
+	alertEngineKlineDelay = 3 * time.Second
+	// alertEngineRetryDelay is the pause after a failed history load.
+	alertEngineRetryDelay = 30 * time.Second
+	// alertEngineWriteQueue bounds the trigger state writes waiting for the
+	// database.
+	alertEngineWriteQueue = 1024
+)
+
+// AlertEngine evaluates price threshold alerts on every price update instead
+// of waiting for the evaluation cron. Active alerts are held in memory,
+// indexed by price feed and sorted by threshold, and reloaded from the database
+// whenever the AlertService reports a change. Markets with rolling-window
+// alerts also keep 24 hours of one-minute bars in memory.
+type AlertEngine struct {
+	db        *sql.DB
+	evaluator *AlertEvaluator
+	price     PriceFunc
+
+	mu      sync.Mutex
+	alerts  map[int]*engineAlert
+	markets map[string]*engineMarket
+
+	// writes persists trigger state changes and fires in order.
+	writes chan alertWrite
+}
+
+type engineAlert struct {
+	id               int
+	userID           string
+	marketID         string
+	priceSource      string
+	feed             string
+	conditionType    string
+	value            float64
+	notificationType string
+	window           WindowConditionParams
+	drawingParams    DrawingConditionParams
+	drawing          *models.Drawing
+	trigger          AlertTrigger
+}
+
+// sameCondition reports whether b evaluates the same condition as a.
+func (a *engineAlert) sameCondition(b *engineAlert) bool {
+	if a.conditionType != b.conditionType || a.value != b.value || a.window != b.window ||
+		a.drawingParams != b.drawingParams {
+		return false
+	}
+	if a.drawing == nil || b.drawing == nil {
+		return a.drawing == b.drawing
+	}
+	if len(a.drawing.Points) != len(b.drawing.Points) {
+		return false
+	}
+	for i := range a.drawing.Points {
+		if a.drawing.Points[i] != b.drawing.Points[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// engineMarket holds the alerts of one price feed: above in ascending and below in
+// descending threshold order, so a price update stops after the alerts
+// whose outcome can have changed since lastPrice. Markets with disarmed
+// rearm alerts are scanned fully (scanAll), as those wait for price to move
+// away from their threshold. windows holds the rolling-window alerts,
+// evaluated from ring, and drawings the alerts on chart drawings, whose
+// level moves with time.
+type engineMarket struct {
+	above     []*engineAlert
+	below     []*engineAlert
+	windows   []*engineAlert
+	drawings  []*engineAlert
+	scanAll   bool
+	lastPrice float64
+	ring      *priceRing
+	retryAt   time.Time
+	updatedAt time.Time
+	polledAt  time.Time
+}
+
+// engineFire is an alert that fired, with the price, the value that made it
+// fire and the threshold it was compared with.
+type engineFire struct {
+	alert     engineAlert
+	price     float64
+	value     float64
+	threshold float64
+}
+
+// engineOutcome collects the effects of evaluating a market's alerts: the
+// alerts that fired, those whose trigger state must be saved, and whether
+// any was deactivated.
+type engineOutcome struct {
+	fired       []engineFire
+	saved       []engineAlert
+	deactivated bool
+}
+
+// step advances an alert's trigger with one outcome of its condition. The
+// caller must hold e.mu.
+func (o *engineOutcome) step(a *engineAlert, now time.Time, met bool, price, value float64) {
+	o.stepAt(a, now, met, price, value, a.value)
+}
+
+// stepAt is step for conditions whose threshold is not the alert's value.
+func (o *engineOutcome) stepAt(a *engineAlert, now time.Time, met bool, price, value, threshold float64) {
+	fire, changed := a.trigger.step(a.conditionType, threshold, value, met, now)
+	switch {
+	case fire:
+		o.fired = append(o.fired, engineFire{alert: *a, price: price, value: value, threshold: threshold})
+	case changed:
+		o.saved = append(o.saved, *a)
+	}
+	if !a.trigger.Active {
+		o.deactivated = true
+	}
+}
+
+// NewAlertEngine creates the engine. The evaluator sends the notifications,
+// and its cron pass skips the alerts the engine keeps up to date.
+func NewAlertEngine(db *sql.DB, evaluator *AlertEvaluator, price PriceFunc) *AlertEngine {
+	e := &AlertEngine{
+		db:        db,
+		evaluator: evaluator,
+		price:     price,
+		alerts:    make(map[int]*engineAlert),
+		markets:   make(map[string]*engineMarket),
+		writes:    make(chan alertWrite, alertEngineWriteQueue),
+	}
+	evaluator.engine = e
+	return e
+}
+
+// isEngineCondition reports whether a condition is evaluated by the engine.
+func isEngineCondition(conditionType string) bool {
+	switch conditionType {
+	case "price_above", "price_below", ConditionPriceCrossesAbove, ConditionPriceCrossesBelow:
+		return true
+	}
+	return IsWindowCondition(conditionType) || IsDrawingCondition(conditionType)
+}
+
+// Start loads the active alerts and starts polling prices for markets the
+// WebSocket stream does not cover.
+func (e *AlertEngine) Start() {
+	if err := e.LoadAll(); err != nil {
+		log.Printf("⚠️ Alert engine: failed to load alerts: %v", err)
+	}
+	go func() {
+		// Writes queued meanwhile are committed together.
+		for w := range e.writes {
+			batch := []alertWrite{w}
+		drain:
+			for len(batch) < alertWriteBatch {
+				select {
+				case w := <-e.writes:
+					batch = append(batch, w)
+				default:
+					break drain
+				}
+			}
+			e.evaluator.writeTriggers(batch)
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(alertEnginePollInterval)
+		defer ticker.Stop()
+		lastReload := time.Now()
+		for now := range ticker.C {
+			if now.Sub(lastReload) >= alertEngineReloadInterval {
+				if err := e.LoadAll(); err != nil {
+					log.Printf("⚠️ Alert engine: failed to reload alerts: %v", err)
+				}
+				lastReload = now
+			}
+			e.poll(now)
+		}
+	}()
+	log.Println("⚡ Real-time alert engine started")
+}
+
+const engineAlertColumns = `a.id, a.user_id, a.market_id, a.price_source, a.condition_type,
+	COALESCE(a.condition_value, 0), a.condition_params, a.notification_type, d.type, d.points, ` + alertTriggerColumns
+
+// engineAlertFrom joins the drawing of drawing conditions.
+const engineAlertFrom = `
+	FROM alerts a
+	LEFT JOIN drawings d ON a.condition_type IN ('drawing_cross', 'drawing_zone')
+		AND d.id::text = a.condition_params->>'drawingId'`
+
+func scanEngineAlert(row rowScanner) (*engineAlert, error) {
+	var a engineAlert
+	var params, drawingPoints []byte
+	var drawingType sql.NullString
+	var tr triggerRow
+	dest := append([]interface{}{&a.id, &a.userID, &a.marketID, &a.priceSource, &a.conditionType, &a.value, &params,
+		&a.notificationType, &drawingType, &drawingPoints}, tr.dest()...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if IsWindowCondition(a.conditionType) {
+		if err := json.Unmarshal(params, &a.window); err != nil {
+			return nil, err
+		}
+	}
+	if IsDrawingCondition(a.conditionType) {
+		if err := json.Unmarshal(params, &a.drawingParams); err != nil {
+			return nil, err
+		}
+		if !drawingType.Valid {
+			return nil, fmt.Errorf("alert %d: drawing %d not found", a.id, a.drawingParams.DrawingID)
+		}
+		a.drawing = &models.Drawing{ID: a.drawingParams.DrawingID, Type: drawingType.String}
+		if err := json.Unmarshal(drawingPoints, &a.drawing.Points); err != nil {
+			return nil, err
+		}
+	}
+	a.feed = priceFeed(a.marketID, a.priceSource)
+	a.trigger = tr.trigger()
+	return &a, nil
+}
+
+// LoadAll replaces the in-memory alerts with the active ones in the database.
+func (e *AlertEngine) LoadAll() error {
+	rows, err := e.db.Query(`
+		SELECT ` + engineAlertColumns + engineAlertFrom + `
+		WHERE a.is_active = true
+		  AND a.condition_type IN ('price_above', 'price_below', 'price_crosses_above', 'price_crosses_below',
+			'price_change', 'volume_spike', 'drawing_cross', 'drawing_zone')
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	loaded := make([]*engineAlert, 0)
+	for rows.Next() {
+		a, err := scanEngineAlert(rows)
+		if err != nil {
+			log.Printf("⚠️ Alert engine: skipping alert: %v", err)
+			continue
+		}
+		loaded = append(loaded, a)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	old := e.alerts
+	e.alerts = make(map[int]*engineAlert, len(loaded))
+	for _, a := range loaded {
+		if prev, ok := old[a.id]; ok {
+			a.trigger.carry(prev.trigger, a.sameCondition(prev))
+		}
+		e.alerts[a.id] = a
+	}
+	for feed := range e.markets {
+		e.reindex(feed)
+	}
+	for _, a := range loaded {
+		e.reindex(a.feed)
+	}
+	return nil
+}
+
+// Reload refreshes one alert from the database after it was created,
+// updated or deleted. It is the AlertService change hook.
+func (e *AlertEngine) Reload(alertID int) {
+	a, err := scanEngineAlert(e.db.QueryRow(`
+		SELECT `+engineAlertColumns+engineAlertFrom+`
+		WHERE a.id = $1 AND a.is_active = true
+	`, alertID))
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("⚠️ Alert engine: failed to reload alert %d: %v", alertID, err)
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if prev, ok := e.alerts[alertID]; ok {
+		delete(e.alerts, alertID)
+		e.reindex(prev.feed)
+		if a != nil {
+			a.trigger.carry(prev.trigger, a.sameCondition(prev))
+		}
+	}
+	if a != nil && isEngineCondition(a.conditionType) {
+		e.alerts[a.id] = a
+		e.reindex(a.feed)
+	}
+}
+
+// ReloadDrawing refreshes the alerts attached to a drawing after it was
+// moved or deleted. It is the DrawingService change hook.
+func (e *AlertEngine) ReloadDrawing(drawingID int) {
+	ids := make(map[int]bool)
+	e.mu.Lock()
+	for id, a := range e.alerts {
+		if a.drawing != nil && a.drawing.ID == drawingID {
+			ids[id] = true
+		}
+	}
+	e.mu.Unlock()
+
+	rows, err := e.db.Query(`
+		SELECT id FROM alerts
+		WHERE is_active = true AND condition_type IN ('drawing_cross', 'drawing_zone')
+		  AND condition_params->>'drawingId' = $1
+	`, strconv.Itoa(drawingID))
+	if err != nil {
+		log.Printf("⚠️ Alert engine: failed to load alerts of drawing %d: %v", drawingID, err)
+	} else {
+		for rows.Next() {
+			var id int
+			if rows.Scan(&id) == nil {
+				ids[id] = true
+			}
+		}
+		rows.Close()
+	}
+	for id := range ids {
+		e.Reload(id)
+	}
+}
+
+// reindex rebuilds the sorted threshold lists of a price feed. The caller must
+// hold e.mu.
+func (e *AlertEngine) reindex(feed string) {
+	m := &engineMarket{}
+	prev, hadPrev := e.markets[feed]
+	if hadPrev {
+		m.updatedAt, m.lastPrice, m.polledAt = prev.updatedAt, prev.lastPrice, prev.polledAt
+	}
+	for _, a := range e.alerts {
+		if a.feed != feed {
+			continue
+		}
+		switch a.conditionType {
+		case "price_above", ConditionPriceCrossesAbove:
+			m.above = append(m.above, a)
+		case "price_below", ConditionPriceCrossesBelow:
+			m.below = append(m.below, a)
+		case ConditionDrawingCross, ConditionDrawingZone:
+			m.drawings = append(m.drawings, a)
+		default:
+			m.windows = append(m.windows, a)
+		}
+		if a.trigger.Mode == TriggerRearm && !a.trigger.Armed {
+			m.scanAll = true
+		}
+	}
+	if len(m.above) == 0 && len(m.below) == 0 && len(m.windows) == 0 && len(m.drawings) == 0 {
+		delete(e.markets, feed)
+		return
+	}
+	if len(m.windows) > 0 {
+		if hadPrev && prev.ring != nil {
+			m.ring, m.retryAt = prev.ring, prev.retryAt
+		} else {
+			m.ring = &priceRing{}
+		}
+		windows, volumeMinutes := make(map[int]bool), 0
+		for _, a := range m.windows {
+			window, baseline, err := a.window.minutes()
+			if err != nil {
+				continue
+			}
+			if a.conditionType == ConditionVolumeSpike {
+				volumeMinutes = max(volumeMinutes, window+baseline)
+			} else {
+				windows[window] = true
+			}
+		}
+		m.ring.track(windows, volumeMinutes, time.Now())
+	}
+	sort.Slice(m.above, func(i, j int) bool { return m.above[i].value < m.above[j].value })
+	sort.Slice(m.below, func(i, j int) bool { return m.below[i].value > m.below[j].value })
+	e.markets[feed] = m
+}
+
+// priceFeed is the feed an alert's price conditions are evaluated on: the
+// marketId for last prices, or a separate mark price feed.
+func priceFeed(marketID, priceSource string) string {
+	if priceSource == PriceSourceMark {
+		return marketID + markFeedSuffix
+	}
+	return marketID
+}
+
+// markFeedSuffix marks the mark price feeds of perpetuals.
+const markFeedSuffix = "|mark"
+
+// OnPrice evaluates a market's last price alerts against a new price. It is
+// fed by the WebSocket market data stream and by the engine's own polling.
+func (e *AlertEngine) OnPrice(marketID string, price float64) {
+	e.onFeed(marketID, price)
+}
+
+// onFeed evaluates the alerts of a price feed against a new price.
+func (e *AlertEngine) onFeed(feed string, price float64) {
+	if price <= 0 {
+		return
+	}
+	now := time.Now()
+
+	e.mu.Lock()
+	m, ok := e.markets[feed]
+	if !ok {
+		e.mu.Unlock()
+		return
+	}
+	m.updatedAt = now
+	high, low := price, price
+	if m.lastPrice > 0 {
+		high, low = math.Max(price, m.lastPrice), math.Min(price, m.lastPrice)
+	}
+	var out engineOutcome
+	for _, a := range m.above {
+		if a.value > high && !m.scanAll {
+			break
+		}
+		m.seedCross(a)
+		out.step(a, now, price >= a.value, price, price)
+	}
+	for _, a := range m.below {
+		if a.value < low && !m.scanAll {
+			break
+		}
+		m.seedCross(a)
+		out.step(a, now, price <= a.value, price, price)
+	}
+	for _, a := range m.drawings {
+		met, level, ok := drawingCondition(a.conditionType, a.drawingParams, *a.drawing, price, now)
+		if !ok {
+			continue
+		}
+		if !a.trigger.LastMet.Valid && m.lastPrice > 0 {
+			// Seed the side from the previous price, like seedCross.
+			prev, _, _ := drawingCondition(a.conditionType, a.drawingParams, *a.drawing, m.lastPrice, now)
+			a.trigger.LastMet = sql.NullBool{Bool: prev, Valid: true}
+		}
+		out.stepAt(a, now, met, price, price, level)
+	}
+	m.lastPrice = price
+	if m.ring != nil {
+		m.ring.addPrice(now, price)
+		for _, a := range m.windows {
+			if a.conditionType != ConditionPriceChange {
+				continue
+			}
+			met, move := m.ring.priceChange(a.window, a.value, price, now)
+			out.step(a, now, met, price, move)
+		}
+	}
+	e.finish(feed, &out)
+	e.mu.Unlock()
+
+	e.notify(out)
+}
+
+// seedCross sets the last outcome of a cross alert that was never evaluated
+// from the previous price, so its first cross is not missed.
+func (m *engineMarket) seedCross(a *engineAlert) {
+	if !IsCrossCondition(a.conditionType) || a.trigger.LastMet.Valid || m.lastPrice <= 0 {
+		return
+	}
+	met := m.lastPrice >= a.value
+	if a.conditionType == ConditionPriceCrossesBelow {
+		met = m.lastPrice <= a.value
+	}
+	a.trigger.LastMet = sql.NullBool{Bool: met, Valid: true}
+}
+
+// finish drops the alerts an evaluation deactivated and reindexes the
+// feed when a rearm alert may have changed state. The caller must hold
+// e.mu.
+func (e *AlertEngine) finish(feed string, out *engineOutcome) {
+	reindex := out.deactivated
+	for _, a := range out.saved {
+		reindex = reindex || a.trigger.Mode == TriggerRearm
+	}
+	for _, f := range out.fired {
+		reindex = reindex || f.alert.trigger.Mode == TriggerRearm
+	}
+	if !reindex {
+		return
+	}
+	for id, a := range e.alerts {
+		if a.feed == feed && !a.trigger.Active {
+			delete(e.alerts, id)
+		}
+	}
+	e.reindex(feed)
+}
+
+// notify queues the trigger state writes of an evaluation and the alerts
+// that fired. It runs on the market data stream, so it never waits for the
+// database: when the queue is full the write is dropped and logged.
+func (e *AlertEngine) notify(out engineOutcome) {
+	for _, a := range out.saved {
+		e.queue(alertWrite{alertID: a.id, trigger: a.trigger})
+	}
+	for _, f := range out.fired {
+		threshold := f.threshold
+		results := []ConditionResult{{
+			MarketID:      f.alert.marketID,
+			ConditionType: f.alert.conditionType,
+			Threshold:     &threshold,
+			Value:         roundTo(f.value, 8),
+			Met:           true,
+		}}
+		e.queue(alertWrite{
+			alertID:          f.alert.id,
+			userID:           f.alert.userID,
+			marketID:         f.alert.marketID,
+			conditionType:    f.alert.conditionType,
+			conditionValue:   f.threshold,
+			notificationType: f.alert.notificationType,
+			price:            f.price,
+			results:          results,
+			trigger:          f.alert.trigger,
+			fired:            true,
+		})
+	}
+}
+
+// queue hands a write to the writer goroutine without blocking.
+func (e *AlertEngine) queue(w alertWrite) {
+	select {
+	case e.writes <- w:
+	default:
+		log.Printf("⚠️ Alert engine: write queue full, dropped write for alert %d (fired=%v)", w.alertID, w.fired)
+	}
+}
+
+// Covers reports whether an alert is kept up to date by the engine, so the
+// cron evaluator can leave it alone.
+func (e *AlertEngine) Covers(alertID int) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	a, ok := e.alerts[alertID]
+	if !ok {
+		return false
+	}
+	m, ok := e.markets[a.feed]
+	return ok && time.Since(m.updatedAt) < alertEngineStaleAfter
+}
+
+// poll fetches prices for feeds that had no update in the fallback interval,
+// and loads the 1m klines that volume_spike alerts need: their window and
+// baseline once, then the minutes closed since.
+func (e *AlertEngine) poll(now time.Time) {
+	minute := now.Unix() / 60
+	klinesDue := now.Sub(time.Unix(minute*60, 0)) >= alertEngineKlineDelay
+
+	e.mu.Lock()
+	feeds := make([]string, 0, len(e.markets))
+	rings := make([]string, 0)
+	for feed, m := range e.markets {
+		if now.Sub(m.updatedAt) >= alertEngineFallbackInterval && now.Sub(m.polledAt) >= alertEngineFallbackInterval {
+			m.polledAt = now
+			feeds = append(feeds, feed)
+		}
+		r := m.ring
+		if r == nil || r.volumeMinutes == 0 || r.loading || now.Before(m.retryAt) {
+			continue
+		}
+		if !r.seeded || (klinesDue && r.refreshed < minute-1) {
+			r.loading = true
+			rings = append(rings, feed)
+		}
+	}
+	e.mu.Unlock()
+
+	sem := make(chan struct{}, alertEngineWorkers)
+	wg := sync.WaitGroup{}
+	run := func(fn func()) {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn()
+		}()
+	}
+	for _, feed := range feeds {
+		feed := feed
+		run(func() {
+			price, err := e.feedPrice(feed)
+			if err != nil {
+				return
+			}
+			e.onFeed(feed, price)
+		})
+	}
+	for _, marketID := range rings {
+		marketID := marketID
+		run(func() { e.loadRing(marketID, now) })
+	}
+	wg.Wait()
+}
+
+// feedPrice fetches the current price of a feed.
+func (e *AlertEngine) feedPrice(feed string) (float64, error) {
+	marketID := strings.TrimSuffix(feed, markFeedSuffix)
+	if marketID == feed {
+		return e.price(marketID)
+	}
+	m, err := e.evaluator.marketData(marketID)
+	return m.MarkPrice, err
+}
+
+// loadRing merges the volumes of recent 1m klines into a market's history
+// (the volume_spike window and baseline on first load) and evaluates its
+// volume spike alerts on the minute that just closed.
+func (e *AlertEngine) loadRing(marketID string, now time.Time) {
+	e.mu.Lock()
+	m, ok := e.markets[marketID]
+	if !ok || m.ring == nil {
+		e.mu.Unlock()
+		return
+	}
+	// The klines since the last merged minute, with the forming one.
+	bars := m.ring.volumeMinutes + 1
+	if m.ring.seeded {
+		bars = min(bars, int(now.Unix()/60-m.ring.refreshed))
+	}
+	e.mu.Unlock()
+
+	candles, err := e.evaluator.candles(context.Background(), marketID, "1m", bars)
+
+	e.mu.Lock()
+	m, ok = e.markets[marketID]
+	if !ok || m.ring == nil {
+		e.mu.Unlock()
+		return
+	}
+	r := m.ring
+	r.loading = false
+	if err != nil {
+		m.retryAt = now.Add(alertEngineRetryDelay)
+		e.mu.Unlock()
+		log.Printf("⚠️ Alert engine: failed to load 1m klines for %s: %v", marketID, err)
+		return
+	}
+	for _, c := range candles {
+		r.addVolume(c)
+	}
+	closed := now.Unix()/60 - 1
+	r.seeded, r.refreshed = true, closed
+
+	var out engineOutcome
+	if _, present := r.volume(closed, closed); present > 0 && m.lastPrice > 0 {
+		for _, a := range m.windows {
+			if a.conditionType != ConditionVolumeSpike {
+				continue
+			}
+			if met, ratio, ok := r.volumeSpike(a.window, a.value, closed); ok {
+				out.step(a, now, met, m.lastPrice, ratio)
+			}
+		}
+	}
+	e.finish(marketID, &out)
+	e.mu.Unlock()
+
+	e.notify(out)
+}
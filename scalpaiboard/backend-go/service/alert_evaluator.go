@@ -1,202 +1,573 @@
 package service
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
+// alertEvalWorkers bounds the markets the scheduled pass evaluates
+// concurrently.
+const alertEvalWorkers = 8
+
+// MarketDataFunc returns the current market data of a marketId.
+type MarketDataFunc func(marketID string) (AlertMarketData, error)
+
 // AlertEvaluator handles scheduled alert evaluation
 type AlertEvaluator struct {
 	db           *sql.DB
 	notification *NotificationService
+	candles      CandleLoader
+	marketData   MarketDataFunc
+
+	// engine, when set, evaluates price alerts in real time; the scheduled
+	// pass only picks up the ones it is not keeping up to date.
+	engine *AlertEngine
+
+	// running is held by the scheduled pass, so passes do not overlap.
+	running sync.Mutex
+	statsMu sync.Mutex
+	stats   AlertEvaluationStats
 }
 
 // NewAlertEvaluator creates a new alert evaluator
-func NewAlertEvaluator(db *sql.DB, notification *NotificationService) *AlertEvaluator {
+func NewAlertEvaluator(db *sql.DB, notification *NotificationService, candles CandleLoader, marketData MarketDataFunc) *AlertEvaluator {
 	return &AlertEvaluator{
 		db:           db,
 		notification: notification,
+		candles:      candles,
+		marketData:   marketData,
 	}
 }
 
-// EvaluateAllAlerts checks all active alerts against current market prices
+// EvaluateAllAlerts checks all active alerts against current market prices.
+// Alerts are grouped by market and the markets evaluated by bounded
+// workers sharing one snapshot, so each price and candle series is fetched
+// once per pass; the resulting trigger writes are batched.
 func (e *AlertEvaluator) EvaluateAllAlerts() {
+	if !e.running.TryLock() {
+		log.Println("⏭️ Previous alert evaluation still running, skipping")
+		e.statsMu.Lock()
+		e.stats.Skipped++
+		e.statsMu.Unlock()
+		return
+	}
+	defer e.running.Unlock()
+
 	log.Println("🔍 Starting alert evaluation...")
+	start := time.Now()
+	e.expireAlerts()
 
-	// Get all active alerts grouped by coin
+	markets, alertCount, err := e.loadEvalAlerts()
+	if err != nil {
+		log.Printf("❌ Failed to fetch alerts: %v", err)
+		return
+	}
+
+	snap := newAlertSnapshot(e)
+	var (
+		mu        sync.Mutex
+		writes    []alertWrite
+		latencies []float64
+		failed    int
+	)
+	sem := make(chan struct{}, alertEvalWorkers)
+	wg := sync.WaitGroup{}
+	for _, alerts := range markets {
+		alerts := alerts
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			began := time.Now()
+			w, errs := e.evaluateMarket(snap, alerts)
+			mu.Lock()
+			writes = append(writes, w...)
+			latencies = append(latencies, float64(time.Since(began))/float64(time.Millisecond))
+			failed += errs
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	evaluated := time.Now()
+
+	triggeredCount := 0
+	for _, w := range writes {
+		if w.fired {
+			triggeredCount++
+		}
+	}
+	e.writeTriggers(writes)
+
+	e.recordStats(start, evaluated, time.Now(), alertCount, len(markets), triggeredCount, failed, latencies, snap)
+	log.Printf("✅ Evaluated %d alerts on %d markets in %s, triggered %d",
+		alertCount, len(markets), time.Since(start).Round(time.Millisecond), triggeredCount)
+}
+
+// evalAlert is an active alert loaded by the scheduled pass.
+type evalAlert struct {
+	id               int
+	userID           string
+	marketID         string
+	priceSource      string
+	conditionType    string
+	conditionValue   sql.NullFloat64
+	conditionParams  []byte
+	notificationType string
+	trigger          AlertTrigger
+}
+
+// loadEvalAlerts loads the alerts the scheduled pass evaluates, grouped by
+// marketId, and returns how many there are.
+func (e *AlertEvaluator) loadEvalAlerts() (map[string][]evalAlert, int, error) {
 	rows, err := e.db.Query(`
-		SELECT a.id, a.user_id, a.coin_id, c.symbol, a.condition_type, a.condition_value, 
-			   a.notification_type, a.last_triggered_at
+		SELECT a.id, a.user_id, a.market_id, a.price_source, a.condition_type, a.condition_value,
+			   a.condition_params, a.notification_type, ` + alertTriggerColumns + `
 		FROM alerts a
-		JOIN coins c ON a.coin_id = c.id
 		WHERE a.is_active = true
+		ORDER BY a.market_id, a.id
 	`)
 	if err != nil {
-		log.Printf("❌ Failed to fetch alerts: %v", err)
-		return
+		return nil, 0, err
 	}
 	defer rows.Close()
 
-	alertCount := 0
-	triggeredCount := 0
-
+	markets := make(map[string][]evalAlert)
+	count := 0
 	for rows.Next() {
-		var (
-			alertID          int
-			userID           string
-			coinID           int
-			symbol           string
-			conditionType    string
-			conditionValue   float64
-			notificationType string
-			lastTriggeredAt  sql.NullTime
-		)
-
-		if err := rows.Scan(&alertID, &userID, &coinID, &symbol, &conditionType,
-			&conditionValue, &notificationType, &lastTriggeredAt); err != nil {
+		var a evalAlert
+		var tr triggerRow
+		dest := append([]interface{}{&a.id, &a.userID, &a.marketID, &a.priceSource, &a.conditionType,
+			&a.conditionValue, &a.conditionParams, &a.notificationType}, tr.dest()...)
+		if err := rows.Scan(dest...); err != nil {
 			log.Printf("⚠️ Failed to scan alert: %v", err)
 			continue
 		}
 
-		alertCount++
+		// Rolling-window and drawing conditions are evaluated by the
+		// engine only.
+		if IsWindowCondition(a.conditionType) || IsDrawingCondition(a.conditionType) ||
+			(e.engine != nil && e.engine.Covers(a.id)) {
+			continue
+		}
+		a.trigger = tr.trigger()
+		markets[a.marketID] = append(markets[a.marketID], a)
+		count++
+	}
+	return markets, count, rows.Err()
+}
 
-		// Check debounce (5 minutes)
-		if lastTriggeredAt.Valid && time.Since(lastTriggeredAt.Time) < 5*time.Minute {
+// evaluateMarket evaluates the alerts of one market and returns the trigger
+// writes they need and the number of alerts that failed to evaluate.
+func (e *AlertEvaluator) evaluateMarket(snap *alertSnapshot, alerts []evalAlert) ([]alertWrite, int) {
+	writes := make([]alertWrite, 0)
+	failed := 0
+	for _, a := range alerts {
+		// Skip alerts in their cooldown, unless crosses or rearming need
+		// this evaluation.
+		now := time.Now()
+		trig := a.trigger
+		if !trig.ready(now) && !trig.watching(a.conditionType) {
 			continue
 		}
 
-		// Get current market data
-		marketData, err := e.getMarketData(symbol)
+		var (
+			met     bool
+			results []ConditionResult
+			err     error
+		)
+		lastTriggeredAt := sql.NullTime{Time: trig.LastTriggeredAt, Valid: !trig.LastTriggeredAt.IsZero()}
+		if a.conditionType == ConditionCompound {
+			met, results, err = e.evaluateCompound(snap, a.marketID, a.conditionParams, lastTriggeredAt)
+		} else {
+			var r ConditionResult
+			r, err = snap.evaluate(a.marketID, a.conditionType, a.priceSource, a.conditionValue, a.conditionParams, lastTriggeredAt)
+			met, results = r.Met, []ConditionResult{r}
+		}
 		if err != nil {
-			log.Printf("⚠️ Failed to get market data for %s: %v", symbol, err)
+			log.Printf("⚠️ Failed to evaluate alert %d: %v", a.id, err)
+			failed++
 			continue
 		}
 
-		// Evaluate condition
-		shouldTrigger := e.evaluateCondition(conditionType, conditionValue, marketData)
+		observed, price := 0.0, snap.price(a.marketID)
+		if len(results) == 1 {
+			observed = results[0].Value
+			if a.priceSource == PriceSourceMark {
+				price = observed
+			}
+		}
+		fire, changed := trig.step(a.conditionType, a.conditionValue.Float64, observed, met, now)
+		if !fire && !changed {
+			continue
+		}
+		writes = append(writes, alertWrite{
+			alertID:          a.id,
+			userID:           a.userID,
+			marketID:         a.marketID,
+			conditionType:    a.conditionType,
+			conditionValue:   a.conditionValue.Float64,
+			notificationType: a.notificationType,
+			price:            price,
+			results:          results,
+			trigger:          trig,
+			fired:            fire,
+		})
+	}
+	return writes, failed
+}
 
-		if shouldTrigger {
-			triggeredCount++
-			e.processTriggeredAlert(alertID, userID, symbol, conditionType, conditionValue,
-				notificationType, marketData.Price)
+// AlertEvaluationStats describes the last scheduled evaluation pass, with
+// totals since start. Market latencies are the time taken to evaluate the
+// alerts of one market, its market data and candle requests included.
+type AlertEvaluationStats struct {
+	LastRunAt          *time.Time `json:"lastRunAt,omitempty"`
+	DurationMs         float64    `json:"durationMs"`
+	EvaluateMs         float64    `json:"evaluateMs"`
+	WriteMs            float64    `json:"writeMs"`
+	Alerts             int        `json:"alerts"`
+	Markets            int        `json:"markets"`
+	Triggered          int        `json:"triggered"`
+	Errors             int        `json:"errors"`
+	MarketDataRequests int        `json:"marketDataRequests"`
+	CandleRequests     int        `json:"candleRequests"`
+	MarketLatencyP50Ms float64    `json:"marketLatencyP50Ms"`
+	MarketLatencyP95Ms float64    `json:"marketLatencyP95Ms"`
+	MarketLatencyMaxMs float64    `json:"marketLatencyMaxMs"`
+	Runs               int64      `json:"runs"`
+	Skipped            int64      `json:"skipped"`
+}
+
+// Stats returns the metrics of the scheduled evaluation.
+func (e *AlertEvaluator) Stats() AlertEvaluationStats {
+	e.statsMu.Lock()
+	defer e.statsMu.Unlock()
+	return e.stats
+}
+
+func (e *AlertEvaluator) recordStats(start, evaluated, done time.Time, alerts, markets, triggered, failed int,
+	latencies []float64, snap *alertSnapshot) {
+
+	ms := func(d time.Duration) float64 { return roundTo(float64(d)/float64(time.Millisecond), 1) }
+	e.statsMu.Lock()
+	defer e.statsMu.Unlock()
+	s := &e.stats
+	s.LastRunAt = &start
+	s.DurationMs = ms(done.Sub(start))
+	s.EvaluateMs = ms(evaluated.Sub(start))
+	s.WriteMs = ms(done.Sub(evaluated))
+	s.Alerts, s.Markets, s.Triggered, s.Errors = alerts, markets, triggered, failed
+	s.MarketDataRequests, s.CandleRequests = snap.requests()
+	s.MarketLatencyP50Ms, s.MarketLatencyP95Ms, s.MarketLatencyMaxMs = 0, 0, 0
+	if len(latencies) > 0 {
+		sort.Float64s(latencies)
+		s.MarketLatencyP50Ms = roundTo(quantile(latencies, 0.50), 1)
+		s.MarketLatencyP95Ms = roundTo(quantile(latencies, 0.95), 1)
+		s.MarketLatencyMaxMs = roundTo(latencies[len(latencies)-1], 1)
+	}
+	s.Runs++
+}
+
+// expireAlerts deactivates the alerts whose expiry has passed.
+func (e *AlertEvaluator) expireAlerts() {
+	rows, err := e.db.Query(`
+		UPDATE alerts
+		SET is_active = false, updated_at = NOW()
+		WHERE is_active = true AND expires_at <= NOW()
+		RETURNING id
+	`)
+	if err != nil {
+		log.Printf("❌ Failed to expire alerts: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var alertID int
+		if rows.Scan(&alertID) == nil && e.engine != nil {
+			e.engine.Reload(alertID)
 		}
 	}
+}
 
-	log.Printf("✅ Evaluated %d alerts, triggered %d", alertCount, triggeredCount)
+// evaluateCompound evaluates a compound alert whose leaves default to
+// marketID. Candle leaves get the alert's last trigger, so a bar signal
+// that already fired the alert does not hold it met for the rest of the
+// bar.
+func (e *AlertEvaluator) evaluateCompound(snap *alertSnapshot, marketID string, params []byte,
+	lastTriggeredAt sql.NullTime) (bool, []ConditionResult, error) {
+	x, err := ParseAlertExpression(params)
+	if err != nil {
+		return false, nil, err
+	}
+	return x.Evaluate(func(leaf AlertExpression) (ConditionResult, error) {
+		leafMarket := leaf.MarketID
+		if leafMarket == "" {
+			leafMarket = marketID
+		}
+		value := sql.NullFloat64{}
+		if leaf.ConditionValue != nil {
+			value = sql.NullFloat64{Float64: *leaf.ConditionValue, Valid: true}
+		}
+		return snap.evaluate(leafMarket, leaf.ConditionType, PriceSourceLast, value, leaf.ConditionParams, lastTriggeredAt)
+	})
 }
 
-// AlertMarketData holds current market information for alert evaluation
+// AlertMarketData holds current market information for alert evaluation.
+// Volume is the 24h base volume. MarkPrice, IndexPrice and FundingRate
+// (percent per funding period) are only set for perpetuals.
 type AlertMarketData struct {
-	Price  float64
-	Volume float64
+	Price       float64
+	Volume      float64
+	Perp        bool
+	MarkPrice   float64
+	IndexPrice  float64
+	FundingRate float64
 }
 
-// evaluateCondition checks if alert condition is met
-func (e *AlertEvaluator) evaluateCondition(conditionType string, value float64, market *AlertMarketData) bool {
-	switch conditionType {
-	case "price_above":
-		return market.Price >= value
-	case "price_below":
-		return market.Price <= value
-	case "volume_above":
-		return market.Volume >= value
-	case "volume_below":
-		return market.Volume <= value
-	default:
-		return false
+// Basis is the premium of the mark price over the index price in percent.
+func (m AlertMarketData) Basis() float64 {
+	if m.IndexPrice == 0 {
+		return 0
 	}
+	return 100 * (m.MarkPrice - m.IndexPrice) / m.IndexPrice
 }
 
-// getMarketData fetches current price from Binance
-func (e *AlertEvaluator) getMarketData(symbol string) (*AlertMarketData, error) {
-	url := fmt.Sprintf("https://api.binance.com/api/v3/ticker/24hr?symbol=%s", symbol)
+// ConditionResult records one evaluated condition of an alert: the market,
+// the observed value (price, volume, funding rate, basis, or the indicator
+// or last close for candle conditions) and whether it was met.
+type ConditionResult struct {
+	MarketID      string   `json:"marketId"`
+	ConditionType string   `json:"conditionType"`
+	Threshold     *float64 `json:"threshold,omitempty"`
+	Value         float64  `json:"value"`
+	Met           bool     `json:"met"`
+}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
+// alertSnapshot caches the market data and candles fetched during one
+// evaluation pass, so alerts on the same market and the conditions of a
+// compound alert share requests. It is safe for concurrent use; concurrent
+// requests for the same data wait for the first one.
+type alertSnapshot struct {
+	e *AlertEvaluator
+
+	mu      sync.Mutex
+	entries map[string]*snapshotEntry
+
+	marketRequests int
+	candleRequests int
+}
+
+// snapshotEntry is the cached market data or candles of one key.
+type snapshotEntry struct {
+	mu      sync.Mutex
+	loaded  bool
+	market  AlertMarketData
+	candles []Candle
+	err     error
+}
+
+func newAlertSnapshot(e *AlertEvaluator) *alertSnapshot {
+	return &alertSnapshot{
+		e:       e,
+		entries: make(map[string]*snapshotEntry),
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("binance API error: %d", resp.StatusCode)
+func (s *alertSnapshot) entry(key string) *snapshotEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	en, ok := s.entries[key]
+	if !ok {
+		en = &snapshotEntry{}
+		s.entries[key] = en
 	}
+	return en
+}
 
-	var result struct {
-		LastPrice string `json:"lastPrice"`
-		Volume    string `json:"volume"`
+// requests returns the number of market data and candle requests made.
+func (s *alertSnapshot) requests() (market, candles int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.marketRequests, s.candleRequests
+}
+
+// marketData returns the market data of marketID. Failures are cached too,
+// so a market that is down is not requested once per alert.
+func (s *alertSnapshot) marketData(marketID string) (AlertMarketData, error) {
+	en := s.entry(marketID)
+	en.mu.Lock()
+	defer en.mu.Unlock()
+	if !en.loaded {
+		en.market, en.err = s.e.marketData(marketID)
+		en.loaded = true
+		s.mu.Lock()
+		s.marketRequests++
+		s.mu.Unlock()
 	}
+	return en.market, en.err
+}
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+// recentCandles returns at least bars recent candles (fewer if the market
+// has less history), the last one forming.
+func (s *alertSnapshot) recentCandles(marketID, interval string, bars int) ([]Candle, error) {
+	en := s.entry(marketID + "|" + interval)
+	en.mu.Lock()
+	defer en.mu.Unlock()
+	if en.loaded && len(en.candles) >= bars {
+		return en.candles[len(en.candles)-bars:], nil
+	}
+	c, err := s.e.candles(context.Background(), marketID, interval, bars)
+	s.mu.Lock()
+	s.candleRequests++
+	s.mu.Unlock()
+	if err != nil {
 		return nil, err
 	}
+	en.candles, en.loaded = c, true
+	return c, nil
+}
+
+// price returns the last price of marketID for the notification message,
+// from what was already fetched when possible. Otherwise the market data is
+// loaded into the market's entry, so each market is requested at most once
+// per pass, failures included.
+func (s *alertSnapshot) price(marketID string) float64 {
+	market := s.entry(marketID)
+	market.mu.Lock()
+	price, loaded, err := market.market.Price, market.loaded, market.err
+	market.mu.Unlock()
+	if loaded && err == nil {
+		return price
+	}
 
-	var price, volume float64
-	fmt.Sscanf(result.LastPrice, "%f", &price)
-	fmt.Sscanf(result.Volume, "%f", &volume)
+	s.mu.Lock()
+	series := make([]*snapshotEntry, 0)
+	for key, en := range s.entries {
+		if strings.HasPrefix(key, marketID+"|") {
+			series = append(series, en)
+		}
+	}
+	s.mu.Unlock()
+	for _, en := range series {
+		en.mu.Lock()
+		c := en.candles
+		en.mu.Unlock()
+		if len(c) > 0 {
+			return c[len(c)-1].Close
+		}
+	}
 
-	return &AlertMarketData{Price: price, Volume: volume}, nil
+	if m, err := s.marketData(marketID); err == nil {
+		return m.Price
+	}
+	return 0
 }
 
-// processTriggeredAlert handles a triggered alert
-func (e *AlertEvaluator) processTriggeredAlert(alertID int, userID, symbol, conditionType string,
-	conditionValue float64, notificationType string, currentPrice float64) {
+// evaluate checks a single (non-compound) condition on marketID. Price
+// conditions compare the mark price when priceSource is mark.
+// lastTriggeredAt is the last trigger of the alert; candle conditions use
+// it to fire at most once per bar.
+func (s *alertSnapshot) evaluate(marketID, conditionType, priceSource string, value sql.NullFloat64, params []byte,
+	lastTriggeredAt sql.NullTime) (ConditionResult, error) {
 
-	log.Printf("🚨 Alert %d triggered for %s (price: $%.2f)", alertID, symbol, currentPrice)
+	r := ConditionResult{MarketID: marketID, ConditionType: conditionType}
+	if value.Valid && ConditionTakesValue(conditionType) {
+		v := value.Float64
+		r.Threshold = &v
+	}
 
-	// Update alert in database
-	_, err := e.db.Exec(`
-		UPDATE alerts 
-		SET triggered_count = triggered_count + 1, 
-			last_triggered_at = NOW(),
-			updated_at = NOW()
-		WHERE id = $1
-	`, alertID)
-	if err != nil {
-		log.Printf("❌ Failed to update alert %d: %v", alertID, err)
+	if IsCandleCondition(conditionType) {
+		met, observed, err := s.evaluateCandleCondition(marketID, conditionType, value.Float64, params, lastTriggeredAt)
+		r.Met, r.Value = met, observed
+		return r, err
 	}
 
-	// Save to history
-	var historyID int
-	err = e.db.QueryRow(`
-		INSERT INTO alert_history (alert_id, triggered_at, notification_status, notification_channel)
-		VALUES ($1, NOW(), 'pending', $2)
-		RETURNING id
-	`, alertID, notificationType).Scan(&historyID)
+	m, err := s.marketData(marketID)
 	if err != nil {
-		log.Printf("❌ Failed to save alert history: %v", err)
-		return
+		return r, fmt.Errorf("market data for %s: %w", marketID, err)
 	}
+	if IsPerpCondition(conditionType) && !m.Perp {
+		return r, fmt.Errorf("%s needs a perpetual market, got %s", conditionType, marketID)
+	}
+	if priceSource == PriceSourceMark && m.Perp {
+		m.Price = m.MarkPrice
+	}
+	r.Met, r.Value = evaluateCondition(conditionType, value.Float64, m)
+	return r, nil
+}
 
-	// Send notification
-	message := e.notification.FormatAlertMessage(symbol, conditionType, conditionValue, currentPrice)
-	var notifyErr error
-
-	switch notificationType {
-	case "telegram":
-		// Get user's telegram chat ID (would need to be stored in user profile)
-		notifyErr = e.notification.SendTelegram(0, message) // TODO: Get real chat ID
-	case "email":
-		notifyErr = e.notification.SendEmail("", "Alert Triggered", message) // TODO: Get real email
+// evaluateCondition checks if a ticker condition is met and returns the
+// value it compared.
+func evaluateCondition(conditionType string, value float64, market AlertMarketData) (bool, float64) {
+	switch conditionType {
+	case "price_above", ConditionPriceCrossesAbove:
+		return market.Price >= value, market.Price
+	case "price_below", ConditionPriceCrossesBelow:
+		return market.Price <= value, market.Price
+	case "volume_above":
+		return market.Volume >= value, market.Volume
+	case "volume_below":
+		return market.Volume <= value, market.Volume
+	case ConditionFundingAbove:
+		return market.FundingRate >= value, market.FundingRate
+	case ConditionFundingBelow:
+		return market.FundingRate <= value, market.FundingRate
+	case ConditionBasisAbove:
+		return market.Basis() >= value, market.Basis()
+	case ConditionBasisBelow:
+		return market.Basis() <= value, market.Basis()
 	default:
-		// in_app - just log it
-		log.Printf("📱 In-app notification: %s", message)
+		return false, 0
+	}
+}
+
+// evaluateCandleCondition checks a candle-based condition on the last closed
+// bar, or on the forming bar for intrabar conditions. It also returns the
+// observed value: the left indicator of indicator conditions, otherwise the
+// latest close. A bar fires at most once: alerts already triggered after
+// the forming bar opened are skipped.
+func (s *alertSnapshot) evaluateCandleCondition(marketID, conditionType string, value float64,
+	params []byte, lastTriggeredAt sql.NullTime) (bool, float64, error) {
+
+	interval, err := CandleConditionInterval(conditionType, params)
+	if err != nil {
+		return false, 0, err
 	}
 
-	// Update history status
-	status := "sent"
-	errMsg := ""
-	if notifyErr != nil {
-		status = "failed"
-		errMsg = notifyErr.Error()
+	candles, err := s.recentCandles(marketID, interval, CandleConditionBars(conditionType, params))
+	if err != nil {
+		return false, 0, err
+	}
+	if len(candles) < 2 {
+		return false, 0, nil
+	}
+
+	price := candles[len(candles)-1].Close
+	// The forming bar opens when the last closed bar closes.
+	closedAt := time.Unix(candles[len(candles)-1].Time, 0)
+	if lastTriggeredAt.Valid && !lastTriggeredAt.Time.Before(closedAt) {
+		return false, price, nil
 	}
 
-	_, _ = e.db.Exec(`
-		UPDATE alert_history 
-		SET notification_status = $1, error_message = $2
-		WHERE id = $3
-	`, status, errMsg, historyID)
+	bars := candles[:len(candles)-1]
+	if CandleConditionIntrabar(conditionType, params) {
+		bars = candles
+	}
+	met, err := CandleConditionMet(conditionType, value, params, bars)
+	if conditionType == ConditionIndicator {
+		var p IndicatorConditionParams
+		if decodeConditionParams(params, &p) == nil {
+			if v, ok := p.Left.last(bars); ok {
+				price = v
+			}
+		}
+	}
+	return met, price, err
 }
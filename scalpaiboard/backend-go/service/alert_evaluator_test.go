@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCandleConditionFiresOncePerBar(t *testing.T) {
+	// 1h candles whose last closed bar closes above 100 for the first
+	// time; the last candle is forming.
+	now := time.Now()
+	forming := now.Unix() - now.Unix()%3600
+	candles := make([]Candle, 0, 30)
+	for i := 29; i >= 0; i-- {
+		close := 90.0
+		if i <= 1 {
+			close = 110
+		}
+		candles = append(candles, Candle{Time: forming - int64(i)*3600, Open: close, High: close, Low: close, Close: close})
+	}
+	e := &AlertEvaluator{candles: func(ctx context.Context, marketID, interval string, bars int) ([]Candle, error) {
+		return candles, nil
+	}}
+
+	params := json.RawMessage(`{"interval":"1h","formula":"close > 100"}`)
+	zero := 0.0
+	leaf, _ := json.Marshal(AlertExpression{ConditionType: ConditionCustomFormula, ConditionValue: &zero, ConditionParams: params})
+	compound := json.RawMessage(`{"op":"or","conditions":[` + string(leaf) + `,` + string(leaf) + `]}`)
+
+	tests := []struct {
+		name            string
+		lastTriggeredAt sql.NullTime
+		met             bool
+	}{
+		{"never triggered", sql.NullTime{}, true},
+		{"triggered during an earlier bar", sql.NullTime{Time: time.Unix(forming-1800, 0), Valid: true}, true},
+		{"already triggered for this bar", sql.NullTime{Time: time.Unix(forming+1, 0), Valid: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newAlertSnapshot(e).evaluate("BI:SPOT:BTCUSDT", ConditionCustomFormula, PriceSourceLast,
+				sql.NullFloat64{}, params, tt.lastTriggeredAt)
+			if err != nil {
+				t.Fatalf("evaluate: %v", err)
+			}
+			if r.Met != tt.met {
+				t.Errorf("standalone met = %v, want %v", r.Met, tt.met)
+			}
+
+			met, _, err := e.evaluateCompound(newAlertSnapshot(e), "BI:SPOT:BTCUSDT", compound, tt.lastTriggeredAt)
+			if err != nil {
+				t.Fatalf("evaluateCompound: %v", err)
+			}
+			if met != tt.met {
+				t.Errorf("compound met = %v, want %v", met, tt.met)
+			}
+		})
+	}
+}
+
+func TestAlertSnapshotPrice(t *testing.T) {
+	tests := []struct {
+		name     string
+		candles  bool
+		fail     bool
+		price    float64
+		requests int
+	}{
+		{"market data once", false, false, 101, 1},
+		{"failures cached", false, true, 0, 1},
+		{"fetched candles first", true, false, 99, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			e := &AlertEvaluator{
+				marketData: func(marketID string) (AlertMarketData, error) {
+					calls++
+					if tt.fail {
+						return AlertMarketData{}, errors.New("down")
+					}
+					return AlertMarketData{Price: 101}, nil
+				},
+				candles: func(ctx context.Context, marketID, interval string, bars int) ([]Candle, error) {
+					return closeCandles(98, 99), nil
+				},
+			}
+			snap := newAlertSnapshot(e)
+			if tt.candles {
+				if _, err := snap.recentCandles("BI:SPOT:BTCUSDT", "1h", 2); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i := 0; i < 3; i++ {
+				if got := snap.price("BI:SPOT:BTCUSDT"); got != tt.price {
+					t.Errorf("price() = %v, want %v", got, tt.price)
+				}
+			}
+			if market, _ := snap.requests(); market != tt.requests || calls != tt.requests {
+				t.Errorf("market data requests = %d (%d calls), want %d", market, calls, tt.requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package service
+
+import (
+	"fmt"
+	"math"
+)
+
+// Operators of indicator conditions.
+const (
+	IndicatorAbove        = "above"
+	IndicatorBelow        = "below"
+	IndicatorCrossesAbove = "crosses_above"
+	IndicatorCrossesBelow = "crosses_below"
+	// IndicatorCrosses fires on a cross in either direction.
+	IndicatorCrosses = "crosses"
+)
+
+// When indicator conditions are evaluated.
+const (
+	// EvaluateOnClose compares the last closed bar with the one before it.
+	EvaluateOnClose = "close"
+	// EvaluateIntrabar compares the forming bar with the last closed one,
+	// so the alert can fire before the bar closes (at most once per bar).
+	EvaluateIntrabar = "intrabar"
+)
+
+// IndicatorOperand is one side of an indicator condition: an indicator with
+// its parameters ({"indicator":"rsi","params":[14]}), a candle field
+// ({"indicator":"close"}) or a constant ({"value":30}). Missing parameters
+// take the defaults listed in indicatorDefaults.
+type IndicatorOperand struct {
+	Indicator string    `json:"indicator,omitempty"`
+	Params    []float64 `json:"params,omitempty"`
+	Value     *float64  `json:"value,omitempty"`
+}
+
+// IndicatorConditionParams configures an indicator alert, e.g. "RSI(14) on
+// 5m crosses below 30":
+//
+//	{"interval":"5m","left":{"indicator":"rsi","params":[14]},
+//	 "operator":"crosses_below","right":{"value":30}}
+//
+// "MACD histogram flips positive" is macd_hist crosses_above 0 and "price
+// outside the upper band" is close above bb_upper. Evaluate defaults to
+// close.
+type IndicatorConditionParams struct {
+	Interval string           `json:"interval"`
+	Left     IndicatorOperand `json:"left"`
+	Operator string           `json:"operator"`
+	Right    IndicatorOperand `json:"right"`
+	Evaluate string           `json:"evaluate,omitempty"`
+}
+
+// indicatorDefaults lists the operand indicators with their default
+// parameters. Candle fields take none.
+var indicatorDefaults = map[string][]float64{
+	"open":        nil,
+	"high":        nil,
+	"low":         nil,
+	"close":       nil,
+	"volume":      nil,
+	"sma":         {20},
+	"ema":         {20},
+	"rsi":         {14},
+	"macd":        {12, 26, 9},
+	"macd_signal": {12, 26, 9},
+	"macd_hist":   {12, 26, 9},
+	"bb_upper":    {20, 2},
+	"bb_middle":   {20, 2},
+	"bb_lower":    {20, 2},
+	"atr":         {14},
+	"natr":        {14},
+	"adx":         {14},
+}
+
+// indicatorMaxPeriod bounds period parameters so the candles needed fit in
+// one kline request.
+const indicatorMaxPeriod = 300
+
+func (p IndicatorConditionParams) validate() error {
+	if !isAlertInterval(p.Interval) {
+		return fmt.Errorf("%w: unsupported interval %q", ErrInvalidCondition, p.Interval)
+	}
+	switch p.Operator {
+	case IndicatorAbove, IndicatorBelow, IndicatorCrossesAbove, IndicatorCrossesBelow, IndicatorCrosses:
+	default:
+		return fmt.Errorf("%w: unknown operator %q", ErrInvalidCondition, p.Operator)
+	}
+	switch p.Evaluate {
+	case "", EvaluateOnClose, EvaluateIntrabar:
+	default:
+		return fmt.Errorf("%w: unknown evaluate mode %q", ErrInvalidCondition, p.Evaluate)
+	}
+	if p.Left.Value != nil {
+		return fmt.Errorf("%w: left operand must be an indicator", ErrInvalidCondition)
+	}
+	for _, o := range []IndicatorOperand{p.Left, p.Right} {
+		if err := o.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o IndicatorOperand) validate() error {
+	if o.Value != nil {
+		if o.Indicator != "" {
+			return fmt.Errorf("%w: operand has both indicator and value", ErrInvalidCondition)
+		}
+		return nil
+	}
+	defaults, ok := indicatorDefaults[o.Indicator]
+	if !ok {
+		return fmt.Errorf("%w: unknown indicator %q", ErrInvalidCondition, o.Indicator)
+	}
+	if len(o.Params) > len(defaults) {
+		return fmt.Errorf("%w: %s takes at most %d params", ErrInvalidCondition, o.Indicator, len(defaults))
+	}
+	params := o.params()
+	for i, v := range params {
+		// Bollinger's second parameter is a multiplier, everything else
+		// is a whole number of bars.
+		if isBandIndicator(o.Indicator) && i == 1 {
+			if v <= 0 {
+				return fmt.Errorf("%w: %s multiplier must be positive", ErrInvalidCondition, o.Indicator)
+			}
+			continue
+		}
+		if v < 1 || v > indicatorMaxPeriod || v != math.Trunc(v) {
+			return fmt.Errorf("%w: %s periods must be whole numbers between 1 and %d", ErrInvalidCondition, o.Indicator, indicatorMaxPeriod)
+		}
+	}
+	if isMACDIndicator(o.Indicator) && params[0] >= params[1] {
+		return fmt.Errorf("%w: MACD fast period must be below the slow period", ErrInvalidCondition)
+	}
+	return nil
+}
+
+// params returns the operand's parameters with defaults filled in.
+func (o IndicatorOperand) params() []float64 {
+	defaults := indicatorDefaults[o.Indicator]
+	params := make([]float64, len(defaults))
+	copy(params, defaults)
+	copy(params, o.Params)
+	return params
+}
+
+func isBandIndicator(name string) bool {
+	return name == "bb_upper" || name == "bb_middle" || name == "bb_lower"
+}
+
+func isMACDIndicator(name string) bool {
+	return name == "macd" || name == "macd_signal" || name == "macd_hist"
+}
+
+// bars is the number of candles fetched to evaluate the condition: enough
+// for the slowest operand to warm up, and at least 200.
+func (p IndicatorConditionParams) bars() int {
+	longest := 0.0
+	for _, o := range []IndicatorOperand{p.Left, p.Right} {
+		if o.Value != nil {
+			continue
+		}
+		params := o.params()
+		if isBandIndicator(o.Indicator) {
+			params = params[:1]
+		}
+		total := 0.0
+		for _, v := range params {
+			total += v
+		}
+		longest = math.Max(longest, total)
+	}
+	return int(math.Min(1000, math.Max(200, 3*longest+2)))
+}
+
+// last returns the operand's value on the last candle; ok is false while
+// the indicator is still warming up.
+func (o IndicatorOperand) last(candles []Candle) (float64, bool) {
+	if o.Value != nil {
+		return *o.Value, true
+	}
+	if len(candles) == 0 {
+		return 0, false
+	}
+	c := candles[len(candles)-1]
+	switch o.Indicator {
+	case "open":
+		return c.Open, true
+	case "high":
+		return c.High, true
+	case "low":
+		return c.Low, true
+	case "close":
+		return c.Close, true
+	case "volume":
+		return c.Volume, true
+	}
+
+	closes, highs, lows := CandleSeries(candles)
+	params := o.params()
+	period := int(params[0])
+	var v float64
+	var err error
+	switch o.Indicator {
+	case "sma":
+		v, err = SMA(closes, period)
+	case "ema":
+		v, err = EMAValue(closes, period)
+	case "rsi":
+		v, err = RSI(closes, period)
+	case "macd", "macd_signal", "macd_hist":
+		var r MACDResult
+		r, err = MACD(closes, period, int(params[1]), int(params[2]))
+		switch o.Indicator {
+		case "macd":
+			v = r.MACD
+		case "macd_signal":
+			v = r.Signal
+		default:
+			v = r.Histogram
+		}
+	case "bb_upper", "bb_middle", "bb_lower":
+		var r BollingerBandsResult
+		r, err = BollingerBands(closes, period, params[1])
+		switch o.Indicator {
+		case "bb_upper":
+			v = r.Upper
+		case "bb_lower":
+			v = r.Lower
+		default:
+			v = r.Middle
+		}
+	case "atr", "natr":
+		var series []float64
+		if o.Indicator == "atr" {
+			series, err = ATR(highs, lows, closes, period)
+		} else {
+			series, err = NATR(highs, lows, closes, period)
+		}
+		if err == nil {
+			v = series[len(series)-1]
+		}
+	case "adx":
+		var r ADXSeriesResult
+		r, err = ADX(highs, lows, closes, period)
+		if err == nil {
+			v = r.ADX[len(r.ADX)-1]
+		}
+	default:
+		return 0, false
+	}
+	if err != nil || math.IsNaN(v) {
+		return 0, false
+	}
+	return v, true
+}
+
+// IndicatorConditionMet evaluates the condition on the last candle against
+// the one before it. For intrabar conditions the last candle is the forming
+// one.
+func IndicatorConditionMet(p IndicatorConditionParams, candles []Candle) bool {
+	n := len(candles)
+	if n < 2 {
+		return false
+	}
+	left, okL := p.Left.last(candles)
+	right, okR := p.Right.last(candles)
+	if !okL || !okR {
+		return false
+	}
+	switch p.Operator {
+	case IndicatorAbove:
+		return left > right
+	case IndicatorBelow:
+		return left < right
+	}
+
+	prevLeft, okL := p.Left.last(candles[:n-1])
+	prevRight, okR := p.Right.last(candles[:n-1])
+	if !okL || !okR {
+		return false
+	}
+	up := prevLeft <= prevRight && left > right
+	down := prevLeft >= prevRight && left < right
+	switch p.Operator {
+	case IndicatorCrossesAbove:
+		return up
+	case IndicatorCrossesBelow:
+		return down
+	default:
+		return up || down
+	}
+}
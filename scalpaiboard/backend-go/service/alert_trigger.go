@@ -0,0 +1,296 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Price conditions that fire only when price crosses the threshold, i.e.
+// the previous evaluation was on the other side. The outcome of the last
+// evaluation is persisted, so a restart does not produce a false cross.
+const (
+	ConditionPriceCrossesAbove = "price_crosses_above"
+	ConditionPriceCrossesBelow = "price_crosses_below"
+)
+
+// Trigger modes of an alert.
+const (
+	// TriggerEveryTime fires whenever the condition holds, at most once per
+	// cooldown.
+	TriggerEveryTime = "every_time"
+	// TriggerOnce fires once and then deactivates the alert.
+	TriggerOnce = "once"
+	// TriggerOncePerBar fires at most once per bar of the bar interval.
+	TriggerOncePerBar = "once_per_bar"
+	// TriggerRearm fires once and then waits until the condition releases:
+	// for threshold conditions the value must move back past the threshold
+	// by the rearm band, for the others the condition must stop holding.
+	TriggerRearm = "rearm"
+)
+
+// DefaultAlertCooldown is the cooldown of every_time alerts that set none.
+const DefaultAlertCooldown = 5 * time.Minute
+
+// IsCrossCondition reports whether conditionType fires on crosses only:
+// price and drawing crosses, and entering or leaving a drawn zone.
+func IsCrossCondition(conditionType string) bool {
+	return conditionType == ConditionPriceCrossesAbove || conditionType == ConditionPriceCrossesBelow ||
+		IsDrawingCondition(conditionType)
+}
+
+// conditionDirection is 1 for conditions met at or above their threshold,
+// -1 for those met at or below it and 0 for conditions without one.
+func conditionDirection(conditionType string) int {
+	switch conditionType {
+	case "price_above", ConditionPriceCrossesAbove, "volume_above", ConditionFundingAbove, ConditionBasisAbove,
+		ConditionPriceChange, ConditionVolumeSpike:
+		return 1
+	case "price_below", ConditionPriceCrossesBelow, "volume_below", ConditionFundingBelow, ConditionBasisBelow:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// AlertTriggerSettings controls when an alert fires while its condition
+// holds. Empty fields take the defaults: every_time with a five minute
+// cooldown, no expiry and no trigger limit. BarInterval is the bar of
+// once_per_bar alerts and defaults to the interval of candle conditions.
+// RearmBand is in the unit of the condition value.
+type AlertTriggerSettings struct {
+	TriggerMode     string     `json:"triggerMode,omitempty"`
+	CooldownSeconds *int       `json:"cooldownSeconds,omitempty"`
+	BarInterval     string     `json:"barInterval,omitempty"`
+	RearmBand       *float64   `json:"rearmBand,omitempty"`
+	ExpiresAt       *time.Time `json:"expiresAt,omitempty"`
+	MaxTriggers     *int       `json:"maxTriggers,omitempty"`
+}
+
+// AlertTriggerUpdate changes the trigger settings of an alert. Nil and empty
+// fields are left unchanged; a zero RearmBand or MaxTriggers removes it and
+// ClearExpiresAt removes the expiry.
+type AlertTriggerUpdate struct {
+	AlertTriggerSettings
+	ClearExpiresAt bool
+}
+
+// validateTrigger normalises and checks trigger settings for a condition.
+func validateTrigger(conditionType string, conditionParams []byte, t *AlertTriggerSettings) error {
+	switch t.TriggerMode {
+	case "":
+		t.TriggerMode = TriggerEveryTime
+	case TriggerEveryTime, TriggerOnce, TriggerOncePerBar, TriggerRearm:
+	default:
+		return fmt.Errorf("%w: unknown triggerMode %q", ErrInvalidCondition, t.TriggerMode)
+	}
+	if t.CooldownSeconds == nil {
+		cooldown := int(DefaultAlertCooldown / time.Second)
+		t.CooldownSeconds = &cooldown
+	}
+	if *t.CooldownSeconds < 0 {
+		return fmt.Errorf("%w: cooldownSeconds must not be negative", ErrInvalidCondition)
+	}
+	if t.TriggerMode == TriggerOncePerBar {
+		if t.BarInterval == "" && IsCandleCondition(conditionType) {
+			interval, err := CandleConditionInterval(conditionType, conditionParams)
+			if err != nil {
+				return err
+			}
+			t.BarInterval = interval
+		}
+		if !isAlertInterval(t.BarInterval) {
+			return fmt.Errorf("%w: once_per_bar needs a barInterval", ErrInvalidCondition)
+		}
+	} else {
+		t.BarInterval = ""
+	}
+	if t.RearmBand != nil {
+		if *t.RearmBand < 0 {
+			return fmt.Errorf("%w: rearmBand must not be negative", ErrInvalidCondition)
+		}
+		if *t.RearmBand == 0 || t.TriggerMode != TriggerRearm {
+			t.RearmBand = nil
+		} else if conditionDirection(conditionType) == 0 {
+			return fmt.Errorf("%w: rearmBand needs a threshold condition", ErrInvalidCondition)
+		}
+	}
+	if t.MaxTriggers != nil {
+		if *t.MaxTriggers < 0 {
+			return fmt.Errorf("%w: maxTriggers must not be negative", ErrInvalidCondition)
+		}
+		if *t.MaxTriggers == 0 {
+			t.MaxTriggers = nil
+		}
+	}
+	return nil
+}
+
+// AlertTrigger is the trigger settings and state of a loaded alert. It is
+// advanced by step on every evaluation of the alert's condition.
+type AlertTrigger struct {
+	Mode        string
+	Cooldown    time.Duration
+	BarSeconds  int64
+	RearmBand   float64
+	ExpiresAt   time.Time
+	MaxTriggers int
+
+	Active          bool
+	TriggeredCount  int
+	LastTriggeredAt time.Time
+	Armed           bool
+	LastMet         sql.NullBool
+}
+
+// alertTriggerColumns are the alert columns scanned by triggerRow.
+const alertTriggerColumns = `a.trigger_mode, a.cooldown_seconds, COALESCE(a.bar_interval, ''), a.rearm_band,
+	a.expires_at, a.max_triggers, a.is_active, a.triggered_count, a.last_triggered_at, a.armed, a.last_met`
+
+// triggerRow scans alertTriggerColumns.
+type triggerRow struct {
+	mode          string
+	cooldown      int
+	barInterval   string
+	rearmBand     sql.NullFloat64
+	expiresAt     sql.NullTime
+	maxTriggers   sql.NullInt64
+	active        bool
+	count         int
+	lastTriggered sql.NullTime
+	armed         bool
+	lastMet       sql.NullBool
+}
+
+func (r *triggerRow) dest() []interface{} {
+	return []interface{}{&r.mode, &r.cooldown, &r.barInterval, &r.rearmBand, &r.expiresAt, &r.maxTriggers,
+		&r.active, &r.count, &r.lastTriggered, &r.armed, &r.lastMet}
+}
+
+func (r *triggerRow) trigger() AlertTrigger {
+	t := AlertTrigger{
+		Mode:           r.mode,
+		Cooldown:       time.Duration(r.cooldown) * time.Second,
+		RearmBand:      r.rearmBand.Float64,
+		MaxTriggers:    int(r.maxTriggers.Int64),
+		Active:         r.active,
+		TriggeredCount: r.count,
+		Armed:          r.armed,
+		LastMet:        r.lastMet,
+	}
+	t.BarSeconds, _ = IntervalSeconds(r.barInterval)
+	if r.expiresAt.Valid {
+		t.ExpiresAt = r.expiresAt.Time
+	}
+	if r.lastTriggered.Valid {
+		t.LastTriggeredAt = r.lastTriggered.Time
+	}
+	return t
+}
+
+// expired reports whether the alert is past its expiry or trigger limit.
+func (t *AlertTrigger) expired(now time.Time) bool {
+	return (!t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt)) ||
+		(t.MaxTriggers > 0 && t.TriggeredCount >= t.MaxTriggers)
+}
+
+// ready reports whether the cooldown or bar of the last trigger has passed.
+func (t *AlertTrigger) ready(now time.Time) bool {
+	switch t.Mode {
+	case TriggerEveryTime:
+		return now.Sub(t.LastTriggeredAt) >= t.Cooldown
+	case TriggerOncePerBar:
+		if t.BarSeconds <= 0 {
+			return true
+		}
+		barOpen := now.Unix() - now.Unix()%t.BarSeconds
+		return t.LastTriggeredAt.Unix() < barOpen
+	default:
+		return true
+	}
+}
+
+// watching reports whether the alert must be evaluated even though it
+// could not fire now, because crosses and rearming depend on every
+// evaluation.
+func (t *AlertTrigger) watching(conditionType string) bool {
+	return IsCrossCondition(conditionType) || (t.Mode == TriggerRearm && !t.Armed)
+}
+
+// released reports whether a fired rearm alert may fire again, given the
+// current outcome and value of its condition.
+func (t *AlertTrigger) released(conditionType string, threshold, value float64, met bool) bool {
+	switch conditionDirection(conditionType) {
+	case 1:
+		if conditionType == ConditionPriceChange {
+			value = math.Abs(value)
+		}
+		return value < threshold-t.RearmBand || (t.RearmBand == 0 && !met)
+	case -1:
+		return value > threshold+t.RearmBand || (t.RearmBand == 0 && !met)
+	default:
+		return !met
+	}
+}
+
+// step applies one evaluation of the condition (met, with the observed
+// value) and reports whether the alert fires. changed is set when state
+// that is persisted (active, armed, or the last outcome of cross
+// conditions) changed, which is always the case when it fires.
+func (t *AlertTrigger) step(conditionType string, threshold, value float64, met bool, now time.Time) (fire, changed bool) {
+	if !t.Active {
+		return false, false
+	}
+	if t.expired(now) {
+		t.Active = false
+		return false, true
+	}
+
+	last := t.LastMet
+	if !last.Valid || last.Bool != met {
+		t.LastMet = sql.NullBool{Bool: met, Valid: true}
+		changed = IsCrossCondition(conditionType)
+	}
+	if t.Mode == TriggerRearm && !t.Armed && t.released(conditionType, threshold, value, met) {
+		t.Armed = true
+		changed = true
+	}
+
+	if IsCrossCondition(conditionType) {
+		// The first evaluation only establishes the side.
+		met = met && last.Valid && !last.Bool
+	}
+	if !met || (t.Mode == TriggerRearm && !t.Armed) || !t.ready(now) {
+		return false, changed
+	}
+
+	t.TriggeredCount++
+	t.LastTriggeredAt = now
+	if t.Mode == TriggerRearm {
+		t.Armed = false
+	}
+	if t.Mode == TriggerOnce || t.expired(now) {
+		t.Active = false
+	}
+	return true, true
+}
+
+// carry takes over the state of the in-memory copy of a reloaded alert:
+// its last trigger, which is written asynchronously, and when the
+// condition and trigger settings are unchanged its armed flag and last
+// outcome.
+func (t *AlertTrigger) carry(prev AlertTrigger, sameCondition bool) {
+	if prev.LastTriggeredAt.After(t.LastTriggeredAt) {
+		t.LastTriggeredAt = prev.LastTriggeredAt
+	}
+	if prev.TriggeredCount > t.TriggeredCount {
+		t.TriggeredCount = prev.TriggeredCount
+	}
+	if sameCondition && prev.Mode == t.Mode && prev.RearmBand == t.RearmBand {
+		t.Armed = prev.Armed
+		if prev.LastMet.Valid {
+			t.LastMet = prev.LastMet
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlertTriggerStep(t *testing.T) {
+	start := time.Date(2026, 3, 2, 10, 5, 0, 0, time.UTC)
+	type eval struct {
+		at    time.Duration
+		value float64
+		fire  bool
+	}
+
+	tests := []struct {
+		name          string
+		conditionType string
+		trigger       AlertTrigger
+		evals         []eval
+		active        bool
+	}{
+		{
+			name:          "every time waits for the cooldown",
+			conditionType: "price_above",
+			trigger:       AlertTrigger{Mode: TriggerEveryTime, Cooldown: 5 * time.Minute},
+			evals:         []eval{{0, 101, true}, {time.Minute, 102, false}, {5 * time.Minute, 101, true}, {11 * time.Minute, 99, false}},
+			active:        true,
+		},
+		{
+			name:          "once deactivates",
+			conditionType: "price_above",
+			trigger:       AlertTrigger{Mode: TriggerOnce},
+			evals:         []eval{{0, 99, false}, {time.Minute, 101, true}, {2 * time.Minute, 101, false}},
+			active:        false,
+		},
+		{
+			name:          "once per bar",
+			conditionType: "price_above",
+			trigger:       AlertTrigger{Mode: TriggerOncePerBar, BarSeconds: 3600},
+			evals:         []eval{{0, 101, true}, {35 * time.Minute, 101, false}, {56 * time.Minute, 101, true}},
+			active:        true,
+		},
+		{
+			name:          "rearm waits for the band",
+			conditionType: "price_above",
+			trigger:       AlertTrigger{Mode: TriggerRearm, RearmBand: 2, Armed: true},
+			evals: []eval{{0, 101, true}, {time.Minute, 99, false}, {2 * time.Minute, 101, false},
+				{3 * time.Minute, 97, false}, {4 * time.Minute, 100, true}},
+			active: true,
+		},
+		{
+			name:          "rearm below without band",
+			conditionType: "price_below",
+			trigger:       AlertTrigger{Mode: TriggerRearm, Armed: true},
+			evals:         []eval{{0, 99, true}, {time.Minute, 98, false}, {2 * time.Minute, 100.5, false}, {3 * time.Minute, 99, true}},
+			active:        true,
+		},
+		{
+			name:          "cross needs the other side first",
+			conditionType: ConditionPriceCrossesAbove,
+			trigger:       AlertTrigger{Mode: TriggerEveryTime},
+			evals:         []eval{{0, 101, false}, {time.Minute, 99, false}, {2 * time.Minute, 101, true}, {3 * time.Minute, 102, false}},
+			active:        true,
+		},
+		{
+			name:          "trigger limit",
+			conditionType: "price_above",
+			trigger:       AlertTrigger{Mode: TriggerEveryTime, MaxTriggers: 2},
+			evals:         []eval{{0, 101, true}, {time.Minute, 101, true}, {2 * time.Minute, 101, false}},
+			active:        false,
+		},
+		{
+			name:          "expired",
+			conditionType: "price_above",
+			trigger:       AlertTrigger{Mode: TriggerEveryTime, ExpiresAt: start.Add(time.Minute)},
+			evals:         []eval{{0, 101, true}, {time.Minute, 101, false}},
+			active:        false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trigger := tt.trigger
+			trigger.Active = true
+			for i, e := range tt.evals {
+				met := e.value >= 100
+				if conditionDirection(tt.conditionType) < 0 {
+					met = e.value <= 100
+				}
+				fire, _ := trigger.step(tt.conditionType, 100, e.value, met, start.Add(e.at))
+				if fire != e.fire {
+					t.Fatalf("evaluation %d at %v: fire %v, want %v", i, e.value, fire, e.fire)
+				}
+			}
+			if trigger.Active != tt.active {
+				t.Fatalf("active %v, want %v", trigger.Active, tt.active)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Rolling-window alert conditions, evaluated by the real-time engine from
+// its in-memory price history; see WindowConditionParams.
+const (
+	// ConditionPriceChange fires when price moves by at least
+	// condition_value (percent, or price units in absolute mode) within
+	// the window.
+	ConditionPriceChange = "price_change"
+	// ConditionVolumeSpike fires when the volume of the last window is at
+	// least condition_value times the average volume per window over the
+	// baseline before it.
+	ConditionVolumeSpike = "volume_spike"
+)
+
+// Directions and modes of price_change alerts.
+const (
+	MoveUp     = "up"
+	MoveDown   = "down"
+	MoveEither = "either"
+
+	MovePercent  = "percent"
+	MoveAbsolute = "absolute"
+)
+
+// ringMinutes is the span of the per-market price history: 24 hours plus
+// the forming minute.
+const ringMinutes = 24*60 + 1
+
+// WindowConditionParams configures price_change and volume_spike alerts.
+// Window and Baseline are durations such as "5m", "1h" or "24h" in whole
+// minutes. A price_change compares the current price with the low (up) or
+// high (down) of the window, so "±3% within 15m" is
+// {"window":"15m","direction":"either"} with condition_value 3. Direction
+// defaults to either and Mode to percent. Baseline defaults to the rest of
+// the last 24 hours.
+type WindowConditionParams struct {
+	Window    string `json:"window"`
+	Direction string `json:"direction,omitempty"`
+	Mode      string `json:"mode,omitempty"`
+	Baseline  string `json:"baseline,omitempty"`
+}
+
+// IsWindowCondition reports whether conditionType is a rolling-window
+// condition, which only the real-time engine can evaluate.
+func IsWindowCondition(conditionType string) bool {
+	return conditionType == ConditionPriceChange || conditionType == ConditionVolumeSpike
+}
+
+// windowMinutes parses a window duration in whole minutes.
+func windowMinutes(s string) (int, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil || d < time.Minute || d%time.Minute != 0 {
+		return 0, fmt.Errorf("%w: window %q must be a whole number of minutes", ErrInvalidCondition, s)
+	}
+	return int(d / time.Minute), nil
+}
+
+// minutes returns the window and baseline lengths.
+func (p WindowConditionParams) minutes() (window, baseline int, err error) {
+	if window, err = windowMinutes(p.Window); err != nil {
+		return 0, 0, err
+	}
+	baseline = ringMinutes - 1 - window
+	if p.Baseline != "" {
+		if baseline, err = windowMinutes(p.Baseline); err != nil {
+			return 0, 0, err
+		}
+	}
+	return window, baseline, nil
+}
+
+func validateWindowCondition(conditionType string, value float64, p WindowConditionParams) error {
+	window, baseline, err := p.minutes()
+	if err != nil {
+		return err
+	}
+	if window > ringMinutes-1 {
+		return fmt.Errorf("%w: window must be at most 24h", ErrInvalidCondition)
+	}
+	if value <= 0 {
+		return fmt.Errorf("%w: conditionValue must be positive", ErrInvalidCondition)
+	}
+	if conditionType == ConditionVolumeSpike {
+		if baseline < window || window+baseline > ringMinutes-1 {
+			return fmt.Errorf("%w: baseline must be at least the window and both together at most 24h", ErrInvalidCondition)
+		}
+		return nil
+	}
+	switch p.Direction {
+	case "", MoveUp, MoveDown, MoveEither:
+	default:
+		return fmt.Errorf("%w: unknown direction %q", ErrInvalidCondition, p.Direction)
+	}
+	switch p.Mode {
+	case "", MovePercent, MoveAbsolute:
+	default:
+		return fmt.Errorf("%w: unknown mode %q", ErrInvalidCondition, p.Mode)
+	}
+	return nil
+}
+
+// ringBar is the low and high of the ticks of one minute.
+type ringBar struct {
+	minute int64
+	low    float64
+	high   float64
+}
+
+// ringVolume is the volume of one minute, from the exchange's 1m kline.
+type ringVolume struct {
+	minute int64
+	volume float64
+}
+
+// priceRing holds the last 24 hours of a market in one-minute buckets.
+// Prices come from the ticks the engine already receives, and windows keeps
+// the extremes of each price_change window length. The ticker only carries
+// a rolling 24h volume, which cannot be split into minutes, so volumes come
+// from 1m klines, loaded only for markets with volume_spike alerts:
+// volumeMinutes back once, then each minute as it closes. seeded is set once
+// that history was backfilled, refreshed is the last closed minute whose
+// kline was merged and loading marks a kline request in flight.
+type priceRing struct {
+	bars          [ringMinutes]ringBar
+	volumes       [ringMinutes]ringVolume
+	windows       map[int]*windowExtremes
+	volumeMinutes int
+	seeded        bool
+	loading       bool
+	refreshed     int64
+}
+
+func (r *priceRing) bar(minute int64) *ringBar {
+	b := &r.bars[minute%ringMinutes]
+	if b.minute != minute {
+		return nil
+	}
+	return b
+}
+
+// track keeps extremes for the given window lengths, building new ones from
+// the minutes already recorded, and sets how many minutes of volume are
+// needed, reloading them when that grew.
+func (r *priceRing) track(windows map[int]bool, volumeMinutes int, now time.Time) {
+	if r.windows == nil {
+		r.windows = make(map[int]*windowExtremes)
+	}
+	for minutes := range r.windows {
+		if !windows[minutes] {
+			delete(r.windows, minutes)
+		}
+	}
+	minute := now.Unix() / 60
+	for minutes := range windows {
+		if _, ok := r.windows[minutes]; ok {
+			continue
+		}
+		w := &windowExtremes{minutes: minutes}
+		for m := minute - int64(minutes) + 1; m <= minute; m++ {
+			if b := r.bar(m); b != nil {
+				w.add(m, b.low, b.high)
+			}
+		}
+		r.windows[minutes] = w
+	}
+	if volumeMinutes > r.volumeMinutes {
+		r.seeded = false
+	}
+	r.volumeMinutes = volumeMinutes
+}
+
+// addPrice records a tick.
+func (r *priceRing) addPrice(t time.Time, price float64) {
+	minute := t.Unix() / 60
+	b := r.bar(minute)
+	if b == nil {
+		b = &r.bars[minute%ringMinutes]
+		*b = ringBar{minute: minute, low: price, high: price}
+	} else {
+		b.low = math.Min(b.low, price)
+		b.high = math.Max(b.high, price)
+	}
+	for _, w := range r.windows {
+		w.add(minute, b.low, b.high)
+	}
+}
+
+// addVolume records the volume of an exchange 1m candle.
+func (r *priceRing) addVolume(c Candle) {
+	minute := c.Time / 60
+	r.volumes[minute%ringMinutes] = ringVolume{minute: minute, volume: c.Volume}
+}
+
+// volume returns the total volume of the minutes from..to and how many of
+// them are present.
+func (r *priceRing) volume(from, to int64) (total float64, present int) {
+	for m := from; m <= to; m++ {
+		if v := r.volumes[m%ringMinutes]; v.minute == m {
+			total += v.volume
+			present++
+		}
+	}
+	return total, present
+}
+
+// ringPoint is the low or high of a minute.
+type ringPoint struct {
+	minute int64
+	price  float64
+}
+
+// windowExtremes keeps the low and high of the last minutes of a ring in
+// monotonic deques: lows rise and highs fall from front to back, so the
+// fronts are the extremes of the window and each tick costs O(1) amortized.
+type windowExtremes struct {
+	minutes int
+	lows    []ringPoint
+	highs   []ringPoint
+}
+
+// add records the low and high so far of minute, the latest minute of the
+// ring.
+func (w *windowExtremes) add(minute int64, low, high float64) {
+	for len(w.lows) > 0 && w.lows[len(w.lows)-1].price >= low {
+		w.lows = w.lows[:len(w.lows)-1]
+	}
+	w.lows = append(w.lows, ringPoint{minute: minute, price: low})
+	for len(w.highs) > 0 && w.highs[len(w.highs)-1].price <= high {
+		w.highs = w.highs[:len(w.highs)-1]
+	}
+	w.highs = append(w.highs, ringPoint{minute: minute, price: high})
+}
+
+// extremes returns the low and high of the window ending with minute.
+func (w *windowExtremes) extremes(minute int64) (low, high float64, ok bool) {
+	from := minute - int64(w.minutes) + 1
+	for len(w.lows) > 0 && w.lows[0].minute < from {
+		w.lows = w.lows[1:]
+	}
+	for len(w.highs) > 0 && w.highs[0].minute < from {
+		w.highs = w.highs[1:]
+	}
+	if len(w.lows) == 0 || len(w.highs) == 0 {
+		return 0, 0, false
+	}
+	return w.lows[0].price, w.highs[0].price, true
+}
+
+// priceChange evaluates a price_change condition at price, the last tick
+// recorded. The window is the M minutes ending with the current one. It
+// returns the move that was compared (percent or absolute, signed by
+// direction).
+func (r *priceRing) priceChange(p WindowConditionParams, value, price float64, now time.Time) (bool, float64) {
+	window, _, err := p.minutes()
+	if err != nil {
+		return false, 0
+	}
+	w, ok := r.windows[window]
+	if !ok {
+		return false, 0
+	}
+	low, high, ok := w.extremes(now.Unix() / 60)
+	if !ok {
+		return false, 0
+	}
+	low, high = math.Min(low, price), math.Max(high, price)
+
+	up, down := price-low, high-price
+	if p.Mode != MoveAbsolute {
+		up, down = 100*up/low, 100*down/high
+	}
+	switch p.Direction {
+	case MoveUp:
+		return up >= value, up
+	case MoveDown:
+		return down >= value, -down
+	default:
+		if up >= down {
+			return up >= value, up
+		}
+		return down >= value, -down
+	}
+}
+
+// volumeSpike evaluates a volume_spike condition on the window ending with
+// the closed minute. It returns the ratio of window volume to the baseline
+// average; ok is false while less than half of the baseline is known.
+func (r *priceRing) volumeSpike(p WindowConditionParams, value float64, closed int64) (met bool, ratio float64, ok bool) {
+	window, baseline, err := p.minutes()
+	if err != nil {
+		return false, 0, false
+	}
+	recent, _ := r.volume(closed-int64(window)+1, closed)
+	base, present := r.volume(closed-int64(window+baseline)+1, closed-int64(window))
+	if present*2 < baseline || base <= 0 {
+		return false, 0, false
+	}
+	average := base / float64(present) * float64(window)
+	ratio = recent / average
+	return ratio >= value, ratio, true
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPriceChange(t *testing.T) {
+	type tick struct {
+		minute int64
+		price  float64
+	}
+	tests := []struct {
+		name   string
+		params WindowConditionParams
+		value  float64
+		ticks  []tick
+		met    bool
+		move   float64
+	}{
+		{
+			name:   "rise within the window",
+			params: WindowConditionParams{Window: "5m"},
+			value:  3,
+			ticks:  []tick{{0, 100}, {2, 99}, {4, 102}, {4, 103.95}},
+			met:    true,
+			move:   5,
+		},
+		{
+			name:   "window is M minutes ending with the current one",
+			params: WindowConditionParams{Window: "2m"},
+			value:  3,
+			ticks:  []tick{{0, 100}, {1, 102}, {2, 104}},
+			met:    false,
+			move:   2 / 1.02,
+		},
+		{
+			name:   "low expired while a later one stays",
+			params: WindowConditionParams{Window: "3m", Direction: MoveUp},
+			value:  1,
+			ticks:  []tick{{0, 90}, {1, 100}, {2, 95}, {3, 99}, {4, 100}},
+			met:    true,
+			move:   100 * 5.0 / 95,
+		},
+		{
+			name:   "absolute fall",
+			params: WindowConditionParams{Window: "10m", Direction: MoveDown, Mode: MoveAbsolute},
+			value:  20,
+			ticks:  []tick{{0, 100}, {3, 130}, {9, 105}},
+			met:    true,
+			move:   -25,
+		},
+		{
+			name:   "either picks the larger move",
+			params: WindowConditionParams{Window: "15m"},
+			value:  15,
+			ticks:  []tick{{0, 100}, {5, 110}, {6, 95}},
+			met:    false,
+			move:   -100 * 15.0 / 110,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window, _, _ := tt.params.minutes()
+			start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+			r := &priceRing{}
+			r.track(map[int]bool{window: true}, 0, start)
+			var met bool
+			var move float64
+			for _, tk := range tt.ticks {
+				now := start.Add(time.Duration(tk.minute)*time.Minute + 30*time.Second)
+				r.addPrice(now, tk.price)
+				met, move = r.priceChange(tt.params, tt.value, tk.price, now)
+			}
+			if met != tt.met || !approx(move, tt.move) {
+				t.Fatalf("got met %v move %v, want %v %v", met, move, tt.met, tt.move)
+			}
+		})
+	}
+}
+
+func approx(a, b float64) bool {
+	d := a - b
+	return d < 1e-9 && d > -1e-9
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// alertWriteBatch is the most trigger writes committed in one transaction.
+const alertWriteBatch = 200
+
+// alertWrite is the trigger state of an evaluated alert that changed: it
+// fired, which also records history and sends the notification, or its
+// active, armed or last outcome state changed without firing. results are
+// the evaluated conditions, saved with the history entry.
+type alertWrite struct {
+	alertID          int
+	userID           string
+	marketID         string
+	conditionType    string
+	conditionValue   float64
+	notificationType string
+	price            float64
+	results          []ConditionResult
+	trigger          AlertTrigger
+	fired            bool
+}
+
+// firedAlert is a fired alert with its saved history entry.
+type firedAlert struct {
+	alertWrite
+	historyID int
+}
+
+// writeTriggers persists trigger writes in batched transactions and then
+// sends the notifications of the alerts that fired.
+func (e *AlertEvaluator) writeTriggers(writes []alertWrite) {
+	for len(writes) > 0 {
+		n := len(writes)
+		if n > alertWriteBatch {
+			n = alertWriteBatch
+		}
+		e.writeBatch(writes[:n])
+		writes = writes[n:]
+	}
+}
+
+func (e *AlertEvaluator) writeBatch(writes []alertWrite) {
+	fired, err := e.saveBatch(writes)
+	if err != nil && len(writes) > 1 {
+		// One bad write (e.g. an alert deleted meanwhile) aborts the
+		// transaction; retry them one by one so the others are kept.
+		fired = nil
+		for _, w := range writes {
+			f, err := e.saveBatch([]alertWrite{w})
+			if err != nil {
+				log.Printf("❌ Failed to update alert %d: %v", w.alertID, err)
+				continue
+			}
+			fired = append(fired, f...)
+		}
+	} else if err != nil {
+		log.Printf("❌ Failed to update alert %d: %v", writes[0].alertID, err)
+		return
+	}
+
+	for _, w := range writes {
+		if !w.trigger.Active && e.engine != nil {
+			e.engine.Reload(w.alertID)
+		}
+	}
+	if len(fired) > 0 {
+		go e.sendNotifications(fired)
+	}
+}
+
+// saveBatch writes the trigger state of alerts, and the history entries of
+// those that fired, in one transaction.
+func (e *AlertEvaluator) saveBatch(writes []alertWrite) ([]firedAlert, error) {
+	tx, err := e.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	save, err := tx.Prepare(`
+		UPDATE alerts
+		SET is_active = $2, armed = $3, last_met = $4, updated_at = NOW()
+		WHERE id = $1
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer save.Close()
+	fire, err := tx.Prepare(`
+		UPDATE alerts
+		SET triggered_count = triggered_count + 1,
+			last_triggered_at = NOW(),
+			is_active = $2,
+			armed = $3,
+			last_met = $4,
+			updated_at = NOW()
+		WHERE id = $1
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer fire.Close()
+	history, err := tx.Prepare(`
+		INSERT INTO alert_history (alert_id, triggered_at, notification_status, notification_channel, details)
+		VALUES ($1, NOW(), 'pending', $2, $3)
+		RETURNING id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer history.Close()
+
+	fired := make([]firedAlert, 0)
+	for _, w := range writes {
+		t := w.trigger
+		if !w.fired {
+			if _, err := save.Exec(w.alertID, t.Active, t.Armed, t.LastMet); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if _, err := fire.Exec(w.alertID, t.Active, t.Armed, t.LastMet); err != nil {
+			return nil, err
+		}
+		details, err := json.Marshal(w.results)
+		if err != nil {
+			details = nil
+		}
+		f := firedAlert{alertWrite: w}
+		if err := history.QueryRow(w.alertID, w.notificationType, details).Scan(&f.historyID); err != nil {
+			return nil, err
+		}
+		fired = append(fired, f)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return fired, nil
+}
+
+// sendNotifications notifies the owners of fired alerts and records the
+// outcome on their history entries.
+func (e *AlertEvaluator) sendNotifications(fired []firedAlert) {
+	type outcome struct {
+		historyID int
+		status    string
+		errMsg    string
+	}
+	outcomes := make([]outcome, 0, len(fired))
+	for _, f := range fired {
+		log.Printf("🚨 Alert %d triggered for %s (price: $%.2f)", f.alertID, f.marketID, f.price)
+		message := e.notification.FormatAlertMessage(f.marketID, f.conditionType, f.conditionValue, f.price)
+		o := outcome{historyID: f.historyID, status: "sent"}
+		data := map[string]interface{}{
+			"alertId":       f.alertID,
+			"historyId":     f.historyID,
+			"marketId":      f.marketID,
+			"conditionType": f.conditionType,
+			"price":         f.price,
+			"conditions":    f.results,
+		}
+		if err := e.notification.Deliver(f.userID, f.notificationType, NotificationKindAlert, "Alert Triggered", message, data); err != nil {
+			o.status, o.errMsg = "failed", err.Error()
+		}
+		outcomes = append(outcomes, o)
+	}
+
+	tx, err := e.db.Begin()
+	if err != nil {
+		log.Printf("❌ Failed to update alert history: %v", err)
+		return
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`
+		UPDATE alert_history
+		SET notification_status = $1, error_message = $2
+		WHERE id = $3
+	`)
+	if err != nil {
+		log.Printf("❌ Failed to update alert history: %v", err)
+		return
+	}
+	defer stmt.Close()
+	for _, o := range outcomes {
+		if _, err := stmt.Exec(o.status, o.errMsg, o.historyID); err != nil {
+			log.Printf("❌ Failed to update alert history: %v", err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("❌ Failed to update alert history: %v", err)
+	}
+}
@@ -0,0 +1,507 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Backtest limits.
+const (
+	BacktestMaxBars    = 5000
+	BacktestMaxMarkets = 10
+
+	backtestMaxOps        = 50_000_000
+	backtestCancelStride  = 256
+	backtestDefaultEquity = 10000
+)
+
+// Position sizing modes.
+const (
+	// SizingPercentEquity invests Value percent of current equity.
+	SizingPercentEquity = "percent_equity"
+	// SizingFixedNotional invests a fixed Value in quote currency.
+	SizingFixedNotional = "fixed_notional"
+	// SizingRiskPercent risks Value percent of equity between entry and
+	// the stop loss; requires StopLossPct.
+	SizingRiskPercent = "risk_percent"
+)
+
+// Trade sides and exit reasons.
+const (
+	SideLong  = "long"
+	SideShort = "short"
+
+	ExitSignal     = "signal"
+	ExitStopLoss   = "stop_loss"
+	ExitTakeProfit = "take_profit"
+	ExitTrailing   = "trailing_stop"
+	ExitMaxBars    = "max_bars"
+	ExitEndOfData  = "end_of_data"
+)
+
+// ErrInvalidStrategy is returned for strategies that fail validation.
+var ErrInvalidStrategy = errors.New("invalid strategy")
+
+// PositionSizing decides the size of each new position.
+type PositionSizing struct {
+	Mode  string  `json:"mode"`
+	Value float64 `json:"value"`
+}
+
+// BacktestStrategy is a rule-based strategy. Entry and Exit are formulas
+// (see ParseFormula) read as booleans on each closed bar; Exit is optional
+// when protective exits are set. Percentages are in percent (2 = 2%).
+// FeePct is charged on the notional of each fill; SlippagePct moves every
+// fill against the trade.
+type BacktestStrategy struct {
+	Side            string         `json:"side"`
+	Entry           string         `json:"entry"`
+	Exit            string         `json:"exit,omitempty"`
+	StopLossPct     float64        `json:"stopLossPct,omitempty"`
+	TakeProfitPct   float64        `json:"takeProfitPct,omitempty"`
+	TrailingStopPct float64        `json:"trailingStopPct,omitempty"`
+	MaxBarsInTrade  int            `json:"maxBarsInTrade,omitempty"`
+	Sizing          PositionSizing `json:"sizing"`
+	MaxLeverage     float64        `json:"maxLeverage,omitempty"`
+	InitialCapital  float64        `json:"initialCapital"`
+	FeePct          float64        `json:"feePct"`
+	SlippagePct     float64        `json:"slippagePct"`
+}
+
+// BacktestTrade is one round trip.
+type BacktestTrade struct {
+	MarketID   string  `json:"marketId"`
+	Side       string  `json:"side"`
+	EntryTime  int64   `json:"entryTime"`
+	EntryPrice float64 `json:"entryPrice"`
+	ExitTime   int64   `json:"exitTime"`
+	ExitPrice  float64 `json:"exitPrice"`
+	Quantity   float64 `json:"quantity"`
+	PnL        float64 `json:"pnl"`
+	PnLPct     float64 `json:"pnlPct"`
+	Fees       float64 `json:"fees"`
+	Bars       int     `json:"bars"`
+	ExitReason string  `json:"exitReason"`
+}
+
+// EquityPoint is the marked-to-market equity at a bar close.
+type EquityPoint struct {
+	Time   int64   `json:"time"`
+	Equity float64 `json:"equity"`
+}
+
+// BacktestStats summarises a run. Sharpe and Sortino are annualised from
+// per-bar equity returns (zero risk-free rate). Exposure is the percentage
+// of bars spent in a position. ProfitFactor is 0 when no trade lost.
+type BacktestStats struct {
+	TotalTrades    int     `json:"totalTrades"`
+	Wins           int     `json:"wins"`
+	Losses         int     `json:"losses"`
+	WinRate        float64 `json:"winRate"`
+	GrossProfit    float64 `json:"grossProfit"`
+	GrossLoss      float64 `json:"grossLoss"`
+	ProfitFactor   float64 `json:"profitFactor"`
+	NetProfit      float64 `json:"netProfit"`
+	NetProfitPct   float64 `json:"netProfitPct"`
+	AvgTrade       float64 `json:"avgTrade"`
+	AvgWin         float64 `json:"avgWin"`
+	AvgLoss        float64 `json:"avgLoss"`
+	LargestWin     float64 `json:"largestWin"`
+	LargestLoss    float64 `json:"largestLoss"`
+	TotalFees      float64 `json:"totalFees"`
+	MaxDrawdown    float64 `json:"maxDrawdown"`
+	MaxDrawdownPct float64 `json:"maxDrawdownPct"`
+	Sharpe         float64 `json:"sharpe"`
+	Sortino        float64 `json:"sortino"`
+	ExposurePct    float64 `json:"exposurePct"`
+}
+
+// MarketBacktest is the result for one market.
+type MarketBacktest struct {
+	MarketID string          `json:"marketId"`
+	Bars     int             `json:"bars"`
+	Trades   []BacktestTrade `json:"trades"`
+	Equity   []EquityPoint   `json:"equity"`
+	Stats    BacktestStats   `json:"stats"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// BacktestResult holds per-market runs (each starting from the full initial
+// capital) and a Summary: trade statistics over all trades, the worst
+// market drawdown and the mean Sharpe, Sortino and exposure.
+type BacktestResult struct {
+	Interval string           `json:"interval"`
+	Markets  []MarketBacktest `json:"markets"`
+	Summary  BacktestStats    `json:"summary"`
+}
+
+// Validate normalises defaults and checks the strategy.
+func (s *BacktestStrategy) Validate() error {
+	if s.Side == "" {
+		s.Side = SideLong
+	}
+	if s.Side != SideLong && s.Side != SideShort {
+		return fmt.Errorf("%w: side must be long or short", ErrInvalidStrategy)
+	}
+	if _, err := ParseFormula(s.Entry); err != nil {
+		return fmt.Errorf("%w: entry: %v", ErrInvalidStrategy, err)
+	}
+	if s.Exit != "" {
+		if _, err := ParseFormula(s.Exit); err != nil {
+			return fmt.Errorf("%w: exit: %v", ErrInvalidStrategy, err)
+		}
+	} else if s.StopLossPct <= 0 && s.TakeProfitPct <= 0 && s.TrailingStopPct <= 0 && s.MaxBarsInTrade <= 0 {
+		return fmt.Errorf("%w: an exit rule, stop, target or maxBarsInTrade is required", ErrInvalidStrategy)
+	}
+	for name, v := range map[string]float64{
+		"stopLossPct": s.StopLossPct, "takeProfitPct": s.TakeProfitPct, "trailingStopPct": s.TrailingStopPct,
+		"feePct": s.FeePct, "slippagePct": s.SlippagePct,
+	} {
+		if v < 0 || v >= 100 {
+			return fmt.Errorf("%w: %s must be between 0 and 100", ErrInvalidStrategy, name)
+		}
+	}
+	if s.MaxBarsInTrade < 0 {
+		return fmt.Errorf("%w: maxBarsInTrade must not be negative", ErrInvalidStrategy)
+	}
+
+	if s.InitialCapital == 0 {
+		s.InitialCapital = backtestDefaultEquity
+	}
+	if s.InitialCapital < 0 {
+		return fmt.Errorf("%w: initialCapital must be positive", ErrInvalidStrategy)
+	}
+	if s.MaxLeverage == 0 {
+		s.MaxLeverage = 1
+	}
+	if s.MaxLeverage < 1 || s.MaxLeverage > 100 {
+		return fmt.Errorf("%w: maxLeverage must be between 1 and 100", ErrInvalidStrategy)
+	}
+
+	if s.Sizing.Mode == "" {
+		s.Sizing = PositionSizing{Mode: SizingPercentEquity, Value: 100}
+	}
+	switch s.Sizing.Mode {
+	case SizingPercentEquity, SizingRiskPercent:
+		if s.Sizing.Value <= 0 || s.Sizing.Value > 100*s.MaxLeverage {
+			return fmt.Errorf("%w: sizing value out of range", ErrInvalidStrategy)
+		}
+		if s.Sizing.Mode == SizingRiskPercent && s.StopLossPct <= 0 {
+			return fmt.Errorf("%w: risk_percent sizing requires stopLossPct", ErrInvalidStrategy)
+		}
+	case SizingFixedNotional:
+		if s.Sizing.Value <= 0 {
+			return fmt.Errorf("%w: sizing value must be positive", ErrInvalidStrategy)
+		}
+	default:
+		return fmt.Errorf("%w: unknown sizing mode %q", ErrInvalidStrategy, s.Sizing.Mode)
+	}
+	return nil
+}
+
+// RunBacktest simulates the strategy over candles (ascending). The
+// strategy must have passed Validate.
+//
+// Signals are read on bar closes and filled at the next bar's open. Stops
+// and targets are checked against each later bar's high/low, filling at the
+// level (or the open when it gaps through); if both are touched in the same
+// bar the stop is assumed to fill first. The trailing stop trails the best
+// price since entry, updated after each bar is checked. A position still
+// open at the end is closed at the last close. progress, if non-nil, is
+// called with the fraction of bars simulated.
+func RunBacktest(ctx context.Context, marketID, interval string, candles []Candle, strategy BacktestStrategy, progress func(float64)) (MarketBacktest, error) {
+	out := MarketBacktest{MarketID: marketID, Bars: len(candles), Trades: make([]BacktestTrade, 0), Equity: make([]EquityPoint, 0, len(candles))}
+	if len(candles) < 2 {
+		return out, errors.New("not enough candles")
+	}
+
+	entry, err := ParseFormula(strategy.Entry)
+	if err != nil {
+		return out, err
+	}
+	entrySignals, err := entry.evaluate(candles, BacktestMaxBars, backtestMaxOps)
+	if err != nil {
+		return out, err
+	}
+	var exitSignals []float64
+	if strategy.Exit != "" {
+		exit, err := ParseFormula(strategy.Exit)
+		if err != nil {
+			return out, err
+		}
+		if exitSignals, err = exit.evaluate(candles, BacktestMaxBars, backtestMaxOps); err != nil {
+			return out, err
+		}
+	}
+
+	dir := 1.0
+	if strategy.Side == SideShort {
+		dir = -1
+	}
+	fee := strategy.FeePct / 100
+	slip := strategy.SlippagePct / 100
+
+	cash := strategy.InitialCapital
+	var pos *BacktestTrade
+	var entryFee, best float64
+	entryIdx, barsInPos := 0, 0
+	pendingEntry, pendingExit := false, false
+
+	closePosition := func(i int, price float64, reason string) {
+		fill := price * (1 - dir*slip)
+		exitFee := fill * pos.Quantity * fee
+		gross := dir * (fill - pos.EntryPrice) * pos.Quantity
+		pos.ExitTime = candles[i].Time
+		pos.ExitPrice = fill
+		pos.Fees = entryFee + exitFee
+		pos.PnL = gross - pos.Fees
+		pos.PnLPct = 100 * pos.PnL / (pos.EntryPrice * pos.Quantity)
+		pos.Bars = i - entryIdx + 1
+		pos.ExitReason = reason
+		cash += gross - exitFee
+		out.Trades = append(out.Trades, *pos)
+		pos = nil
+	}
+
+	for i, bar := range candles {
+		if i%backtestCancelStride == 0 {
+			if err := ctx.Err(); err != nil {
+				return out, err
+			}
+			if progress != nil {
+				progress(float64(i) / float64(len(candles)))
+			}
+		}
+
+		// Orders from the previous close fill at this open.
+		if pos != nil && pendingExit {
+			closePosition(i, bar.Open, ExitSignal)
+		}
+		pendingExit = false
+		if pos == nil && pendingEntry && cash > 0 {
+			fill := bar.Open * (1 + dir*slip)
+			qty := positionSize(strategy, cash, fill)
+			if qty > 0 {
+				pos = &BacktestTrade{MarketID: marketID, Side: strategy.Side, EntryTime: bar.Time, EntryPrice: fill, Quantity: qty}
+				entryFee = fill * qty * fee
+				cash -= entryFee
+				best = fill
+				entryIdx = i
+			}
+		}
+		pendingEntry = false
+
+		// Protective exits within the bar.
+		if pos != nil {
+			if price, reason, hit := protectiveExit(strategy, pos.EntryPrice, best, dir, bar); hit {
+				// The position was held until the exit within this bar.
+				barsInPos++
+				closePosition(i, price, reason)
+			} else if dir > 0 {
+				best = math.Max(best, bar.High)
+			} else {
+				best = math.Min(best, bar.Low)
+			}
+		}
+
+		if pos != nil {
+			barsInPos++
+			if strategy.MaxBarsInTrade > 0 && i-entryIdx+1 >= strategy.MaxBarsInTrade {
+				closePosition(i, bar.Close, ExitMaxBars)
+			}
+		}
+
+		// Signals on this close act on the next bar.
+		if i < len(candles)-1 {
+			if pos != nil && exitSignals != nil && truthy(exitSignals[i]) {
+				pendingExit = true
+			}
+			if pos == nil && truthy(entrySignals[i]) {
+				pendingEntry = true
+			}
+		} else if pos != nil {
+			closePosition(i, bar.Close, ExitEndOfData)
+		}
+
+		equity := cash
+		if pos != nil {
+			equity += dir * (bar.Close - pos.EntryPrice) * pos.Quantity
+		}
+		out.Equity = append(out.Equity, EquityPoint{Time: bar.Time, Equity: equity})
+	}
+	if progress != nil {
+		progress(1)
+	}
+
+	out.Stats = computeBacktestStats(out.Trades, out.Equity, strategy.InitialCapital, interval)
+	out.Stats.ExposurePct = roundTo(100*float64(barsInPos)/float64(len(candles)), 2)
+	return out, nil
+}
+
+// SummarizeBacktest aggregates per-market results into BacktestResult.Summary.
+func SummarizeBacktest(markets []MarketBacktest, initialCapital float64) BacktestStats {
+	trades := make([]BacktestTrade, 0)
+	var sharpe, sortino, exposure, worstDD, worstDDPct float64
+	counted := 0
+	for _, m := range markets {
+		if m.Error != "" {
+			continue
+		}
+		trades = append(trades, m.Trades...)
+		sharpe += m.Stats.Sharpe
+		sortino += m.Stats.Sortino
+		exposure += m.Stats.ExposurePct
+		worstDD = math.Max(worstDD, m.Stats.MaxDrawdown)
+		worstDDPct = math.Max(worstDDPct, m.Stats.MaxDrawdownPct)
+		counted++
+	}
+
+	stats := computeBacktestStats(trades, nil, initialCapital*float64(counted), "")
+	if counted > 0 {
+		stats.Sharpe = roundTo(sharpe/float64(counted), 3)
+		stats.Sortino = roundTo(sortino/float64(counted), 3)
+		stats.ExposurePct = roundTo(exposure/float64(counted), 2)
+	}
+	stats.MaxDrawdown = worstDD
+	stats.MaxDrawdownPct = worstDDPct
+	return stats
+}
+
+func positionSize(s BacktestStrategy, equity, price float64) float64 {
+	var notional float64
+	switch s.Sizing.Mode {
+	case SizingFixedNotional:
+		notional = s.Sizing.Value
+	case SizingRiskPercent:
+		notional = equity * s.Sizing.Value / s.StopLossPct
+	default:
+		notional = equity * s.Sizing.Value / 100
+	}
+	notional = math.Min(notional, equity*s.MaxLeverage)
+	if notional <= 0 || price <= 0 {
+		return 0
+	}
+	return notional / price
+}
+
+// protectiveExit checks stop loss, trailing stop and take profit against
+// one bar, stops first.
+func protectiveExit(s BacktestStrategy, entryPrice, best, dir float64, bar Candle) (float64, string, bool) {
+	// adverse/favourable extremes of the bar for this side
+	adverse, favourable := bar.Low, bar.High
+	if dir < 0 {
+		adverse, favourable = bar.High, bar.Low
+	}
+
+	stop, reason := 0.0, ""
+	if s.StopLossPct > 0 {
+		stop, reason = entryPrice*(1-dir*s.StopLossPct/100), ExitStopLoss
+	}
+	if s.TrailingStopPct > 0 {
+		trail := best * (1 - dir*s.TrailingStopPct/100)
+		if reason == "" || dir*(trail-stop) > 0 {
+			stop, reason = trail, ExitTrailing
+		}
+	}
+	if reason != "" && dir*(adverse-stop) <= 0 {
+		if dir*(bar.Open-stop) < 0 {
+			return bar.Open, reason, true
+		}
+		return stop, reason, true
+	}
+
+	if s.TakeProfitPct > 0 {
+		target := entryPrice * (1 + dir*s.TakeProfitPct/100)
+		if dir*(favourable-target) >= 0 {
+			if dir*(bar.Open-target) > 0 {
+				return bar.Open, ExitTakeProfit, true
+			}
+			return target, ExitTakeProfit, true
+		}
+	}
+	return 0, "", false
+}
+
+func computeBacktestStats(trades []BacktestTrade, equity []EquityPoint, initialCapital float64, interval string) BacktestStats {
+	st := BacktestStats{TotalTrades: len(trades)}
+	for _, t := range trades {
+		st.NetProfit += t.PnL
+		st.TotalFees += t.Fees
+		if t.PnL > 0 {
+			st.Wins++
+			st.GrossProfit += t.PnL
+			st.LargestWin = math.Max(st.LargestWin, t.PnL)
+		} else {
+			st.Losses++
+			st.GrossLoss += -t.PnL
+			st.LargestLoss = math.Min(st.LargestLoss, t.PnL)
+		}
+	}
+	if st.TotalTrades > 0 {
+		st.WinRate = roundTo(100*float64(st.Wins)/float64(st.TotalTrades), 2)
+		st.AvgTrade = st.NetProfit / float64(st.TotalTrades)
+	}
+	if st.Wins > 0 {
+		st.AvgWin = st.GrossProfit / float64(st.Wins)
+	}
+	if st.Losses > 0 {
+		st.AvgLoss = -st.GrossLoss / float64(st.Losses)
+	}
+	if st.GrossLoss > 0 {
+		st.ProfitFactor = roundTo(st.GrossProfit/st.GrossLoss, 3)
+	}
+	if initialCapital > 0 {
+		st.NetProfitPct = roundTo(100*st.NetProfit/initialCapital, 2)
+	}
+
+	if len(equity) < 2 {
+		return st
+	}
+
+	peak := equity[0].Equity
+	returns := make([]float64, 0, len(equity)-1)
+	for i, p := range equity {
+		peak = math.Max(peak, p.Equity)
+		if dd := peak - p.Equity; dd > st.MaxDrawdown {
+			st.MaxDrawdown = dd
+			if peak > 0 {
+				st.MaxDrawdownPct = roundTo(100*dd/peak, 2)
+			}
+		}
+		if i > 0 && equity[i-1].Equity > 0 {
+			returns = append(returns, p.Equity/equity[i-1].Equity-1)
+		}
+	}
+
+	barSec, ok := IntervalSeconds(interval)
+	if !ok || len(returns) < 2 {
+		return st
+	}
+	annual := math.Sqrt(365 * 86400 / float64(barSec))
+	avg := mean(returns)
+	variance, downside := 0.0, 0.0
+	for _, r := range returns {
+		variance += (r - avg) * (r - avg)
+		if r < 0 {
+			downside += r * r
+		}
+	}
+	if sd := math.Sqrt(variance / float64(len(returns)-1)); sd > 0 {
+		st.Sharpe = roundTo(avg/sd*annual, 3)
+	}
+	if dd := math.Sqrt(downside / float64(len(returns))); dd > 0 {
+		st.Sortino = roundTo(avg/dd*annual, 3)
+	}
+	return st
+}
+
+func truthy(v float64) bool {
+	return v != 0 && !math.IsNaN(v)
+}
+
+func roundTo(v float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(v*p) / p
+}
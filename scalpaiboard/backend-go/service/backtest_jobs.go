@@ -0,0 +1,314 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Backtest job states.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Candle sources for backtests.
+const (
+	// CandleSourceExchange pages klines from the market's exchange.
+	CandleSourceExchange = "exchange"
+	// CandleSourceStored reads the candles table.
+	CandleSourceStored = "stored"
+)
+
+const (
+	backtestConcurrentJobs = 2
+	backtestMaxJobsPerUser = 5
+	backtestJobRetention   = time.Hour
+)
+
+// ErrBacktestNotFound is returned for unknown or foreign job ids.
+var ErrBacktestNotFound = errors.New("backtest not found")
+
+// CandleLoader fetches up to bars candles (ascending) for a marketId.
+type CandleLoader func(ctx context.Context, marketID, interval string, bars int) ([]Candle, error)
+
+// BacktestRequest describes a backtest run.
+type BacktestRequest struct {
+	Markets  []string         `json:"markets"`
+	Interval string           `json:"interval"`
+	Bars     int              `json:"bars"`
+	Source   string           `json:"source,omitempty"`
+	Strategy BacktestStrategy `json:"strategy"`
+}
+
+// BacktestJob is an asynchronous backtest. Progress runs from 0 to 1.
+type BacktestJob struct {
+	ID         string          `json:"id"`
+	Status     string          `json:"status"`
+	Progress   float64         `json:"progress"`
+	Request    BacktestRequest `json:"request"`
+	Result     *BacktestResult `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	StartedAt  *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+
+	userID string
+	cancel context.CancelFunc
+}
+
+// BacktestService runs backtest jobs in the background, at most
+// backtestConcurrentJobs at a time. Jobs live in memory and are dropped an
+// hour after they finish.
+type BacktestService struct {
+	db     *sql.DB
+	loader CandleLoader
+
+	mu   sync.Mutex
+	jobs map[string]*BacktestJob
+	sem  chan struct{}
+}
+
+func NewBacktestService(db *sql.DB, loader CandleLoader) *BacktestService {
+	return &BacktestService{
+		db:     db,
+		loader: loader,
+		jobs:   make(map[string]*BacktestJob),
+		sem:    make(chan struct{}, backtestConcurrentJobs),
+	}
+}
+
+// Submit validates a request and queues it.
+func (s *BacktestService) Submit(userID string, req BacktestRequest) (*BacktestJob, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.pruneLocked()
+	active := 0
+	for _, job := range s.jobs {
+		if job.userID == userID && (job.Status == JobQueued || job.Status == JobRunning) {
+			active++
+		}
+	}
+	if active >= backtestMaxJobsPerUser {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: %d backtests already running", ErrInvalidStrategy, active)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &BacktestJob{
+		ID:        newJobID(),
+		Status:    JobQueued,
+		Request:   req,
+		CreatedAt: time.Now(),
+		userID:    userID,
+		cancel:    cancel,
+	}
+	s.jobs[job.ID] = job
+	snapshot := *job
+	s.mu.Unlock()
+
+	go s.run(ctx, job)
+	return &snapshot, nil
+}
+
+// Get returns a copy of one of the user's jobs.
+func (s *BacktestService) Get(userID, id string) (*BacktestJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok || job.userID != userID {
+		return nil, ErrBacktestNotFound
+	}
+	snapshot := *job
+	return &snapshot, nil
+}
+
+// List returns the user's jobs, newest first, without results.
+func (s *BacktestService) List(userID string) []BacktestJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]BacktestJob, 0)
+	for _, job := range s.jobs {
+		if job.userID != userID {
+			continue
+		}
+		snapshot := *job
+		snapshot.Result = nil
+		out = append(out, snapshot)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+// Cancel stops a queued or running job.
+func (s *BacktestService) Cancel(userID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok || job.userID != userID {
+		return ErrBacktestNotFound
+	}
+	job.cancel()
+	return nil
+}
+
+func (s *BacktestService) run(ctx context.Context, job *BacktestJob) {
+	defer job.cancel()
+
+	select {
+	case s.sem <- struct{}{}:
+		defer func() { <-s.sem }()
+	case <-ctx.Done():
+		s.finish(job, nil, ctx.Err())
+		return
+	}
+
+	now := time.Now()
+	s.update(job, func() {
+		job.Status = JobRunning
+		job.StartedAt = &now
+	})
+
+	req := job.Request
+	n := float64(len(req.Markets))
+	result := &BacktestResult{Interval: req.Interval, Markets: make([]MarketBacktest, 0, len(req.Markets))}
+
+	for i, marketID := range req.Markets {
+		base := float64(i) / n
+		candles, err := s.loadCandles(ctx, req, marketID)
+		if err == nil {
+			// Loading counts for the first 30% of each market's share.
+			s.setProgress(job, base+0.3/n)
+			var mb MarketBacktest
+			mb, err = RunBacktest(ctx, marketID, req.Interval, candles, req.Strategy, func(p float64) {
+				s.setProgress(job, base+(0.3+0.7*p)/n)
+			})
+			if err == nil {
+				result.Markets = append(result.Markets, mb)
+				continue
+			}
+		}
+		if ctx.Err() != nil {
+			s.finish(job, nil, ctx.Err())
+			return
+		}
+		result.Markets = append(result.Markets, MarketBacktest{MarketID: marketID, Error: err.Error()})
+	}
+
+	result.Summary = SummarizeBacktest(result.Markets, req.Strategy.InitialCapital)
+	s.finish(job, result, nil)
+}
+
+func (s *BacktestService) loadCandles(ctx context.Context, req BacktestRequest, marketID string) ([]Candle, error) {
+	// One extra bar stands in for the forming one, which is dropped: its
+	// close is not final and would leak into the last signals.
+	var candles []Candle
+	var err error
+	if req.Source == CandleSourceStored {
+		candles, err = LoadStoredCandles(s.db, marketID, req.Interval, req.Bars+1)
+	} else {
+		candles, err = s.loader(ctx, marketID, req.Interval, req.Bars+1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if LastBarForming(req.Interval, candles, time.Now()) {
+		candles = candles[:len(candles)-1]
+	}
+	if len(candles) > req.Bars {
+		candles = candles[len(candles)-req.Bars:]
+	}
+	return candles, nil
+}
+
+func (s *BacktestService) update(job *BacktestJob, fn func()) {
+	s.mu.Lock()
+	fn()
+	s.mu.Unlock()
+}
+
+func (s *BacktestService) setProgress(job *BacktestJob, p float64) {
+	s.update(job, func() { job.Progress = roundTo(p, 3) })
+}
+
+func (s *BacktestService) finish(job *BacktestJob, result *BacktestResult, err error) {
+	now := time.Now()
+	s.update(job, func() {
+		job.FinishedAt = &now
+		switch {
+		case errors.Is(err, context.Canceled):
+			job.Status = JobCancelled
+		case err != nil:
+			job.Status = JobFailed
+			job.Error = err.Error()
+		default:
+			job.Status = JobCompleted
+			job.Progress = 1
+			job.Result = result
+		}
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("⚠️ Backtest %s failed: %v", job.ID, err)
+	}
+}
+
+// pruneLocked drops finished jobs past their retention.
+func (s *BacktestService) pruneLocked() {
+	cutoff := time.Now().Add(-backtestJobRetention)
+	for id, job := range s.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+func (r *BacktestRequest) validate() error {
+	if len(r.Markets) == 0 || len(r.Markets) > BacktestMaxMarkets {
+		return fmt.Errorf("%w: between 1 and %d markets required", ErrInvalidStrategy, BacktestMaxMarkets)
+	}
+	if r.Interval == "" {
+		r.Interval = "1h"
+	}
+	if _, ok := IntervalSeconds(r.Interval); !ok {
+		return fmt.Errorf("%w: unsupported interval %q", ErrInvalidStrategy, r.Interval)
+	}
+	if r.Bars == 0 {
+		r.Bars = 1000
+	}
+	if r.Bars < 50 || r.Bars > BacktestMaxBars {
+		return fmt.Errorf("%w: bars must be between 50 and %d", ErrInvalidStrategy, BacktestMaxBars)
+	}
+	switch r.Source {
+	case "":
+		r.Source = CandleSourceExchange
+	case CandleSourceExchange:
+	case CandleSourceStored:
+		for _, marketID := range r.Markets {
+			if !IsStoredCandleMarket(marketID) {
+				return fmt.Errorf("%w: stored candles are only kept for spot markets, not %s", ErrInvalidStrategy, marketID)
+			}
+		}
+	default:
+		return fmt.Errorf("%w: unknown source %q", ErrInvalidStrategy, r.Source)
+	}
+	return r.Strategy.Validate()
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBacktestExposure(t *testing.T) {
+	// Entries fill at the open after a close above 100; bar 3 dips to the
+	// stop and bar 7 reaches the target.
+	candles := closeCandles(101, 101, 101, 101, 101, 101, 101, 101, 101, 99)
+	candles[3].Low = 95
+	candles[7].High = 110
+
+	tests := []struct {
+		name     string
+		strategy BacktestStrategy
+		bars     []int
+		exposure float64
+	}{
+		{
+			name:     "stop and take-profit bars count as held",
+			strategy: BacktestStrategy{Entry: "close > 100", StopLossPct: 2, TakeProfitPct: 5},
+			bars:     []int{3, 4, 2},
+			exposure: 90,
+		},
+		{
+			name:     "max bars exit",
+			strategy: BacktestStrategy{Entry: "close > 100", MaxBarsInTrade: 4},
+			bars:     []int{4, 4, 1},
+			exposure: 90,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.strategy.Validate(); err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			out, err := RunBacktest(context.Background(), "BI:SPOT:BTCUSDT", "1m", candles, tt.strategy, nil)
+			if err != nil {
+				t.Fatalf("RunBacktest: %v", err)
+			}
+			if len(out.Trades) != len(tt.bars) {
+				t.Fatalf("got %d trades %+v, want %d", len(out.Trades), out.Trades, len(tt.bars))
+			}
+			for i, trade := range out.Trades {
+				if trade.Bars != tt.bars[i] {
+					t.Errorf("trade %d: %d bars (%s), want %d", i, trade.Bars, trade.ExitReason, tt.bars[i])
+				}
+			}
+			if out.Stats.ExposurePct != tt.exposure {
+				t.Errorf("exposure %v, want %v", out.Stats.ExposurePct, tt.exposure)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Candle is a single OHLCV bar. Time is the bar open time in Unix seconds,
+// matching the candles returned by /api/coins/:symbol/candles.
+type Candle struct {
+	Time   int64   `json:"time"`
+	Open   float64 `json:"open"`
+	High   float64 `json:"high"`
+	Low    float64 `json:"low"`
+	Close  float64 `json:"close"`
+	Volume float64 `json:"volume"`
+}
+
+// CandleSeries splits candles into the close/high/low slices used by the
+// indicator functions.
+func CandleSeries(candles []Candle) (closes, highs, lows []float64) {
+	closes = make([]float64, len(candles))
+	highs = make([]float64, len(candles))
+	lows = make([]float64, len(candles))
+	for i, c := range candles {
+		closes[i] = c.Close
+		highs[i] = c.High
+		lows[i] = c.Low
+	}
+	return closes, highs, lows
+}
+
+var intervalSeconds = map[string]int64{
+	"1m": 60, "3m": 3 * 60, "5m": 5 * 60, "15m": 15 * 60, "30m": 30 * 60,
+	"1h": 3600, "2h": 2 * 3600, "4h": 4 * 3600, "6h": 6 * 3600, "12h": 12 * 3600,
+	"1d": 86400, "1w": 7 * 86400, "1M": 30 * 86400,
+}
+
+// IntervalSeconds returns the nominal length of a kline interval ("1M" is
+// counted as 30 days).
+func IntervalSeconds(interval string) (int64, bool) {
+	sec, ok := intervalSeconds[interval]
+	return sec, ok
+}
+
+// LastBarForming reports whether the last of ascending candles is still
+// forming at now, i.e. its interval has not ended yet. Unknown intervals
+// count as forming.
+func LastBarForming(interval string, candles []Candle, now time.Time) bool {
+	if len(candles) == 0 {
+		return false
+	}
+	sec, ok := IntervalSeconds(interval)
+	return !ok || candles[len(candles)-1].Time+sec > now.Unix()
+}
+
+// ErrNoStoredCandles is returned when the candle store has no candles for
+// a market.
+var ErrNoStoredCandles = errors.New("no stored candles")
+
+// storedCandleCoin returns the exchange and symbol of the coin whose
+// candles a market reads from the store. Coins are spot markets, so ok is
+// false for perpetuals and malformed marketIds.
+func storedCandleCoin(marketID string) (exchange, symbol string, ok bool) {
+	parts := strings.Split(strings.ToUpper(marketID), ":")
+	if len(parts) != 3 || parts[1] != "SPOT" || parts[2] == "" {
+		return "", "", false
+	}
+	switch parts[0] {
+	case "BI":
+		return "binance", parts[2], true
+	case "BY":
+		return "bybit", parts[2], true
+	}
+	return "", "", false
+}
+
+// IsStoredCandleMarket reports whether the candle store can hold candles of
+// a market, which is only the case for spot markets.
+func IsStoredCandleMarket(marketID string) bool {
+	_, _, ok := storedCandleCoin(marketID)
+	return ok
+}
+
+// LoadStoredCandles reads the newest bars rows of the candles table for the
+// market's coin and returns them in ascending order.
+func LoadStoredCandles(db *sql.DB, marketID, interval string, bars int) ([]Candle, error) {
+	exchange, symbol, ok := storedCandleCoin(marketID)
+	if !ok {
+		return nil, fmt.Errorf("%w: only spot markets are stored, not %s", ErrNoStoredCandles, marketID)
+	}
+
+	query := `
+		SELECT EXTRACT(EPOCH FROM c.timestamp)::BIGINT, c.open, c.high, c.low, c.close, c.volume
+		FROM candles c
+		JOIN coins co ON co.id = c.coin_id
+		WHERE co.symbol = $1 AND co.exchange = $2 AND c.timeframe = $3
+		ORDER BY c.timestamp DESC
+		LIMIT $4
+	`
+	rows, err := db.Query(query, symbol, exchange, interval, bars)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candles := make([]Candle, 0, bars)
+	for rows.Next() {
+		var c Candle
+		if err := rows.Scan(&c.Time, &c.Open, &c.High, &c.Low, &c.Close, &c.Volume); err != nil {
+			return nil, err
+		}
+		candles = append(candles, c)
+	}
+	if len(candles) == 0 {
+		return nil, ErrNoStoredCandles
+	}
+	sort.Slice(candles, func(i, j int) bool { return candles[i].Time < candles[j].Time })
+	return candles, nil
+}
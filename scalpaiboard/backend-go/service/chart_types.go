@@ -0,0 +1,553 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Chart types accepted by GetCandles and GetAnalysis.
+const (
+	ChartCandles     = "candles"
+	ChartHeikinAshi  = "heikin_ashi"
+	ChartRenko       = "renko"
+	ChartRange       = "range"
+	ChartPointFigure = "point_figure"
+	ChartKagi        = "kagi"
+)
+
+const (
+	defaultChartATRPeriod = 14
+	defaultPnFReversal    = 3
+	// minChartBoxFraction is the smallest box as a fraction of the highest
+	// price: a finer box yields an unbounded number of bars and, below the
+	// float resolution of the price, never advances.
+	minChartBoxFraction = 1e-5
+	// maxChartBars bounds the bars one chart may produce.
+	maxChartBars = 20000
+)
+
+// ErrInvalidChartType is returned for unknown chart types or bad options.
+var ErrInvalidChartType = errors.New("invalid chart type")
+
+// ChartOptions configures a chart transform. BoxSize is the Renko brick,
+// the range-bar size, the point-and-figure box and the Kagi reversal
+// amount; when it is 0 the last ATR(ATRPeriod) of the history is used.
+// Reversal is the number of boxes a point-and-figure column needs to turn.
+type ChartOptions struct {
+	Type      string  `json:"chartType"`
+	BoxSize   float64 `json:"boxSize,omitempty"`
+	ATRPeriod int     `json:"atrPeriod,omitempty"`
+	Reversal  int     `json:"reversal,omitempty"`
+}
+
+// ChartBar is one bar of a transformed chart. Time is the open time of the
+// candle that started the bar, so several bars built from one candle share
+// a time. Direction is 1 for up bars (X columns, rising Kagi lines) and -1
+// for down bars. Boxes is set for point-and-figure columns and Line
+// ("yang" or "yin") for Kagi segments.
+type ChartBar struct {
+	Time      int64   `json:"time"`
+	Open      float64 `json:"open"`
+	High      float64 `json:"high"`
+	Low       float64 `json:"low"`
+	Close     float64 `json:"close"`
+	Volume    float64 `json:"volume"`
+	Direction int     `json:"direction"`
+	Boxes     int     `json:"boxes,omitempty"`
+	Line      string  `json:"line,omitempty"`
+}
+
+// ChartBuilder turns a candle stream into chart bars incrementally: Add
+// commits closed candles and Preview shows what a still-forming candle
+// would produce, so a chart can be built from history and then kept up to
+// date from live candles. A builder produces at most maxChartBars bars.
+type ChartBuilder struct {
+	opts  ChartOptions
+	state chartState
+	bars  int
+}
+
+// chartState builds bars; add stops once it has produced room bars.
+type chartState interface {
+	add(c Candle, room int) []ChartBar
+	forming() *ChartBar
+	clone() chartState
+}
+
+// IsChartType reports whether name is a known chart type.
+func IsChartType(name string) bool {
+	switch name {
+	case ChartCandles, ChartHeikinAshi, ChartRenko, ChartRange, ChartPointFigure, ChartKagi:
+		return true
+	}
+	return false
+}
+
+// NewChartBuilder validates opts and returns a builder. history is read to
+// size the ATR box when BoxSize is 0 and to check the box against the
+// price; it is not added.
+func NewChartBuilder(opts ChartOptions, history []Candle) (*ChartBuilder, error) {
+	if !IsChartType(opts.Type) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidChartType, opts.Type)
+	}
+	if opts.BoxSize < 0 || math.IsNaN(opts.BoxSize) || math.IsInf(opts.BoxSize, 0) {
+		return nil, fmt.Errorf("%w: boxSize must be a non-negative number", ErrInvalidChartType)
+	}
+
+	b := &ChartBuilder{opts: opts}
+	switch opts.Type {
+	case ChartCandles:
+		b.state = &candleState{}
+		return b, nil
+	case ChartHeikinAshi:
+		b.state = &heikinAshiState{}
+		return b, nil
+	}
+
+	if b.opts.BoxSize == 0 {
+		if b.opts.ATRPeriod == 0 {
+			b.opts.ATRPeriod = defaultChartATRPeriod
+		}
+		box, err := atrBoxSize(history, b.opts.ATRPeriod)
+		if err != nil {
+			return nil, err
+		}
+		b.opts.BoxSize = box
+	}
+
+	box := b.opts.BoxSize
+	if least := maxCandleHigh(history) * minChartBoxFraction; box < least {
+		return nil, fmt.Errorf("%w: boxSize must be at least %g", ErrInvalidChartType, roundSignificant(least, 3))
+	}
+	switch opts.Type {
+	case ChartRenko:
+		b.state = &renkoState{box: box}
+	case ChartRange:
+		b.state = &rangeState{size: box}
+	case ChartPointFigure:
+		if b.opts.Reversal == 0 {
+			b.opts.Reversal = defaultPnFReversal
+		}
+		if b.opts.Reversal < 1 || b.opts.Reversal > 10 {
+			return nil, fmt.Errorf("%w: reversal must be between 1 and 10 boxes", ErrInvalidChartType)
+		}
+		b.state = &pointFigureState{box: box, reversal: float64(b.opts.Reversal)}
+	case ChartKagi:
+		b.state = &kagiState{reversal: box}
+	}
+	return b, nil
+}
+
+// Options returns the options with defaults and the ATR box filled in.
+func (b *ChartBuilder) Options() ChartOptions {
+	return b.opts
+}
+
+// Add commits a closed candle and returns the bars it completed. It fails
+// once the chart would exceed maxChartBars.
+func (b *ChartBuilder) Add(c Candle) ([]ChartBar, error) {
+	bars := b.state.add(c, maxChartBars-b.bars+1)
+	b.bars += len(bars)
+	if b.bars > maxChartBars {
+		return nil, errChartTooLarge()
+	}
+	return bars, nil
+}
+
+// Preview returns the bars a forming candle would complete followed by the
+// bar still in progress, without changing the builder.
+func (b *ChartBuilder) Preview(c Candle) ([]ChartBar, error) {
+	s := b.state.clone()
+	bars := s.add(c, maxChartBars-b.bars+1)
+	if b.bars+len(bars) > maxChartBars {
+		return nil, errChartTooLarge()
+	}
+	if f := s.forming(); f != nil {
+		bars = append(bars, *f)
+	}
+	return bars, nil
+}
+
+func errChartTooLarge() error {
+	return fmt.Errorf("%w: more than %d bars; use a larger boxSize or fewer candles", ErrInvalidChartType, maxChartBars)
+}
+
+// BuildChart transforms closed candles plus an optional forming candle
+// and returns the committed bars and the provisional ones.
+func BuildChart(opts ChartOptions, closed []Candle, forming *Candle) (ChartOptions, []ChartBar, []ChartBar, error) {
+	history := closed
+	if forming != nil {
+		history = append(append([]Candle(nil), closed...), *forming)
+	}
+	b, err := NewChartBuilder(opts, history)
+	if err != nil {
+		return opts, nil, nil, err
+	}
+
+	bars := make([]ChartBar, 0, len(closed))
+	for _, c := range closed {
+		added, err := b.Add(c)
+		if err != nil {
+			return opts, nil, nil, err
+		}
+		bars = append(bars, added...)
+	}
+	provisional := make([]ChartBar, 0)
+	if forming != nil {
+		if provisional, err = b.Preview(*forming); err != nil {
+			return opts, nil, nil, err
+		}
+	} else if f := b.state.forming(); f != nil {
+		provisional = append(provisional, *f)
+	}
+	return b.Options(), bars, provisional, nil
+}
+
+// ChartBarsToCandles converts chart bars into candles so indicators can
+// run on a transformed series.
+func ChartBarsToCandles(bars []ChartBar) []Candle {
+	out := make([]Candle, len(bars))
+	for i, b := range bars {
+		out[i] = Candle{Time: b.Time, Open: b.Open, High: b.High, Low: b.Low, Close: b.Close, Volume: b.Volume}
+	}
+	return out
+}
+
+func atrBoxSize(candles []Candle, period int) (float64, error) {
+	if period < 2 || period > 200 {
+		return 0, fmt.Errorf("%w: atrPeriod must be between 2 and 200", ErrInvalidChartType)
+	}
+	closes, highs, lows := CandleSeries(candles)
+	atr, err := ATR(highs, lows, closes, period)
+	if err != nil || len(atr) == 0 || atr[len(atr)-1] <= 0 {
+		return 0, fmt.Errorf("%w: not enough candles for an ATR(%d) box; set boxSize", ErrInvalidChartType, period)
+	}
+	return roundSignificant(atr[len(atr)-1], 3), nil
+}
+
+func maxCandleHigh(candles []Candle) float64 {
+	high := 0.0
+	for _, c := range candles {
+		high = math.Max(high, math.Max(c.High, c.Close))
+	}
+	return high
+}
+
+// roundSignificant rounds v to digits significant figures, so ATR boxes
+// come out as readable prices such as 152 or 0.00314.
+func roundSignificant(v float64, digits int) float64 {
+	if v == 0 {
+		return 0
+	}
+	decimals := digits - 1 - int(math.Floor(math.Log10(math.Abs(v))))
+	if decimals < 0 {
+		p := math.Pow(10, float64(-decimals))
+		return math.Round(v/p) * p
+	}
+	return roundTo(v, decimals)
+}
+
+func barDirection(open, close float64) int {
+	if close < open {
+		return -1
+	}
+	return 1
+}
+
+// candleState passes candles through unchanged.
+type candleState struct{}
+
+func (s *candleState) add(c Candle, _ int) []ChartBar {
+	return []ChartBar{{Time: c.Time, Open: c.Open, High: c.High, Low: c.Low, Close: c.Close, Volume: c.Volume, Direction: barDirection(c.Open, c.Close)}}
+}
+
+func (s *candleState) forming() *ChartBar { return nil }
+func (s *candleState) clone() chartState  { cp := *s; return &cp }
+
+type heikinAshiState struct {
+	started             bool
+	prevOpen, prevClose float64
+}
+
+func (s *heikinAshiState) add(c Candle, _ int) []ChartBar {
+	haClose := (c.Open + c.High + c.Low + c.Close) / 4
+	haOpen := (c.Open + c.Close) / 2
+	if s.started {
+		haOpen = (s.prevOpen + s.prevClose) / 2
+	}
+	s.started, s.prevOpen, s.prevClose = true, haOpen, haClose
+	return []ChartBar{{
+		Time:      c.Time,
+		Open:      haOpen,
+		High:      math.Max(c.High, math.Max(haOpen, haClose)),
+		Low:       math.Min(c.Low, math.Min(haOpen, haClose)),
+		Close:     haClose,
+		Volume:    c.Volume,
+		Direction: barDirection(haOpen, haClose),
+	}}
+}
+
+func (s *heikinAshiState) forming() *ChartBar { return nil }
+func (s *heikinAshiState) clone() chartState  { cp := *s; return &cp }
+
+// volumePool collects candle volume until bars complete and splits it
+// evenly between the bars a candle completes.
+type volumePool struct {
+	pending float64
+}
+
+func (p *volumePool) take(c Candle) {
+	p.pending += c.Volume
+}
+
+func (p *volumePool) assign(bars []ChartBar) []ChartBar {
+	if len(bars) == 0 {
+		return bars
+	}
+	share := p.pending / float64(len(bars))
+	for i := range bars {
+		bars[i].Volume = share
+	}
+	p.pending = 0
+	return bars
+}
+
+// renkoState builds fixed-size bricks from closes on a grid of box
+// multiples. A brick in the trend direction needs one box beyond the last
+// brick; a reversal needs two.
+type renkoState struct {
+	box         float64
+	started     bool
+	top, bottom float64
+	vol         volumePool
+}
+
+func (s *renkoState) add(c Candle, room int) []ChartBar {
+	s.vol.take(c)
+	if !s.started {
+		level := RoundToStep(c.Close, s.box)
+		s.started, s.top, s.bottom = true, level, level
+		return nil
+	}
+
+	bars := make([]ChartBar, 0)
+	for c.Close >= s.top+s.box && len(bars) < room {
+		next := RoundToStep(s.top+s.box, s.box)
+		bars = append(bars, ChartBar{Time: c.Time, Open: s.top, High: next, Low: s.top, Close: next, Direction: 1})
+		s.bottom, s.top = s.top, next
+	}
+	for c.Close <= s.bottom-s.box && len(bars) < room {
+		next := RoundToStep(s.bottom-s.box, s.box)
+		bars = append(bars, ChartBar{Time: c.Time, Open: s.bottom, High: s.bottom, Low: next, Close: next, Direction: -1})
+		s.top, s.bottom = s.bottom, next
+	}
+	return s.vol.assign(bars)
+}
+
+func (s *renkoState) forming() *ChartBar { return nil }
+func (s *renkoState) clone() chartState  { cp := *s; return &cp }
+
+// rangeState builds bars whose high-low span is exactly size. Inside a
+// candle the price is assumed to travel open, low, high, close for up
+// candles and open, high, low, close for down candles.
+type rangeState struct {
+	size    float64
+	started bool
+	cur     ChartBar
+	vol     volumePool
+}
+
+func (s *rangeState) add(c Candle, room int) []ChartBar {
+	s.vol.take(c)
+	path := []float64{c.Open, c.Low, c.High, c.Close}
+	if c.Close < c.Open {
+		path = []float64{c.Open, c.High, c.Low, c.Close}
+	}
+
+	bars := make([]ChartBar, 0)
+	for _, p := range path {
+		if !s.started {
+			s.started = true
+			s.cur = ChartBar{Time: c.Time, Open: p, High: p, Low: p, Close: p}
+			continue
+		}
+		for len(bars) < room {
+			if p > s.cur.Low+s.size {
+				s.cur.High, s.cur.Close = s.cur.Low+s.size, s.cur.Low+s.size
+			} else if p < s.cur.High-s.size {
+				s.cur.Low, s.cur.Close = s.cur.High-s.size, s.cur.High-s.size
+			} else {
+				s.cur.High, s.cur.Low, s.cur.Close = math.Max(s.cur.High, p), math.Min(s.cur.Low, p), p
+				break
+			}
+			s.cur.Direction = barDirection(s.cur.Open, s.cur.Close)
+			bars = append(bars, s.cur)
+			next := s.cur.Close
+			s.cur = ChartBar{Time: c.Time, Open: next, High: next, Low: next, Close: next}
+		}
+	}
+	return s.vol.assign(bars)
+}
+
+func (s *rangeState) forming() *ChartBar {
+	if !s.started {
+		return nil
+	}
+	f := s.cur
+	f.Direction = barDirection(f.Open, f.Close)
+	f.Volume = s.vol.pending
+	return &f
+}
+
+func (s *rangeState) clone() chartState { cp := *s; return &cp }
+
+// pointFigureState builds X (rising) and O (falling) columns from closes
+// on a grid of box multiples. A column turns after reversal boxes.
+type pointFigureState struct {
+	box, reversal float64
+	started       bool
+	dir           int
+	top, bottom   float64
+	since         int64
+	vol           volumePool
+}
+
+func (s *pointFigureState) add(c Candle, _ int) []ChartBar {
+	s.vol.take(c)
+	up := math.Floor(c.Close/s.box+1e-9) * s.box
+	down := math.Ceil(c.Close/s.box-1e-9) * s.box
+	if !s.started {
+		s.started, s.top, s.bottom, s.since = true, up, up, c.Time
+		return nil
+	}
+
+	bars := make([]ChartBar, 0)
+	switch {
+	case s.dir >= 0 && up >= s.top+s.box:
+		s.dir, s.top = 1, up
+	case s.dir <= 0 && down <= s.bottom-s.box:
+		s.dir, s.bottom = -1, down
+	case s.dir == 1 && c.Close <= s.top-s.reversal*s.box:
+		bars = append(bars, s.column())
+		s.dir, s.top, s.bottom, s.since = -1, s.top-s.box, down, c.Time
+	case s.dir == -1 && c.Close >= s.bottom+s.reversal*s.box:
+		bars = append(bars, s.column())
+		s.dir, s.bottom, s.top, s.since = 1, s.bottom+s.box, up, c.Time
+	}
+	return s.vol.assign(bars)
+}
+
+func (s *pointFigureState) column() ChartBar {
+	bar := ChartBar{
+		Time:      s.since,
+		Open:      s.bottom,
+		High:      s.top,
+		Low:       s.bottom,
+		Close:     s.top,
+		Direction: 1,
+		Boxes:     int(math.Round((s.top-s.bottom)/s.box)) + 1,
+	}
+	if s.dir == -1 {
+		bar.Open, bar.Close, bar.Direction = s.top, s.bottom, -1
+	}
+	return bar
+}
+
+func (s *pointFigureState) forming() *ChartBar {
+	if !s.started || s.dir == 0 {
+		return nil
+	}
+	f := s.column()
+	f.Volume = s.vol.pending
+	return &f
+}
+
+func (s *pointFigureState) clone() chartState { cp := *s; return &cp }
+
+// kagiState builds Kagi segments from closes. A segment turns when price
+// retraces the reversal amount from its extreme. The line turns yang
+// (thick) above the previous shoulder and yin (thin) below the previous
+// waist.
+type kagiState struct {
+	reversal    float64
+	started     bool
+	dir         int
+	start, ext  float64
+	since       int64
+	yang        bool
+	shoulder    float64
+	waist       float64
+	hasShoulder bool
+	hasWaist    bool
+	vol         volumePool
+}
+
+func (s *kagiState) add(c Candle, _ int) []ChartBar {
+	s.vol.take(c)
+	p := c.Close
+	if !s.started {
+		s.started, s.start, s.ext, s.since = true, p, p, c.Time
+		return nil
+	}
+
+	bars := make([]ChartBar, 0)
+	switch s.dir {
+	case 0:
+		if p >= s.start+s.reversal {
+			s.dir, s.ext, s.yang = 1, p, true
+		} else if p <= s.start-s.reversal {
+			s.dir, s.ext, s.yang = -1, p, false
+		}
+	case 1:
+		if p > s.ext {
+			s.ext = p
+		} else if p <= s.ext-s.reversal {
+			bars = append(bars, s.segment())
+			s.shoulder, s.hasShoulder = s.ext, true
+			s.dir, s.start, s.ext, s.since = -1, s.ext, p, c.Time
+		}
+	case -1:
+		if p < s.ext {
+			s.ext = p
+		} else if p >= s.ext+s.reversal {
+			bars = append(bars, s.segment())
+			s.waist, s.hasWaist = s.ext, true
+			s.dir, s.start, s.ext, s.since = 1, s.ext, p, c.Time
+		}
+	}
+	if s.dir == 1 && s.hasShoulder && s.ext > s.shoulder {
+		s.yang = true
+	}
+	if s.dir == -1 && s.hasWaist && s.ext < s.waist {
+		s.yang = false
+	}
+	return s.vol.assign(bars)
+}
+
+func (s *kagiState) segment() ChartBar {
+	line := "yin"
+	if s.yang {
+		line = "yang"
+	}
+	return ChartBar{
+		Time:      s.since,
+		Open:      s.start,
+		High:      math.Max(s.start, s.ext),
+		Low:       math.Min(s.start, s.ext),
+		Close:     s.ext,
+		Direction: s.dir,
+		Line:      line,
+	}
+}
+
+func (s *kagiState) forming() *ChartBar {
+	if !s.started || s.dir == 0 {
+		return nil
+	}
+	f := s.segment()
+	f.Volume = s.vol.pending
+	return &f
+}
+
+func (s *kagiState) clone() chartState { cp := *s; return &cp }
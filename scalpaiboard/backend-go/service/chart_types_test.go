@@ -0,0 +1,102 @@
+package service
+
+import (
+	"errors"
+	"testing"
+)
+
+func closeCandles(closes ...float64) []Candle {
+	candles := make([]Candle, len(closes))
+	for i, c := range closes {
+		candles[i] = Candle{Time: int64(i * 60), Open: c, High: c, Low: c, Close: c, Volume: 1}
+	}
+	return candles
+}
+
+func TestBuildChart(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       ChartOptions
+		candles    []Candle
+		closes     []float64
+		directions []int
+		lines      []string
+	}{
+		{
+			name:       "renko bricks and two-box reversal",
+			opts:       ChartOptions{Type: ChartRenko, BoxSize: 10},
+			candles:    closeCandles(100, 105, 121, 125, 99, 85),
+			closes:     []float64{110, 120, 100, 90},
+			directions: []int{1, 1, -1, -1},
+		},
+		{
+			name:       "renko move below one box",
+			opts:       ChartOptions{Type: ChartRenko, BoxSize: 10},
+			candles:    closeCandles(100, 109, 91, 101),
+			closes:     []float64{},
+			directions: []int{},
+		},
+		{
+			name:       "range bars from one candle path",
+			opts:       ChartOptions{Type: ChartRange, BoxSize: 10},
+			candles:    []Candle{{Time: 0, Open: 100, High: 125, Low: 95, Close: 120}},
+			closes:     []float64{105, 115},
+			directions: []int{1, 1},
+		},
+		{
+			name:       "kagi turns keep yang until the waist breaks",
+			opts:       ChartOptions{Type: ChartKagi, BoxSize: 10},
+			candles:    closeCandles(100, 112, 120, 108, 105, 130, 115, 95),
+			closes:     []float64{120, 105, 130},
+			directions: []int{1, -1, 1},
+			lines:      []string{"yang", "yang", "yang"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, bars, _, err := BuildChart(tt.opts, tt.candles, nil)
+			if err != nil {
+				t.Fatalf("BuildChart: %v", err)
+			}
+			if len(bars) != len(tt.closes) {
+				t.Fatalf("got %d bars %+v, want %d", len(bars), bars, len(tt.closes))
+			}
+			for i, b := range bars {
+				if b.Close != tt.closes[i] || b.Direction != tt.directions[i] {
+					t.Errorf("bar %d: close %v direction %d, want %v %d", i, b.Close, b.Direction, tt.closes[i], tt.directions[i])
+				}
+				if tt.lines != nil && b.Line != tt.lines[i] {
+					t.Errorf("bar %d: line %q, want %q", i, b.Line, tt.lines[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBuildChartLimits(t *testing.T) {
+	swings := make([]float64, 0, 40)
+	for i := 0; i < 40; i++ {
+		swings = append(swings, 60000, 61000)
+	}
+
+	tests := []struct {
+		name    string
+		opts    ChartOptions
+		candles []Candle
+	}{
+		{"box below the price resolution", ChartOptions{Type: ChartRenko, BoxSize: 1e-9}, closeCandles(60000, 60001)},
+		{"range box below the minimum", ChartOptions{Type: ChartRange, BoxSize: 0.1}, closeCandles(60000, 60001)},
+		{"negative box", ChartOptions{Type: ChartKagi, BoxSize: -1}, closeCandles(100, 110)},
+		{"too many bars", ChartOptions{Type: ChartRenko, BoxSize: 1}, closeCandles(swings...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := BuildChart(tt.opts, tt.candles, nil)
+			if !errors.Is(err, ErrInvalidChartType) {
+				t.Fatalf("got %v, want ErrInvalidChartType", err)
+			}
+		})
+	}
+}
@@ -83,6 +83,11 @@ func (s *CoinService) GetCoinBySymbol(symbol string) (*models.Coin, error) {
 	return &c, nil
 }
 
+// GetStoredCandles returns the newest bars stored candles of a market
+func (s *CoinService) GetStoredCandles(marketID, interval string, bars int) ([]Candle, error) {
+	return LoadStoredCandles(s.db, marketID, interval, bars)
+}
+
 // ExchangeService handles real-time market data from exchanges
 type ExchangeService struct {
 	redis *redis.Client
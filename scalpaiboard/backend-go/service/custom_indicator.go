@@ -0,0 +1,160 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/lib/pq"
+	"github.com/scalpaiboard/backend/models"
+)
+
+const maxCustomIndicatorsPerUser = 50
+
+// ErrCustomIndicatorNotFound is returned when a user has no indicator with
+// the requested id or name.
+var ErrCustomIndicatorNotFound = errors.New("custom indicator not found")
+
+// ErrCustomIndicatorExists is returned when the user already has an
+// indicator with the requested name.
+var ErrCustomIndicatorExists = errors.New("custom indicator already exists")
+
+// ErrInvalidCustomIndicator is returned for bad names or when the per-user
+// limit is reached. Formula errors are reported as ErrInvalidFormula.
+var ErrInvalidCustomIndicator = errors.New("invalid custom indicator")
+
+var customIndicatorName = regexp.MustCompile(`^[a-z][a-z0-9_]{0,49}$`)
+
+type CustomIndicatorService struct {
+	db *sql.DB
+}
+
+func NewCustomIndicatorService(db *sql.DB) *CustomIndicatorService {
+	return &CustomIndicatorService{db: db}
+}
+
+// List returns the user's indicators ordered by name
+func (s *CustomIndicatorService) List(userID string) ([]models.CustomIndicator, error) {
+	query := `
+		SELECT id, user_id, name, formula, COALESCE(description, ''), created_at, updated_at
+		FROM custom_indicators
+		WHERE user_id = $1
+		ORDER BY name
+	`
+	rows, err := s.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]models.CustomIndicator, 0)
+	for rows.Next() {
+		var ind models.CustomIndicator
+		if err := rows.Scan(&ind.ID, &ind.UserID, &ind.Name, &ind.Formula, &ind.Description, &ind.CreatedAt, &ind.UpdatedAt); err != nil {
+			continue
+		}
+		items = append(items, ind)
+	}
+	return items, nil
+}
+
+// Formulas parses the user's indicators with the given names. Unknown names
+// return ErrCustomIndicatorNotFound.
+func (s *CustomIndicatorService) Formulas(userID string, names []string) (map[string]*Formula, error) {
+	all, err := s.List(userID)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]string, len(all))
+	for _, ind := range all {
+		byName[ind.Name] = ind.Formula
+	}
+
+	out := make(map[string]*Formula, len(names))
+	for _, name := range names {
+		src, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrCustomIndicatorNotFound, name)
+		}
+		f, err := ParseFormula(src)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		out[name] = f
+	}
+	return out, nil
+}
+
+// Create saves a new indicator after validating its name and formula
+func (s *CustomIndicatorService) Create(userID, name, formula, description string) (*models.CustomIndicator, error) {
+	if !customIndicatorName.MatchString(name) {
+		return nil, fmt.Errorf("%w: name must be lowercase letters, digits or _ and start with a letter", ErrInvalidCustomIndicator)
+	}
+	if _, err := ParseFormula(formula); err != nil {
+		return nil, err
+	}
+
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM custom_indicators WHERE user_id = $1", userID).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count >= maxCustomIndicatorsPerUser {
+		return nil, fmt.Errorf("%w: limit of %d indicators reached", ErrInvalidCustomIndicator, maxCustomIndicatorsPerUser)
+	}
+
+	query := `
+		INSERT INTO custom_indicators (user_id, name, formula, description)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at
+	`
+	ind := models.CustomIndicator{UserID: userID, Name: name, Formula: formula, Description: description}
+	err := s.db.QueryRow(query, userID, name, formula, description).Scan(&ind.ID, &ind.CreatedAt, &ind.UpdatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		// UNIQUE (user_id, name)
+		return nil, fmt.Errorf("%w: an indicator named %q already exists", ErrCustomIndicatorExists, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ind, nil
+}
+
+// Update changes an indicator's formula and/or description
+func (s *CustomIndicatorService) Update(userID string, id int, formula, description *string) (*models.CustomIndicator, error) {
+	if formula != nil {
+		if _, err := ParseFormula(*formula); err != nil {
+			return nil, err
+		}
+	}
+
+	query := `
+		UPDATE custom_indicators
+		SET formula = COALESCE($1, formula), description = COALESCE($2, description), updated_at = NOW()
+		WHERE id = $3 AND user_id = $4
+		RETURNING id, user_id, name, formula, COALESCE(description, ''), created_at, updated_at
+	`
+	var ind models.CustomIndicator
+	err := s.db.QueryRow(query, formula, description, id, userID).
+		Scan(&ind.ID, &ind.UserID, &ind.Name, &ind.Formula, &ind.Description, &ind.CreatedAt, &ind.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrCustomIndicatorNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ind, nil
+}
+
+// Delete removes an indicator
+func (s *CustomIndicatorService) Delete(userID string, id int) error {
+	res, err := s.db.Exec("DELETE FROM custom_indicators WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrCustomIndicatorNotFound
+	}
+	return nil
+}
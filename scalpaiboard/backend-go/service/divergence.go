@@ -0,0 +1,215 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// Divergence oscillators and kinds.
+const (
+	DivergenceRSI      = "rsi"
+	DivergenceMACDHist = "macd_hist"
+	DivergenceOBV      = "obv"
+
+	DivergenceRegular = "regular"
+	DivergenceHidden  = "hidden"
+)
+
+// DivergenceOptions configures DetectDivergences. PivotWidth is the fractal
+// width used to find price swings; Lookback is the maximum number of bars
+// between the two pivots of a divergence.
+type DivergenceOptions struct {
+	Indicator  string `json:"indicator"`
+	PivotWidth int    `json:"pivotWidth"`
+	Lookback   int    `json:"lookback"`
+}
+
+// DefaultDivergenceOptions returns RSI(14) divergences on width-3 pivots
+// at most 60 bars apart.
+func DefaultDivergenceOptions() DivergenceOptions {
+	return DivergenceOptions{Indicator: DivergenceRSI, PivotWidth: 3, Lookback: 60}
+}
+
+// Divergence is a disagreement between two consecutive price swings and the
+// oscillator at the same bars. Price and Oscillator hold the two pivot pairs
+// ({time, price} and {time, value}). Regular divergences signal reversal
+// (price makes a new extreme the oscillator does not confirm); hidden ones
+// signal continuation. DetectedIndex/DetectedTime is the bar at which the
+// second pivot was confirmed, i.e. when the divergence became knowable.
+type Divergence struct {
+	Type          string        `json:"type"`
+	Direction     string        `json:"direction"`
+	Indicator     string        `json:"indicator"`
+	Price         [2]ChartPoint `json:"price"`
+	Oscillator    [2]ChartPoint `json:"oscillator"`
+	StartIndex    int           `json:"startIndex"`
+	EndIndex      int           `json:"endIndex"`
+	DetectedIndex int           `json:"detectedIndex"`
+	DetectedTime  int64         `json:"detectedTime"`
+	Strength      float64       `json:"strength"`
+}
+
+// DetectDivergences finds regular and hidden bullish/bearish divergences
+// between price swings and the chosen oscillator.
+func DetectDivergences(candles []Candle, opts DivergenceOptions) ([]Divergence, error) {
+	if opts.PivotWidth < 1 || opts.PivotWidth > structureMaxSwingWidth {
+		return nil, errors.New("pivot width must be between 1 and 10")
+	}
+	if opts.Lookback <= opts.PivotWidth {
+		return nil, errors.New("lookback must be greater than pivot width")
+	}
+
+	osc, err := oscillatorSeries(candles, opts.Indicator)
+	if err != nil {
+		return nil, err
+	}
+
+	closes, highs, lows := CandleSeries(candles)
+	atrSeries, err := ATR(highs, lows, closes, structureATRPeriod)
+	if err != nil {
+		return nil, err
+	}
+	atr := atrSeries[len(atrSeries)-1]
+	oscRange := seriesRange(osc)
+
+	var swingHighs, swingLows []SwingPoint
+	for _, s := range FindSwings(candles, opts.PivotWidth) {
+		if math.IsNaN(osc[s.Index]) {
+			continue
+		}
+		if s.Kind == SwingHigh {
+			swingHighs = append(swingHighs, s)
+		} else {
+			swingLows = append(swingLows, s)
+		}
+	}
+
+	out := make([]Divergence, 0)
+	build := func(a, b SwingPoint, kind, direction string) Divergence {
+		detected := b.Index + opts.PivotWidth
+		if detected > len(candles)-1 {
+			detected = len(candles) - 1
+		}
+		oscMove := 0.0
+		if oscRange > 0 {
+			oscMove = math.Abs(osc[b.Index]-osc[a.Index]) / oscRange
+		}
+		priceMove := 0.0
+		if atr > 0 {
+			priceMove = math.Abs(b.Price-a.Price) / (3 * atr)
+		}
+		return Divergence{
+			Type:      kind,
+			Direction: direction,
+			Indicator: opts.Indicator,
+			Price: [2]ChartPoint{
+				{Time: a.Time, Price: a.Price},
+				{Time: b.Time, Price: b.Price},
+			},
+			Oscillator: [2]ChartPoint{
+				{Time: a.Time, Price: osc[a.Index]},
+				{Time: b.Time, Price: osc[b.Index]},
+			},
+			StartIndex:    a.Index,
+			EndIndex:      b.Index,
+			DetectedIndex: detected,
+			DetectedTime:  candles[detected].Time,
+			Strength:      roundStrength(0.6*clamp01(oscMove*2) + 0.4*clamp01(priceMove)),
+		}
+	}
+
+	for k := 1; k < len(swingLows); k++ {
+		a, b := swingLows[k-1], swingLows[k]
+		if b.Index-a.Index > opts.Lookback {
+			continue
+		}
+		switch {
+		case b.Price < a.Price && osc[b.Index] > osc[a.Index]:
+			out = append(out, build(a, b, DivergenceRegular, DirectionBullish))
+		case b.Price > a.Price && osc[b.Index] < osc[a.Index]:
+			out = append(out, build(a, b, DivergenceHidden, DirectionBullish))
+		}
+	}
+	for k := 1; k < len(swingHighs); k++ {
+		a, b := swingHighs[k-1], swingHighs[k]
+		if b.Index-a.Index > opts.Lookback {
+			continue
+		}
+		switch {
+		case b.Price > a.Price && osc[b.Index] < osc[a.Index]:
+			out = append(out, build(a, b, DivergenceRegular, DirectionBearish))
+		case b.Price < a.Price && osc[b.Index] > osc[a.Index]:
+			out = append(out, build(a, b, DivergenceHidden, DirectionBearish))
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool { return out[i].EndIndex < out[j].EndIndex })
+	return out, nil
+}
+
+// IsDivergenceIndicator reports whether name is a supported oscillator.
+func IsDivergenceIndicator(name string) bool {
+	switch name {
+	case DivergenceRSI, DivergenceMACDHist, DivergenceOBV:
+		return true
+	default:
+		return false
+	}
+}
+
+// oscillatorSeries returns the oscillator aligned to candles, NaN-padded
+// during warm-up.
+func oscillatorSeries(candles []Candle, indicator string) ([]float64, error) {
+	closes := make([]float64, len(candles))
+	volumes := make([]float64, len(candles))
+	for i, c := range candles {
+		closes[i] = c.Close
+		volumes[i] = c.Volume
+	}
+
+	switch indicator {
+	case DivergenceRSI:
+		rsi, err := RSISeries(closes, 14)
+		if err != nil {
+			return nil, err
+		}
+		return padSeries(rsi, len(candles)), nil
+	case DivergenceMACDHist:
+		macd, err := MACDSeries(closes, 12, 26, 9)
+		if err != nil {
+			return nil, err
+		}
+		return padSeries(macd.Histogram, len(candles)), nil
+	case DivergenceOBV:
+		return OBV(closes, volumes)
+	default:
+		return nil, errors.New("unsupported divergence indicator")
+	}
+}
+
+// padSeries left-pads an end-aligned indicator series with NaN to length n.
+func padSeries(series []float64, n int) []float64 {
+	out := make([]float64, n)
+	offset := n - len(series)
+	for i := 0; i < offset; i++ {
+		out[i] = math.NaN()
+	}
+	copy(out[offset:], series)
+	return out
+}
+
+func seriesRange(series []float64) float64 {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range series {
+		if math.IsNaN(v) {
+			continue
+		}
+		lo = math.Min(lo, v)
+		hi = math.Max(hi, v)
+	}
+	if math.IsInf(lo, 0) {
+		return 0
+	}
+	return hi - lo
+}
@@ -0,0 +1,343 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/scalpaiboard/backend/models"
+)
+
+// Drawing types. Lines are evaluated as a price at a time; zones as a
+// price range.
+const (
+	// DrawingLine is the infinite line through two points.
+	DrawingLine = "line"
+	// DrawingTrendline is the segment between two points.
+	DrawingTrendline = "trendline"
+	// DrawingRay is a horizontal ray from its point to the right.
+	DrawingRay = "ray"
+	// DrawingHorizontal is a horizontal level at its point's price.
+	DrawingHorizontal = "hline"
+	// DrawingRect is the rectangle between two corners.
+	DrawingRect = "rect"
+	// DrawingChannel is the zone between the infinite line through its
+	// first two points and the parallel line through the third.
+	DrawingChannel = "channel"
+)
+
+// drawingPoints is the number of anchors of each drawing type.
+var drawingPoints = map[string]int{
+	DrawingLine:       2,
+	DrawingTrendline:  2,
+	DrawingRay:        1,
+	DrawingHorizontal: 1,
+	DrawingRect:       2,
+	DrawingChannel:    3,
+}
+
+const (
+	maxDrawingsPerMarket = 200
+	maxDrawingStyleBytes = 2048
+)
+
+// ErrDrawingNotFound is returned when a user has no drawing with the
+// requested id.
+var ErrDrawingNotFound = errors.New("drawing not found")
+
+// ErrInvalidDrawing is returned for bad drawing types, anchors or styles, or
+// when the per-market limit is reached.
+var ErrInvalidDrawing = errors.New("invalid drawing")
+
+type DrawingService struct {
+	db *sql.DB
+
+	onChange func(drawingID int)
+}
+
+func NewDrawingService(db *sql.DB) *DrawingService {
+	return &DrawingService{db: db}
+}
+
+// OnChange registers a hook called with the ID of every drawing that is
+// moved or deleted (e.g. the alert engine, to reload attached alerts).
+func (s *DrawingService) OnChange(fn func(drawingID int)) {
+	s.onChange = fn
+}
+
+func (s *DrawingService) changed(drawingID int) {
+	if s.onChange != nil {
+		s.onChange(drawingID)
+	}
+}
+
+const drawingColumns = `id, user_id, market_id, type, points, style, created_at, updated_at`
+
+func scanDrawing(row rowScanner) (*models.Drawing, error) {
+	var d models.Drawing
+	var points, style []byte
+	if err := row.Scan(&d.ID, &d.UserID, &d.MarketID, &d.Type, &points, &style, &d.CreatedAt, &d.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(points, &d.Points); err != nil {
+		return nil, err
+	}
+	if len(style) > 0 {
+		d.Style = style
+	}
+	return &d, nil
+}
+
+// List returns the user's drawings, on one market when marketID is set,
+// oldest first.
+func (s *DrawingService) List(userID, marketID string) ([]models.Drawing, error) {
+	query := `SELECT ` + drawingColumns + ` FROM drawings WHERE user_id = $1`
+	args := []interface{}{userID}
+	if marketID != "" {
+		query += ` AND market_id = $2`
+		args = append(args, strings.ToUpper(marketID))
+	}
+	query += ` ORDER BY created_at, id`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]models.Drawing, 0)
+	for rows.Next() {
+		d, err := scanDrawing(rows)
+		if err != nil {
+			continue
+		}
+		items = append(items, *d)
+	}
+	return items, nil
+}
+
+// Get returns one of the user's drawings.
+func (s *DrawingService) Get(userID string, id int) (*models.Drawing, error) {
+	d, err := scanDrawing(s.db.QueryRow(`SELECT `+drawingColumns+` FROM drawings WHERE id = $1 AND user_id = $2`, id, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrDrawingNotFound
+	}
+	return d, err
+}
+
+// Create saves a new drawing after validating its anchors
+func (s *DrawingService) Create(userID, marketID, drawingType string, points []models.DrawingPoint, style json.RawMessage) (*models.Drawing, error) {
+	marketID = strings.ToUpper(strings.TrimSpace(marketID))
+	if !isAlertMarketID(marketID) {
+		return nil, fmt.Errorf("%w: invalid marketId %q", ErrInvalidDrawing, marketID)
+	}
+	if err := validateDrawing(drawingType, points, style); err != nil {
+		return nil, err
+	}
+
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM drawings WHERE user_id = $1 AND market_id = $2", userID, marketID).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count >= maxDrawingsPerMarket {
+		return nil, fmt.Errorf("%w: limit of %d drawings per market reached", ErrInvalidDrawing, maxDrawingsPerMarket)
+	}
+
+	raw, err := json.Marshal(points)
+	if err != nil {
+		return nil, err
+	}
+	query := `
+		INSERT INTO drawings (user_id, market_id, type, points, style)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, updated_at
+	`
+	d := models.Drawing{UserID: userID, MarketID: marketID, Type: drawingType, Points: points, Style: style}
+	if err := s.db.QueryRow(query, userID, marketID, drawingType, raw, nullJSON(style)).Scan(&d.ID, &d.CreatedAt, &d.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Update moves a drawing and/or changes its style. Moving it makes the
+// alerts attached to it forget which side of it price was on, so the move
+// itself does not count as a cross.
+func (s *DrawingService) Update(userID string, id int, points []models.DrawingPoint, style json.RawMessage) (*models.Drawing, error) {
+	d, err := s.Get(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if points == nil {
+		points = d.Points
+	}
+	if len(style) == 0 {
+		style = d.Style
+	}
+	if err := validateDrawing(d.Type, points, style); err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(points)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	d, err = scanDrawing(tx.QueryRow(`
+		UPDATE drawings
+		SET points = $1, style = $2, updated_at = NOW()
+		WHERE id = $3 AND user_id = $4
+		RETURNING `+drawingColumns, raw, nullJSON(style), id, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrDrawingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`
+		UPDATE alerts SET last_met = NULL
+		WHERE user_id = $1 AND condition_type IN ('drawing_cross', 'drawing_zone')
+		  AND condition_params->>'drawingId' = $2
+	`, userID, fmt.Sprint(id)); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.changed(id)
+	return d, nil
+}
+
+// Delete removes a drawing together with the alerts attached to it
+func (s *DrawingService) Delete(userID string, id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		DELETE FROM alerts
+		WHERE user_id = $1 AND condition_type IN ('drawing_cross', 'drawing_zone')
+		  AND condition_params->>'drawingId' = $2
+	`, userID, fmt.Sprint(id)); err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM drawings WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrDrawingNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.changed(id)
+	return nil
+}
+
+func validateDrawing(drawingType string, points []models.DrawingPoint, style json.RawMessage) error {
+	n, ok := drawingPoints[drawingType]
+	if !ok {
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidDrawing, drawingType)
+	}
+	if len(points) != n {
+		return fmt.Errorf("%w: %s takes %d points", ErrInvalidDrawing, drawingType, n)
+	}
+	for _, p := range points {
+		if p.Time <= 0 || p.Price <= 0 || math.IsInf(p.Price, 0) || math.IsNaN(p.Price) {
+			return fmt.Errorf("%w: points need a positive time and price", ErrInvalidDrawing)
+		}
+	}
+	if n >= 2 && drawingType != DrawingRect && points[0].Time == points[1].Time {
+		return fmt.Errorf("%w: the first two points of a %s need different times", ErrInvalidDrawing, drawingType)
+	}
+	if len(style) > maxDrawingStyleBytes {
+		return fmt.Errorf("%w: style is larger than %d bytes", ErrInvalidDrawing, maxDrawingStyleBytes)
+	}
+	if len(style) > 0 && !json.Valid(style) {
+		return fmt.Errorf("%w: style must be JSON", ErrInvalidDrawing)
+	}
+	return nil
+}
+
+// nullJSON stores an empty JSON value as NULL.
+func nullJSON(raw json.RawMessage) interface{} {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	return []byte(raw)
+}
+
+// IsLineDrawing reports whether a drawing type is a line, which price can
+// cross.
+func IsLineDrawing(drawingType string) bool {
+	switch drawingType {
+	case DrawingLine, DrawingTrendline, DrawingRay, DrawingHorizontal:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsZoneDrawing reports whether a drawing type is a zone, which price can
+// enter and exit.
+func IsZoneDrawing(drawingType string) bool {
+	return drawingType == DrawingRect || drawingType == DrawingChannel
+}
+
+// lineThrough interpolates the line through a and b at t.
+func lineThrough(a, b models.DrawingPoint, t int64) float64 {
+	return a.Price + (b.Price-a.Price)*float64(t-a.Time)/float64(b.Time-a.Time)
+}
+
+// DrawingLineAt returns the price of a line drawing at time t (Unix
+// seconds); ok is false where the drawing does not extend.
+func DrawingLineAt(d models.Drawing, t int64) (float64, bool) {
+	p := d.Points
+	switch d.Type {
+	case DrawingHorizontal:
+		return p[0].Price, true
+	case DrawingRay:
+		return p[0].Price, t >= p[0].Time
+	case DrawingLine:
+		return lineThrough(p[0], p[1], t), true
+	case DrawingTrendline:
+		from, to := p[0].Time, p[1].Time
+		if from > to {
+			from, to = to, from
+		}
+		return lineThrough(p[0], p[1], t), t >= from && t <= to
+	default:
+		return 0, false
+	}
+}
+
+// DrawingZoneAt returns the price range of a zone drawing at time t; ok is
+// false where the drawing does not extend.
+func DrawingZoneAt(d models.Drawing, t int64) (low, high float64, ok bool) {
+	p := d.Points
+	switch d.Type {
+	case DrawingRect:
+		from, to := p[0].Time, p[1].Time
+		if from > to {
+			from, to = to, from
+		}
+		low, high = math.Min(p[0].Price, p[1].Price), math.Max(p[0].Price, p[1].Price)
+		return low, high, t >= from && t <= to
+	case DrawingChannel:
+		base := lineThrough(p[0], p[1], t)
+		offset := p[2].Price - lineThrough(p[0], p[1], p[2].Time)
+		return math.Min(base, base+offset), math.Max(base, base+offset), true
+	default:
+		return 0, 0, false
+	}
+}
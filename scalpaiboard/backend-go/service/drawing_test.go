@@ -0,0 +1,74 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/scalpaiboard/backend/models"
+)
+
+func drawing(drawingType string, points ...models.DrawingPoint) models.Drawing {
+	return models.Drawing{Type: drawingType, Points: points}
+}
+
+func TestDrawingLineAt(t *testing.T) {
+	a, b := models.DrawingPoint{Time: 1000, Price: 100}, models.DrawingPoint{Time: 2000, Price: 200}
+
+	tests := []struct {
+		name    string
+		drawing models.Drawing
+		at      int64
+		price   float64
+		ok      bool
+	}{
+		{"line between anchors", drawing(DrawingLine, a, b), 1500, 150, true},
+		{"line extends past anchors", drawing(DrawingLine, a, b), 3000, 300, true},
+		{"line with anchors reversed", drawing(DrawingLine, b, a), 500, 50, true},
+		{"trendline inside", drawing(DrawingTrendline, b, a), 1250, 125, true},
+		{"trendline after its end", drawing(DrawingTrendline, a, b), 2001, 200.1, false},
+		{"ray before its start", drawing(DrawingRay, a), 999, 100, false},
+		{"ray after its start", drawing(DrawingRay, a), 5000, 100, true},
+		{"horizontal anywhere", drawing(DrawingHorizontal, b), 0, 200, true},
+		{"zone type is no line", drawing(DrawingRect, a, b), 1500, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			price, ok := DrawingLineAt(tt.drawing, tt.at)
+			if ok != tt.ok || !approx(price, tt.price) {
+				t.Fatalf("got %v %v, want %v %v", price, ok, tt.price, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDrawingZoneAt(t *testing.T) {
+	tests := []struct {
+		name      string
+		drawing   models.Drawing
+		at        int64
+		low, high float64
+		ok        bool
+	}{
+		{"rect inside", drawing(DrawingRect, models.DrawingPoint{Time: 2000, Price: 90}, models.DrawingPoint{Time: 1000, Price: 110}),
+			1500, 90, 110, true},
+		{"rect outside its span", drawing(DrawingRect, models.DrawingPoint{Time: 1000, Price: 90}, models.DrawingPoint{Time: 2000, Price: 110}),
+			2500, 90, 110, false},
+		{"channel above its base", drawing(DrawingChannel, models.DrawingPoint{Time: 1000, Price: 100},
+			models.DrawingPoint{Time: 2000, Price: 200}, models.DrawingPoint{Time: 1500, Price: 170}),
+			3000, 300, 320, true},
+		{"channel below its base", drawing(DrawingChannel, models.DrawingPoint{Time: 1000, Price: 100},
+			models.DrawingPoint{Time: 2000, Price: 200}, models.DrawingPoint{Time: 2000, Price: 180}),
+			1000, 80, 100, true},
+		{"line type is no zone", drawing(DrawingLine, models.DrawingPoint{Time: 1000, Price: 100},
+			models.DrawingPoint{Time: 2000, Price: 200}), 1500, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			low, high, ok := DrawingZoneAt(tt.drawing, tt.at)
+			if ok != tt.ok || !approx(low, tt.low) || !approx(high, tt.high) {
+				t.Fatalf("got %v-%v %v, want %v-%v %v", low, high, ok, tt.low, tt.high, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package service
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultImbalanceRatio is the diagonal imbalance ratio used when none is
+// requested.
+const DefaultImbalanceRatio = 3.0
+
+// AggTrade is one aggregated trade. Time is in milliseconds. BuyerMaker is
+// true when the buyer's order was resting, i.e. a seller hit the bid.
+type AggTrade struct {
+	ID         string  `json:"id"`
+	Time       int64   `json:"time"`
+	Price      float64 `json:"price"`
+	Qty        float64 `json:"qty"`
+	BuyerMaker bool    `json:"buyerMaker"`
+}
+
+// FootprintLevel is the traded volume in one price bucket of a bar. Price
+// is the bottom of the bucket, Bid the volume of sells hitting the bid and
+// Ask the volume of buys lifting the offer. AskImbalance is set when Ask is
+// at least the ratio times the Bid one bucket below; BidImbalance when Bid
+// is at least the ratio times the Ask one bucket above.
+type FootprintLevel struct {
+	Price        float64 `json:"price"`
+	Bid          float64 `json:"bid"`
+	Ask          float64 `json:"ask"`
+	Delta        float64 `json:"delta"`
+	BidImbalance bool    `json:"bidImbalance,omitempty"`
+	AskImbalance bool    `json:"askImbalance,omitempty"`
+}
+
+// FootprintBar is a bar with its volume by price. Delta is buy minus sell
+// volume; MinDelta and MaxDelta are the extremes of the running delta
+// inside the bar. POC is the bucket with the most volume. Partial marks
+// bars that are missing trades, e.g. the first bar after recording starts.
+// Levels are in ascending price order.
+type FootprintBar struct {
+	Time       int64            `json:"time"`
+	Open       float64          `json:"open"`
+	High       float64          `json:"high"`
+	Low        float64          `json:"low"`
+	Close      float64          `json:"close"`
+	Volume     float64          `json:"volume"`
+	BuyVolume  float64          `json:"buyVolume"`
+	SellVolume float64          `json:"sellVolume"`
+	Delta      float64          `json:"delta"`
+	MinDelta   float64          `json:"minDelta"`
+	MaxDelta   float64          `json:"maxDelta"`
+	Trades     int              `json:"trades"`
+	POC        float64          `json:"poc"`
+	Bucket     float64          `json:"bucket"`
+	Partial    bool             `json:"partial,omitempty"`
+	Levels     []FootprintLevel `json:"levels"`
+}
+
+// footprintAcc accumulates trades or finer bars into one bar.
+type footprintAcc struct {
+	time     int64
+	bucket   float64
+	started  bool
+	open     float64
+	high     float64
+	low      float64
+	close    float64
+	buy      float64
+	sell     float64
+	minDelta float64
+	maxDelta float64
+	trades   int
+	partial  bool
+	levels   map[int64]*[2]float64
+}
+
+func newFootprintAcc(time int64, bucket float64) *footprintAcc {
+	return &footprintAcc{time: time, bucket: bucket, levels: make(map[int64]*[2]float64)}
+}
+
+func (a *footprintAcc) level(price float64) *[2]float64 {
+	idx := int64(math.Floor(price/a.bucket + 1e-9))
+	lv, ok := a.levels[idx]
+	if !ok {
+		lv = &[2]float64{}
+		a.levels[idx] = lv
+	}
+	return lv
+}
+
+func (a *footprintAcc) price(p float64) {
+	if !a.started {
+		a.started, a.open, a.high, a.low = true, p, p, p
+	}
+	a.high = math.Max(a.high, p)
+	a.low = math.Min(a.low, p)
+	a.close = p
+}
+
+func (a *footprintAcc) addTrade(t AggTrade) {
+	a.price(t.Price)
+	lv := a.level(t.Price)
+	if t.BuyerMaker {
+		lv[0] += t.Qty
+		a.sell += t.Qty
+	} else {
+		lv[1] += t.Qty
+		a.buy += t.Qty
+	}
+	delta := a.buy - a.sell
+	a.minDelta = math.Min(a.minDelta, delta)
+	a.maxDelta = math.Max(a.maxDelta, delta)
+	a.trades++
+}
+
+// addBar merges a finer bar that follows everything added so far.
+func (a *footprintAcc) addBar(b FootprintBar) {
+	if b.Trades == 0 {
+		return
+	}
+	offset := a.buy - a.sell
+	if !a.started {
+		a.started, a.open, a.high, a.low = true, b.Open, b.High, b.Low
+	}
+	a.high = math.Max(a.high, b.High)
+	a.low = math.Min(a.low, b.Low)
+	a.close = b.Close
+	a.minDelta = math.Min(a.minDelta, offset+b.MinDelta)
+	a.maxDelta = math.Max(a.maxDelta, offset+b.MaxDelta)
+	a.buy += b.BuyVolume
+	a.sell += b.SellVolume
+	a.trades += b.Trades
+	a.partial = a.partial || b.Partial
+	for _, l := range b.Levels {
+		lv := a.level(l.Price)
+		lv[0] += l.Bid
+		lv[1] += l.Ask
+	}
+}
+
+// bar builds the footprint bar, flagging diagonal imbalances at ratio.
+func (a *footprintAcc) bar(ratio float64) FootprintBar {
+	b := FootprintBar{
+		Time:       a.time,
+		Open:       a.open,
+		High:       a.high,
+		Low:        a.low,
+		Close:      a.close,
+		Volume:     roundTo(a.buy+a.sell, 8),
+		BuyVolume:  roundTo(a.buy, 8),
+		SellVolume: roundTo(a.sell, 8),
+		Delta:      roundTo(a.buy-a.sell, 8),
+		MinDelta:   roundTo(a.minDelta, 8),
+		MaxDelta:   roundTo(a.maxDelta, 8),
+		Trades:     a.trades,
+		Bucket:     a.bucket,
+		Partial:    a.partial,
+		Levels:     make([]FootprintLevel, 0, len(a.levels)),
+	}
+
+	idx := make([]int64, 0, len(a.levels))
+	for i := range a.levels {
+		idx = append(idx, i)
+	}
+	sort.Slice(idx, func(i, j int) bool { return idx[i] < idx[j] })
+
+	decimals := stepDecimals(a.bucket)
+	pocVolume := -1.0
+	for _, i := range idx {
+		lv := a.levels[i]
+		l := FootprintLevel{
+			Price: roundTo(float64(i)*a.bucket, decimals),
+			Bid:   roundTo(lv[0], 8),
+			Ask:   roundTo(lv[1], 8),
+			Delta: roundTo(lv[1]-lv[0], 8),
+		}
+		if ratio > 0 {
+			var below, above float64
+			if o, ok := a.levels[i-1]; ok {
+				below = o[0]
+			}
+			if o, ok := a.levels[i+1]; ok {
+				above = o[1]
+			}
+			l.AskImbalance = l.Ask > 0 && l.Ask >= ratio*below
+			l.BidImbalance = l.Bid > 0 && l.Bid >= ratio*above
+		}
+		if v := l.Bid + l.Ask; v > pocVolume {
+			pocVolume, b.POC = v, l.Price
+		}
+		b.Levels = append(b.Levels, l)
+	}
+	return b
+}
+
+// AggregateFootprint merges ascending base bars into bars of intervalSec
+// seconds at bucket (a coarser multiple of the base bucket).
+func AggregateFootprint(bars []FootprintBar, intervalSec int64, bucket, ratio float64) []FootprintBar {
+	out := make([]FootprintBar, 0)
+	var acc *footprintAcc
+	for _, b := range bars {
+		start := b.Time - b.Time%intervalSec
+		if acc == nil || start != acc.time {
+			if acc != nil && acc.started {
+				out = append(out, acc.bar(ratio))
+			}
+			acc = newFootprintAcc(start, bucket)
+		}
+		acc.addBar(b)
+	}
+	if acc != nil && acc.started {
+		out = append(out, acc.bar(ratio))
+	}
+	return out
+}
+
+// FootprintBucket picks a 1-2-5 price bucket of roughly one basis point of
+// price, so a bar has tens of levels in a typical minute.
+func FootprintBucket(price float64) float64 {
+	if price <= 0 {
+		return 1
+	}
+	target := price / 10000
+	step := math.Pow(10, math.Floor(math.Log10(target)))
+	for _, m := range []float64{5, 2, 1} {
+		if step*m <= target {
+			return roundSignificant(step*m, 1)
+		}
+	}
+	return roundSignificant(step, 1)
+}
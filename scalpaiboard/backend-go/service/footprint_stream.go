@@ -0,0 +1,444 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	footprintPollInterval = time.Second
+	footprintWorkers      = 10
+	// footprintCloseGrace is how long after a minute ends its bar stays open
+	// for trades that the exchange reports late.
+	footprintCloseGrace = 5 * time.Second
+	footprintRetention  = 7 * 24 * time.Hour
+	// FootprintBaseSeconds is the resolution footprint bars are recorded at.
+	FootprintBaseSeconds = 60
+)
+
+// ErrFootprintNotTracked is returned for markets whose trades are not
+// being recorded.
+var ErrFootprintNotTracked = errors.New("footprint not recorded for market")
+
+// TradeStream pushes the trades of the markets it streams to the
+// footprint recorder (see FootprintService.Push and MarkGap). Streams
+// reports whether a market is served by it rather than by polling; Watch
+// and Unwatch start and stop a market and must not block.
+type TradeStream interface {
+	Streams(marketID string) bool
+	Watch(marketID string)
+	Unwatch(marketID string)
+}
+
+// TradeFetcher returns a market's aggregated trades in ascending order,
+// starting after lastID where the exchange supports it ("" for the most
+// recent ones). Trades already seen are filtered by the caller.
+type TradeFetcher func(marketID, lastID string) ([]AggTrade, error)
+
+// FootprintUpdate is a live footprint bar pushed to subscribers; Closed is
+// set once the bar is final.
+type FootprintUpdate struct {
+	MarketID string       `json:"marketId"`
+	Closed   bool         `json:"closed"`
+	Bar      FootprintBar `json:"bar"`
+}
+
+// FootprintService records one-minute footprint bars from aggregated trades
+// for pinned markets (FOOTPRINT_MARKETS) and for markets with live
+// WebSocket subscribers, and serves them by range. Trades are polled with
+// the TradeFetcher, or pushed by the TradeStream for the markets it
+// streams.
+type FootprintService struct {
+	db     *sql.DB
+	fetch  TradeFetcher
+	stream TradeStream
+	sink   func(update FootprintUpdate)
+
+	mu         sync.Mutex
+	feeds      map[string]*footprintFeed
+	lastPruned time.Time
+}
+
+type footprintFeed struct {
+	pinned bool
+	refs   int
+	bucket float64
+
+	cur        *footprintAcc
+	lastClosed int64
+
+	// Watermark of trades already applied.
+	lastID   string
+	lastTime int64
+	seen     map[string]bool
+
+	// streamed feeds receive their trades in pending instead of polling.
+	streamed bool
+	pending  []AggTrade
+
+	// gap marks the next bar partial (start of recording, fetch errors,
+	// stream reconnects).
+	gap   bool
+	dirty bool
+}
+
+func NewFootprintService(db *sql.DB, fetch TradeFetcher) *FootprintService {
+	return &FootprintService{
+		db:    db,
+		fetch: fetch,
+		feeds: make(map[string]*footprintFeed),
+	}
+}
+
+// UseStream sets the stream that pushes trades of the markets it serves.
+// It must be called before recording starts.
+func (s *FootprintService) UseStream(stream TradeStream) {
+	s.stream = stream
+}
+
+// Push queues streamed trades of a market, in ascending order, for the
+// next tick.
+func (s *FootprintService) Push(marketID string, trades []AggTrade) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if feed, ok := s.feeds[marketID]; ok && feed.streamed {
+		feed.pending = append(feed.pending, trades...)
+	}
+}
+
+// MarkGap marks the bar being recorded and the next one partial, when a
+// stream may have missed trades of a market.
+func (s *FootprintService) MarkGap(marketID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if feed, ok := s.feeds[marketID]; ok {
+		feed.gap = true
+		if feed.cur != nil {
+			feed.cur.partial = true
+		}
+	}
+}
+
+// OnUpdate registers the receiver of live bars (e.g. the WebSocket hub).
+func (s *FootprintService) OnUpdate(fn func(update FootprintUpdate)) {
+	s.sink = fn
+}
+
+// Track records a market for as long as the process runs.
+func (s *FootprintService) Track(marketID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.feed(marketID).pinned = true
+}
+
+// Acquire starts recording a market for a live subscriber. Every Acquire
+// must be matched by a Release.
+func (s *FootprintService) Acquire(marketID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.feed(marketID).refs++
+}
+
+// Release drops a subscriber; a market nobody watches and that is not
+// pinned stops recording, keeping its last bar as partial.
+func (s *FootprintService) Release(marketID string) {
+	s.mu.Lock()
+	feed, ok := s.feeds[marketID]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	feed.refs--
+	if feed.refs > 0 || feed.pinned {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.feeds, marketID)
+	if feed.streamed {
+		s.stream.Unwatch(marketID)
+	}
+	var last *footprintAcc
+	if feed.cur != nil && feed.cur.started {
+		feed.cur.partial = true
+		last = feed.cur
+	}
+	s.mu.Unlock()
+
+	if last != nil {
+		s.persist(marketID, last.bar(0))
+	}
+}
+
+// IsTracked reports whether a market is being recorded.
+func (s *FootprintService) IsTracked(marketID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.feeds[marketID]
+	return ok
+}
+
+func (s *FootprintService) feed(marketID string) *footprintFeed {
+	feed, ok := s.feeds[marketID]
+	if !ok {
+		feed = &footprintFeed{seen: make(map[string]bool), gap: true}
+		if s.stream != nil && s.stream.Streams(marketID) {
+			feed.streamed = true
+			s.stream.Watch(marketID)
+		}
+		s.feeds[marketID] = feed
+	}
+	return feed
+}
+
+// Start polls every recorded market's trades (or takes the streamed ones)
+// once a second, closes bars a few seconds after their minute ends and
+// prunes bars older than a week.
+func (s *FootprintService) Start() {
+	go func() {
+		ticker := time.NewTicker(footprintPollInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			s.tick(now)
+		}
+	}()
+	log.Println("👣 Footprint recorder started")
+}
+
+func (s *FootprintService) tick(now time.Time) {
+	s.mu.Lock()
+	cursors := make(map[string]string, len(s.feeds))
+	for marketID, feed := range s.feeds {
+		if !feed.streamed {
+			cursors[marketID] = feed.lastID
+		}
+	}
+	recording := len(s.feeds) > 0
+	s.mu.Unlock()
+
+	if recording {
+		trades, failed := s.fetchAll(cursors)
+
+		s.mu.Lock()
+		closed := make(map[string][]FootprintBar)
+		updates := make([]FootprintUpdate, 0)
+		for marketID, feed := range s.feeds {
+			if failed[marketID] {
+				feed.gap = true
+				if feed.cur != nil {
+					feed.cur.partial = true
+				}
+			}
+			list := trades[marketID]
+			if feed.streamed {
+				list, feed.pending = feed.pending, nil
+			}
+			done := s.apply(feed, list, now)
+			closed[marketID] = done
+			for _, b := range done {
+				updates = append(updates, FootprintUpdate{MarketID: marketID, Closed: true, Bar: b})
+			}
+			if feed.dirty && feed.cur != nil {
+				updates = append(updates, FootprintUpdate{MarketID: marketID, Bar: feed.cur.bar(DefaultImbalanceRatio)})
+			}
+			feed.dirty = false
+		}
+		s.mu.Unlock()
+
+		for marketID, bars := range closed {
+			for _, b := range bars {
+				s.persist(marketID, b)
+			}
+		}
+		if s.sink != nil {
+			for _, u := range updates {
+				s.sink(u)
+			}
+		}
+	}
+
+	if now.Sub(s.lastPruned) > time.Hour {
+		s.lastPruned = now
+		cutoff := now.Add(-footprintRetention).Unix()
+		if _, err := s.db.Exec(`DELETE FROM footprint_bars WHERE open_time < to_timestamp($1) AT TIME ZONE 'UTC'`, cutoff); err != nil {
+			log.Printf("⚠️ Footprint: failed to prune bars: %v", err)
+		}
+	}
+}
+
+// fetchAll fetches new trades for every market in parallel.
+func (s *FootprintService) fetchAll(cursors map[string]string) (map[string][]AggTrade, map[string]bool) {
+	sem := make(chan struct{}, footprintWorkers)
+	mu := sync.Mutex{}
+	trades := make(map[string][]AggTrade, len(cursors))
+	failed := make(map[string]bool)
+
+	wg := sync.WaitGroup{}
+	for marketID, lastID := range cursors {
+		marketID, lastID := marketID, lastID
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			list, err := s.fetch(marketID, lastID)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed[marketID] = true
+				return
+			}
+			trades[marketID] = list
+		}()
+	}
+	wg.Wait()
+	return trades, failed
+}
+
+// apply adds new trades to a feed and returns the bars that closed. The
+// caller holds s.mu.
+func (s *FootprintService) apply(feed *footprintFeed, trades []AggTrade, now time.Time) []FootprintBar {
+	closed := make([]FootprintBar, 0)
+	closeCur := func() {
+		if feed.cur != nil && feed.cur.started {
+			closed = append(closed, feed.cur.bar(DefaultImbalanceRatio))
+			feed.lastClosed = feed.cur.time
+		}
+		feed.cur = nil
+	}
+
+	for _, t := range trades {
+		if t.Time < feed.lastTime || (t.Time == feed.lastTime && feed.seen[t.ID]) || t.Price <= 0 {
+			continue
+		}
+		if t.Time > feed.lastTime {
+			feed.lastTime = t.Time
+			feed.seen = make(map[string]bool)
+		}
+		feed.seen[t.ID] = true
+		feed.lastID = t.ID
+
+		start := t.Time / 1000
+		start -= start % FootprintBaseSeconds
+		if start <= feed.lastClosed {
+			// Too late for a bar that was already closed.
+			continue
+		}
+		if feed.cur != nil && start != feed.cur.time {
+			closeCur()
+		}
+		if feed.cur == nil {
+			if feed.bucket == 0 {
+				feed.bucket = FootprintBucket(t.Price)
+			}
+			feed.cur = newFootprintAcc(start, feed.bucket)
+			feed.cur.partial = feed.gap
+			feed.gap = false
+		}
+		feed.cur.addTrade(t)
+		feed.dirty = true
+	}
+
+	if feed.cur != nil {
+		end := time.Unix(feed.cur.time+FootprintBaseSeconds, 0)
+		if now.Sub(end) >= footprintCloseGrace {
+			closeCur()
+		}
+	}
+	return closed
+}
+
+// persist upserts a bar. Imbalance flags depend on the requested ratio and
+// are left out.
+func (s *FootprintService) persist(marketID string, b FootprintBar) {
+	raw := make([]FootprintLevel, len(b.Levels))
+	for i, l := range b.Levels {
+		raw[i] = FootprintLevel{Price: l.Price, Bid: l.Bid, Ask: l.Ask, Delta: l.Delta}
+	}
+	levels, err := json.Marshal(raw)
+	if err != nil {
+		return
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO footprint_bars (market_id, open_time, bucket, open, high, low, close,
+			buy_volume, sell_volume, min_delta, max_delta, trades, partial, levels)
+		VALUES ($1, to_timestamp($2) AT TIME ZONE 'UTC', $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT (market_id, open_time) DO UPDATE SET
+			bucket = EXCLUDED.bucket, open = EXCLUDED.open, high = EXCLUDED.high, low = EXCLUDED.low,
+			close = EXCLUDED.close, buy_volume = EXCLUDED.buy_volume, sell_volume = EXCLUDED.sell_volume,
+			min_delta = EXCLUDED.min_delta, max_delta = EXCLUDED.max_delta, trades = EXCLUDED.trades,
+			partial = EXCLUDED.partial, levels = EXCLUDED.levels
+	`, marketID, b.Time, b.Bucket, b.Open, b.High, b.Low, b.Close, b.BuyVolume, b.SellVolume,
+		b.MinDelta, b.MaxDelta, b.Trades, b.Partial, string(levels))
+	if err != nil {
+		log.Printf("⚠️ Footprint: failed to store %s bar %d: %v", marketID, b.Time, err)
+	}
+}
+
+// Bars returns footprint bars of intervalSec seconds whose open time is in
+// [from, to), including the bar being recorded. bucket is rounded to a
+// multiple of the recorded bucket (0 keeps it) and ratio sets the diagonal
+// imbalance threshold.
+func (s *FootprintService) Bars(marketID string, intervalSec, from, to int64, bucket, ratio float64) ([]FootprintBar, error) {
+	rows, err := s.db.Query(`
+		SELECT EXTRACT(EPOCH FROM open_time)::BIGINT, bucket, open, high, low, close,
+			buy_volume, sell_volume, min_delta, max_delta, trades, partial, levels
+		FROM footprint_bars
+		WHERE market_id = $1
+			AND open_time >= to_timestamp($2) AT TIME ZONE 'UTC'
+			AND open_time < to_timestamp($3) AT TIME ZONE 'UTC'
+		ORDER BY open_time
+	`, marketID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	base := make([]FootprintBar, 0)
+	for rows.Next() {
+		var b FootprintBar
+		var levels []byte
+		if err := rows.Scan(&b.Time, &b.Bucket, &b.Open, &b.High, &b.Low, &b.Close, &b.BuyVolume, &b.SellVolume,
+			&b.MinDelta, &b.MaxDelta, &b.Trades, &b.Partial, &levels); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(levels, &b.Levels); err != nil {
+			return nil, err
+		}
+		base = append(base, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	feed, tracked := s.feeds[marketID]
+	if tracked && feed.cur != nil && feed.cur.started && feed.cur.time >= from && feed.cur.time < to {
+		base = append(base, feed.cur.bar(0))
+	}
+	s.mu.Unlock()
+
+	if len(base) == 0 {
+		if !tracked {
+			return nil, ErrFootprintNotTracked
+		}
+		return []FootprintBar{}, nil
+	}
+
+	recorded := 0.0
+	for _, b := range base {
+		if b.Bucket > recorded {
+			recorded = b.Bucket
+		}
+	}
+	if bucket <= recorded {
+		bucket = recorded
+	} else {
+		bucket = roundTo(recorded*float64(int64(bucket/recorded+0.5)), stepDecimals(recorded))
+	}
+	return AggregateFootprint(base, intervalSec, bucket, ratio), nil
+}
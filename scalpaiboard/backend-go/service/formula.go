@@ -0,0 +1,850 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Formula limits. Parsing bounds the size of a formula; evaluation bounds
+// the number of bars and the estimated work (cost per bar × bars).
+const (
+	FormulaMaxLength = 1000
+	FormulaMaxBars   = 1000
+
+	formulaMaxNodes  = 200
+	formulaMaxDepth  = 32
+	formulaMaxPeriod = 500
+	formulaMaxOps    = 5_000_000
+)
+
+// ErrInvalidFormula is returned for formulas that fail to parse or exceed
+// the evaluation limits.
+var ErrInvalidFormula = errors.New("invalid formula")
+
+// Formula is a parsed custom indicator expression over candle series, e.g.
+// "ema(rsi(close, 14), 9)" or "crossover(sma(close, 20), sma(close, 50))".
+// Comparisons and logical operators yield 1 or 0; NaN marks bars where the
+// value is undefined (indicator warm-up, division by zero).
+type Formula struct {
+	source string
+	root   formulaNode
+	cost   int
+}
+
+// Series available to formulas.
+var formulaSeries = map[string]func(c Candle) float64{
+	"open":   func(c Candle) float64 { return c.Open },
+	"high":   func(c Candle) float64 { return c.High },
+	"low":    func(c Candle) float64 { return c.Low },
+	"close":  func(c Candle) float64 { return c.Close },
+	"volume": func(c Candle) float64 { return c.Volume },
+	"hl2":    func(c Candle) float64 { return (c.High + c.Low) / 2 },
+	"hlc3":   func(c Candle) float64 { return (c.High + c.Low + c.Close) / 3 },
+	"ohlc4":  func(c Candle) float64 { return (c.Open + c.High + c.Low + c.Close) / 4 },
+}
+
+// ParseFormula parses and validates a formula.
+func ParseFormula(source string) (*Formula, error) {
+	source = strings.TrimSpace(source)
+	if source == "" {
+		return nil, fmt.Errorf("%w: empty formula", ErrInvalidFormula)
+	}
+	if len(source) > FormulaMaxLength {
+		return nil, fmt.Errorf("%w: formula longer than %d characters", ErrInvalidFormula, FormulaMaxLength)
+	}
+
+	tokens, err := lexFormula(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &formulaParser{tokens: tokens}
+	root, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("%w: unexpected %q at position %d", ErrInvalidFormula, tok.text, tok.pos)
+	}
+	if p.nodes > formulaMaxNodes {
+		return nil, fmt.Errorf("%w: formula has more than %d terms", ErrInvalidFormula, formulaMaxNodes)
+	}
+
+	return &Formula{source: source, root: root, cost: root.cost()}, nil
+}
+
+// String returns the formula source.
+func (f *Formula) String() string {
+	return f.source
+}
+
+// Evaluate computes the formula for every candle.
+func (f *Formula) Evaluate(candles []Candle) ([]float64, error) {
+	return f.evaluate(candles, FormulaMaxBars, formulaMaxOps)
+}
+
+// evaluate applies caller-specific bar and work limits; backtests run longer
+// series than interactive requests.
+func (f *Formula) evaluate(candles []Candle, maxBars, maxOps int) ([]float64, error) {
+	if len(candles) > maxBars {
+		return nil, fmt.Errorf("%w: more than %d bars", ErrInvalidFormula, maxBars)
+	}
+	if f.cost*len(candles) > maxOps {
+		return nil, fmt.Errorf("%w: formula too expensive for %d bars", ErrInvalidFormula, len(candles))
+	}
+	if len(candles) == 0 {
+		return []float64{}, nil
+	}
+	return f.root.eval(candles), nil
+}
+
+// Last evaluates the formula and returns its value on the final candle.
+// ok is false when the value is undefined there.
+func (f *Formula) Last(candles []Candle) (value float64, ok bool, err error) {
+	series, err := f.Evaluate(candles)
+	if err != nil || len(series) == 0 {
+		return 0, false, err
+	}
+	v := series[len(series)-1]
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false, nil
+	}
+	return v, true, nil
+}
+
+// FormulaFunctions lists the callable functions with their signatures.
+func FormulaFunctions() []string {
+	out := make([]string, 0, len(formulaFuncs))
+	for _, name := range formulaFuncOrder {
+		out = append(out, formulaFuncs[name].signature)
+	}
+	return out
+}
+
+// --- lexer -----------------------------------------------------------------
+
+type formulaTokenKind int
+
+const (
+	tokEOF formulaTokenKind = iota
+	tokNumber
+	tokIdent
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type formulaToken struct {
+	kind formulaTokenKind
+	text string
+	num  float64
+	pos  int
+}
+
+func lexFormula(src string) ([]formulaToken, error) {
+	tokens := make([]formulaToken, 0, len(src)/2)
+	i := 0
+	for i < len(src) {
+		ch := src[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case ch >= '0' && ch <= '9' || ch == '.':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			num, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: bad number %q at position %d", ErrInvalidFormula, src[start:i], start)
+			}
+			tokens = append(tokens, formulaToken{kind: tokNumber, text: src[start:i], num: num, pos: start})
+		case ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z':
+			start := i
+			for i < len(src) && (src[i] == '_' || src[i] >= 'a' && src[i] <= 'z' || src[i] >= 'A' && src[i] <= 'Z' || src[i] >= '0' && src[i] <= '9') {
+				i++
+			}
+			word := strings.ToLower(src[start:i])
+			switch word {
+			case "and":
+				tokens = append(tokens, formulaToken{kind: tokOp, text: "&&", pos: start})
+			case "or":
+				tokens = append(tokens, formulaToken{kind: tokOp, text: "||", pos: start})
+			case "not":
+				tokens = append(tokens, formulaToken{kind: tokOp, text: "!", pos: start})
+			default:
+				tokens = append(tokens, formulaToken{kind: tokIdent, text: word, pos: start})
+			}
+		case ch == '(':
+			tokens = append(tokens, formulaToken{kind: tokLParen, text: "(", pos: i})
+			i++
+		case ch == ')':
+			tokens = append(tokens, formulaToken{kind: tokRParen, text: ")", pos: i})
+			i++
+		case ch == ',':
+			tokens = append(tokens, formulaToken{kind: tokComma, text: ",", pos: i})
+			i++
+		default:
+			op := ""
+			if i+1 < len(src) {
+				switch two := src[i : i+2]; two {
+				case "<=", ">=", "==", "!=", "&&", "||":
+					op = two
+				}
+			}
+			if op == "" && strings.IndexByte("+-*/%<>!", ch) >= 0 {
+				op = string(ch)
+			}
+			if op == "" {
+				return nil, fmt.Errorf("%w: unexpected character %q at position %d", ErrInvalidFormula, ch, i)
+			}
+			tokens = append(tokens, formulaToken{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, formulaToken{kind: tokEOF, text: "end of formula", pos: len(src)}), nil
+}
+
+// --- parser ----------------------------------------------------------------
+
+// Binary operator precedence, loosest first.
+var formulaBinaryPrec = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+const formulaUnaryPrec = 7
+
+type formulaParser struct {
+	tokens []formulaToken
+	pos    int
+	nodes  int
+	depth  int
+}
+
+func (p *formulaParser) peek() formulaToken {
+	return p.tokens[p.pos]
+}
+
+func (p *formulaParser) next() formulaToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *formulaParser) expect(kind formulaTokenKind, what string) error {
+	tok := p.next()
+	if tok.kind != kind {
+		return fmt.Errorf("%w: expected %s at position %d, got %q", ErrInvalidFormula, what, tok.pos, tok.text)
+	}
+	return nil
+}
+
+// parseExpr is a precedence-climbing parser for binary operators.
+func (p *formulaParser) parseExpr(minPrec int) (formulaNode, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > formulaMaxDepth {
+		return nil, fmt.Errorf("%w: formula nested too deeply", ErrInvalidFormula)
+	}
+
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		prec, ok := formulaBinaryPrec[tok.text]
+		if tok.kind != tokOp || !ok || prec <= minPrec {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseExpr(prec)
+		if err != nil {
+			return nil, err
+		}
+		p.nodes++
+		left = &binaryNode{op: tok.text, left: left, right: right}
+	}
+}
+
+func (p *formulaParser) parseUnary() (formulaNode, error) {
+	tok := p.peek()
+	if tok.kind == tokOp && (tok.text == "-" || tok.text == "!" || tok.text == "+") {
+		p.next()
+		operand, err := p.parseExpr(formulaUnaryPrec)
+		if err != nil {
+			return nil, err
+		}
+		if tok.text == "+" {
+			return operand, nil
+		}
+		p.nodes++
+		return &unaryNode{op: tok.text, operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *formulaParser) parsePrimary() (formulaNode, error) {
+	tok := p.next()
+	p.nodes++
+
+	switch tok.kind {
+	case tokNumber:
+		return &numberNode{value: tok.num}, nil
+
+	case tokLParen:
+		p.nodes--
+		inner, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+
+	case tokIdent:
+		if p.peek().kind != tokLParen {
+			if _, ok := formulaSeries[tok.text]; ok {
+				return &seriesNode{name: tok.text}, nil
+			}
+			if _, ok := formulaFuncs[tok.text]; ok {
+				return nil, fmt.Errorf("%w: %s is a function, call it as %s", ErrInvalidFormula, tok.text, formulaFuncs[tok.text].signature)
+			}
+			return nil, fmt.Errorf("%w: unknown series %q", ErrInvalidFormula, tok.text)
+		}
+		fn, ok := formulaFuncs[tok.text]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown function %q", ErrInvalidFormula, tok.text)
+		}
+		p.next()
+
+		args := make([]formulaNode, 0, len(fn.args))
+		if p.peek().kind != tokRParen {
+			for {
+				arg, err := p.parseExpr(0)
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+				if p.peek().kind != tokComma {
+					break
+				}
+				p.next()
+			}
+		}
+		if err := p.expect(tokRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return newCallNode(fn, args)
+
+	default:
+		return nil, fmt.Errorf("%w: unexpected %q at position %d", ErrInvalidFormula, tok.text, tok.pos)
+	}
+}
+
+// --- AST -------------------------------------------------------------------
+
+type formulaNode interface {
+	eval(candles []Candle) []float64
+	// cost is the estimated work per bar.
+	cost() int
+}
+
+type numberNode struct{ value float64 }
+
+func (n *numberNode) eval(candles []Candle) []float64 {
+	out := make([]float64, len(candles))
+	for i := range out {
+		out[i] = n.value
+	}
+	return out
+}
+
+func (n *numberNode) cost() int { return 1 }
+
+type seriesNode struct{ name string }
+
+func (n *seriesNode) eval(candles []Candle) []float64 {
+	get := formulaSeries[n.name]
+	out := make([]float64, len(candles))
+	for i, c := range candles {
+		out[i] = get(c)
+	}
+	return out
+}
+
+func (n *seriesNode) cost() int { return 1 }
+
+type unaryNode struct {
+	op      string
+	operand formulaNode
+}
+
+func (n *unaryNode) eval(candles []Candle) []float64 {
+	out := n.operand.eval(candles)
+	for i, v := range out {
+		if math.IsNaN(v) {
+			continue
+		}
+		if n.op == "-" {
+			out[i] = -v
+		} else {
+			out[i] = boolValue(v == 0)
+		}
+	}
+	return out
+}
+
+func (n *unaryNode) cost() int { return n.operand.cost() + 1 }
+
+type binaryNode struct {
+	op          string
+	left, right formulaNode
+}
+
+func (n *binaryNode) eval(candles []Candle) []float64 {
+	a := n.left.eval(candles)
+	b := n.right.eval(candles)
+	for i := range a {
+		x, y := a[i], b[i]
+		if math.IsNaN(x) || math.IsNaN(y) {
+			a[i] = math.NaN()
+			continue
+		}
+		switch n.op {
+		case "+":
+			a[i] = x + y
+		case "-":
+			a[i] = x - y
+		case "*":
+			a[i] = x * y
+		case "/":
+			a[i] = divide(x, y)
+		case "%":
+			if y == 0 {
+				a[i] = math.NaN()
+			} else {
+				a[i] = math.Mod(x, y)
+			}
+		case "<":
+			a[i] = boolValue(x < y)
+		case "<=":
+			a[i] = boolValue(x <= y)
+		case ">":
+			a[i] = boolValue(x > y)
+		case ">=":
+			a[i] = boolValue(x >= y)
+		case "==":
+			a[i] = boolValue(x == y)
+		case "!=":
+			a[i] = boolValue(x != y)
+		case "&&":
+			a[i] = boolValue(x != 0 && y != 0)
+		case "||":
+			a[i] = boolValue(x != 0 || y != 0)
+		}
+	}
+	return a
+}
+
+func (n *binaryNode) cost() int { return n.left.cost() + n.right.cost() + 1 }
+
+type callNode struct {
+	fn     *formulaFunc
+	series []formulaNode
+	consts []float64
+}
+
+func newCallNode(fn *formulaFunc, args []formulaNode) (formulaNode, error) {
+	if len(args) != len(fn.args) {
+		return nil, fmt.Errorf("%w: %s takes %d argument(s): %s", ErrInvalidFormula, fn.name, len(fn.args), fn.signature)
+	}
+	call := &callNode{fn: fn}
+	for i, kind := range fn.args {
+		if kind == argSeries {
+			call.series = append(call.series, args[i])
+			continue
+		}
+		num, ok := constantValue(args[i])
+		if !ok {
+			return nil, fmt.Errorf("%w: argument %d of %s must be a number", ErrInvalidFormula, i+1, fn.name)
+		}
+		if kind == argPeriod {
+			if num != math.Trunc(num) || num < fn.minPeriod || num > formulaMaxPeriod {
+				return nil, fmt.Errorf("%w: period of %s must be a whole number between %d and %d",
+					ErrInvalidFormula, fn.name, int(fn.minPeriod), formulaMaxPeriod)
+			}
+		}
+		call.consts = append(call.consts, num)
+	}
+	return call, nil
+}
+
+func (n *callNode) eval(candles []Candle) []float64 {
+	inputs := make([][]float64, len(n.series))
+	for i, s := range n.series {
+		inputs[i] = s.eval(candles)
+	}
+	return n.fn.eval(candles, inputs, n.consts)
+}
+
+func (n *callNode) cost() int {
+	total := n.fn.weight(n.consts)
+	for _, s := range n.series {
+		total += s.cost()
+	}
+	return total
+}
+
+// constantValue folds a (possibly negated) number literal.
+func constantValue(node formulaNode) (float64, bool) {
+	switch n := node.(type) {
+	case *numberNode:
+		return n.value, true
+	case *unaryNode:
+		if v, ok := constantValue(n.operand); ok && n.op == "-" {
+			return -v, true
+		}
+	}
+	return 0, false
+}
+
+// --- functions -------------------------------------------------------------
+
+type formulaArgKind int
+
+const (
+	argSeries formulaArgKind = iota
+	// argPeriod is a whole-number literal bar count.
+	argPeriod
+	// argNumber is any numeric literal.
+	argNumber
+)
+
+type formulaFunc struct {
+	name      string
+	signature string
+	args      []formulaArgKind
+	minPeriod float64
+	eval      func(candles []Candle, in [][]float64, consts []float64) []float64
+	// weight is the per-bar cost, defaulting to 4 when nil.
+	weightFn func(consts []float64) int
+}
+
+func (f *formulaFunc) weight(consts []float64) int {
+	if f.weightFn == nil {
+		return 4
+	}
+	return f.weightFn(consts)
+}
+
+// windowWeight charges a window function its period per bar.
+func windowWeight(consts []float64) int {
+	return int(consts[0]) + 1
+}
+
+var formulaFuncOrder = []string{
+	"sma", "ema", "rma", "wma", "rsi", "stdev", "highest", "lowest", "sum",
+	"ref", "change", "roc", "atr", "natr", "adx", "obv",
+	"macd", "macd_signal", "macd_hist", "bb_upper", "bb_lower",
+	"crossover", "crossunder", "abs", "sqrt", "log", "min", "max", "iff",
+}
+
+var formulaFuncs map[string]*formulaFunc
+
+func init() {
+	s, p, num := argSeries, argPeriod, argNumber
+	defs := []*formulaFunc{
+		{name: "sma", signature: "sma(x, period)", args: []formulaArgKind{s, p}, minPeriod: 1, weightFn: windowWeight,
+			eval: windowFunc(mean)},
+		{name: "ema", signature: "ema(x, period)", args: []formulaArgKind{s, p}, minPeriod: 1,
+			eval: func(_ []Candle, in [][]float64, c []float64) []float64 {
+				return smoothedSeries(in[0], int(c[0]), 2/(c[0]+1))
+			}},
+		{name: "rma", signature: "rma(x, period)", args: []formulaArgKind{s, p}, minPeriod: 1,
+			eval: func(_ []Candle, in [][]float64, c []float64) []float64 {
+				return smoothedSeries(in[0], int(c[0]), 1/c[0])
+			}},
+		{name: "wma", signature: "wma(x, period)", args: []formulaArgKind{s, p}, minPeriod: 1, weightFn: windowWeight,
+			eval: windowFunc(func(w []float64) float64 {
+				sum, norm := 0.0, 0.0
+				for i, v := range w {
+					sum += v * float64(i+1)
+					norm += float64(i + 1)
+				}
+				return sum / norm
+			})},
+		{name: "rsi", signature: "rsi(x, period)", args: []formulaArgKind{s, p}, minPeriod: 1,
+			eval: func(_ []Candle, in [][]float64, c []float64) []float64 {
+				return validTail(in[0], func(x []float64) ([]float64, error) { return RSISeries(x, int(c[0])) })
+			}},
+		{name: "stdev", signature: "stdev(x, period)", args: []formulaArgKind{s, p}, minPeriod: 1, weightFn: windowWeight,
+			eval: windowFunc(func(w []float64) float64 {
+				m := mean(w)
+				v := 0.0
+				for _, x := range w {
+					v += (x - m) * (x - m)
+				}
+				return math.Sqrt(v / float64(len(w)))
+			})},
+		{name: "highest", signature: "highest(x, period)", args: []formulaArgKind{s, p}, minPeriod: 1, weightFn: windowWeight,
+			eval: windowFunc(highest)},
+		{name: "lowest", signature: "lowest(x, period)", args: []formulaArgKind{s, p}, minPeriod: 1, weightFn: windowWeight,
+			eval: windowFunc(lowest)},
+		{name: "sum", signature: "sum(x, period)", args: []formulaArgKind{s, p}, minPeriod: 1, weightFn: windowWeight,
+			eval: windowFunc(func(w []float64) float64 { return mean(w) * float64(len(w)) })},
+		{name: "ref", signature: "ref(x, bars)", args: []formulaArgKind{s, p}, minPeriod: 0,
+			eval: func(_ []Candle, in [][]float64, c []float64) []float64 {
+				return shiftSeries(in[0], int(c[0]))
+			}},
+		{name: "change", signature: "change(x, bars)", args: []formulaArgKind{s, p}, minPeriod: 1,
+			eval: func(_ []Candle, in [][]float64, c []float64) []float64 {
+				prev := shiftSeries(in[0], int(c[0]))
+				for i, v := range in[0] {
+					prev[i] = v - prev[i]
+				}
+				return prev
+			}},
+		{name: "roc", signature: "roc(x, bars)", args: []formulaArgKind{s, p}, minPeriod: 1,
+			eval: func(_ []Candle, in [][]float64, c []float64) []float64 {
+				prev := shiftSeries(in[0], int(c[0]))
+				for i, v := range in[0] {
+					prev[i] = 100 * divide(v-prev[i], prev[i])
+				}
+				return prev
+			}},
+		{name: "atr", signature: "atr(period)", args: []formulaArgKind{p}, minPeriod: 1,
+			eval: func(candles []Candle, _ [][]float64, c []float64) []float64 {
+				closes, highs, lows := CandleSeries(candles)
+				atr, err := ATR(highs, lows, closes, int(c[0]))
+				return paddedOrNaN(atr, err, len(candles))
+			}},
+		{name: "natr", signature: "natr(period)", args: []formulaArgKind{p}, minPeriod: 1,
+			eval: func(candles []Candle, _ [][]float64, c []float64) []float64 {
+				closes, highs, lows := CandleSeries(candles)
+				natr, err := NATR(highs, lows, closes, int(c[0]))
+				return paddedOrNaN(natr, err, len(candles))
+			}},
+		{name: "adx", signature: "adx(period)", args: []formulaArgKind{p}, minPeriod: 1,
+			eval: func(candles []Candle, _ [][]float64, c []float64) []float64 {
+				closes, highs, lows := CandleSeries(candles)
+				adx, err := ADX(highs, lows, closes, int(c[0]))
+				return paddedOrNaN(adx.ADX, err, len(candles))
+			}},
+		{name: "obv", signature: "obv()", args: []formulaArgKind{},
+			eval: func(candles []Candle, _ [][]float64, _ []float64) []float64 {
+				closes := make([]float64, len(candles))
+				volumes := make([]float64, len(candles))
+				for i, cd := range candles {
+					closes[i] = cd.Close
+					volumes[i] = cd.Volume
+				}
+				obv, err := OBV(closes, volumes)
+				return paddedOrNaN(obv, err, len(candles))
+			}},
+		{name: "macd", signature: "macd(x, fast, slow)", args: []formulaArgKind{s, p, p}, minPeriod: 1,
+			eval: macdFunc(func(r MACDSeriesResult) []float64 { return r.MACD })},
+		{name: "macd_signal", signature: "macd_signal(x, fast, slow, signal)", args: []formulaArgKind{s, p, p, p}, minPeriod: 1,
+			eval: macdFunc(func(r MACDSeriesResult) []float64 { return r.Signal })},
+		{name: "macd_hist", signature: "macd_hist(x, fast, slow, signal)", args: []formulaArgKind{s, p, p, p}, minPeriod: 1,
+			eval: macdFunc(func(r MACDSeriesResult) []float64 { return r.Histogram })},
+		{name: "bb_upper", signature: "bb_upper(x, period, mult)", args: []formulaArgKind{s, p, num}, minPeriod: 1, weightFn: windowWeight,
+			eval: bandFunc(1)},
+		{name: "bb_lower", signature: "bb_lower(x, period, mult)", args: []formulaArgKind{s, p, num}, minPeriod: 1, weightFn: windowWeight,
+			eval: bandFunc(-1)},
+		{name: "crossover", signature: "crossover(a, b)", args: []formulaArgKind{s, s},
+			eval: crossFunc(1)},
+		{name: "crossunder", signature: "crossunder(a, b)", args: []formulaArgKind{s, s},
+			eval: crossFunc(-1)},
+		{name: "abs", signature: "abs(x)", args: []formulaArgKind{s},
+			eval: mapFunc(math.Abs)},
+		{name: "sqrt", signature: "sqrt(x)", args: []formulaArgKind{s},
+			eval: mapFunc(math.Sqrt)},
+		{name: "log", signature: "log(x)", args: []formulaArgKind{s},
+			eval: mapFunc(math.Log)},
+		{name: "min", signature: "min(a, b)", args: []formulaArgKind{s, s},
+			eval: func(_ []Candle, in [][]float64, _ []float64) []float64 {
+				for i := range in[0] {
+					in[0][i] = math.Min(in[0][i], in[1][i])
+				}
+				return in[0]
+			}},
+		{name: "max", signature: "max(a, b)", args: []formulaArgKind{s, s},
+			eval: func(_ []Candle, in [][]float64, _ []float64) []float64 {
+				for i := range in[0] {
+					in[0][i] = math.Max(in[0][i], in[1][i])
+				}
+				return in[0]
+			}},
+		{name: "iff", signature: "iff(cond, a, b)", args: []formulaArgKind{s, s, s},
+			eval: func(_ []Candle, in [][]float64, _ []float64) []float64 {
+				out := make([]float64, len(in[0]))
+				for i, cond := range in[0] {
+					switch {
+					case math.IsNaN(cond):
+						out[i] = math.NaN()
+					case cond != 0:
+						out[i] = in[1][i]
+					default:
+						out[i] = in[2][i]
+					}
+				}
+				return out
+			}},
+	}
+
+	formulaFuncs = make(map[string]*formulaFunc, len(defs))
+	for _, fn := range defs {
+		formulaFuncs[fn.name] = fn
+	}
+}
+
+// windowFunc applies fn to every full window of period values; windows
+// containing NaN yield NaN.
+func windowFunc(fn func(window []float64) float64) func([]Candle, [][]float64, []float64) []float64 {
+	return func(_ []Candle, in [][]float64, c []float64) []float64 {
+		x, period := in[0], int(c[0])
+		out := make([]float64, len(x))
+		lastNaN := -1
+		for i, v := range x {
+			if math.IsNaN(v) {
+				lastNaN = i
+			}
+			if i < period-1 || lastNaN > i-period {
+				out[i] = math.NaN()
+				continue
+			}
+			out[i] = fn(x[i-period+1 : i+1])
+		}
+		return out
+	}
+}
+
+// smoothedSeries is an exponential average with the given alpha, seeded
+// with the SMA of the first period valid values.
+func smoothedSeries(x []float64, period int, alpha float64) []float64 {
+	return validTail(x, func(v []float64) ([]float64, error) {
+		if len(v) < period {
+			return nil, errors.New("not enough data")
+		}
+		out := make([]float64, 0, len(v)-period+1)
+		prev := mean(v[:period])
+		out = append(out, prev)
+		for _, val := range v[period:] {
+			prev = alpha*val + (1-alpha)*prev
+			out = append(out, prev)
+		}
+		return out, nil
+	})
+}
+
+// validTail runs an end-aligned indicator on the values after x's leading
+// NaN warm-up and pads the result back to len(x).
+func validTail(x []float64, fn func([]float64) ([]float64, error)) []float64 {
+	start := 0
+	for start < len(x) && math.IsNaN(x[start]) {
+		start++
+	}
+	out, err := fn(x[start:])
+	return paddedOrNaN(out, err, len(x))
+}
+
+func paddedOrNaN(series []float64, err error, n int) []float64 {
+	if err != nil || len(series) > n {
+		return padSeries(nil, n)
+	}
+	return padSeries(series, n)
+}
+
+func shiftSeries(x []float64, bars int) []float64 {
+	out := make([]float64, len(x))
+	for i := range out {
+		if i < bars {
+			out[i] = math.NaN()
+		} else {
+			out[i] = x[i-bars]
+		}
+	}
+	return out
+}
+
+func macdFunc(pick func(MACDSeriesResult) []float64) func([]Candle, [][]float64, []float64) []float64 {
+	return func(_ []Candle, in [][]float64, c []float64) []float64 {
+		signal := 9
+		if len(c) > 2 {
+			signal = int(c[2])
+		}
+		return validTail(in[0], func(x []float64) ([]float64, error) {
+			r, err := MACDSeries(x, int(c[0]), int(c[1]), signal)
+			if err != nil {
+				return nil, err
+			}
+			return pick(r), nil
+		})
+	}
+}
+
+func bandFunc(side float64) func([]Candle, [][]float64, []float64) []float64 {
+	return func(candles []Candle, in [][]float64, c []float64) []float64 {
+		mult := c[1]
+		return windowFunc(func(w []float64) float64 {
+			m := mean(w)
+			v := 0.0
+			for _, x := range w {
+				v += (x - m) * (x - m)
+			}
+			return m + side*mult*math.Sqrt(v/float64(len(w)))
+		})(candles, in, c)
+	}
+}
+
+func crossFunc(dir float64) func([]Candle, [][]float64, []float64) []float64 {
+	return func(_ []Candle, in [][]float64, _ []float64) []float64 {
+		a, b := in[0], in[1]
+		out := make([]float64, len(a))
+		out[0] = math.NaN()
+		for i := 1; i < len(a); i++ {
+			prev, cur := dir*(a[i-1]-b[i-1]), dir*(a[i]-b[i])
+			if math.IsNaN(prev) || math.IsNaN(cur) {
+				out[i] = math.NaN()
+				continue
+			}
+			out[i] = boolValue(prev <= 0 && cur > 0)
+		}
+		return out
+	}
+}
+
+func mapFunc(fn func(float64) float64) func([]Candle, [][]float64, []float64) []float64 {
+	return func(_ []Candle, in [][]float64, _ []float64) []float64 {
+		for i, v := range in[0] {
+			in[0][i] = fn(v)
+		}
+		return in[0]
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func divide(x, y float64) float64 {
+	if y == 0 {
+		return math.NaN()
+	}
+	return x / y
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseFormulaErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"empty", "  ", "empty formula"},
+		{"too long", strings.Repeat("1+", 500) + "1", "longer than 1000 characters"},
+		{"bad character", "close $ 1", "unexpected character"},
+		{"bad number", "1..2", "bad number"},
+		{"dangling operator", "close +", "unexpected \"end of formula\""},
+		{"unclosed paren", "(close", "expected ')'"},
+		{"stray paren", "close)", "unexpected \")\""},
+		{"missing comma", "sma(close 14)", "expected ')'"},
+		{"unknown series", "price", "unknown series"},
+		{"unknown function", "vwap(close)", "unknown function"},
+		{"function as series", "sma", "call it as sma(x, period)"},
+		{"wrong argument count", "sma(close)", "takes 2 argument(s)"},
+		{"series as period", "sma(close, close)", "must be a number"},
+		{"fractional period", "sma(close, 1.5)", "whole number"},
+		{"period below minimum", "rsi(close, 0)", "between 1 and 500"},
+		{"period above maximum", "ema(close, 501)", "between 1 and 500"},
+		{"too many terms", strings.Repeat("1+", 200) + "1", "more than 200 terms"},
+		{"nested too deeply", strings.Repeat("(", 40) + "1" + strings.Repeat(")", 40), "nested too deeply"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFormula(tt.source)
+			if !errors.Is(err, ErrInvalidFormula) {
+				t.Fatalf("ParseFormula(%q) error = %v, want ErrInvalidFormula", tt.source, err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormulaEvaluateLimits(t *testing.T) {
+	// Ten 500-bar windows cost just over formulaMaxOps at FormulaMaxBars.
+	expensive := strings.TrimSuffix(strings.Repeat("sma(close, 500) + ", 10), " + ")
+
+	tests := []struct {
+		name   string
+		source string
+		bars   int
+		want   string
+	}{
+		{"within limits", "sma(close, 500)", FormulaMaxBars, ""},
+		{"too many bars", "close", FormulaMaxBars + 1, "more than 1000 bars"},
+		{"too expensive", expensive, FormulaMaxBars, "too expensive"},
+		{"expensive on fewer bars", expensive, FormulaMaxBars / 2, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseFormula(tt.source)
+			if err != nil {
+				t.Fatalf("ParseFormula: %v", err)
+			}
+			series, err := f.Evaluate(make([]Candle, tt.bars))
+			if tt.want == "" {
+				if err != nil || len(series) != tt.bars {
+					t.Fatalf("Evaluate() = %d values, %v; want %d values", len(series), err, tt.bars)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidFormula) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Evaluate() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormulaSeries(t *testing.T) {
+	nan := math.NaN()
+	candles := closeCandles(1, 2, 3, 2, 3)
+
+	tests := []struct {
+		source string
+		want   []float64
+	}{
+		{"ref(close, 2)", []float64{nan, nan, 1, 2, 3}},
+		{"ref(close, 0)", []float64{1, 2, 3, 2, 3}},
+		{"change(close, 1)", []float64{nan, 1, 1, -1, 1}},
+		{"crossover(close, 2.5)", []float64{nan, 0, 1, 0, 1}},
+		{"crossunder(close, 2.5)", []float64{nan, 0, 0, 1, 0}},
+		{"crossover(close, ref(close, 1))", []float64{nan, nan, 0, 0, 1}},
+		{"sma(close, 3) + 1", []float64{nan, nan, 3, 3.3333333333333335, 3.6666666666666665}},
+		{"close > sma(close, 3)", []float64{nan, nan, 1, 0, 1}},
+		{"not (close > sma(close, 3))", []float64{nan, nan, 0, 1, 0}},
+		{"iff(ref(close, 1) < close, 1, -1)", []float64{nan, 1, 1, -1, 1}},
+		{"close / (close - 2)", []float64{-1, nan, 3, nan, 3}},
+		{"close % 0", []float64{nan, nan, nan, nan, nan}},
+		{"sma(ref(close, 1), 2)", []float64{nan, nan, 1.5, 2.5, 2.5}},
+		{"-close * 2 + 10 and 1", []float64{1, 1, 1, 1, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			f, err := ParseFormula(tt.source)
+			if err != nil {
+				t.Fatalf("ParseFormula: %v", err)
+			}
+			got, err := f.Evaluate(candles)
+			if err != nil {
+				t.Fatalf("Evaluate: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d values, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if math.IsNaN(tt.want[i]) != math.IsNaN(got[i]) || !math.IsNaN(got[i]) && !approx(got[i], tt.want[i]) {
+					t.Errorf("value %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFormulaLastUndefined(t *testing.T) {
+	f, err := ParseFormula("close / (close - 3)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := f.Last(closeCandles(1, 2, 3)); ok || err != nil {
+		t.Errorf("Last() ok = %v, err = %v; want undefined", ok, err)
+	}
+	if v, ok, err := f.Last(closeCandles(1, 2, 4)); !ok || err != nil || v != 4 {
+		t.Errorf("Last() = %v, %v, %v; want 4", v, ok, err)
+	}
+}
+
+func TestFormulaMatchesBuiltins(t *testing.T) {
+	closes := make([]float64, 80)
+	for i := range closes {
+		closes[i] = 100 + 10*math.Sin(float64(i)/3) + 0.2*float64(i)
+	}
+	candles := closeCandles(closes...)
+
+	rsi, _ := RSISeries(closes, 14)
+	ema, _ := EMA(closes, 10)
+	emaOfRSI, _ := EMA(rsi, 9)
+	sma := make([]float64, 0, len(closes))
+	for i := 19; i < len(closes); i++ {
+		v, _ := SMA(closes[:i+1], 20)
+		sma = append(sma, v)
+	}
+
+	tests := []struct {
+		source string
+		want   []float64
+	}{
+		{"rsi(close, 14)", rsi},
+		{"ema(close, 10)", ema},
+		{"sma(close, 20)", sma},
+		{"ema(rsi(close, 14), 9)", emaOfRSI},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			f, err := ParseFormula(tt.source)
+			if err != nil {
+				t.Fatalf("ParseFormula: %v", err)
+			}
+			got, err := f.Evaluate(candles)
+			if err != nil {
+				t.Fatalf("Evaluate: %v", err)
+			}
+			// Built-ins are end-aligned; the formula pads the warm-up with NaN.
+			warmup := len(got) - len(tt.want)
+			for i, v := range got {
+				if i < warmup {
+					if !math.IsNaN(v) {
+						t.Errorf("value %d = %v during warm-up, want NaN", i, v)
+					}
+					continue
+				}
+				if !approx(v, tt.want[i-warmup]) {
+					t.Errorf("value %d = %v, want %v", i, v, tt.want[i-warmup])
+				}
+			}
+		})
+	}
+}
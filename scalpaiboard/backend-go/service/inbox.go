@@ -0,0 +1,241 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+	"github.com/scalpaiboard/backend/models"
+)
+
+// Kinds of in-app notifications.
+const (
+	NotificationKindAlert    = "alert"
+	NotificationKindScreener = "screener"
+)
+
+// Inbox events pushed to the user's sockets.
+const (
+	// InboxEventNew carries a new notification.
+	InboxEventNew = "new"
+	// InboxEventUnread carries the unread count after notifications were
+	// read or cleared.
+	InboxEventUnread = "unread"
+)
+
+const (
+	// maxInboxNotifications is how many notifications a user keeps; older
+	// ones are dropped as new ones arrive.
+	maxInboxNotifications = 500
+	defaultInboxPage      = 50
+	maxInboxPage          = 200
+)
+
+// ErrNotificationNotFound is returned when a user has no notification with
+// the requested id.
+var ErrNotificationNotFound = errors.New("notification not found")
+
+// InboxEvent is a change of a user's inbox. Unread is the unread count
+// after the change.
+type InboxEvent struct {
+	Type         string               `json:"event"`
+	Notification *models.Notification `json:"data,omitempty"`
+	Unread       int                  `json:"unread"`
+}
+
+// InboxPage is a page of notifications, newest first. NextBefore is the
+// cursor of the next page, unset on the last one.
+type InboxPage struct {
+	Data       []models.Notification `json:"data"`
+	Unread     int                   `json:"unread"`
+	NextBefore *int                  `json:"nextBefore,omitempty"`
+}
+
+// InboxService stores the in-app notifications of users.
+type InboxService struct {
+	db   *sql.DB
+	sink func(userID string, event InboxEvent)
+}
+
+func NewInboxService(db *sql.DB) *InboxService {
+	return &InboxService{db: db}
+}
+
+// OnEvent registers the receiver of inbox events (e.g. the WebSocket hub).
+func (s *InboxService) OnEvent(fn func(userID string, event InboxEvent)) {
+	s.sink = fn
+}
+
+func (s *InboxService) emit(userID string, event InboxEvent) {
+	if s.sink != nil {
+		s.sink(userID, event)
+	}
+}
+
+const notificationColumns = `id, user_id, kind, title, message, data, read_at, created_at`
+
+func scanNotification(row rowScanner) (*models.Notification, error) {
+	var n models.Notification
+	var data []byte
+	var readAt sql.NullTime
+	if err := row.Scan(&n.ID, &n.UserID, &n.Kind, &n.Title, &n.Message, &data, &readAt, &n.CreatedAt); err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		n.Data = data
+	}
+	if readAt.Valid {
+		n.Read, n.ReadAt = true, &readAt.Time
+	}
+	return &n, nil
+}
+
+// Add stores a notification and pushes it to the user. data is stored as
+// JSON and may be nil.
+func (s *InboxService) Add(userID, kind, title, message string, data interface{}) (*models.Notification, error) {
+	var raw []byte
+	if data != nil {
+		var err error
+		if raw, err = json.Marshal(data); err != nil {
+			return nil, err
+		}
+	}
+	n, err := scanNotification(s.db.QueryRow(`
+		INSERT INTO notifications (user_id, kind, title, message, data)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+notificationColumns, userID, kind, title, message, raw))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.Exec(`
+		DELETE FROM notifications
+		WHERE user_id = $1 AND id <= (
+			SELECT id FROM notifications WHERE user_id = $1 ORDER BY id DESC OFFSET $2 LIMIT 1
+		)
+	`, userID, maxInboxNotifications)
+	if err != nil {
+		log.Printf("⚠️ Failed to prune notifications of %s: %v", userID, err)
+	}
+
+	unread, err := s.UnreadCount(userID)
+	if err != nil {
+		return n, nil
+	}
+	s.emit(userID, InboxEvent{Type: InboxEventNew, Notification: n, Unread: unread})
+	return n, nil
+}
+
+// List returns a page of the user's notifications older than the before
+// cursor (0 for the newest), only the unread ones when unreadOnly is set.
+func (s *InboxService) List(userID string, before, limit int, unreadOnly bool) (*InboxPage, error) {
+	if limit <= 0 {
+		limit = defaultInboxPage
+	}
+	if limit > maxInboxPage {
+		limit = maxInboxPage
+	}
+	query := `SELECT ` + notificationColumns + ` FROM notifications WHERE user_id = $1`
+	args := []interface{}{userID}
+	if before > 0 {
+		args = append(args, before)
+		query += ` AND id < $2`
+	}
+	if unreadOnly {
+		query += ` AND read_at IS NULL`
+	}
+	// One extra row tells whether there is a next page.
+	args = append(args, limit+1)
+	query += fmt.Sprintf(` ORDER BY id DESC LIMIT $%d`, len(args))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &InboxPage{Data: make([]models.Notification, 0, limit)}
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			continue
+		}
+		page.Data = append(page.Data, *n)
+	}
+	if len(page.Data) > limit {
+		page.Data = page.Data[:limit]
+		next := page.Data[limit-1].ID
+		page.NextBefore = &next
+	}
+	if page.Unread, err = s.UnreadCount(userID); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// UnreadCount returns the number of unread notifications of a user.
+func (s *InboxService) UnreadCount(userID string) (int, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL", userID).Scan(&n)
+	return n, err
+}
+
+// MarkRead marks the given notifications, or all of them when ids is
+// empty, as read and returns the unread count.
+func (s *InboxService) MarkRead(userID string, ids []int) (int, error) {
+	var err error
+	if len(ids) == 0 {
+		_, err = s.db.Exec("UPDATE notifications SET read_at = NOW() WHERE user_id = $1 AND read_at IS NULL", userID)
+	} else {
+		ids64 := make([]int64, 0, len(ids))
+		for _, id := range ids {
+			ids64 = append(ids64, int64(id))
+		}
+		_, err = s.db.Exec(`
+			UPDATE notifications SET read_at = NOW()
+			WHERE user_id = $1 AND read_at IS NULL AND id = ANY($2)
+		`, userID, pq.Array(ids64))
+	}
+	if err != nil {
+		return 0, err
+	}
+	return s.changed(userID)
+}
+
+// Delete removes one notification and returns the unread count.
+func (s *InboxService) Delete(userID string, id int) (int, error) {
+	res, err := s.db.Exec("DELETE FROM notifications WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, ErrNotificationNotFound
+	}
+	return s.changed(userID)
+}
+
+// Clear removes the user's notifications, only the read ones when readOnly
+// is set, and returns the unread count.
+func (s *InboxService) Clear(userID string, readOnly bool) (int, error) {
+	query := "DELETE FROM notifications WHERE user_id = $1"
+	if readOnly {
+		query += " AND read_at IS NOT NULL"
+	}
+	if _, err := s.db.Exec(query, userID); err != nil {
+		return 0, err
+	}
+	return s.changed(userID)
+}
+
+// changed pushes the unread count after notifications were read or removed.
+func (s *InboxService) changed(userID string) (int, error) {
+	unread, err := s.UnreadCount(userID)
+	if err != nil {
+		return 0, err
+	}
+	s.emit(userID, InboxEvent{Type: InboxEventUnread, Unread: unread})
+	return unread, nil
+}
@@ -0,0 +1,329 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	instrumentTTL      = time.Hour
+	instrumentRetryTTL = time.Minute
+)
+
+// ErrInstrumentNotFound is returned for symbols the exchange does not list.
+var ErrInstrumentNotFound = errors.New("instrument not found")
+
+// Instrument holds an exchange's trading rules for one market. Quantities
+// are in base units. MaxLeverage is 0 when the exchange does not publish it
+// without authentication.
+type Instrument struct {
+	Exchange       string  `json:"exchange"`
+	MarketType     string  `json:"marketType"`
+	Symbol         string  `json:"symbol"`
+	TickSize       float64 `json:"tickSize"`
+	LotSize        float64 `json:"lotSize"`
+	MinQty         float64 `json:"minQty"`
+	MinNotional    float64 `json:"minNotional"`
+	MaxLeverage    float64 `json:"maxLeverage,omitempty"`
+	PricePrecision int     `json:"pricePrecision"`
+	QtyPrecision   int     `json:"qtyPrecision"`
+}
+
+// InstrumentService fetches and caches exchange instrument metadata, one
+// full listing per exchange and market type. Listings are fetched outside
+// the lock, once at a time per listing, and a stale listing keeps being
+// served while it is refreshed.
+type InstrumentService struct {
+	httpClient *http.Client
+
+	mu   sync.Mutex
+	sets map[string]*instrumentSet
+}
+
+type instrumentSet struct {
+	items     map[string]Instrument
+	err       error
+	fetchedAt time.Time
+	// loading is closed when the fetch in flight completes; nil when idle.
+	loading chan struct{}
+}
+
+func NewInstrumentService() *InstrumentService {
+	return &InstrumentService{
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+		sets:       make(map[string]*instrumentSet),
+	}
+}
+
+// Get returns the instrument for an exchange ("binance", "bybit"), market
+// type ("spot", "perp") and symbol, waiting for the listing when it was
+// never fetched.
+func (s *InstrumentService) Get(exchange, marketType, symbol string) (Instrument, error) {
+	items, err := s.listing(exchange, marketType, true)
+	if err != nil {
+		return Instrument{}, err
+	}
+	inst, ok := items[strings.ToUpper(symbol)]
+	if !ok {
+		return Instrument{}, fmt.Errorf("%w: %s %s %s", ErrInstrumentNotFound, exchange, marketType, symbol)
+	}
+	return inst, nil
+}
+
+// Cached returns the instrument from the cached listing without waiting
+// for the exchange; a missing or expired listing is fetched in the
+// background.
+func (s *InstrumentService) Cached(exchange, marketType, symbol string) (Instrument, bool) {
+	items, err := s.listing(exchange, marketType, false)
+	if err != nil {
+		return Instrument{}, false
+	}
+	inst, ok := items[strings.ToUpper(symbol)]
+	return inst, ok
+}
+
+// listing returns the listing of an exchange and market type, starting a
+// refresh when it is missing or expired. A stale listing is returned at
+// once; without one, listing waits for the fetch only when wait is set.
+func (s *InstrumentService) listing(exchange, marketType string, wait bool) (map[string]Instrument, error) {
+	key := exchange + ":" + marketType
+	switch key {
+	case "binance:spot", "binance:perp", "bybit:spot", "bybit:perp":
+	default:
+		return nil, fmt.Errorf("unsupported market: %s", key)
+	}
+
+	s.mu.Lock()
+	set, ok := s.sets[key]
+	if !ok {
+		set = &instrumentSet{}
+		s.sets[key] = set
+	}
+	ttl := instrumentTTL
+	if set.err != nil {
+		ttl = instrumentRetryTTL
+	}
+	fetched := !set.fetchedAt.IsZero()
+	if fetched && time.Since(set.fetchedAt) < ttl {
+		items, err := set.items, set.err
+		s.mu.Unlock()
+		return items, err
+	}
+	if set.loading == nil {
+		set.loading = make(chan struct{})
+		go s.refresh(key, set, exchange, marketType)
+	}
+	if set.items != nil || (fetched && !wait) {
+		items, err := set.items, set.err
+		s.mu.Unlock()
+		return items, err
+	}
+	loading := set.loading
+	s.mu.Unlock()
+
+	if !wait {
+		return nil, fmt.Errorf("instruments for %s are loading", key)
+	}
+	<-loading
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return set.items, set.err
+}
+
+// refresh fetches a listing and stores it in set.
+func (s *InstrumentService) refresh(key string, set *instrumentSet, exchange, marketType string) {
+	var items map[string]Instrument
+	var err error
+	switch key {
+	case "binance:spot":
+		items, err = s.fetchBinance("https://api.binance.com/api/v3/exchangeInfo", exchange, marketType)
+	case "binance:perp":
+		items, err = s.fetchBinance("https://fapi.binance.com/fapi/v1/exchangeInfo", exchange, marketType)
+	case "bybit:spot":
+		items, err = s.fetchBybit("spot", exchange, marketType)
+	case "bybit:perp":
+		items, err = s.fetchBybit("linear", exchange, marketType)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil && set.items != nil {
+		// Keep serving the stale listing and retry in a minute.
+		set.err = nil
+		set.fetchedAt = time.Now().Add(instrumentRetryTTL - instrumentTTL)
+	} else {
+		set.items, set.err, set.fetchedAt = items, err, time.Now()
+	}
+	close(set.loading)
+	set.loading = nil
+}
+
+func (s *InstrumentService) fetchBinance(url, exchange, marketType string) (map[string]Instrument, error) {
+	resp, err := s.httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("binance exchangeInfo: status %d", resp.StatusCode)
+	}
+
+	var info struct {
+		Symbols []struct {
+			Symbol  string            `json:"symbol"`
+			Filters []json.RawMessage `json:"filters"`
+		} `json:"symbols"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+
+	items := make(map[string]Instrument, len(info.Symbols))
+	for _, sym := range info.Symbols {
+		inst := Instrument{Exchange: exchange, MarketType: marketType, Symbol: sym.Symbol}
+		for _, raw := range sym.Filters {
+			// Filter values are mostly strings; numeric fields are ignored.
+			var f map[string]interface{}
+			if json.Unmarshal(raw, &f) != nil {
+				continue
+			}
+			str := func(k string) float64 {
+				v, _ := f[k].(string)
+				n, _ := strconv.ParseFloat(v, 64)
+				return n
+			}
+			switch f["filterType"] {
+			case "PRICE_FILTER":
+				inst.TickSize = str("tickSize")
+			case "LOT_SIZE":
+				inst.LotSize = str("stepSize")
+				inst.MinQty = str("minQty")
+			case "NOTIONAL", "MIN_NOTIONAL":
+				if v := str("minNotional"); v > 0 {
+					inst.MinNotional = v
+				} else {
+					inst.MinNotional = str("notional")
+				}
+			}
+		}
+		items[sym.Symbol] = withPrecision(inst)
+	}
+	return items, nil
+}
+
+func (s *InstrumentService) fetchBybit(category, exchange, marketType string) (map[string]Instrument, error) {
+	items := make(map[string]Instrument)
+	cursor := ""
+	for page := 0; page < 20; page++ {
+		url := "https://api.bybit.com/v5/market/instruments-info?limit=1000&category=" + category
+		if cursor != "" {
+			url += "&cursor=" + cursor
+		}
+		resp, err := s.httpClient.Get(url)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			RetCode int    `json:"retCode"`
+			RetMsg  string `json:"retMsg"`
+			Result  struct {
+				List []struct {
+					Symbol      string `json:"symbol"`
+					PriceFilter struct {
+						TickSize string `json:"tickSize"`
+					} `json:"priceFilter"`
+					LotSizeFilter struct {
+						BasePrecision    string `json:"basePrecision"`
+						QtyStep          string `json:"qtyStep"`
+						MinOrderQty      string `json:"minOrderQty"`
+						MinOrderAmt      string `json:"minOrderAmt"`
+						MinNotionalValue string `json:"minNotionalValue"`
+					} `json:"lotSizeFilter"`
+					LeverageFilter struct {
+						MaxLeverage string `json:"maxLeverage"`
+					} `json:"leverageFilter"`
+				} `json:"list"`
+				NextPageCursor string `json:"nextPageCursor"`
+			} `json:"result"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if result.RetCode != 0 {
+			return nil, fmt.Errorf("bybit instruments-info: %s", result.RetMsg)
+		}
+
+		for _, it := range result.Result.List {
+			f := func(v string) float64 {
+				n, _ := strconv.ParseFloat(v, 64)
+				return n
+			}
+			lot := it.LotSizeFilter
+			inst := Instrument{
+				Exchange:    exchange,
+				MarketType:  marketType,
+				Symbol:      it.Symbol,
+				TickSize:    f(it.PriceFilter.TickSize),
+				LotSize:     f(lot.QtyStep),
+				MinQty:      f(lot.MinOrderQty),
+				MinNotional: f(lot.MinNotionalValue),
+				MaxLeverage: f(it.LeverageFilter.MaxLeverage),
+			}
+			if category == "spot" {
+				inst.LotSize = f(lot.BasePrecision)
+				inst.MinNotional = f(lot.MinOrderAmt)
+			}
+			items[it.Symbol] = withPrecision(inst)
+		}
+
+		cursor = result.Result.NextPageCursor
+		if cursor == "" {
+			break
+		}
+	}
+	return items, nil
+}
+
+func withPrecision(inst Instrument) Instrument {
+	inst.PricePrecision = stepDecimals(inst.TickSize)
+	inst.QtyPrecision = stepDecimals(inst.LotSize)
+	return inst
+}
+
+// stepDecimals returns the number of decimals in a step size, e.g. 2 for
+// 0.01 and 0 for 10.
+func stepDecimals(step float64) int {
+	s := strconv.FormatFloat(step, 'f', -1, 64)
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		return len(s) - i - 1
+	}
+	return 0
+}
+
+// FloorToStep rounds v down to a multiple of step, for order quantities.
+func FloorToStep(v, step float64) float64 {
+	if step <= 0 {
+		return v
+	}
+	// The epsilon absorbs float error such as 0.3/0.1 = 2.9999999999999996.
+	n := math.Floor(v/step + 1e-9)
+	return roundTo(n*step, stepDecimals(step))
+}
+
+// RoundToStep rounds v to the nearest multiple of step, for prices.
+func RoundToStep(v, step float64) float64 {
+	if step <= 0 {
+		return v
+	}
+	return roundTo(math.Round(v/step)*step, stepDecimals(step))
+}
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// Pivot point formulas.
+const (
+	PivotClassic   = "classic"
+	PivotWoodie    = "woodie"
+	PivotCamarilla = "camarilla"
+	PivotFibonacci = "fibonacci"
+)
+
+// PivotPoints are pivot levels computed from one completed bar of a higher
+// timeframe (BarTime is that bar's open time). R4/S4 are only produced by
+// Camarilla.
+type PivotPoints struct {
+	Method    string  `json:"method"`
+	Timeframe string  `json:"timeframe"`
+	BarTime   int64   `json:"barTime"`
+	Pivot     float64 `json:"pivot"`
+	R1        float64 `json:"r1"`
+	R2        float64 `json:"r2"`
+	R3        float64 `json:"r3"`
+	R4        float64 `json:"r4,omitempty"`
+	S1        float64 `json:"s1"`
+	S2        float64 `json:"s2"`
+	S3        float64 `json:"s3"`
+	S4        float64 `json:"s4,omitempty"`
+}
+
+// SRLevel is a horizontal support/resistance zone built from clustered
+// swing pivots. Price is the mean of the clustered pivots and Low/High the
+// zone bounds; Strength (0..1) grows with touches and recency.
+type SRLevel struct {
+	Price     float64 `json:"price"`
+	Low       float64 `json:"low"`
+	High      float64 `json:"high"`
+	Kind      string  `json:"kind"`
+	Touches   int     `json:"touches"`
+	Strength  float64 `json:"strength"`
+	FirstTime int64   `json:"firstTime"`
+	LastTime  int64   `json:"lastTime"`
+}
+
+// FibLevel is one Fibonacci ratio and its price.
+type FibLevel struct {
+	Ratio float64 `json:"ratio"`
+	Price float64 `json:"price"`
+}
+
+// FibonacciLevels are retracements and extensions of the dominant swing,
+// drawn From the swing start To its end. Direction is bullish for a swing
+// up (retracements measured down from the high) and bearish for a swing
+// down.
+type FibonacciLevels struct {
+	Direction    string     `json:"direction"`
+	From         ChartPoint `json:"from"`
+	To           ChartPoint `json:"to"`
+	Retracements []FibLevel `json:"retracements"`
+	Extensions   []FibLevel `json:"extensions"`
+}
+
+const (
+	SupportLevel    = "support"
+	ResistanceLevel = "resistance"
+)
+
+var (
+	fibRetracementRatios = []float64{0.236, 0.382, 0.5, 0.618, 0.786}
+	fibExtensionRatios   = []float64{1.272, 1.618, 2.0, 2.618}
+)
+
+// IsPivotMethod reports whether method is a supported pivot formula.
+func IsPivotMethod(method string) bool {
+	switch method {
+	case PivotClassic, PivotWoodie, PivotCamarilla, PivotFibonacci:
+		return true
+	default:
+		return false
+	}
+}
+
+// ComputePivotPoints applies a pivot formula to a completed bar.
+func ComputePivotPoints(method, timeframe string, bar Candle) (PivotPoints, error) {
+	h, l, c := bar.High, bar.Low, bar.Close
+	r := h - l
+	p := PivotPoints{Method: method, Timeframe: timeframe, BarTime: bar.Time}
+
+	switch method {
+	case PivotClassic:
+		p.Pivot = (h + l + c) / 3
+		p.R1, p.S1 = 2*p.Pivot-l, 2*p.Pivot-h
+		p.R2, p.S2 = p.Pivot+r, p.Pivot-r
+		p.R3, p.S3 = h+2*(p.Pivot-l), l-2*(h-p.Pivot)
+	case PivotWoodie:
+		p.Pivot = (h + l + 2*c) / 4
+		p.R1, p.S1 = 2*p.Pivot-l, 2*p.Pivot-h
+		p.R2, p.S2 = p.Pivot+r, p.Pivot-r
+		p.R3, p.S3 = h+2*(p.Pivot-l), l-2*(h-p.Pivot)
+	case PivotFibonacci:
+		p.Pivot = (h + l + c) / 3
+		p.R1, p.S1 = p.Pivot+0.382*r, p.Pivot-0.382*r
+		p.R2, p.S2 = p.Pivot+0.618*r, p.Pivot-0.618*r
+		p.R3, p.S3 = p.Pivot+r, p.Pivot-r
+	case PivotCamarilla:
+		p.Pivot = (h + l + c) / 3
+		p.R1, p.S1 = c+r*1.1/12, c-r*1.1/12
+		p.R2, p.S2 = c+r*1.1/6, c-r*1.1/6
+		p.R3, p.S3 = c+r*1.1/4, c-r*1.1/4
+		p.R4, p.S4 = c+r*1.1/2, c-r*1.1/2
+	default:
+		return PivotPoints{}, errors.New("unsupported pivot method")
+	}
+
+	return p, nil
+}
+
+// SwingClusterLevels groups swing highs and lows that lie within
+// tolerance×ATR(14) of each other into horizontal levels, returning the
+// strongest maxLevels ordered by price.
+func SwingClusterLevels(candles []Candle, swingWidth int, tolerance float64, maxLevels int) ([]SRLevel, error) {
+	if len(candles) < structureATRPeriod+1 {
+		return nil, errors.New("not enough candles")
+	}
+	closes, highs, lows := CandleSeries(candles)
+	atrSeries, err := ATR(highs, lows, closes, structureATRPeriod)
+	if err != nil {
+		return nil, err
+	}
+	atr := atrSeries[len(atrSeries)-1]
+	tol := tolerance * atr
+
+	swings := FindSwings(candles, swingWidth)
+	sort.Slice(swings, func(i, j int) bool { return swings[i].Price < swings[j].Price })
+
+	type cluster struct {
+		sum, low, high float64
+		members        []SwingPoint
+	}
+	clusters := make([]cluster, 0)
+	for _, s := range swings {
+		if n := len(clusters); n > 0 {
+			cl := &clusters[n-1]
+			if s.Price-cl.sum/float64(len(cl.members)) <= tol {
+				cl.sum += s.Price
+				cl.high = s.Price
+				cl.members = append(cl.members, s)
+				continue
+			}
+		}
+		clusters = append(clusters, cluster{sum: s.Price, low: s.Price, high: s.Price, members: []SwingPoint{s}})
+	}
+
+	last := len(candles) - 1
+	lastClose := closes[last]
+	levels := make([]SRLevel, 0, len(clusters))
+	for _, cl := range clusters {
+		lvl := SRLevel{
+			Price:     cl.sum / float64(len(cl.members)),
+			Low:       cl.low,
+			High:      cl.high,
+			Touches:   len(cl.members),
+			FirstTime: cl.members[0].Time,
+			LastTime:  cl.members[0].Time,
+		}
+		lastIdx := cl.members[0].Index
+		for _, m := range cl.members {
+			if m.Time < lvl.FirstTime {
+				lvl.FirstTime = m.Time
+			}
+			if m.Time > lvl.LastTime {
+				lvl.LastTime = m.Time
+				lastIdx = m.Index
+			}
+		}
+		lvl.Kind = SupportLevel
+		if lvl.Price > lastClose {
+			lvl.Kind = ResistanceLevel
+		}
+		recency := 1 - float64(last-lastIdx)/float64(last+1)
+		lvl.Strength = roundStrength(0.7*clamp01(float64(lvl.Touches-1)/4) + 0.3*recency)
+		levels = append(levels, lvl)
+	}
+
+	sort.SliceStable(levels, func(i, j int) bool { return levels[i].Strength > levels[j].Strength })
+	if maxLevels > 0 && len(levels) > maxLevels {
+		levels = levels[:maxLevels]
+	}
+	sort.Slice(levels, func(i, j int) bool { return levels[i].Price < levels[j].Price })
+	return levels, nil
+}
+
+// AutoFibonacci measures retracements and extensions of the dominant swing:
+// the move between the highest high and lowest low of the last lookback
+// candles, oriented by which extreme came first.
+func AutoFibonacci(candles []Candle, lookback int) (FibonacciLevels, error) {
+	if len(candles) < 2 {
+		return FibonacciLevels{}, errors.New("not enough candles")
+	}
+	start := 0
+	if lookback > 0 && lookback < len(candles) {
+		start = len(candles) - lookback
+	}
+
+	hi, lo := start, start
+	for i := start; i < len(candles); i++ {
+		if candles[i].High > candles[hi].High {
+			hi = i
+		}
+		if candles[i].Low < candles[lo].Low {
+			lo = i
+		}
+	}
+
+	high, low := candles[hi].High, candles[lo].Low
+	rng := high - low
+	if rng <= 0 || math.IsNaN(rng) {
+		return FibonacciLevels{}, errors.New("flat price range")
+	}
+
+	fib := FibonacciLevels{
+		Retracements: make([]FibLevel, 0, len(fibRetracementRatios)),
+		Extensions:   make([]FibLevel, 0, len(fibExtensionRatios)),
+	}
+	up := lo < hi
+	if up {
+		fib.Direction = DirectionBullish
+		fib.From = ChartPoint{Time: candles[lo].Time, Price: low}
+		fib.To = ChartPoint{Time: candles[hi].Time, Price: high}
+	} else {
+		fib.Direction = DirectionBearish
+		fib.From = ChartPoint{Time: candles[hi].Time, Price: high}
+		fib.To = ChartPoint{Time: candles[lo].Time, Price: low}
+	}
+
+	for _, r := range fibRetracementRatios {
+		price := low + r*rng
+		if up {
+			price = high - r*rng
+		}
+		fib.Retracements = append(fib.Retracements, FibLevel{Ratio: r, Price: price})
+	}
+	for _, e := range fibExtensionRatios {
+		price := high - e*rng
+		if up {
+			price = low + e*rng
+		}
+		fib.Extensions = append(fib.Extensions, FibLevel{Ratio: e, Price: price})
+	}
+
+	return fib, nil
+}
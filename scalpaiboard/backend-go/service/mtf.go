@@ -0,0 +1,260 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Indicators available to multi-timeframe analysis.
+const (
+	MTFTrend     = "trend"
+	MTFRSI       = "rsi"
+	MTFMACD      = "macd"
+	MTFBollinger = "bollinger"
+	MTFEMA200    = "ema200"
+)
+
+// MTFIndicators lists the supported indicators in display order.
+var MTFIndicators = []string{MTFTrend, MTFRSI, MTFMACD, MTFBollinger, MTFEMA200}
+
+// TimeframeIndicators is one row of the multi-timeframe matrix. Values holds
+// the raw indicator readings, States a label per indicator (e.g. "up",
+// "oversold") and Votes the +1/0/-1 direction each indicator contributes to
+// Bias, which is their mean.
+type TimeframeIndicators struct {
+	Interval string             `json:"interval"`
+	Close    float64            `json:"close"`
+	Values   map[string]float64 `json:"values"`
+	States   map[string]string  `json:"states"`
+	Votes    map[string]int     `json:"votes"`
+	Bias     float64            `json:"bias"`
+	Error    string             `json:"error,omitempty"`
+}
+
+// ConfluenceSignal groups the timeframes on which an indicator is in the
+// same non-neutral state.
+type ConfluenceSignal struct {
+	Indicator string   `json:"indicator"`
+	State     string   `json:"state"`
+	Direction string   `json:"direction"`
+	Intervals []string `json:"intervals"`
+}
+
+// MTFAnalysis is the timeframe × indicator matrix plus derived confluence.
+type MTFAnalysis struct {
+	Indicators  []string              `json:"indicators"`
+	Matrix      []TimeframeIndicators `json:"matrix"`
+	Signals     []ConfluenceSignal    `json:"signals"`
+	Summary     string                `json:"summary"`
+	OverallBias float64               `json:"overallBias"`
+	Aligned     bool                  `json:"aligned"`
+}
+
+// IsMTFIndicator reports whether name is a supported MTF indicator.
+func IsMTFIndicator(name string) bool {
+	for _, ind := range MTFIndicators {
+		if ind == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ComputeTimeframeIndicators evaluates indicators on one interval's candles.
+// Indicators lacking data are left out of the row rather than failing it.
+//
+// Vote rules:
+//
+//	trend     EMA21 vs EMA50, confirmed by close vs EMA50 ("up"/"down"/"mixed")
+//	rsi       RSI(14) < 30 "oversold" (+1), > 70 "overbought" (-1), else "neutral"
+//	macd      MACD(12,26,9) histogram sign ("bullish"/"bearish")
+//	bollinger %B of BB(20,2): < 0 "below_lower" (+1), > 1 "above_upper" (-1)
+//	ema200    close above/below EMA200 ("above"/"below")
+func ComputeTimeframeIndicators(interval string, candles []Candle, indicators []string) (TimeframeIndicators, error) {
+	if len(candles) < 2 {
+		return TimeframeIndicators{}, errors.New("not enough candles")
+	}
+	closes, _, _ := CandleSeries(candles)
+	last := closes[len(closes)-1]
+
+	row := TimeframeIndicators{
+		Interval: interval,
+		Close:    last,
+		Values:   make(map[string]float64),
+		States:   make(map[string]string),
+		Votes:    make(map[string]int),
+	}
+
+	for _, ind := range indicators {
+		switch ind {
+		case MTFTrend:
+			fast, err1 := EMAValue(closes, 21)
+			slow, err2 := EMAValue(closes, 50)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			row.Values["ema21"] = fast
+			row.Values["ema50"] = slow
+			switch {
+			case fast > slow && last > slow:
+				row.States[ind], row.Votes[ind] = "up", 1
+			case fast < slow && last < slow:
+				row.States[ind], row.Votes[ind] = "down", -1
+			default:
+				row.States[ind], row.Votes[ind] = "mixed", 0
+			}
+
+		case MTFRSI:
+			rsi, err := RSI(closes, 14)
+			if err != nil {
+				continue
+			}
+			row.Values["rsi"] = rsi
+			switch {
+			case rsi < 30:
+				row.States[ind], row.Votes[ind] = "oversold", 1
+			case rsi > 70:
+				row.States[ind], row.Votes[ind] = "overbought", -1
+			default:
+				row.States[ind], row.Votes[ind] = "neutral", 0
+			}
+
+		case MTFMACD:
+			macd, err := MACD(closes, 12, 26, 9)
+			if err != nil {
+				continue
+			}
+			row.Values["macdHistogram"] = macd.Histogram
+			switch {
+			case macd.Histogram > 0:
+				row.States[ind], row.Votes[ind] = "bullish", 1
+			case macd.Histogram < 0:
+				row.States[ind], row.Votes[ind] = "bearish", -1
+			default:
+				row.States[ind], row.Votes[ind] = "neutral", 0
+			}
+
+		case MTFBollinger:
+			bb, err := BollingerBands(closes, 20, 2.0)
+			if err != nil || bb.Upper == bb.Lower {
+				continue
+			}
+			pctB := (last - bb.Lower) / (bb.Upper - bb.Lower)
+			row.Values["percentB"] = pctB
+			switch {
+			case pctB < 0:
+				row.States[ind], row.Votes[ind] = "below_lower", 1
+			case pctB > 1:
+				row.States[ind], row.Votes[ind] = "above_upper", -1
+			default:
+				row.States[ind], row.Votes[ind] = "inside", 0
+			}
+
+		case MTFEMA200:
+			ema, err := EMAValue(closes, 200)
+			if err != nil {
+				continue
+			}
+			row.Values["ema200"] = ema
+			if last >= ema {
+				row.States[ind], row.Votes[ind] = "above", 1
+			} else {
+				row.States[ind], row.Votes[ind] = "below", -1
+			}
+		}
+	}
+
+	if len(row.Votes) > 0 {
+		sum := 0
+		for _, v := range row.Votes {
+			sum += v
+		}
+		row.Bias = math.Round(float64(sum)/float64(len(row.Votes))*100) / 100
+	}
+	return row, nil
+}
+
+// BuildMTFAnalysis derives confluence signals and an overall bias from the
+// per-timeframe rows. Rows should be ordered from the highest timeframe to
+// the lowest so intervals in signals read naturally ("4h/1h").
+func BuildMTFAnalysis(indicators []string, rows []TimeframeIndicators) MTFAnalysis {
+	out := MTFAnalysis{
+		Indicators: indicators,
+		Matrix:     rows,
+		Signals:    make([]ConfluenceSignal, 0),
+	}
+
+	for _, ind := range indicators {
+		byState := make(map[string]*ConfluenceSignal)
+		order := make([]string, 0)
+		for _, row := range rows {
+			vote, ok := row.Votes[ind]
+			if !ok || vote == 0 {
+				continue
+			}
+			state := row.States[ind]
+			sig, ok := byState[state]
+			if !ok {
+				direction := DirectionBullish
+				if vote < 0 {
+					direction = DirectionBearish
+				}
+				sig = &ConfluenceSignal{Indicator: ind, State: state, Direction: direction}
+				byState[state] = sig
+				order = append(order, state)
+			}
+			sig.Intervals = append(sig.Intervals, row.Interval)
+		}
+		for _, state := range order {
+			out.Signals = append(out.Signals, *byState[state])
+		}
+	}
+
+	// Signals spanning more timeframes first.
+	sort.SliceStable(out.Signals, func(i, j int) bool {
+		return len(out.Signals[i].Intervals) > len(out.Signals[j].Intervals)
+	})
+
+	parts := make([]string, 0, len(out.Signals))
+	for _, sig := range out.Signals {
+		parts = append(parts, describeSignal(sig))
+	}
+	out.Summary = strings.Join(parts, ", ")
+
+	counted := 0
+	sum := 0.0
+	allPos, allNeg := true, true
+	for _, row := range rows {
+		if row.Error != "" || len(row.Votes) == 0 {
+			continue
+		}
+		counted++
+		sum += row.Bias
+		if row.Bias <= 0 {
+			allPos = false
+		}
+		if row.Bias >= 0 {
+			allNeg = false
+		}
+	}
+	if counted > 0 {
+		out.OverallBias = math.Round(sum/float64(counted)*100) / 100
+		out.Aligned = allPos || allNeg
+	}
+
+	return out
+}
+
+func describeSignal(sig ConfluenceSignal) string {
+	name := sig.Indicator
+	switch sig.Indicator {
+	case MTFRSI, MTFMACD:
+		name = strings.ToUpper(sig.Indicator)
+	case MTFEMA200:
+		name = "price vs EMA200"
+	}
+	state := strings.ReplaceAll(sig.State, "_", " ")
+	return name + " " + state + " on " + strings.Join(sig.Intervals, "/")
+}
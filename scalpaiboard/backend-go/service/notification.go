@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -14,12 +16,15 @@ import (
 type NotificationService struct {
 	telegramToken string
 	httpClient    *http.Client
+	inbox         *InboxService
 }
 
-// NewNotificationService creates a new notification service
-func NewNotificationService() *NotificationService {
+// NewNotificationService creates a new notification service. In-app
+// notifications are stored in inbox.
+func NewNotificationService(inbox *InboxService) *NotificationService {
 	return &NotificationService{
 		telegramToken: os.Getenv("TELEGRAM_BOT_TOKEN"),
+		inbox:         inbox,
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
@@ -51,15 +56,106 @@ func (n *NotificationService) formatCondition(conditionType string, value float6
 		return fmt.Sprintf("Price above $%.2f", value)
 	case "price_below":
 		return fmt.Sprintf("Price below $%.2f", value)
+	case ConditionPriceCrossesAbove:
+		return fmt.Sprintf("Price crossed above $%.2f", value)
+	case ConditionPriceCrossesBelow:
+		return fmt.Sprintf("Price crossed below $%.2f", value)
 	case "volume_above":
 		return fmt.Sprintf("Volume above %.0f", value)
 	case "volume_below":
 		return fmt.Sprintf("Volume below %.0f", value)
+	case ConditionCandlePattern:
+		return fmt.Sprintf("Candlestick pattern (strength ≥ %.2f)", value)
+	case ConditionDivergence:
+		return fmt.Sprintf("Divergence (strength ≥ %.2f)", value)
+	case ConditionTechnicalRating:
+		return "Technical rating change"
+	case ConditionRegimeChange:
+		return "Market regime change"
+	case ConditionCustomFormula:
+		return fmt.Sprintf("Custom formula (%.2f)", value)
+	case ConditionIndicator:
+		return "Indicator condition"
+	case ConditionCompound:
+		return "Compound condition"
+	case ConditionPriceChange:
+		return fmt.Sprintf("Price move of %.2f within window", value)
+	case ConditionVolumeSpike:
+		return fmt.Sprintf("Volume spike (%.1f× average)", value)
+	case ConditionDrawingCross:
+		return fmt.Sprintf("Price crossed drawing at $%.2f", value)
+	case ConditionDrawingZone:
+		return fmt.Sprintf("Price crossed zone boundary at $%.2f", value)
+	case ConditionFundingAbove:
+		return fmt.Sprintf("Funding rate above %.4f%%", value)
+	case ConditionFundingBelow:
+		return fmt.Sprintf("Funding rate below %.4f%%", value)
+	case ConditionBasisAbove:
+		return fmt.Sprintf("Basis above %.2f%%", value)
+	case ConditionBasisBelow:
+		return fmt.Sprintf("Basis below %.2f%%", value)
 	default:
 		return fmt.Sprintf("%s: %.2f", conditionType, value)
 	}
 }
 
+// FormatScreenerMessage creates the notification of the markets that
+// entered and left a screener alert's result set in one evaluation.
+func (n *NotificationService) FormatScreenerMessage(name string, changes []screenerChange) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "🔎 *Screener Alert:* %s\n", name)
+	for i, c := range changes {
+		if i == maxScreenerMessageLines {
+			fmt.Fprintf(&b, "…and %d more\n", len(changes)-i)
+			break
+		}
+		verb := "entered"
+		if !c.matched {
+			verb = "left"
+		}
+		fmt.Fprintf(&b, "\n*%s* %s: %s", c.marketID, verb, formatScreenerValues(c.values))
+	}
+	fmt.Fprintf(&b, "\n\n*Time:* %s UTC\n\n_Scalpaiboard Alert System_", time.Now().UTC().Format("2006-01-02 15:04"))
+	return b.String()
+}
+
+// maxScreenerMessageLines bounds the markets listed in one screener
+// notification.
+const maxScreenerMessageLines = 20
+
+func formatScreenerValues(values map[string]float64) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, key+"="+strconv.FormatFloat(roundTo(values[key], 4), 'f', -1, 64))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Deliver sends a message to a user on a notification channel: telegram,
+// email or in_app. title is the email subject and the inbox title; kind and
+// data (references for the client, may be nil) are kept in the inbox.
+func (n *NotificationService) Deliver(userID, notificationType, kind, title, message string, data interface{}) error {
+	switch notificationType {
+	case "telegram":
+		// Get user's telegram chat ID (would need to be stored in user profile)
+		return n.SendTelegram(0, message) // TODO: Get real chat ID
+	case "email":
+		return n.SendEmail("", title, message) // TODO: Get real email
+	default:
+		if n.inbox == nil {
+			log.Printf("📱 In-app notification for %s: %s", userID, message)
+			return nil
+		}
+		_, err := n.inbox.Add(userID, kind, title, message, data)
+		return err
+	}
+}
+
 // SendTelegram sends a message via Telegram bot
 func (n *NotificationService) SendTelegram(chatID int64, message string) error {
 	if n.telegramToken == "" {
@@ -0,0 +1,324 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/scalpaiboard/backend/models"
+)
+
+const (
+	paperMatchInterval = time.Second
+	paperPriceWorkers  = 10
+	paperFundingPeriod = 8 * time.Hour
+)
+
+// Start runs the matching loop: once a second it matches the resting orders
+// of every market with open orders or positions at its latest price,
+// liquidates accounts below maintenance margin and settles funding on perps
+// at 00:00, 08:00 and 16:00 UTC. Prices come from the market data stream
+// (see OnPrice); markets it has not updated within the interval are fetched.
+func (s *PaperTradingService) Start() {
+	go func() {
+		ticker := time.NewTicker(paperMatchInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			s.tick(now)
+		}
+	}()
+	log.Println("📈 Paper trading matching engine started")
+}
+
+func (s *PaperTradingService) tick(now time.Time) {
+	markets, err := s.activeMarkets()
+	if err != nil {
+		log.Printf("⚠️ Paper trading: failed to load markets: %v", err)
+		return
+	}
+	if len(markets) == 0 {
+		return
+	}
+	fresh := s.refreshPrices(markets, now)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for marketID, price := range fresh {
+		s.matchMarket(marketID, price)
+	}
+	s.liquidate()
+	if period := fundingPeriodStart(now); period.After(s.lastFunding) {
+		if !period.Equal(s.fundingPeriod) {
+			s.fundingPeriod, s.funded = period, make(map[int]bool)
+		}
+		// Positions without a price are settled on a later tick.
+		if s.settleFunding(fresh) {
+			s.lastFunding = period
+		}
+	}
+}
+
+// OnPrice records a price from the market data stream.
+func (s *PaperTradingService) OnPrice(marketID string, price float64) {
+	if price > 0 {
+		s.setPrice(marketID, price)
+	}
+}
+
+func (s *PaperTradingService) activeMarkets() ([]string, error) {
+	rows, err := s.db.Query(`
+		SELECT market_id FROM paper_orders WHERE status = 'open'
+		UNION
+		SELECT market_id FROM paper_positions
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	markets := make([]string, 0)
+	for rows.Next() {
+		var m string
+		if err := rows.Scan(&m); err == nil {
+			markets = append(markets, m)
+		}
+	}
+	return markets, rows.Err()
+}
+
+// refreshPrices returns each market's last price: the streamed one when it
+// arrived within the match interval, else one fetched in parallel. Markets
+// whose price cannot be fetched are left out of this tick.
+func (s *PaperTradingService) refreshPrices(markets []string, now time.Time) map[string]float64 {
+	sem := make(chan struct{}, paperPriceWorkers)
+	mu := sync.Mutex{}
+	out := make(map[string]float64, len(markets))
+
+	wg := sync.WaitGroup{}
+	for _, marketID := range markets {
+		marketID := marketID
+		s.pricesMu.RLock()
+		q, ok := s.prices[marketID]
+		s.pricesMu.RUnlock()
+		if ok && now.Sub(q.at) < paperMatchInterval {
+			out[marketID] = q.price
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			price, err := s.price(marketID)
+			if err != nil || price <= 0 {
+				return
+			}
+			s.setPrice(marketID, price)
+			mu.Lock()
+			out[marketID] = price
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return out
+}
+
+// matchMarket steps every open order of a market, oldest first.
+func (s *PaperTradingService) matchMarket(marketID string, price float64) {
+	rows, err := s.db.Query(`
+		SELECT `+paperOrderColumns+`
+		FROM paper_orders
+		WHERE market_id = $1 AND status = 'open'
+		ORDER BY created_at, id
+	`, marketID)
+	if err != nil {
+		log.Printf("⚠️ Paper trading: failed to load orders for %s: %v", marketID, err)
+		return
+	}
+	orders := make([]*models.PaperOrder, 0)
+	for rows.Next() {
+		if o, err := scanPaperOrder(rows); err == nil {
+			orders = append(orders, o)
+		}
+	}
+	rows.Close()
+
+	cancelled := make(map[int]bool)
+	for _, o := range orders {
+		if cancelled[o.ID] {
+			continue
+		}
+		events, err := s.step(o, price, true)
+		if err != nil {
+			log.Printf("⚠️ Paper trading: order %d: %v", o.ID, err)
+			continue
+		}
+		// A fill may cancel later orders in this batch (OCO legs,
+		// reduce-only orders on a now flat position).
+		for _, e := range events {
+			if c, ok := e.Data.(models.PaperOrder); ok && c.Status == OrderStatusCancelled {
+				cancelled[c.ID] = true
+			}
+		}
+		s.publish(events...)
+	}
+}
+
+// liquidate closes every position of an account whose equity has fallen
+// to its maintenance margin, at the current price with taker fees, after
+// cancelling its open orders.
+func (s *PaperTradingService) liquidate() {
+	rows, err := s.db.Query(`SELECT ` + paperAccountColumns + ` FROM paper_accounts WHERE id IN (SELECT account_id FROM paper_positions)`)
+	if err != nil {
+		log.Printf("⚠️ Paper trading: failed to load accounts: %v", err)
+		return
+	}
+	accounts := make([]*models.PaperAccount, 0)
+	for rows.Next() {
+		if a, err := scanPaperAccount(rows); err == nil {
+			accounts = append(accounts, a)
+		}
+	}
+	rows.Close()
+
+	for _, acct := range accounts {
+		positions, err := s.positions(s.db, acct.ID)
+		if err != nil || len(positions) == 0 {
+			continue
+		}
+		summary := s.markAccount(acct, positions)
+		maintenance := 0.0
+		for _, p := range summary.Positions {
+			maintenance += math.Abs(p.Quantity) * p.MarkPrice * paperMaintenanceMarginPct / 100
+		}
+		if summary.Equity > maintenance {
+			continue
+		}
+
+		log.Printf("💥 Paper account %d liquidated (equity %.2f, maintenance %.2f)", acct.ID, summary.Equity, maintenance)
+		events := []PaperEvent{{Type: PaperEventLiquidation, AccountID: acct.ID, Data: summary, userID: acct.UserID}}
+		cancelled, err := cancelPaperOrders(s.db, `account_id = $1 AND status = 'open'`, acct.ID)
+		if err != nil {
+			log.Printf("⚠️ Paper trading: liquidation of account %d: %v", acct.ID, err)
+			continue
+		}
+		for _, c := range cancelled {
+			events = append(events, PaperEvent{Type: PaperEventOrder, AccountID: acct.ID, Data: c, userID: acct.UserID})
+		}
+
+		for _, p := range summary.Positions {
+			side := OrderSideSell
+			if p.Quantity < 0 {
+				side = OrderSideBuy
+			}
+			order, err := scanPaperOrder(s.db.QueryRow(`
+				INSERT INTO paper_orders (account_id, market_id, side, order_type, quantity, leverage, reduce_only)
+				VALUES ($1, $2, $3, 'market', $4, $5, TRUE)
+				RETURNING `+paperOrderColumns, acct.ID, p.MarketID, side, math.Abs(p.Quantity), p.Leverage))
+			if err != nil {
+				log.Printf("⚠️ Paper trading: liquidation of account %d: %v", acct.ID, err)
+				continue
+			}
+			fills, err := s.execute(order, p.MarkPrice, LiquidityTaker)
+			if err != nil {
+				log.Printf("⚠️ Paper trading: liquidation of account %d: %v", acct.ID, err)
+				continue
+			}
+			events = append(events, fills...)
+		}
+		s.publish(events...)
+	}
+}
+
+// settleFunding charges each perp position quantity × price × rate: longs
+// pay and shorts receive when the account's rate is positive. Positions
+// settled in this period are recorded in s.funded, so a retry charges only
+// the rest. It reports whether every position was settled.
+func (s *PaperTradingService) settleFunding(prices map[string]float64) bool {
+	rows, err := s.db.Query(`
+		SELECT p.id, p.account_id, p.market_id, p.quantity, a.funding_rate_pct, a.user_id
+		FROM paper_positions p
+		JOIN paper_accounts a ON a.id = p.account_id
+	`)
+	if err != nil {
+		log.Printf("⚠️ Paper trading: failed to load positions for funding: %v", err)
+		return false
+	}
+	type charge struct {
+		positionID, accountID int
+		marketID, userID      string
+		amount                float64
+	}
+	charges := make([]charge, 0)
+	complete := true
+	for rows.Next() {
+		var c charge
+		var qty, rate float64
+		if err := rows.Scan(&c.positionID, &c.accountID, &c.marketID, &qty, &rate, &c.userID); err != nil {
+			complete = false
+			continue
+		}
+		if !isPerpMarket(c.marketID) || rate == 0 || s.funded[c.positionID] {
+			continue
+		}
+		price, ok := prices[c.marketID]
+		if !ok {
+			complete = false
+			continue
+		}
+		c.amount = qty * price * rate / 100
+		charges = append(charges, c)
+	}
+	if rows.Err() != nil {
+		complete = false
+	}
+	rows.Close()
+
+	for _, c := range charges {
+		tx, err := s.db.Begin()
+		if err != nil {
+			complete = false
+			continue
+		}
+		pos, err := scanPaperPosition(tx.QueryRow(`
+			UPDATE paper_positions SET funding_paid = funding_paid + $2, updated_at = NOW()
+			WHERE id = $1
+			RETURNING `+paperPositionColumns, c.positionID, c.amount))
+		if errors.Is(err, sql.ErrNoRows) {
+			// Closed since it was read.
+			tx.Rollback()
+			continue
+		}
+		if err != nil {
+			tx.Rollback()
+			complete = false
+			continue
+		}
+		acct, err := scanPaperAccount(tx.QueryRow(`
+			UPDATE paper_accounts SET balance = balance - $2, updated_at = NOW()
+			WHERE id = $1
+			RETURNING `+paperAccountColumns, c.accountID, c.amount))
+		if err != nil || tx.Commit() != nil {
+			tx.Rollback()
+			complete = false
+			continue
+		}
+		s.funded[c.positionID] = true
+		s.publish(
+			PaperEvent{Type: PaperEventFunding, AccountID: c.accountID, Data: pos, userID: c.userID},
+			PaperEvent{Type: PaperEventAccount, AccountID: c.accountID, Data: acct, userID: c.userID},
+		)
+	}
+	return complete
+}
+
+// fundingPeriodStart returns the start of the 8-hour UTC funding period
+// containing t.
+func fundingPeriodStart(t time.Time) time.Time {
+	return t.UTC().Truncate(paperFundingPeriod)
+}
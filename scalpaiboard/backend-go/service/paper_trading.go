@@ -0,0 +1,1078 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scalpaiboard/backend/models"
+)
+
+// Paper order types, sides and statuses.
+const (
+	OrderTypeMarket    = "market"
+	OrderTypeLimit     = "limit"
+	OrderTypeStop      = "stop"
+	OrderTypeStopLimit = "stop_limit"
+
+	OrderSideBuy  = "buy"
+	OrderSideSell = "sell"
+
+	OrderStatusOpen      = "open"
+	OrderStatusFilled    = "filled"
+	OrderStatusCancelled = "cancelled"
+	OrderStatusRejected  = "rejected"
+
+	LiquidityMaker = "maker"
+	LiquidityTaker = "taker"
+)
+
+// Paper event types pushed to the account owner.
+const (
+	PaperEventOrder       = "order"
+	PaperEventFill        = "fill"
+	PaperEventPosition    = "position"
+	PaperEventAccount     = "account"
+	PaperEventFunding     = "funding"
+	PaperEventLiquidation = "liquidation"
+)
+
+const (
+	maxPaperAccountsPerUser   = 5
+	maxPaperOpenOrders        = 200
+	paperMaxLeverage          = 125
+	paperMaxBalance           = 1e9
+	paperMaintenanceMarginPct = 0.5
+	paperQtyEpsilon           = 1e-12
+	paperPriceMaxAge          = 5 * time.Second
+)
+
+var (
+	ErrPaperAccountNotFound = errors.New("paper account not found")
+	ErrPaperOrderNotFound   = errors.New("paper order not found")
+	// ErrInvalidPaperOrder covers malformed orders and accounts.
+	ErrInvalidPaperOrder  = errors.New("invalid paper order")
+	ErrInsufficientMargin = errors.New("insufficient margin")
+	ErrPriceUnavailable   = errors.New("price unavailable")
+)
+
+// PriceFunc returns the last traded price of a marketId.
+type PriceFunc func(marketID string) (float64, error)
+
+// PaperEvent is a change on a paper account, delivered to its owner.
+type PaperEvent struct {
+	Type      string      `json:"event"`
+	AccountID int         `json:"accountId"`
+	Data      interface{} `json:"data"`
+
+	userID string
+}
+
+// PaperAccountRequest creates a paper account. Fees and funding are in
+// percent; funding is charged to longs (paid to shorts) every 8 hours.
+type PaperAccountRequest struct {
+	Name           string   `json:"name"`
+	Balance        float64  `json:"balance"`
+	MakerFeePct    *float64 `json:"makerFeePct"`
+	TakerFeePct    *float64 `json:"takerFeePct"`
+	FundingRatePct *float64 `json:"fundingRatePct"`
+}
+
+// PaperOrderRequest places an order. Price is the limit price (limit and
+// stop_limit), StopPrice the trigger (stop and stop_limit). TakeProfit and
+// StopLoss attach a reduce-only OCO bracket once the order fills.
+type PaperOrderRequest struct {
+	MarketID   string   `json:"marketId"`
+	Side       string   `json:"side"`
+	Type       string   `json:"type"`
+	Quantity   float64  `json:"quantity"`
+	Price      *float64 `json:"price"`
+	StopPrice  *float64 `json:"stopPrice"`
+	Leverage   float64  `json:"leverage"`
+	ReduceOnly bool     `json:"reduceOnly"`
+	TakeProfit *float64 `json:"takeProfit"`
+	StopLoss   *float64 `json:"stopLoss"`
+}
+
+// PaperAccountSummary is an account marked to market. OrderMargin is held
+// for open orders that may add to a position.
+type PaperAccountSummary struct {
+	models.PaperAccount
+	Equity          float64                `json:"equity"`
+	UnrealizedPnL   float64                `json:"unrealizedPnl"`
+	UsedMargin      float64                `json:"usedMargin"`
+	OrderMargin     float64                `json:"orderMargin"`
+	AvailableMargin float64                `json:"availableMargin"`
+	Positions       []models.PaperPosition `json:"positions"`
+}
+
+// PaperTradingService manages paper accounts and matches their orders
+// against live prices. All order and position changes are serialised by mu.
+type PaperTradingService struct {
+	db    *sql.DB
+	price PriceFunc
+
+	mu          sync.Mutex
+	lastFunding time.Time
+	// funded holds the positions settled in fundingPeriod while its
+	// settlement is still incomplete.
+	fundingPeriod time.Time
+	funded        map[int]bool
+
+	pricesMu sync.RWMutex
+	prices   map[string]paperQuote
+
+	sink func(userID string, event PaperEvent)
+}
+
+type paperQuote struct {
+	price float64
+	at    time.Time
+}
+
+func NewPaperTradingService(db *sql.DB, price PriceFunc) *PaperTradingService {
+	return &PaperTradingService{
+		db:          db,
+		price:       price,
+		prices:      make(map[string]paperQuote),
+		lastFunding: fundingPeriodStart(time.Now()),
+	}
+}
+
+// OnEvent registers the receiver of account events (e.g. the WebSocket hub).
+func (s *PaperTradingService) OnEvent(fn func(userID string, event PaperEvent)) {
+	s.sink = fn
+}
+
+// ---- accounts ----
+
+const paperAccountColumns = `id, user_id, name, initial_balance, balance, maker_fee_pct, taker_fee_pct,
+	funding_rate_pct, created_at, updated_at`
+
+func scanPaperAccount(row rowScanner) (*models.PaperAccount, error) {
+	var a models.PaperAccount
+	err := row.Scan(&a.ID, &a.UserID, &a.Name, &a.InitialBalance, &a.Balance, &a.MakerFeePct,
+		&a.TakerFeePct, &a.FundingRatePct, &a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// ListAccounts returns the user's paper accounts
+func (s *PaperTradingService) ListAccounts(userID string) ([]models.PaperAccount, error) {
+	rows, err := s.db.Query(`SELECT `+paperAccountColumns+` FROM paper_accounts WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]models.PaperAccount, 0)
+	for rows.Next() {
+		a, err := scanPaperAccount(rows)
+		if err != nil {
+			continue
+		}
+		items = append(items, *a)
+	}
+	return items, nil
+}
+
+// CreateAccount opens a paper account with the given starting balance
+func (s *PaperTradingService) CreateAccount(userID string, req PaperAccountRequest) (*models.PaperAccount, error) {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 50 {
+		return nil, fmt.Errorf("%w: name must be 1-50 characters", ErrInvalidPaperOrder)
+	}
+	if req.Balance <= 0 || req.Balance > paperMaxBalance {
+		return nil, fmt.Errorf("%w: balance must be between 0 and %.0f", ErrInvalidPaperOrder, paperMaxBalance)
+	}
+	maker, taker, funding := 0.02, 0.05, 0.01
+	if req.MakerFeePct != nil {
+		maker = *req.MakerFeePct
+	}
+	if req.TakerFeePct != nil {
+		taker = *req.TakerFeePct
+	}
+	if req.FundingRatePct != nil {
+		funding = *req.FundingRatePct
+	}
+	if maker < 0 || maker > 1 || taker < 0 || taker > 1 || math.Abs(funding) > 1 {
+		return nil, fmt.Errorf("%w: fees must be between 0 and 1%% and funding within ±1%%", ErrInvalidPaperOrder)
+	}
+
+	var count int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM paper_accounts WHERE user_id = $1`, userID).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count >= maxPaperAccountsPerUser {
+		return nil, fmt.Errorf("%w: limit of %d accounts reached", ErrInvalidPaperOrder, maxPaperAccountsPerUser)
+	}
+
+	row := s.db.QueryRow(`
+		INSERT INTO paper_accounts (user_id, name, initial_balance, balance, maker_fee_pct, taker_fee_pct, funding_rate_pct)
+		VALUES ($1, $2, $3, $3, $4, $5, $6)
+		RETURNING `+paperAccountColumns,
+		userID, req.Name, req.Balance, maker, taker, funding)
+	a, err := scanPaperAccount(row)
+	if err != nil && strings.Contains(err.Error(), "duplicate key") {
+		return nil, fmt.Errorf("%w: an account named %q already exists", ErrInvalidPaperOrder, req.Name)
+	}
+	return a, err
+}
+
+// GetAccount returns an account with its positions marked to market
+func (s *PaperTradingService) GetAccount(userID string, accountID int) (*PaperAccountSummary, error) {
+	acct, err := s.account(s.db, userID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	positions, err := s.positions(s.db, accountID)
+	if err != nil {
+		return nil, err
+	}
+	summary := s.markAccount(acct, positions)
+	if err := s.reserveOrders(s.db, &summary, 0); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// ResetAccount restores the initial balance and removes all positions,
+// orders and fills.
+func (s *PaperTradingService) ResetAccount(userID string, accountID int) (*models.PaperAccount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := s.account(tx, userID, accountID); err != nil {
+		return nil, err
+	}
+	for _, table := range []string{"paper_fills", "paper_positions", "paper_orders"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE account_id = $1`, accountID); err != nil {
+			return nil, err
+		}
+	}
+	acct, err := scanPaperAccount(tx.QueryRow(`
+		UPDATE paper_accounts SET balance = initial_balance, updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+paperAccountColumns, accountID))
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	s.publish(PaperEvent{Type: PaperEventAccount, AccountID: acct.ID, Data: acct, userID: userID})
+	return acct, nil
+}
+
+// DeleteAccount deletes a paper account and everything on it
+func (s *PaperTradingService) DeleteAccount(userID string, accountID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res, err := s.db.Exec(`DELETE FROM paper_accounts WHERE id = $1 AND user_id = $2`, accountID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrPaperAccountNotFound
+	}
+	return nil
+}
+
+// ---- orders ----
+
+const paperOrderColumns = `id, account_id, market_id, side, order_type, quantity, price, stop_price, leverage,
+	reduce_only, take_profit, stop_loss, parent_order_id, status, triggered, filled_price,
+	COALESCE(reject_reason, ''), created_at, updated_at`
+
+func scanPaperOrder(row rowScanner) (*models.PaperOrder, error) {
+	var o models.PaperOrder
+	var price, stop, tp, sl, filled sql.NullFloat64
+	var parent sql.NullInt64
+	err := row.Scan(&o.ID, &o.AccountID, &o.MarketID, &o.Side, &o.Type, &o.Quantity, &price, &stop, &o.Leverage,
+		&o.ReduceOnly, &tp, &sl, &parent, &o.Status, &o.Triggered, &filled,
+		&o.RejectReason, &o.CreatedAt, &o.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	o.Price, o.StopPrice, o.TakeProfit, o.StopLoss, o.FilledPrice =
+		nullFloatPtr(price), nullFloatPtr(stop), nullFloatPtr(tp), nullFloatPtr(sl), nullFloatPtr(filled)
+	if parent.Valid {
+		id := int(parent.Int64)
+		o.ParentOrderID = &id
+	}
+	return &o, nil
+}
+
+// ListOrders returns an account's orders, newest first. status may be
+// empty for all orders.
+func (s *PaperTradingService) ListOrders(userID string, accountID int, status string, limit int) ([]models.PaperOrder, error) {
+	if _, err := s.account(s.db, userID, accountID); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`
+		SELECT `+paperOrderColumns+`
+		FROM paper_orders
+		WHERE account_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3`, accountID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]models.PaperOrder, 0)
+	for rows.Next() {
+		o, err := scanPaperOrder(rows)
+		if err != nil {
+			continue
+		}
+		items = append(items, *o)
+	}
+	return items, nil
+}
+
+// ListFills returns an account's executions, newest first
+func (s *PaperTradingService) ListFills(userID string, accountID int, limit int) ([]models.PaperFill, error) {
+	if _, err := s.account(s.db, userID, accountID); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`
+		SELECT id, account_id, order_id, market_id, side, quantity, price, fee, realized_pnl, liquidity, created_at
+		FROM paper_fills
+		WHERE account_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2`, accountID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]models.PaperFill, 0)
+	for rows.Next() {
+		var f models.PaperFill
+		var orderID sql.NullInt64
+		if err := rows.Scan(&f.ID, &f.AccountID, &orderID, &f.MarketID, &f.Side, &f.Quantity, &f.Price,
+			&f.Fee, &f.RealizedPnL, &f.Liquidity, &f.CreatedAt); err != nil {
+			continue
+		}
+		if orderID.Valid {
+			id := int(orderID.Int64)
+			f.OrderID = &id
+		}
+		items = append(items, f)
+	}
+	return items, nil
+}
+
+// PlaceOrder validates and stores an order, then matches it against the
+// current price: market orders and marketable limits fill immediately as
+// taker. The returned order reflects its state after matching.
+func (s *PaperTradingService) PlaceOrder(userID string, accountID int, req PaperOrderRequest) (*models.PaperOrder, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+	last, err := s.markPrice(req.MarketID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acct, err := s.account(s.db, userID, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	var open int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM paper_orders WHERE account_id = $1 AND status = 'open'`, accountID).Scan(&open); err != nil {
+		return nil, err
+	}
+	if open >= maxPaperOpenOrders {
+		return nil, fmt.Errorf("%w: limit of %d open orders reached", ErrInvalidPaperOrder, maxPaperOpenOrders)
+	}
+
+	ref := req.referencePrice(last)
+	if err := req.validateBracket(ref); err != nil {
+		return nil, err
+	}
+
+	if !req.ReduceOnly {
+		positions, err := s.positions(s.db, accountID)
+		if err != nil {
+			return nil, err
+		}
+		summary := s.markAccount(acct, positions)
+		if err := s.reserveOrders(s.db, &summary, 0); err != nil {
+			return nil, err
+		}
+		need := req.Quantity*ref/req.Leverage + req.Quantity*ref*acct.TakerFeePct/100
+		if opposing := positionQuantity(positions, req.MarketID); opposing != 0 && (opposing > 0) != (req.Side == OrderSideBuy) {
+			// Closing part of the order frees margin rather than using it.
+			need *= math.Max(0, req.Quantity-math.Abs(opposing)) / req.Quantity
+		}
+		if need > summary.AvailableMargin {
+			return nil, fmt.Errorf("%w: order needs %.2f, available %.2f", ErrInsufficientMargin, need, summary.AvailableMargin)
+		}
+	}
+
+	order, err := scanPaperOrder(s.db.QueryRow(`
+		INSERT INTO paper_orders (account_id, market_id, side, order_type, quantity, price, stop_price, leverage,
+			reduce_only, take_profit, stop_loss)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING `+paperOrderColumns,
+		accountID, req.MarketID, req.Side, req.Type, req.Quantity, req.Price, req.StopPrice, req.Leverage,
+		req.ReduceOnly, req.TakeProfit, req.StopLoss))
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := s.step(order, last, false)
+	if err != nil {
+		return nil, err
+	}
+	s.publish(events...)
+	return order, nil
+}
+
+// CancelOrder cancels an open order
+func (s *PaperTradingService) CancelOrder(userID string, accountID, orderID int) (*models.PaperOrder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.account(s.db, userID, accountID); err != nil {
+		return nil, err
+	}
+	order, err := scanPaperOrder(s.db.QueryRow(`
+		UPDATE paper_orders SET status = 'cancelled', updated_at = NOW()
+		WHERE id = $1 AND account_id = $2 AND status = 'open'
+		RETURNING `+paperOrderColumns, orderID, accountID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPaperOrderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.publish(PaperEvent{Type: PaperEventOrder, AccountID: accountID, Data: order, userID: userID})
+	return order, nil
+}
+
+func (r *PaperOrderRequest) validate() error {
+	r.MarketID = strings.ToUpper(strings.TrimSpace(r.MarketID))
+	r.Side = strings.ToLower(r.Side)
+	r.Type = strings.ToLower(r.Type)
+	if r.Type == "" {
+		r.Type = OrderTypeMarket
+	}
+
+	if r.Side != OrderSideBuy && r.Side != OrderSideSell {
+		return fmt.Errorf("%w: side must be buy or sell", ErrInvalidPaperOrder)
+	}
+	if !(r.Quantity >= paperQtyEpsilon) || math.IsInf(r.Quantity, 0) {
+		// Quantities are stored with 12 decimals; anything smaller rounds to zero.
+		return fmt.Errorf("%w: quantity must be at least 1e-12", ErrInvalidPaperOrder)
+	}
+	positive := func(v *float64) bool { return v != nil && *v > 0 }
+	switch r.Type {
+	case OrderTypeMarket:
+		r.Price, r.StopPrice = nil, nil
+	case OrderTypeLimit:
+		if !positive(r.Price) {
+			return fmt.Errorf("%w: limit orders need a price", ErrInvalidPaperOrder)
+		}
+		r.StopPrice = nil
+	case OrderTypeStop:
+		if !positive(r.StopPrice) {
+			return fmt.Errorf("%w: stop orders need a stopPrice", ErrInvalidPaperOrder)
+		}
+		r.Price = nil
+	case OrderTypeStopLimit:
+		if !positive(r.Price) || !positive(r.StopPrice) {
+			return fmt.Errorf("%w: stop_limit orders need a price and a stopPrice", ErrInvalidPaperOrder)
+		}
+	default:
+		return fmt.Errorf("%w: unknown order type %q", ErrInvalidPaperOrder, r.Type)
+	}
+
+	if r.Leverage == 0 {
+		r.Leverage = 1
+	}
+	perp := isPerpMarket(r.MarketID)
+	if r.Leverage < 1 || r.Leverage > paperMaxLeverage || (!perp && r.Leverage != 1) {
+		return fmt.Errorf("%w: leverage must be 1 for spot and 1-%d for perps", ErrInvalidPaperOrder, paperMaxLeverage)
+	}
+	if !perp && (r.ReduceOnly || r.TakeProfit != nil || r.StopLoss != nil) {
+		return fmt.Errorf("%w: reduce-only and brackets are only available on perps", ErrInvalidPaperOrder)
+	}
+	if r.ReduceOnly && (r.TakeProfit != nil || r.StopLoss != nil) {
+		return fmt.Errorf("%w: reduce-only orders cannot carry a bracket", ErrInvalidPaperOrder)
+	}
+	if (r.TakeProfit != nil && !positive(r.TakeProfit)) || (r.StopLoss != nil && !positive(r.StopLoss)) {
+		return fmt.Errorf("%w: takeProfit and stopLoss must be positive", ErrInvalidPaperOrder)
+	}
+	return nil
+}
+
+// referencePrice is the expected fill price used for margin and bracket
+// checks: the limit price, else the stop price, else the last price.
+func (r *PaperOrderRequest) referencePrice(last float64) float64 {
+	switch {
+	case r.Price != nil:
+		return *r.Price
+	case r.StopPrice != nil:
+		return *r.StopPrice
+	default:
+		return last
+	}
+}
+
+func (r *PaperOrderRequest) validateBracket(ref float64) error {
+	dir := 1.0
+	if r.Side == OrderSideSell {
+		dir = -1
+	}
+	if r.TakeProfit != nil && dir*(*r.TakeProfit-ref) <= 0 {
+		return fmt.Errorf("%w: takeProfit must be on the profit side of %.8g", ErrInvalidPaperOrder, ref)
+	}
+	if r.StopLoss != nil && dir*(*r.StopLoss-ref) >= 0 {
+		return fmt.Errorf("%w: stopLoss must be on the loss side of %.8g", ErrInvalidPaperOrder, ref)
+	}
+	return nil
+}
+
+// ---- matching ----
+
+// paperMatch is what an open order does at a price.
+type paperMatch struct {
+	trigger   bool
+	fill      bool
+	price     float64
+	liquidity string
+}
+
+// matchPaperOrder decides whether an open order triggers or fills at
+// price. Resting limit orders fill at their limit as maker; orders that are
+// marketable when placed or triggered fill at price as taker. Stops trigger
+// when price trades through the stop; stop orders then fill at price and
+// stop-limits become resting limits.
+func matchPaperOrder(o *models.PaperOrder, price float64, resting bool) paperMatch {
+	buy := o.Side == OrderSideBuy
+	var m paperMatch
+
+	switch o.Type {
+	case OrderTypeMarket:
+		return paperMatch{fill: true, price: price, liquidity: LiquidityTaker}
+	case OrderTypeStop, OrderTypeStopLimit:
+		if !o.Triggered {
+			stop := *o.StopPrice
+			if (buy && price < stop) || (!buy && price > stop) {
+				return m
+			}
+			m.trigger = true
+			if o.Type == OrderTypeStop {
+				m.fill, m.price, m.liquidity = true, price, LiquidityTaker
+				return m
+			}
+			resting = false
+		}
+	}
+
+	limit := *o.Price
+	if (buy && price <= limit) || (!buy && price >= limit) {
+		m.fill = true
+		if resting {
+			m.price, m.liquidity = limit, LiquidityMaker
+		} else {
+			m.price, m.liquidity = price, LiquidityTaker
+		}
+	}
+	return m
+}
+
+// step matches one open order at price, updating it in place.
+func (s *PaperTradingService) step(o *models.PaperOrder, price float64, resting bool) ([]PaperEvent, error) {
+	m := matchPaperOrder(o, price, resting)
+	if m.trigger {
+		o.Triggered = true
+	}
+	if m.fill {
+		return s.execute(o, m.price, m.liquidity)
+	}
+	if !m.trigger {
+		return nil, nil
+	}
+
+	var userID string
+	err := s.db.QueryRow(`
+		UPDATE paper_orders o SET triggered = TRUE, updated_at = NOW()
+		FROM paper_accounts a
+		WHERE o.id = $1 AND a.id = o.account_id
+		RETURNING a.user_id`, o.ID).Scan(&userID)
+	if err != nil {
+		return nil, err
+	}
+	return []PaperEvent{{Type: PaperEventOrder, AccountID: o.AccountID, Data: *o, userID: userID}}, nil
+}
+
+// paperFill is the effect of filling an order on its market's position.
+type paperFill struct {
+	qty      float64 // filled quantity, after reduce-only clamping
+	newQty   float64 // resulting net position; zero when flat
+	closing  float64
+	opening  float64
+	realized float64
+	fee      float64
+	entry    float64 // entry price of the resulting position
+	leverage float64
+	flipped  bool    // the remainder opened on the other side at the fill price
+	freed    float64 // margin and PnL released by the closing part
+	price    float64
+	reject   string
+}
+
+// planPaperFill works out a fill of o at price against pos, the current
+// position in the order's market (nil when flat). Closing quantity realizes
+// PnL against the entry, opening quantity averages into it, and a fill that
+// crosses zero reopens the remainder at price with the order's leverage.
+// The margin check is left to fits, since it needs the account summary.
+func planPaperFill(o *models.PaperOrder, pos *models.PaperPosition, price, feePct float64) paperFill {
+	dir := 1.0
+	if o.Side == OrderSideSell {
+		dir = -1
+	}
+	cur, entry := 0.0, 0.0
+	f := paperFill{qty: o.Quantity, leverage: o.Leverage, price: price}
+	if pos != nil {
+		cur, entry, f.leverage = pos.Quantity, pos.EntryPrice, pos.Leverage
+	}
+
+	if o.ReduceOnly {
+		if cur == 0 || cur*dir > 0 {
+			f.reject = "reduce-only order would increase the position"
+			return f
+		}
+		f.qty = math.Min(f.qty, math.Abs(cur))
+	}
+	if f.qty < paperQtyEpsilon {
+		f.reject = "quantity too small"
+		return f
+	}
+	f.newQty = cur + dir*f.qty
+	if !isPerpMarket(o.MarketID) && f.newQty < -paperQtyEpsilon {
+		f.reject = "insufficient holdings"
+		return f
+	}
+
+	if cur*dir < 0 {
+		f.closing = math.Min(f.qty, math.Abs(cur))
+		f.realized = f.closing * (price - entry) * math.Copysign(1, cur)
+		f.freed = f.closing*entry/pos.Leverage + f.realized
+	}
+	f.opening = f.qty - f.closing
+	f.fee = f.qty * price * feePct / 100
+
+	f.entry = entry
+	switch {
+	case math.Abs(f.newQty) < paperQtyEpsilon:
+		if pos == nil {
+			f.reject = "quantity too small"
+			return f
+		}
+		f.newQty = 0
+	case cur*f.newQty < 0:
+		f.entry, f.leverage, f.flipped = price, o.Leverage, true
+	case cur == 0:
+		f.entry = price
+	case f.opening > 0:
+		f.entry = (math.Abs(cur)*entry + f.opening*price) / math.Abs(f.newQty)
+	}
+	return f
+}
+
+// fits reports whether the margin for the opening part and the fee are
+// covered by available margin plus what the closing part frees.
+func (f paperFill) fits(available float64) bool {
+	if f.opening <= 0 {
+		return true
+	}
+	return f.opening*f.price/f.leverage+f.fee <= available+f.freed
+}
+
+// execute fills an order at price in one transaction: it updates the net
+// position and wallet balance, records the fill, places the bracket for
+// entry orders, cancels the other leg of a filled bracket and drops
+// reduce-only orders once the position is flat. Orders that can no longer
+// fill (reduce-only with nothing to reduce, missing spot holdings or
+// margin) are rejected instead.
+func (s *PaperTradingService) execute(o *models.PaperOrder, price float64, liquidity string) ([]PaperEvent, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	acct, err := scanPaperAccount(tx.QueryRow(`SELECT `+paperAccountColumns+` FROM paper_accounts WHERE id = $1 FOR UPDATE`, o.AccountID))
+	if err != nil {
+		return nil, err
+	}
+	positions, err := s.positions(tx, acct.ID)
+	if err != nil {
+		return nil, err
+	}
+	var pos *models.PaperPosition
+	for i := range positions {
+		if positions[i].MarketID == o.MarketID {
+			pos = &positions[i]
+		}
+	}
+
+	reject := func(reason string) ([]PaperEvent, error) {
+		rejected, err := scanPaperOrder(tx.QueryRow(`
+			UPDATE paper_orders SET status = 'rejected', triggered = $2, reject_reason = $3, updated_at = NOW()
+			WHERE id = $1
+			RETURNING `+paperOrderColumns, o.ID, o.Triggered, reason))
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		*o = *rejected
+		return []PaperEvent{{Type: PaperEventOrder, AccountID: acct.ID, Data: *o, userID: acct.UserID}}, nil
+	}
+
+	feePct := acct.TakerFeePct
+	if liquidity == LiquidityMaker {
+		feePct = acct.MakerFeePct
+	}
+	f := planPaperFill(o, pos, price, feePct)
+	if f.reject != "" {
+		return reject(f.reject)
+	}
+	if f.opening > 0 {
+		summary := s.markAccount(acct, positions)
+		if err := s.reserveOrders(tx, &summary, o.ID); err != nil {
+			return nil, err
+		}
+		if !f.fits(summary.AvailableMargin) {
+			return reject("insufficient margin")
+		}
+	}
+	qty, realized, fee := f.qty, f.realized, f.fee
+
+	// Net position.
+	var position models.PaperPosition
+	switch {
+	case f.newQty == 0:
+		if _, err := tx.Exec(`DELETE FROM paper_positions WHERE id = $1`, pos.ID); err != nil {
+			return nil, err
+		}
+		position = *pos
+		position.Quantity = 0
+		position.RealizedPnL += realized
+		position.UnrealizedPnL = 0
+	case pos == nil:
+		p, err := scanPaperPosition(tx.QueryRow(`
+			INSERT INTO paper_positions (account_id, market_id, quantity, entry_price, leverage)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING `+paperPositionColumns, acct.ID, o.MarketID, f.newQty, f.entry, f.leverage))
+		if err != nil {
+			return nil, err
+		}
+		position = *p
+	default:
+		opened := "opened_at"
+		if f.flipped {
+			opened = "NOW()"
+		}
+		p, err := scanPaperPosition(tx.QueryRow(`
+			UPDATE paper_positions
+			SET quantity = $2, entry_price = $3, leverage = $4, realized_pnl = realized_pnl + $5,
+				opened_at = `+opened+`, updated_at = NOW()
+			WHERE id = $1
+			RETURNING `+paperPositionColumns, pos.ID, f.newQty, f.entry, f.leverage, realized))
+		if err != nil {
+			return nil, err
+		}
+		position = *p
+	}
+
+	// Wallet balance.
+	acct, err = scanPaperAccount(tx.QueryRow(`
+		UPDATE paper_accounts SET balance = balance + $2, updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+paperAccountColumns, acct.ID, realized-fee))
+	if err != nil {
+		return nil, err
+	}
+
+	fill := models.PaperFill{
+		AccountID: acct.ID, OrderID: &o.ID, MarketID: o.MarketID, Side: o.Side,
+		Quantity: qty, Price: price, Fee: fee, RealizedPnL: realized, Liquidity: liquidity,
+	}
+	err = tx.QueryRow(`
+		INSERT INTO paper_fills (account_id, order_id, market_id, side, quantity, price, fee, realized_pnl, liquidity)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at`,
+		fill.AccountID, o.ID, fill.MarketID, fill.Side, fill.Quantity, fill.Price, fill.Fee, fill.RealizedPnL, fill.Liquidity,
+	).Scan(&fill.ID, &fill.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	filled, err := scanPaperOrder(tx.QueryRow(`
+		UPDATE paper_orders SET status = 'filled', quantity = $2, triggered = $3, filled_price = $4, updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+paperOrderColumns, o.ID, qty, o.Triggered, price))
+	if err != nil {
+		return nil, err
+	}
+	*o = *filled
+
+	events := []PaperEvent{
+		{Type: PaperEventFill, AccountID: acct.ID, Data: fill},
+		{Type: PaperEventOrder, AccountID: acct.ID, Data: *o},
+	}
+
+	// Bracket legs for an entry order, as reduce-only children.
+	if !o.ReduceOnly && (o.TakeProfit != nil || o.StopLoss != nil) {
+		exitSide := OrderSideSell
+		if o.Side == OrderSideSell {
+			exitSide = OrderSideBuy
+		}
+		legs := make([][3]interface{}, 0, 2)
+		if o.TakeProfit != nil {
+			legs = append(legs, [3]interface{}{OrderTypeLimit, *o.TakeProfit, nil})
+		}
+		if o.StopLoss != nil {
+			legs = append(legs, [3]interface{}{OrderTypeStop, nil, *o.StopLoss})
+		}
+		for _, leg := range legs {
+			child, err := scanPaperOrder(tx.QueryRow(`
+				INSERT INTO paper_orders (account_id, market_id, side, order_type, quantity, price, stop_price,
+					leverage, reduce_only, parent_order_id)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, TRUE, $9)
+				RETURNING `+paperOrderColumns,
+				acct.ID, o.MarketID, exitSide, leg[0], qty, leg[1], leg[2], o.Leverage, o.ID))
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, PaperEvent{Type: PaperEventOrder, AccountID: acct.ID, Data: *child})
+		}
+	}
+
+	// One-cancels-other for bracket legs, and no reduce-only orders left
+	// behind on a flat position.
+	cancelled, err := cancelPaperOrders(tx, `
+		account_id = $1 AND status = 'open' AND id <> $2 AND (
+			(parent_order_id IS NOT NULL AND parent_order_id = $3) OR
+			($4 AND market_id = $5 AND reduce_only)
+		)`, acct.ID, o.ID, o.ParentOrderID, position.Quantity == 0, o.MarketID)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range cancelled {
+		events = append(events, PaperEvent{Type: PaperEventOrder, AccountID: acct.ID, Data: c})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	position.MarkPrice = price
+	if position.Quantity != 0 {
+		position.UnrealizedPnL = position.Quantity * (price - position.EntryPrice)
+	}
+	events = append(events,
+		PaperEvent{Type: PaperEventPosition, AccountID: acct.ID, Data: position},
+		PaperEvent{Type: PaperEventAccount, AccountID: acct.ID, Data: *acct},
+	)
+	for i := range events {
+		events[i].userID = acct.UserID
+	}
+	return events, nil
+}
+
+func cancelPaperOrders(q paperQuerier, where string, args ...interface{}) ([]models.PaperOrder, error) {
+	rows, err := q.Query(`
+		UPDATE paper_orders SET status = 'cancelled', updated_at = NOW()
+		WHERE `+where+`
+		RETURNING `+paperOrderColumns, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]models.PaperOrder, 0)
+	for rows.Next() {
+		o, err := scanPaperOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *o)
+	}
+	return out, rows.Err()
+}
+
+// ---- positions and marks ----
+
+const paperPositionColumns = `id, account_id, market_id, quantity, entry_price, leverage, realized_pnl,
+	funding_paid, opened_at, updated_at`
+
+func scanPaperPosition(row rowScanner) (*models.PaperPosition, error) {
+	var p models.PaperPosition
+	err := row.Scan(&p.ID, &p.AccountID, &p.MarketID, &p.Quantity, &p.EntryPrice, &p.Leverage,
+		&p.RealizedPnL, &p.FundingPaid, &p.OpenedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *PaperTradingService) positions(q paperQuerier, accountID int) ([]models.PaperPosition, error) {
+	rows, err := q.Query(`SELECT `+paperPositionColumns+` FROM paper_positions WHERE account_id = $1 ORDER BY market_id`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]models.PaperPosition, 0)
+	for rows.Next() {
+		p, err := scanPaperPosition(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *p)
+	}
+	return items, rows.Err()
+}
+
+// markAccount values positions at the cached last price (entry price when
+// none is cached). Margin is notional at entry divided by leverage.
+func (s *PaperTradingService) markAccount(acct *models.PaperAccount, positions []models.PaperPosition) PaperAccountSummary {
+	summary := PaperAccountSummary{PaperAccount: *acct, Positions: positions}
+	for i := range summary.Positions {
+		p := &summary.Positions[i]
+		p.MarkPrice = s.cachedPrice(p.MarketID, p.EntryPrice)
+		p.UnrealizedPnL = p.Quantity * (p.MarkPrice - p.EntryPrice)
+		summary.UnrealizedPnL += p.UnrealizedPnL
+		summary.UsedMargin += math.Abs(p.Quantity) * p.EntryPrice / p.Leverage
+	}
+	summary.Equity = acct.Balance + summary.UnrealizedPnL
+	summary.AvailableMargin = summary.Equity - summary.UsedMargin
+	return summary
+}
+
+// reserveOrders holds margin and taker fees for the account's open orders
+// other than exclude, at their limit or stop price, and takes them from the
+// available margin. Reduce-only orders, such as bracket legs, hold nothing.
+func (s *PaperTradingService) reserveOrders(q paperQuerier, summary *PaperAccountSummary, exclude int) error {
+	err := q.QueryRow(`
+		SELECT COALESCE(SUM(quantity * COALESCE(price, stop_price) * (1 / leverage + $3::numeric / 100)), 0)
+		FROM paper_orders
+		WHERE account_id = $1 AND status = 'open' AND NOT reduce_only AND id <> $2`,
+		summary.ID, exclude, summary.TakerFeePct).Scan(&summary.OrderMargin)
+	if err != nil {
+		return err
+	}
+	summary.AvailableMargin -= summary.OrderMargin
+	return nil
+}
+
+// markPrice returns a recent price for a market, fetching it when the
+// cached one is stale.
+func (s *PaperTradingService) markPrice(marketID string) (float64, error) {
+	s.pricesMu.RLock()
+	q, ok := s.prices[marketID]
+	s.pricesMu.RUnlock()
+	if ok && time.Since(q.at) < paperPriceMaxAge {
+		return q.price, nil
+	}
+
+	price, err := s.price(marketID)
+	if err != nil || price <= 0 {
+		return 0, fmt.Errorf("%w: %s", ErrPriceUnavailable, marketID)
+	}
+	s.setPrice(marketID, price)
+	return price, nil
+}
+
+func (s *PaperTradingService) cachedPrice(marketID string, fallback float64) float64 {
+	s.pricesMu.RLock()
+	defer s.pricesMu.RUnlock()
+	if q, ok := s.prices[marketID]; ok {
+		return q.price
+	}
+	return fallback
+}
+
+func (s *PaperTradingService) setPrice(marketID string, price float64) {
+	s.pricesMu.Lock()
+	s.prices[marketID] = paperQuote{price: price, at: time.Now()}
+	s.pricesMu.Unlock()
+}
+
+func (s *PaperTradingService) account(q paperQuerier, userID string, accountID int) (*models.PaperAccount, error) {
+	a, err := scanPaperAccount(q.QueryRow(`SELECT `+paperAccountColumns+` FROM paper_accounts WHERE id = $1 AND user_id = $2`, accountID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPaperAccountNotFound
+	}
+	return a, err
+}
+
+func (s *PaperTradingService) publish(events ...PaperEvent) {
+	if s.sink == nil {
+		return
+	}
+	for _, e := range events {
+		s.sink(e.userID, e)
+	}
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// paperQuerier is implemented by *sql.DB and *sql.Tx.
+type paperQuerier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func positionQuantity(positions []models.PaperPosition, marketID string) float64 {
+	for _, p := range positions {
+		if p.MarketID == marketID {
+			return p.Quantity
+		}
+	}
+	return 0
+}
+
+// isPerpMarket reports whether a marketId (e.g. BI:PERP:BTCUSDT) is a
+// perpetual.
+func isPerpMarket(marketID string) bool {
+	parts := strings.Split(marketID, ":")
+	return len(parts) == 3 && strings.EqualFold(parts[1], "PERP")
+}
+
+func nullFloatPtr(v sql.NullFloat64) *float64 {
+	if !v.Valid {
+		return nil
+	}
+	f := v.Float64
+	return &f
+}
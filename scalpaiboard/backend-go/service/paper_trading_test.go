@@ -0,0 +1,258 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/scalpaiboard/backend/models"
+)
+
+func TestMatchPaperOrder(t *testing.T) {
+	ptr := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name    string
+		order   models.PaperOrder
+		price   float64
+		resting bool
+		want    paperMatch
+	}{
+		{
+			name:  "market fills at price as taker",
+			order: models.PaperOrder{Side: OrderSideSell, Type: OrderTypeMarket},
+			price: 100,
+			want:  paperMatch{fill: true, price: 100, liquidity: LiquidityTaker},
+		},
+		{
+			name:    "resting buy limit fills at its limit as maker",
+			order:   models.PaperOrder{Side: OrderSideBuy, Type: OrderTypeLimit, Price: ptr(100)},
+			price:   99,
+			resting: true,
+			want:    paperMatch{fill: true, price: 100, liquidity: LiquidityMaker},
+		},
+		{
+			name:    "resting buy limit above price waits",
+			order:   models.PaperOrder{Side: OrderSideBuy, Type: OrderTypeLimit, Price: ptr(100)},
+			price:   101,
+			resting: true,
+		},
+		{
+			name:  "marketable sell limit fills at price as taker",
+			order: models.PaperOrder{Side: OrderSideSell, Type: OrderTypeLimit, Price: ptr(100)},
+			price: 105,
+			want:  paperMatch{fill: true, price: 105, liquidity: LiquidityTaker},
+		},
+		{
+			name:    "resting sell limit below price waits",
+			order:   models.PaperOrder{Side: OrderSideSell, Type: OrderTypeLimit, Price: ptr(100)},
+			price:   99,
+			resting: true,
+		},
+		{
+			name:    "buy stop below its stop waits",
+			order:   models.PaperOrder{Side: OrderSideBuy, Type: OrderTypeStop, StopPrice: ptr(110)},
+			price:   109,
+			resting: true,
+		},
+		{
+			name:    "buy stop triggers and fills at price",
+			order:   models.PaperOrder{Side: OrderSideBuy, Type: OrderTypeStop, StopPrice: ptr(110)},
+			price:   111,
+			resting: true,
+			want:    paperMatch{trigger: true, fill: true, price: 111, liquidity: LiquidityTaker},
+		},
+		{
+			name:    "sell stop triggers and fills at price",
+			order:   models.PaperOrder{Side: OrderSideSell, Type: OrderTypeStop, StopPrice: ptr(90)},
+			price:   89,
+			resting: true,
+			want:    paperMatch{trigger: true, fill: true, price: 89, liquidity: LiquidityTaker},
+		},
+		{
+			name:    "sell stop above its stop waits",
+			order:   models.PaperOrder{Side: OrderSideSell, Type: OrderTypeStop, StopPrice: ptr(90)},
+			price:   91,
+			resting: true,
+		},
+		{
+			name:    "buy stop-limit triggers marketable and fills as taker",
+			order:   models.PaperOrder{Side: OrderSideBuy, Type: OrderTypeStopLimit, StopPrice: ptr(110), Price: ptr(112)},
+			price:   111,
+			resting: true,
+			want:    paperMatch{trigger: true, fill: true, price: 111, liquidity: LiquidityTaker},
+		},
+		{
+			name:    "buy stop-limit triggers past its limit and rests",
+			order:   models.PaperOrder{Side: OrderSideBuy, Type: OrderTypeStopLimit, StopPrice: ptr(110), Price: ptr(110.5)},
+			price:   111,
+			resting: true,
+			want:    paperMatch{trigger: true},
+		},
+		{
+			name:    "triggered sell stop-limit fills at its limit as maker",
+			order:   models.PaperOrder{Side: OrderSideSell, Type: OrderTypeStopLimit, StopPrice: ptr(92), Price: ptr(90), Triggered: true},
+			price:   91,
+			resting: true,
+			want:    paperMatch{fill: true, price: 90, liquidity: LiquidityMaker},
+		},
+		{
+			name:    "sell stop-limit above its stop waits",
+			order:   models.PaperOrder{Side: OrderSideSell, Type: OrderTypeStopLimit, StopPrice: ptr(90), Price: ptr(89)},
+			price:   95,
+			resting: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchPaperOrder(&tt.order, tt.price, tt.resting); got != tt.want {
+				t.Errorf("matchPaperOrder() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanPaperFill(t *testing.T) {
+	const perp, spot = "BI:PERP:BTCUSDT", "BI:SPOT:BTCUSDT"
+	position := func(qty, entry, leverage float64) *models.PaperPosition {
+		return &models.PaperPosition{ID: 1, MarketID: perp, Quantity: qty, EntryPrice: entry, Leverage: leverage}
+	}
+
+	tests := []struct {
+		name      string
+		order     models.PaperOrder
+		pos       *models.PaperPosition
+		price     float64
+		available float64
+		want      paperFill
+	}{
+		{
+			name:      "opens a long",
+			order:     models.PaperOrder{MarketID: perp, Side: OrderSideBuy, Quantity: 2, Leverage: 10},
+			price:     100,
+			available: 1000,
+			want:      paperFill{qty: 2, newQty: 2, opening: 2, fee: 0.2, entry: 100, leverage: 10},
+		},
+		{
+			name:      "increase averages the entry and keeps the leverage",
+			order:     models.PaperOrder{MarketID: perp, Side: OrderSideBuy, Quantity: 2, Leverage: 20},
+			pos:       position(2, 100, 5),
+			price:     110,
+			available: 1000,
+			want:      paperFill{qty: 2, newQty: 4, opening: 2, fee: 0.22, entry: 105, leverage: 5},
+		},
+		{
+			name:  "reduce realizes against the entry",
+			order: models.PaperOrder{MarketID: perp, Side: OrderSideSell, Quantity: 1, Leverage: 5},
+			pos:   position(4, 100, 5),
+			price: 110,
+			want:  paperFill{qty: 1, newQty: 3, closing: 1, realized: 10, fee: 0.11, entry: 100, leverage: 5, freed: 30},
+		},
+		{
+			name:  "reduce a short",
+			order: models.PaperOrder{MarketID: perp, Side: OrderSideBuy, Quantity: 1, Leverage: 5},
+			pos:   position(-2, 100, 5),
+			price: 90,
+			want:  paperFill{qty: 1, newQty: -1, closing: 1, realized: 10, fee: 0.09, entry: 100, leverage: 5, freed: 30},
+		},
+		{
+			name:  "close goes flat",
+			order: models.PaperOrder{MarketID: perp, Side: OrderSideSell, Quantity: 2, Leverage: 5},
+			pos:   position(2, 100, 5),
+			price: 90,
+			want:  paperFill{qty: 2, closing: 2, realized: -20, fee: 0.18, entry: 100, leverage: 5, freed: 20},
+		},
+		{
+			name:  "flip reopens the remainder at price with the order leverage",
+			order: models.PaperOrder{MarketID: perp, Side: OrderSideSell, Quantity: 3, Leverage: 10},
+			pos:   position(2, 100, 5),
+			price: 120,
+			want: paperFill{qty: 3, newQty: -1, closing: 2, opening: 1, realized: 40, fee: 0.36,
+				entry: 120, leverage: 10, flipped: true, freed: 80},
+		},
+		{
+			name:  "reduce-only is clamped to the position",
+			order: models.PaperOrder{MarketID: perp, Side: OrderSideSell, Quantity: 5, Leverage: 5, ReduceOnly: true},
+			pos:   position(2, 100, 5),
+			price: 110,
+			want:  paperFill{qty: 2, closing: 2, realized: 20, fee: 0.22, entry: 100, leverage: 5, freed: 60},
+		},
+		{
+			name:  "reduce-only cannot increase",
+			order: models.PaperOrder{MarketID: perp, Side: OrderSideBuy, Quantity: 1, Leverage: 5, ReduceOnly: true},
+			pos:   position(2, 100, 5),
+			price: 110,
+			want:  paperFill{reject: "reduce-only order would increase the position"},
+		},
+		{
+			name:  "reduce-only needs a position",
+			order: models.PaperOrder{MarketID: perp, Side: OrderSideSell, Quantity: 1, Leverage: 5, ReduceOnly: true},
+			price: 110,
+			want:  paperFill{reject: "reduce-only order would increase the position"},
+		},
+		{
+			name:  "spot cannot sell without holdings",
+			order: models.PaperOrder{MarketID: spot, Side: OrderSideSell, Quantity: 1, Leverage: 1},
+			price: 100,
+			want:  paperFill{reject: "insufficient holdings"},
+		},
+		{
+			name:  "quantity below the stored scale",
+			order: models.PaperOrder{MarketID: perp, Side: OrderSideBuy, Quantity: 1e-13, Leverage: 5},
+			price: 100,
+			want:  paperFill{reject: "quantity too small"},
+		},
+		{
+			name:      "open needs margin for size and fee",
+			order:     models.PaperOrder{MarketID: perp, Side: OrderSideBuy, Quantity: 2, Leverage: 10},
+			price:     100,
+			available: 20,
+			want:      paperFill{qty: 2, newQty: 2, opening: 2, fee: 0.2, entry: 100, leverage: 10, reject: "insufficient margin"},
+		},
+		{
+			name:      "flip counts the margin the close frees",
+			order:     models.PaperOrder{MarketID: perp, Side: OrderSideSell, Quantity: 3, Leverage: 10},
+			pos:       position(2, 100, 5),
+			price:     120,
+			available: -68,
+			want: paperFill{qty: 3, newQty: -1, closing: 2, opening: 1, realized: 40, fee: 0.36,
+				entry: 120, leverage: 10, flipped: true, freed: 80, reject: "insufficient margin"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := planPaperFill(&tt.order, tt.pos, tt.price, 0.1)
+			if got.reject == "" && !got.fits(tt.available) {
+				got.reject = "insufficient margin"
+			}
+			if got.reject != tt.want.reject {
+				t.Fatalf("reject = %q, want %q", got.reject, tt.want.reject)
+			}
+			if tt.want.reject != "" && tt.want.qty == 0 {
+				return
+			}
+			if got.flipped != tt.want.flipped {
+				t.Errorf("flipped = %v, want %v", got.flipped, tt.want.flipped)
+			}
+			checks := []struct {
+				field     string
+				got, want float64
+			}{
+				{"qty", got.qty, tt.want.qty},
+				{"newQty", got.newQty, tt.want.newQty},
+				{"closing", got.closing, tt.want.closing},
+				{"opening", got.opening, tt.want.opening},
+				{"realized", got.realized, tt.want.realized},
+				{"fee", got.fee, tt.want.fee},
+				{"entry", got.entry, tt.want.entry},
+				{"leverage", got.leverage, tt.want.leverage},
+				{"freed", got.freed, tt.want.freed},
+			}
+			for _, c := range checks {
+				if !approx(c.got, c.want) {
+					t.Errorf("%s = %v, want %v", c.field, c.got, c.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package service
+
+import (
+	"math"
+	"sort"
+)
+
+// Pattern directions.
+const (
+	DirectionBullish = "bullish"
+	DirectionBearish = "bearish"
+	DirectionNeutral = "neutral"
+)
+
+// Candlestick pattern names reported in PatternHit.Pattern.
+const (
+	PatternBullishEngulfing   = "bullish_engulfing"
+	PatternBearishEngulfing   = "bearish_engulfing"
+	PatternHammer             = "hammer"
+	PatternShootingStar       = "shooting_star"
+	PatternDoji               = "doji"
+	PatternDragonflyDoji      = "dragonfly_doji"
+	PatternGravestoneDoji     = "gravestone_doji"
+	PatternLongLeggedDoji     = "long_legged_doji"
+	PatternMorningStar        = "morning_star"
+	PatternEveningStar        = "evening_star"
+	PatternThreeWhiteSoldiers = "three_white_soldiers"
+	PatternThreeBlackCrows    = "three_black_crows"
+	PatternInsideBar          = "inside_bar"
+	PatternOutsideBar         = "outside_bar"
+	PatternBullishPinBar      = "bullish_pin_bar"
+	PatternBearishPinBar      = "bearish_pin_bar"
+)
+
+// CandlestickPatterns lists every pattern DetectCandlestickPatterns can report.
+var CandlestickPatterns = []string{
+	PatternBullishEngulfing, PatternBearishEngulfing,
+	PatternHammer, PatternShootingStar,
+	PatternDoji, PatternDragonflyDoji, PatternGravestoneDoji, PatternLongLeggedDoji,
+	PatternMorningStar, PatternEveningStar,
+	PatternThreeWhiteSoldiers, PatternThreeBlackCrows,
+	PatternInsideBar, PatternOutsideBar,
+	PatternBullishPinBar, PatternBearishPinBar,
+}
+
+// PatternHit is a single pattern occurrence. Index and Time refer to the last
+// candle of the pattern; Bars is how many candles the pattern spans.
+// Strength is a 0..1 score combining shape quality, bar size relative to the
+// recent average range and, where relevant, the preceding trend.
+type PatternHit struct {
+	Pattern   string  `json:"pattern"`
+	Index     int     `json:"index"`
+	Time      int64   `json:"time"`
+	Bars      int     `json:"bars"`
+	Direction string  `json:"direction"`
+	Strength  float64 `json:"strength"`
+}
+
+const (
+	patternRangePeriod = 14
+	patternTrendPeriod = 5
+)
+
+// DetectCandlestickPatterns scans candles (ascending by time) and returns
+// every pattern hit ordered by bar index.
+func DetectCandlestickPatterns(candles []Candle) []PatternHit {
+	hits := make([]PatternHit, 0)
+	for i := range candles {
+		avgRange := averageRange(candles, i, patternRangePeriod)
+		if avgRange <= 0 {
+			continue
+		}
+		trend := priorTrend(candles, i, patternTrendPeriod)
+
+		hits = append(hits, singleBarPatterns(candles, i, avgRange, trend)...)
+		if i >= 1 {
+			hits = append(hits, twoBarPatterns(candles, i, avgRange, trend)...)
+		}
+		if i >= 2 {
+			hits = append(hits, threeBarPatterns(candles, i, avgRange, trend)...)
+		}
+	}
+
+	sort.SliceStable(hits, func(a, b int) bool { return hits[a].Index < hits[b].Index })
+	return hits
+}
+
+// FilterPatternHits keeps hits whose pattern is in names (all when names is
+// empty) and whose index is at or after fromIndex.
+func FilterPatternHits(hits []PatternHit, names []string, fromIndex int) []PatternHit {
+	allowed := make(map[string]bool, len(names))
+	for _, n := range names {
+		allowed[n] = true
+	}
+
+	out := make([]PatternHit, 0, len(hits))
+	for _, h := range hits {
+		if h.Index < fromIndex {
+			continue
+		}
+		if len(allowed) > 0 && !allowed[h.Pattern] {
+			continue
+		}
+		out = append(out, h)
+	}
+	return out
+}
+
+// PatternScore sums bullish and bearish strengths of the hits ending on index.
+func PatternScore(hits []PatternHit, index int) (bullish, bearish float64) {
+	for _, h := range hits {
+		if h.Index != index {
+			continue
+		}
+		switch h.Direction {
+		case DirectionBullish:
+			bullish += h.Strength
+		case DirectionBearish:
+			bearish += h.Strength
+		}
+	}
+	return bullish, bearish
+}
+
+// IsCandlestickPattern reports whether name is a known pattern.
+func IsCandlestickPattern(name string) bool {
+	for _, p := range CandlestickPatterns {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+func singleBarPatterns(candles []Candle, i int, avgRange float64, trend int) []PatternHit {
+	c := candles[i]
+	rng := c.High - c.Low
+	if rng <= 0 {
+		return nil
+	}
+	body := math.Abs(c.Close - c.Open)
+	upper := c.High - math.Max(c.Open, c.Close)
+	lower := math.Min(c.Open, c.Close) - c.Low
+	size := clamp01(rng / avgRange / 2)
+
+	hits := make([]PatternHit, 0, 2)
+	hit := func(pattern, direction string, strength float64) {
+		hits = append(hits, PatternHit{
+			Pattern:   pattern,
+			Index:     i,
+			Time:      c.Time,
+			Bars:      1,
+			Direction: direction,
+			Strength:  roundStrength(strength),
+		})
+	}
+
+	if body <= 0.1*rng {
+		shape := 1 - body/(0.1*rng)
+		switch {
+		case upper <= 0.1*rng && lower >= 0.6*rng:
+			hit(PatternDragonflyDoji, DirectionBullish, 0.4*shape+0.3*size+0.3*trendBonus(trend, -1))
+		case lower <= 0.1*rng && upper >= 0.6*rng:
+			hit(PatternGravestoneDoji, DirectionBearish, 0.4*shape+0.3*size+0.3*trendBonus(trend, 1))
+		case upper >= 0.3*rng && lower >= 0.3*rng && rng >= avgRange:
+			hit(PatternLongLeggedDoji, DirectionNeutral, 0.5*shape+0.5*size)
+		default:
+			hit(PatternDoji, DirectionNeutral, 0.6*shape+0.4*size)
+		}
+		return hits
+	}
+
+	// Hammer / shooting star: small body at one end, long opposite wick,
+	// only meaningful after a move in the opposite direction.
+	if lower >= 2*body && upper <= 0.25*rng && trend < 0 {
+		hit(PatternHammer, DirectionBullish, 0.4*clamp01(lower/body/4)+0.3*size+0.3)
+	}
+	if upper >= 2*body && lower <= 0.25*rng && trend > 0 {
+		hit(PatternShootingStar, DirectionBearish, 0.4*clamp01(upper/body/4)+0.3*size+0.3)
+	}
+
+	// Pin bars: a dominant rejection wick on a bar at least as large as the
+	// recent average, independent of trend.
+	if body <= rng/3 && rng >= 0.8*avgRange {
+		if lower >= 2*rng/3 {
+			hit(PatternBullishPinBar, DirectionBullish, 0.5*clamp01((lower/rng-2.0/3)*3)+0.3*size+0.2*trendBonus(trend, -1))
+		}
+		if upper >= 2*rng/3 {
+			hit(PatternBearishPinBar, DirectionBearish, 0.5*clamp01((upper/rng-2.0/3)*3)+0.3*size+0.2*trendBonus(trend, 1))
+		}
+	}
+
+	return hits
+}
+
+func twoBarPatterns(candles []Candle, i int, avgRange float64, trend int) []PatternHit {
+	prev, c := candles[i-1], candles[i]
+	prevBody := math.Abs(prev.Close - prev.Open)
+	body := math.Abs(c.Close - c.Open)
+	prevRange := prev.High - prev.Low
+	rng := c.High - c.Low
+
+	hits := make([]PatternHit, 0, 2)
+	hit := func(pattern, direction string, strength float64) {
+		hits = append(hits, PatternHit{
+			Pattern:   pattern,
+			Index:     i,
+			Time:      c.Time,
+			Bars:      2,
+			Direction: direction,
+			Strength:  roundStrength(strength),
+		})
+	}
+
+	if prevBody > 0 && body > prevBody {
+		ratio := clamp01((body/prevBody - 1) / 2)
+		size := clamp01(body / avgRange)
+		if prev.Close < prev.Open && c.Close > c.Open && c.Open <= prev.Close && c.Close >= prev.Open {
+			hit(PatternBullishEngulfing, DirectionBullish, 0.4*ratio+0.3*size+0.3*trendBonus(trend, -1))
+		}
+		if prev.Close > prev.Open && c.Close < c.Open && c.Open >= prev.Close && c.Close <= prev.Open {
+			hit(PatternBearishEngulfing, DirectionBearish, 0.4*ratio+0.3*size+0.3*trendBonus(trend, 1))
+		}
+	}
+
+	if prevRange > 0 && rng > 0 {
+		if c.High < prev.High && c.Low > prev.Low {
+			hit(PatternInsideBar, DirectionNeutral, 0.7*(1-rng/prevRange)+0.3*clamp01(prevRange/avgRange/2))
+		}
+		if c.High > prev.High && c.Low < prev.Low {
+			direction := DirectionNeutral
+			if c.Close > c.Open {
+				direction = DirectionBullish
+			} else if c.Close < c.Open {
+				direction = DirectionBearish
+			}
+			hit(PatternOutsideBar, direction, 0.5*clamp01(rng/prevRange-1)+0.5*clamp01(body/rng))
+		}
+	}
+
+	return hits
+}
+
+func threeBarPatterns(candles []Candle, i int, avgRange float64, trend int) []PatternHit {
+	a, b, c := candles[i-2], candles[i-1], candles[i]
+	bodyA := math.Abs(a.Close - a.Open)
+	bodyB := math.Abs(b.Close - b.Open)
+	bodyC := math.Abs(c.Close - c.Open)
+
+	hits := make([]PatternHit, 0, 1)
+	hit := func(pattern, direction string, strength float64) {
+		hits = append(hits, PatternHit{
+			Pattern:   pattern,
+			Index:     i,
+			Time:      c.Time,
+			Bars:      3,
+			Direction: direction,
+			Strength:  roundStrength(strength),
+		})
+	}
+
+	// Stars: a large first body, a small indecision body, then a strong
+	// reversal closing beyond the first candle's midpoint.
+	if bodyA >= 0.6*avgRange && bodyB <= 0.3*bodyA {
+		mid := (a.Open + a.Close) / 2
+		if a.Close < a.Open && c.Close > c.Open && c.Close > mid && math.Max(b.Open, b.Close) <= a.Close+0.1*bodyA {
+			depth := clamp01((c.Close - mid) / (bodyA / 2))
+			hit(PatternMorningStar, DirectionBullish, 0.4*depth+0.3*(1-bodyB/bodyA)+0.3*trendBonus(priorTrend(candles, i-2, patternTrendPeriod), -1))
+		}
+		if a.Close > a.Open && c.Close < c.Open && c.Close < mid && math.Min(b.Open, b.Close) >= a.Close-0.1*bodyA {
+			depth := clamp01((mid - c.Close) / (bodyA / 2))
+			hit(PatternEveningStar, DirectionBearish, 0.4*depth+0.3*(1-bodyB/bodyA)+0.3*trendBonus(priorTrend(candles, i-2, patternTrendPeriod), 1))
+		}
+	}
+
+	// Three soldiers / crows: three same-direction bodies, each opening
+	// inside the previous body and closing near its extreme.
+	minBody := math.Min(bodyA, math.Min(bodyB, bodyC))
+	if minBody >= 0.5*avgRange {
+		size := clamp01(minBody / avgRange / 1.5)
+		if a.Close > a.Open && b.Close > b.Open && c.Close > c.Open &&
+			b.Close > a.Close && c.Close > b.Close &&
+			b.Open >= a.Open && b.Open <= a.Close && c.Open >= b.Open && c.Open <= b.Close &&
+			c.High-c.Close <= 0.3*bodyC && b.High-b.Close <= 0.3*bodyB {
+			hit(PatternThreeWhiteSoldiers, DirectionBullish, 0.6*size+0.4*trendBonus(trend, -1))
+		}
+		if a.Close < a.Open && b.Close < b.Open && c.Close < c.Open &&
+			b.Close < a.Close && c.Close < b.Close &&
+			b.Open <= a.Open && b.Open >= a.Close && c.Open <= b.Open && c.Open >= b.Close &&
+			c.Close-c.Low <= 0.3*bodyC && b.Close-b.Low <= 0.3*bodyB {
+			hit(PatternThreeBlackCrows, DirectionBearish, 0.6*size+0.4*trendBonus(trend, 1))
+		}
+	}
+
+	return hits
+}
+
+// averageRange is the mean high-low range of up to period candles before i.
+func averageRange(candles []Candle, i, period int) float64 {
+	start := i - period
+	if start < 0 {
+		start = 0
+	}
+	if i-start < 3 {
+		return 0
+	}
+	sum := 0.0
+	for k := start; k < i; k++ {
+		sum += candles[k].High - candles[k].Low
+	}
+	return sum / float64(i-start)
+}
+
+// priorTrend returns the sign of the close-to-close move over the period
+// candles before i: 1 up, -1 down, 0 flat or not enough data.
+func priorTrend(candles []Candle, i, period int) int {
+	if i-1-period < 0 {
+		return 0
+	}
+	delta := candles[i-1].Close - candles[i-1-period].Close
+	switch {
+	case delta > 0:
+		return 1
+	case delta < 0:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// trendBonus is 1 when the prior trend matches want (the move a reversal
+// pattern reverses), 0.5 when flat, 0 when opposite.
+func trendBonus(trend, want int) float64 {
+	switch trend {
+	case want:
+		return 1
+	case 0:
+		return 0.5
+	default:
+		return 0
+	}
+}
+
+func clamp01(v float64) float64 {
+	if math.IsNaN(v) || v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
+
+func roundStrength(v float64) float64 {
+	return math.Round(clamp01(v)*1000) / 1000
+}
@@ -0,0 +1,341 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// Technical rating labels, from most bearish to most bullish.
+const (
+	RatingStrongSell = "strong_sell"
+	RatingSell       = "sell"
+	RatingNeutral    = "neutral"
+	RatingBuy        = "buy"
+	RatingStrongBuy  = "strong_buy"
+)
+
+// Rating component groups.
+const (
+	RatingGroupOscillators    = "oscillators"
+	RatingGroupMovingAverages = "moving_averages"
+)
+
+// RatingComponent is one indicator's vote: +1 buy, 0 neutral, -1 sell.
+type RatingComponent struct {
+	Name   string  `json:"name"`
+	Group  string  `json:"group"`
+	Value  float64 `json:"value"`
+	Vote   int     `json:"vote"`
+	Action string  `json:"action"`
+}
+
+// RatingGroup summarises the votes of one component group. Score is
+// (buy - sell) / total, in -1..1.
+type RatingGroup struct {
+	Rating  string  `json:"rating"`
+	Score   float64 `json:"score"`
+	Buy     int     `json:"buy"`
+	Neutral int     `json:"neutral"`
+	Sell    int     `json:"sell"`
+}
+
+// TechnicalRating is a TradingView-style summary of oscillator and moving
+// average votes. Score is the mean of the two group scores.
+type TechnicalRating struct {
+	Rating         string            `json:"rating"`
+	Score          float64           `json:"score"`
+	Oscillators    RatingGroup       `json:"oscillators"`
+	MovingAverages RatingGroup       `json:"movingAverages"`
+	Components     []RatingComponent `json:"components"`
+}
+
+// IsRating reports whether label is a known rating label.
+func IsRating(label string) bool {
+	switch label {
+	case RatingStrongSell, RatingSell, RatingNeutral, RatingBuy, RatingStrongBuy:
+		return true
+	default:
+		return false
+	}
+}
+
+// RatingLabel maps a -1..1 score to a label: below -0.5 strong sell, below
+// -0.1 sell, up to 0.1 neutral, up to 0.5 buy, above that strong buy.
+func RatingLabel(score float64) string {
+	switch {
+	case score < -0.5:
+		return RatingStrongSell
+	case score < -0.1:
+		return RatingSell
+	case score <= 0.1:
+		return RatingNeutral
+	case score <= 0.5:
+		return RatingBuy
+	default:
+		return RatingStrongBuy
+	}
+}
+
+// ComputeTechnicalRating derives the rating from a TechnicalAnalysis plus the
+// series it was computed on. Components without enough data are skipped.
+//
+// Vote rules (buy / sell, neutral otherwise):
+//
+//	RSI(14)            < 30 / > 70
+//	Stoch %K(14,3,3)   < 20 and %K > %D / > 80 and %K < %D
+//	CCI(20)            < -100 / > 100
+//	Williams %R(14)    < -80 / > -20
+//	Momentum(10)       rising / falling
+//	MACD(12,26,9)      MACD > signal / MACD < signal
+//	SMA, EMA (9,21,50,200)  close above / below the average
+func ComputeTechnicalRating(analysis TechnicalAnalysis, closes, highs, lows []float64) (TechnicalRating, error) {
+	if len(closes) < 2 || len(highs) != len(closes) || len(lows) != len(closes) {
+		return TechnicalRating{}, errors.New("not enough candles")
+	}
+	last := closes[len(closes)-1]
+
+	components := make([]RatingComponent, 0, 16)
+	osc := func(name string, value float64, vote int) {
+		components = append(components, RatingComponent{
+			Name:   name,
+			Group:  RatingGroupOscillators,
+			Value:  value,
+			Vote:   vote,
+			Action: voteAction(vote),
+		})
+	}
+
+	if analysis.RSI.Period > 0 {
+		rsi := analysis.RSI.Value
+		osc("RSI(14)", rsi, thresholdVote(rsi, 30, 70))
+	}
+
+	if k, d, ok := stochastic(closes, highs, lows, 14, 3, 3); ok {
+		vote := 0
+		switch {
+		case k < 20 && k > d:
+			vote = 1
+		case k > 80 && k < d:
+			vote = -1
+		}
+		osc("Stoch %K(14,3,3)", k, vote)
+	}
+
+	if cci, ok := commodityChannelIndex(closes, highs, lows, 20); ok {
+		osc("CCI(20)", cci, thresholdVote(cci, -100, 100))
+	}
+
+	if wr, ok := williamsR(closes, highs, lows, 14); ok {
+		osc("Williams %R(14)", wr, thresholdVote(wr, -80, -20))
+	}
+
+	if n := len(closes); n > 11 {
+		mom := closes[n-1] - closes[n-11]
+		prev := closes[n-2] - closes[n-12]
+		vote := 0
+		switch {
+		case mom > prev:
+			vote = 1
+		case mom < prev:
+			vote = -1
+		}
+		osc("Momentum(10)", mom, vote)
+	}
+
+	if analysis.MACD.SlowPeriod > 0 {
+		vote := 0
+		switch {
+		case analysis.MACD.MACD > analysis.MACD.Signal:
+			vote = 1
+		case analysis.MACD.MACD < analysis.MACD.Signal:
+			vote = -1
+		}
+		osc("MACD(12,26,9)", analysis.MACD.MACD, vote)
+	}
+
+	components = appendMAVotes(components, "SMA", analysis.SMA, last)
+	components = appendMAVotes(components, "EMA", analysis.EMA, last)
+
+	rating := TechnicalRating{Components: components}
+	rating.Oscillators = tallyRatingGroup(components, RatingGroupOscillators)
+	rating.MovingAverages = tallyRatingGroup(components, RatingGroupMovingAverages)
+	rating.Score = math.Round((rating.Oscillators.Score+rating.MovingAverages.Score)/2*100) / 100
+	rating.Rating = RatingLabel(rating.Score)
+	return rating, nil
+}
+
+// RatingOf computes the technical rating for a candle series.
+func RatingOf(candles []Candle) (TechnicalRating, error) {
+	closes, highs, lows := CandleSeries(candles)
+	analysis, err := ComputeTechnicalAnalysis("", "", closes, highs, lows, len(candles))
+	if err != nil {
+		return TechnicalRating{}, err
+	}
+	return analysis.Rating, nil
+}
+
+func appendMAVotes(components []RatingComponent, kind string, averages map[string]float64, last float64) []RatingComponent {
+	keys := make([]string, 0, len(averages))
+	for k := range averages {
+		keys = append(keys, k)
+	}
+	// Keys are "p<period>"; order by period.
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) < len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+
+	for _, k := range keys {
+		ma := averages[k]
+		vote := 0
+		switch {
+		case last > ma:
+			vote = 1
+		case last < ma:
+			vote = -1
+		}
+		components = append(components, RatingComponent{
+			Name:   kind + "(" + k[1:] + ")",
+			Group:  RatingGroupMovingAverages,
+			Value:  ma,
+			Vote:   vote,
+			Action: voteAction(vote),
+		})
+	}
+	return components
+}
+
+func tallyRatingGroup(components []RatingComponent, group string) RatingGroup {
+	g := RatingGroup{}
+	for _, c := range components {
+		if c.Group != group {
+			continue
+		}
+		switch {
+		case c.Vote > 0:
+			g.Buy++
+		case c.Vote < 0:
+			g.Sell++
+		default:
+			g.Neutral++
+		}
+	}
+	if total := g.Buy + g.Neutral + g.Sell; total > 0 {
+		g.Score = math.Round(float64(g.Buy-g.Sell)/float64(total)*100) / 100
+	}
+	g.Rating = RatingLabel(g.Score)
+	return g
+}
+
+// thresholdVote buys below lower and sells above upper.
+func thresholdVote(v, lower, upper float64) int {
+	switch {
+	case v < lower:
+		return 1
+	case v > upper:
+		return -1
+	default:
+		return 0
+	}
+}
+
+func voteAction(vote int) string {
+	switch {
+	case vote > 0:
+		return RatingBuy
+	case vote < 0:
+		return RatingSell
+	default:
+		return RatingNeutral
+	}
+}
+
+// stochastic returns the latest slow %K (raw %K smoothed over smoothK) and
+// its %D (smoothD-period SMA of slow %K).
+func stochastic(closes, highs, lows []float64, period, smoothK, smoothD int) (k, d float64, ok bool) {
+	n := len(closes)
+	need := period + smoothK + smoothD - 2
+	if n < need {
+		return 0, 0, false
+	}
+
+	raw := make([]float64, 0, smoothK+smoothD-1)
+	for end := n - (smoothK + smoothD - 2); end <= n; end++ {
+		hi, lo := highest(highs[end-period:end]), lowest(lows[end-period:end])
+		v := 50.0
+		if hi > lo {
+			v = (closes[end-1] - lo) / (hi - lo) * 100
+		}
+		raw = append(raw, v)
+	}
+
+	slow := make([]float64, 0, smoothD)
+	for i := smoothK; i <= len(raw); i++ {
+		slow = append(slow, mean(raw[i-smoothK:i]))
+	}
+	return slow[len(slow)-1], mean(slow), true
+}
+
+func commodityChannelIndex(closes, highs, lows []float64, period int) (float64, bool) {
+	n := len(closes)
+	if n < period {
+		return 0, false
+	}
+	tp := make([]float64, period)
+	for i := range tp {
+		j := n - period + i
+		tp[i] = (highs[j] + lows[j] + closes[j]) / 3
+	}
+	avg := mean(tp)
+	dev := 0.0
+	for _, v := range tp {
+		dev += math.Abs(v - avg)
+	}
+	dev /= float64(period)
+	if dev == 0 {
+		return 0, true
+	}
+	return (tp[period-1] - avg) / (0.015 * dev), true
+}
+
+func williamsR(closes, highs, lows []float64, period int) (float64, bool) {
+	n := len(closes)
+	if n < period {
+		return 0, false
+	}
+	hi, lo := highest(highs[n-period:]), lowest(lows[n-period:])
+	if hi == lo {
+		return -50, true
+	}
+	return (hi - closes[n-1]) / (hi - lo) * -100, true
+}
+
+func highest(values []float64) float64 {
+	m := math.Inf(-1)
+	for _, v := range values {
+		m = math.Max(m, v)
+	}
+	return m
+}
+
+func lowest(values []float64) float64 {
+	m := math.Inf(1)
+	for _, v := range values {
+		m = math.Min(m, v)
+	}
+	return m
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
@@ -0,0 +1,230 @@
+package service
+
+import (
+	"errors"
+	"math"
+)
+
+// Trend regimes.
+const (
+	RegimeTrendingUp   = "trending_up"
+	RegimeTrendingDown = "trending_down"
+	RegimeRanging      = "ranging"
+	RegimeChoppy       = "choppy"
+)
+
+// Volatility regimes.
+const (
+	VolatilityCompressed = "compressed"
+	VolatilityNormal     = "normal"
+	VolatilityExpanding  = "expanding"
+)
+
+// Regime change events.
+const (
+	RegimeEventTrendChange      = "trend_change"
+	RegimeEventVolatilityChange = "volatility_change"
+	// RegimeEventSqueezeStarted is a volatility change into compressed.
+	RegimeEventSqueezeStarted = "squeeze_started"
+	// RegimeEventSqueezeReleased is a volatility change out of compressed.
+	RegimeEventSqueezeReleased = "squeeze_released"
+)
+
+const (
+	regimePeriod          = 14
+	regimeERPeriod        = 20
+	regimeBBPeriod        = 20
+	regimePercentileWidth = 100
+	regimeMinCandles      = 60
+)
+
+// MarketRegime labels the trend and volatility state at the bar Time.
+// Percentiles (0..100) rank the latest value against the previous 100 bars.
+type MarketRegime struct {
+	Time                int64   `json:"time"`
+	Trend               string  `json:"trend"`
+	Volatility          string  `json:"volatility"`
+	ADX                 float64 `json:"adx"`
+	PlusDI              float64 `json:"plusDi"`
+	MinusDI             float64 `json:"minusDi"`
+	EfficiencyRatio     float64 `json:"efficiencyRatio"`
+	NATR                float64 `json:"natr"`
+	NATRPercentile      float64 `json:"natrPercentile"`
+	BandWidth           float64 `json:"bandWidth"`
+	BandWidthPercentile float64 `json:"bandWidthPercentile"`
+}
+
+// RegimeEvent is a change of trend or volatility regime between two
+// consecutive bars; Time is the bar on which the new regime appeared.
+type RegimeEvent struct {
+	Type string `json:"type"`
+	From string `json:"from"`
+	To   string `json:"to"`
+	Time int64  `json:"time"`
+}
+
+// IsRegimeEvent reports whether name is a known regime event type.
+func IsRegimeEvent(name string) bool {
+	switch name {
+	case RegimeEventTrendChange, RegimeEventVolatilityChange, RegimeEventSqueezeStarted, RegimeEventSqueezeReleased:
+		return true
+	default:
+		return false
+	}
+}
+
+// ClassifyRegime labels the last candle.
+//
+// Volatility uses the mean of the Bollinger(20,2) bandwidth percentile and
+// the NATR(14) percentile: <= 20 compressed, >= 80 expanding, else normal.
+//
+// Trend: ADX(14) >= 25 with efficiency ratio(20) >= 0.3 is trending in the
+// direction of the dominant DI; efficiency ratio < 0.2 with NATR percentile
+// >= 50 is choppy (moving a lot, going nowhere); anything else is ranging.
+func ClassifyRegime(candles []Candle) (MarketRegime, error) {
+	if len(candles) < regimeMinCandles {
+		return MarketRegime{}, errors.New("not enough candles")
+	}
+	closes, highs, lows := CandleSeries(candles)
+
+	adx, err := ADX(highs, lows, closes, regimePeriod)
+	if err != nil {
+		return MarketRegime{}, err
+	}
+	natr, err := NATR(highs, lows, closes, regimePeriod)
+	if err != nil {
+		return MarketRegime{}, err
+	}
+	bw := bandWidthSeries(closes, regimeBBPeriod, 2.0)
+
+	r := MarketRegime{
+		Time:                candles[len(candles)-1].Time,
+		ADX:                 adx.ADX[len(adx.ADX)-1],
+		PlusDI:              adx.PlusDI[len(adx.PlusDI)-1],
+		MinusDI:             adx.MinusDI[len(adx.MinusDI)-1],
+		EfficiencyRatio:     efficiencyRatio(closes, regimeERPeriod),
+		NATR:                natr[len(natr)-1],
+		NATRPercentile:      lastPercentile(natr, regimePercentileWidth),
+		BandWidth:           bw[len(bw)-1],
+		BandWidthPercentile: lastPercentile(bw, regimePercentileWidth),
+	}
+
+	volScore := (r.NATRPercentile + r.BandWidthPercentile) / 2
+	switch {
+	case volScore <= 20:
+		r.Volatility = VolatilityCompressed
+	case volScore >= 80:
+		r.Volatility = VolatilityExpanding
+	default:
+		r.Volatility = VolatilityNormal
+	}
+
+	switch {
+	case r.ADX >= 25 && r.EfficiencyRatio >= 0.3:
+		r.Trend = RegimeTrendingUp
+		if r.MinusDI > r.PlusDI {
+			r.Trend = RegimeTrendingDown
+		}
+	case r.EfficiencyRatio < 0.2 && r.NATRPercentile >= 50:
+		r.Trend = RegimeChoppy
+	default:
+		r.Trend = RegimeRanging
+	}
+
+	return r, nil
+}
+
+// RegimeChanges classifies the last two candles and returns the latest
+// regime plus the events between them.
+func RegimeChanges(candles []Candle) (MarketRegime, []RegimeEvent, error) {
+	current, err := ClassifyRegime(candles)
+	if err != nil {
+		return MarketRegime{}, nil, err
+	}
+	previous, err := ClassifyRegime(candles[:len(candles)-1])
+	if err != nil {
+		return current, []RegimeEvent{}, nil
+	}
+	return current, CompareRegimes(previous, current), nil
+}
+
+// CompareRegimes lists the events that lead from prev to cur. A move out of
+// or into compression also emits the squeeze event.
+func CompareRegimes(prev, cur MarketRegime) []RegimeEvent {
+	events := make([]RegimeEvent, 0)
+	if prev.Trend != cur.Trend {
+		events = append(events, RegimeEvent{Type: RegimeEventTrendChange, From: prev.Trend, To: cur.Trend, Time: cur.Time})
+	}
+	if prev.Volatility != cur.Volatility {
+		events = append(events, RegimeEvent{Type: RegimeEventVolatilityChange, From: prev.Volatility, To: cur.Volatility, Time: cur.Time})
+		switch {
+		case prev.Volatility == VolatilityCompressed:
+			events = append(events, RegimeEvent{Type: RegimeEventSqueezeReleased, From: prev.Volatility, To: cur.Volatility, Time: cur.Time})
+		case cur.Volatility == VolatilityCompressed:
+			events = append(events, RegimeEvent{Type: RegimeEventSqueezeStarted, From: prev.Volatility, To: cur.Volatility, Time: cur.Time})
+		}
+	}
+	return events
+}
+
+// bandWidthSeries returns Bollinger bandwidth, (upper-lower)/middle in
+// percent, for every full window of closes.
+func bandWidthSeries(closes []float64, period int, stdMult float64) []float64 {
+	if len(closes) < period {
+		return nil
+	}
+	out := make([]float64, 0, len(closes)-period+1)
+	for end := period; end <= len(closes); end++ {
+		window := closes[end-period : end]
+		m := mean(window)
+		variance := 0.0
+		for _, v := range window {
+			variance += (v - m) * (v - m)
+		}
+		std := math.Sqrt(variance / float64(period))
+		bw := 0.0
+		if m != 0 {
+			bw = 100 * 2 * stdMult * std / m
+		}
+		out = append(out, bw)
+	}
+	return out
+}
+
+// efficiencyRatio is Kaufman's net move over the sum of absolute moves for
+// the last period closes (1 = straight line, 0 = no progress).
+func efficiencyRatio(closes []float64, period int) float64 {
+	n := len(closes)
+	if n <= period {
+		return 0
+	}
+	path := 0.0
+	for i := n - period; i < n; i++ {
+		path += math.Abs(closes[i] - closes[i-1])
+	}
+	if path == 0 {
+		return 0
+	}
+	return math.Abs(closes[n-1]-closes[n-1-period]) / path
+}
+
+// lastPercentile ranks the final value against the preceding width values
+// (0..100, share of values at or below it).
+func lastPercentile(series []float64, width int) float64 {
+	if len(series) < 2 {
+		return 50
+	}
+	last := series[len(series)-1]
+	start := len(series) - 1 - width
+	if start < 0 {
+		start = 0
+	}
+	window := series[start : len(series)-1]
+	below := 0
+	for _, v := range window {
+		if v <= last {
+			below++
+		}
+	}
+	return math.Round(float64(below)/float64(len(window))*1000) / 10
+}
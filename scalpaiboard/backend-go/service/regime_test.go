@@ -0,0 +1,114 @@
+package service
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// regimeCandles builds candles from closes with a high-low range of
+// spread percent around each close.
+func regimeCandles(closes []float64, spread []float64) []Candle {
+	candles := make([]Candle, len(closes))
+	for i, c := range closes {
+		half := c * spread[i] / 200
+		open := c
+		if i > 0 {
+			open = closes[i-1]
+		}
+		candles[i] = Candle{Time: int64(i * 3600), Open: open, High: math.Max(open, c) + half, Low: math.Min(open, c) - half, Close: c, Volume: 1}
+	}
+	return candles
+}
+
+func TestClassifyRegime(t *testing.T) {
+	const n = 160
+	trend := func(step float64) []Candle {
+		closes, spread := make([]float64, n), make([]float64, n)
+		for i := range closes {
+			closes[i] = 1000 + step*float64(i) + 2*math.Sin(float64(i))
+			spread[i] = 0.5
+		}
+		return regimeCandles(closes, spread)
+	}
+	// swings switches from wide to narrow swings (or back) for the last
+	// tail bars.
+	swings := func(before, after float64, tail int) []Candle {
+		closes, spread := make([]float64, n), make([]float64, n)
+		for i := range closes {
+			amp := before
+			if i >= n-tail {
+				amp = after
+			}
+			closes[i] = 1000 + amp*math.Sin(float64(i)*1.3)
+			spread[i] = amp / 10
+		}
+		return regimeCandles(closes, spread)
+	}
+
+	tests := []struct {
+		name       string
+		candles    []Candle
+		trend      string
+		volatility string
+	}{
+		{"steady rise", trend(5), RegimeTrendingUp, ""},
+		{"steady fall", trend(-5), RegimeTrendingDown, ""},
+		{"quiet after wide swings", swings(30, 1, 30), "", VolatilityCompressed},
+		{"wide swings after quiet", swings(1, 30, 5), "", VolatilityExpanding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ClassifyRegime(tt.candles)
+			if err != nil {
+				t.Fatalf("ClassifyRegime: %v", err)
+			}
+			if tt.trend != "" && r.Trend != tt.trend {
+				t.Errorf("trend %s, want %s (%+v)", r.Trend, tt.trend, r)
+			}
+			if tt.volatility != "" && r.Volatility != tt.volatility {
+				t.Errorf("volatility %s, want %s (%+v)", r.Volatility, tt.volatility, r)
+			}
+		})
+	}
+
+	if _, err := ClassifyRegime(trend(5)[:regimeMinCandles-1]); err == nil {
+		t.Error("ClassifyRegime accepted too few candles")
+	}
+}
+
+func TestCompareRegimes(t *testing.T) {
+	regime := func(trend, volatility string) MarketRegime {
+		return MarketRegime{Time: 60, Trend: trend, Volatility: volatility}
+	}
+	tests := []struct {
+		name      string
+		prev, cur MarketRegime
+		events    []string
+	}{
+		{"unchanged", regime(RegimeRanging, VolatilityNormal), regime(RegimeRanging, VolatilityNormal), []string{}},
+		{"trend only", regime(RegimeRanging, VolatilityNormal), regime(RegimeTrendingUp, VolatilityNormal),
+			[]string{RegimeEventTrendChange}},
+		{"squeeze starts", regime(RegimeRanging, VolatilityNormal), regime(RegimeRanging, VolatilityCompressed),
+			[]string{RegimeEventVolatilityChange, RegimeEventSqueezeStarted}},
+		{"squeeze releases into a trend", regime(RegimeRanging, VolatilityCompressed), regime(RegimeTrendingDown, VolatilityExpanding),
+			[]string{RegimeEventTrendChange, RegimeEventVolatilityChange, RegimeEventSqueezeReleased}},
+		{"normal to expanding", regime(RegimeChoppy, VolatilityNormal), regime(RegimeChoppy, VolatilityExpanding),
+			[]string{RegimeEventVolatilityChange}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := CompareRegimes(tt.prev, tt.cur)
+			types := make([]string, 0, len(events))
+			for _, ev := range events {
+				types = append(types, ev.Type)
+				if ev.Time != tt.cur.Time {
+					t.Errorf("%s at %d, want %d", ev.Type, ev.Time, tt.cur.Time)
+				}
+			}
+			if !reflect.DeepEqual(types, tt.events) {
+				t.Errorf("events %v, want %v", types, tt.events)
+			}
+		})
+	}
+}
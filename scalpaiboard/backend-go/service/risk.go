@@ -0,0 +1,219 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	defaultSizingFeePct         = 0.05
+	defaultMaintenanceMarginPct = 0.5
+	positionSizeMaxTargets      = 10
+	positionSizeMaxLeverage     = 125
+)
+
+// ErrInvalidPositionSize is returned for inconsistent sizing inputs.
+var ErrInvalidPositionSize = errors.New("invalid position size request")
+
+// PositionSizeRequest describes a planned trade. Risk is RiskAmount when
+// set, else RiskPct of AccountSize. FeePct (taker, per side) defaults to
+// 0.05 and MaintenanceMarginPct to 0.5.
+type PositionSizeRequest struct {
+	AccountSize          float64   `json:"accountSize"`
+	RiskPct              float64   `json:"riskPct"`
+	RiskAmount           float64   `json:"riskAmount"`
+	Entry                float64   `json:"entry"`
+	Stop                 float64   `json:"stop"`
+	Targets              []float64 `json:"targets"`
+	Leverage             float64   `json:"leverage"`
+	FeePct               *float64  `json:"feePct"`
+	MaintenanceMarginPct *float64  `json:"maintenanceMarginPct"`
+}
+
+// TargetPlan is the outcome of exiting the whole position at one target.
+// RMultiple is the price distance over the stop distance; NetRMultiple is
+// the net PnL after fees over the net risk.
+type TargetPlan struct {
+	Price        float64 `json:"price"`
+	RMultiple    float64 `json:"rMultiple"`
+	NetRMultiple float64 `json:"netRMultiple"`
+	PnL          float64 `json:"pnl"`
+	ExitFee      float64 `json:"exitFee"`
+}
+
+// PositionSize is a sized trade. Quantity is rounded down to the lot size
+// and prices to the tick size. RiskAmount is the loss at the stop including
+// entry and exit fees, which the sizing keeps within the risk budget.
+type PositionSize struct {
+	Side             string       `json:"side"`
+	Entry            float64      `json:"entry"`
+	Stop             float64      `json:"stop"`
+	Quantity         float64      `json:"quantity"`
+	Notional         float64      `json:"notional"`
+	Leverage         float64      `json:"leverage"`
+	RequiredMargin   float64      `json:"requiredMargin"`
+	RiskBudget       float64      `json:"riskBudget"`
+	RiskAmount       float64      `json:"riskAmount"`
+	RiskPct          float64      `json:"riskPct,omitempty"`
+	StopDistancePct  float64      `json:"stopDistancePct"`
+	EntryFee         float64      `json:"entryFee"`
+	StopExitFee      float64      `json:"stopExitFee"`
+	LiquidationPrice *float64     `json:"liquidationPrice,omitempty"`
+	Targets          []TargetPlan `json:"targets"`
+	Instrument       Instrument   `json:"instrument"`
+	Warnings         []string     `json:"warnings"`
+}
+
+// ComputePositionSize sizes a trade so that the loss at the stop, fees
+// included, stays within the risk budget, then rounds it to the
+// instrument's lot size. For perps it estimates the isolated-margin
+// liquidation price (ignoring fees and funding):
+//
+//	long:  entry × (1 − 1/leverage) / (1 − mmr)
+//	short: entry × (1 + 1/leverage) / (1 + mmr)
+func ComputePositionSize(inst Instrument, req PositionSizeRequest) (PositionSize, error) {
+	perp := inst.MarketType == "perp"
+	if req.Leverage == 0 {
+		req.Leverage = 1
+	}
+	if err := req.validate(perp, inst.MaxLeverage); err != nil {
+		return PositionSize{}, err
+	}
+
+	feePct, mmrPct := defaultSizingFeePct, defaultMaintenanceMarginPct
+	if req.FeePct != nil {
+		feePct = *req.FeePct
+	}
+	if req.MaintenanceMarginPct != nil {
+		mmrPct = *req.MaintenanceMarginPct
+	}
+	fee, mmr := feePct/100, mmrPct/100
+
+	// Rounding to the tick can take a price to zero or collapse the stop
+	// distance; both must survive it with the side the request asked for.
+	entry := RoundToStep(req.Entry, inst.TickSize)
+	stop := RoundToStep(req.Stop, inst.TickSize)
+	if entry <= 0 || stop <= 0 {
+		return PositionSize{}, fmt.Errorf("%w: entry and stop round to zero at tick size %g", ErrInvalidPositionSize, inst.TickSize)
+	}
+	if entry == stop {
+		return PositionSize{}, fmt.Errorf("%w: entry and stop are the same price at tick size %g", ErrInvalidPositionSize, inst.TickSize)
+	}
+	if (stop < entry) != (req.Stop < req.Entry) {
+		return PositionSize{}, fmt.Errorf("%w: entry and stop change sides at tick size %g", ErrInvalidPositionSize, inst.TickSize)
+	}
+
+	out := PositionSize{
+		Side:            SideLong,
+		Entry:           entry,
+		Stop:            stop,
+		Leverage:        req.Leverage,
+		StopDistancePct: roundTo(100*math.Abs(entry-stop)/entry, 4),
+		Targets:         make([]TargetPlan, 0, len(req.Targets)),
+		Instrument:      inst,
+		Warnings:        make([]string, 0),
+	}
+	dir := 1.0
+	if stop > entry {
+		out.Side, dir = SideShort, -1
+	}
+
+	out.RiskBudget = req.RiskAmount
+	if out.RiskBudget == 0 {
+		out.RiskBudget = req.AccountSize * req.RiskPct / 100
+	}
+
+	// Loss per unit at the stop: the price move plus fees on both fills.
+	perUnit := math.Abs(entry-stop) + entry*fee + stop*fee
+	qty := FloorToStep(out.RiskBudget/perUnit, inst.LotSize)
+
+	if req.AccountSize > 0 {
+		maxQty := FloorToStep(req.AccountSize*req.Leverage/(entry*(1+fee)), inst.LotSize)
+		if qty > maxQty {
+			qty = maxQty
+			out.Warnings = append(out.Warnings, "size capped by account size and leverage; risk is below budget")
+		}
+	}
+	if qty <= 0 {
+		return PositionSize{}, fmt.Errorf("%w: risk budget is smaller than one lot (%g)", ErrInvalidPositionSize, inst.LotSize)
+	}
+	if inst.MinQty > 0 && qty < inst.MinQty {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("quantity is below the exchange minimum of %g", inst.MinQty))
+	}
+
+	out.Quantity = qty
+	out.Notional = roundTo(qty*entry, 8)
+	out.RequiredMargin = roundTo(out.Notional/req.Leverage, 8)
+	out.EntryFee = roundTo(out.Notional*fee, 8)
+	out.StopExitFee = roundTo(qty*stop*fee, 8)
+	out.RiskAmount = roundTo(qty*math.Abs(entry-stop)+out.EntryFee+out.StopExitFee, 8)
+	if req.AccountSize > 0 {
+		out.RiskPct = roundTo(100*out.RiskAmount/req.AccountSize, 4)
+	}
+	if inst.MinNotional > 0 && out.Notional < inst.MinNotional {
+		out.Warnings = append(out.Warnings, fmt.Sprintf("notional is below the exchange minimum of %g", inst.MinNotional))
+	}
+
+	if perp {
+		liq := entry * (1 - dir/req.Leverage) / (1 - dir*mmr)
+		liq = roundTo(math.Max(liq, 0), inst.PricePrecision)
+		out.LiquidationPrice = &liq
+		if dir*(stop-liq) <= 0 {
+			out.Warnings = append(out.Warnings, "stop is beyond the estimated liquidation price; lower the leverage")
+		}
+	}
+
+	for _, t := range req.Targets {
+		t = RoundToStep(t, inst.TickSize)
+		exitFee := qty * t * fee
+		pnl := dir*(t-entry)*qty - out.EntryFee - exitFee
+		out.Targets = append(out.Targets, TargetPlan{
+			Price:        t,
+			RMultiple:    roundTo(math.Abs(t-entry)/math.Abs(entry-stop), 2),
+			NetRMultiple: roundTo(pnl/out.RiskAmount, 2),
+			PnL:          roundTo(pnl, 8),
+			ExitFee:      roundTo(exitFee, 8),
+		})
+	}
+	return out, nil
+}
+
+func (r *PositionSizeRequest) validate(perp bool, maxLeverage float64) error {
+	if r.Entry <= 0 || r.Stop <= 0 {
+		return fmt.Errorf("%w: entry and stop must be positive", ErrInvalidPositionSize)
+	}
+	if r.AccountSize < 0 || r.RiskAmount < 0 || r.RiskPct < 0 || r.RiskPct > 100 {
+		return fmt.Errorf("%w: accountSize, riskAmount and riskPct must not be negative", ErrInvalidPositionSize)
+	}
+	if r.RiskAmount == 0 && (r.AccountSize == 0 || r.RiskPct == 0) {
+		return fmt.Errorf("%w: set riskAmount, or accountSize and riskPct", ErrInvalidPositionSize)
+	}
+	limit := float64(positionSizeMaxLeverage)
+	if maxLeverage > 0 {
+		limit = maxLeverage
+	}
+	if !perp {
+		limit = 1
+	}
+	if r.Leverage < 1 || r.Leverage > limit {
+		return fmt.Errorf("%w: leverage must be between 1 and %g", ErrInvalidPositionSize, limit)
+	}
+	if (r.FeePct != nil && (*r.FeePct < 0 || *r.FeePct > 1)) ||
+		(r.MaintenanceMarginPct != nil && (*r.MaintenanceMarginPct < 0 || *r.MaintenanceMarginPct > 50)) {
+		return fmt.Errorf("%w: feePct must be 0-1 and maintenanceMarginPct 0-50", ErrInvalidPositionSize)
+	}
+	if len(r.Targets) > positionSizeMaxTargets {
+		return fmt.Errorf("%w: at most %d targets", ErrInvalidPositionSize, positionSizeMaxTargets)
+	}
+	long := r.Stop < r.Entry
+	if !perp && !long {
+		return fmt.Errorf("%w: spot trades can only be long (stop below entry)", ErrInvalidPositionSize)
+	}
+	for _, t := range r.Targets {
+		if t <= 0 || (long && t <= r.Entry) || (!long && t >= r.Entry) {
+			return fmt.Errorf("%w: target %g is not on the profit side of entry", ErrInvalidPositionSize, t)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package service
+
+import "sort"
+
+// ScreenerColumn is a numeric value the screener can compute for a market
+// from its candles. Candle-derived signals (patterns and the like) are read
+// from the last closed bar, i.e. the second-to-last candle, because the
+// final candle of a live series is still forming.
+type ScreenerColumn struct {
+	Key         string `json:"key"`
+	Description string `json:"description"`
+
+	compute func(candles []Candle) (float64, bool)
+	// rating reads the column from the technical rating of the closed
+	// bars, which ComputeScreenerValues computes once for all such columns.
+	rating func(r TechnicalRating) float64
+	// bars is the fewest candles, forming one included, the column has a
+	// value on; span is the closed history in seconds it needs besides.
+	bars int
+	span int64
+}
+
+var screenerColumns = map[string]ScreenerColumn{
+	"close": {
+		Key:         "close",
+		Description: "Last close price",
+		compute: func(candles []Candle) (float64, bool) {
+			if len(candles) == 0 {
+				return 0, false
+			}
+			return candles[len(candles)-1].Close, true
+		},
+	},
+	"volume": {
+		Key:         "volume",
+		Description: "Volume of the last closed bar",
+		compute: func(candles []Candle) (float64, bool) {
+			if len(candles) < 2 {
+				return 0, false
+			}
+			return candles[len(candles)-2].Volume, true
+		},
+	},
+	"quote_volume_24h": {
+		Key:         "quote_volume_24h",
+		Description: "Quote volume (close × volume) of the closed bars of the last 24 hours; needs bars spanning 24 hours",
+		compute:     quoteVolume24h,
+		span:        24 * 60 * 60,
+	},
+	"rsi14": {
+		Key:         "rsi14",
+		Description: "RSI(14) of closes",
+		compute: func(candles []Candle) (float64, bool) {
+			closes, _, _ := CandleSeries(candles)
+			v, err := RSI(closes, 14)
+			return v, err == nil
+		},
+	},
+	"pattern_bull_score": {
+		Key:         "pattern_bull_score",
+		Description: "Sum of bullish candlestick pattern strengths on the last closed bar",
+		compute: func(candles []Candle) (float64, bool) {
+			bull, _, ok := lastClosedPatternScore(candles)
+			return bull, ok
+		},
+	},
+	"pattern_bear_score": {
+		Key:         "pattern_bear_score",
+		Description: "Sum of bearish candlestick pattern strengths on the last closed bar",
+		compute: func(candles []Candle) (float64, bool) {
+			_, bear, ok := lastClosedPatternScore(candles)
+			return bear, ok
+		},
+	},
+	"pattern_net_score": {
+		Key:         "pattern_net_score",
+		Description: "Bullish minus bearish pattern strength on the last closed bar",
+		compute: func(candles []Candle) (float64, bool) {
+			bull, bear, ok := lastClosedPatternScore(candles)
+			return bull - bear, ok
+		},
+	},
+	"rating_score": {
+		Key:         "rating_score",
+		Description: "Technical rating score of the closed bars, -1 (strong sell) to 1 (strong buy)",
+		rating:      func(r TechnicalRating) float64 { return r.Score },
+	},
+	"rating_oscillators": {
+		Key:         "rating_oscillators",
+		Description: "Technical rating score of the oscillator votes on the closed bars",
+		rating:      func(r TechnicalRating) float64 { return r.Oscillators.Score },
+	},
+	"rating_moving_averages": {
+		Key:         "rating_moving_averages",
+		Description: "Technical rating score of the moving average votes on the closed bars",
+		rating:      func(r TechnicalRating) float64 { return r.MovingAverages.Score },
+	},
+	"adx14": {
+		Key:         "adx14",
+		Description: "ADX(14)",
+		compute:     regimeColumn(func(r MarketRegime) float64 { return r.ADX }),
+		bars:        regimeMinCandles + 1,
+	},
+	"efficiency_ratio": {
+		Key:         "efficiency_ratio",
+		Description: "Kaufman efficiency ratio(20), 0 (noise) to 1 (straight line)",
+		compute:     regimeColumn(func(r MarketRegime) float64 { return r.EfficiencyRatio }),
+		bars:        regimeMinCandles + 1,
+	},
+	"natr14": {
+		Key:         "natr14",
+		Description: "NATR(14), ATR as a percentage of price",
+		compute:     regimeColumn(func(r MarketRegime) float64 { return r.NATR }),
+		bars:        regimeMinCandles + 1,
+	},
+	"natr_percentile": {
+		Key:         "natr_percentile",
+		Description: "Percentile of NATR(14) over the previous 100 bars",
+		compute:     regimeColumn(func(r MarketRegime) float64 { return r.NATRPercentile }),
+		bars:        regimeMinCandles + 1,
+	},
+	"bb_width_percentile": {
+		Key:         "bb_width_percentile",
+		Description: "Percentile of Bollinger(20,2) bandwidth over the previous 100 bars",
+		compute:     regimeColumn(func(r MarketRegime) float64 { return r.BandWidthPercentile }),
+		bars:        regimeMinCandles + 1,
+	},
+	"hv20": {
+		Key:         "hv20",
+		Description: "Annualised close-to-close realized volatility over 20 closed bars, in percent",
+		compute:     volatilityColumn(20, VolCloseToClose, false),
+		bars:        22,
+	},
+	"hv_yz20": {
+		Key:         "hv_yz20",
+		Description: "Annualised Yang-Zhang realized volatility over 20 closed bars, in percent",
+		compute:     volatilityColumn(20, VolYangZhang, false),
+		bars:        22,
+	},
+	"hv20_percentile": {
+		Key:         "hv20_percentile",
+		Description: "Percentile rank of hv20 among its rolling values over the fetched bars",
+		compute:     volatilityColumn(20, VolCloseToClose, true),
+		bars:        22,
+	},
+	"trend_regime": {
+		Key:         "trend_regime",
+		Description: "Trend regime: 1 trending up, -1 trending down, 0 ranging or choppy",
+		compute: regimeColumn(func(r MarketRegime) float64 {
+			switch r.Trend {
+			case RegimeTrendingUp:
+				return 1
+			case RegimeTrendingDown:
+				return -1
+			default:
+				return 0
+			}
+		}),
+		bars: regimeMinCandles + 1,
+	},
+	"volatility_regime": {
+		Key:         "volatility_regime",
+		Description: "Volatility regime: -1 compressed, 0 normal, 1 expanding",
+		compute: regimeColumn(func(r MarketRegime) float64 {
+			switch r.Volatility {
+			case VolatilityCompressed:
+				return -1
+			case VolatilityExpanding:
+				return 1
+			default:
+				return 0
+			}
+		}),
+		bars: regimeMinCandles + 1,
+	},
+}
+
+// ScreenerColumns returns all available columns ordered by key.
+func ScreenerColumns() []ScreenerColumn {
+	cols := make([]ScreenerColumn, 0, len(screenerColumns))
+	for _, col := range screenerColumns {
+		cols = append(cols, col)
+	}
+	sort.Slice(cols, func(i, j int) bool { return cols[i].Key < cols[j].Key })
+	return cols
+}
+
+// IsScreenerColumn reports whether key names a known column.
+func IsScreenerColumn(key string) bool {
+	_, ok := screenerColumns[key]
+	return ok
+}
+
+// ScreenerColumnBars returns how many candles of barSeconds a column needs
+// to have a value, or 0 for unknown columns.
+func ScreenerColumnBars(key string, barSeconds int64) int {
+	col, ok := screenerColumns[key]
+	if !ok {
+		return 0
+	}
+	bars := col.bars
+	if col.span > 0 && barSeconds > 0 {
+		// Closed bars spanning span before the forming one.
+		bars = max(bars, int((col.span+barSeconds-1)/barSeconds)+1)
+	}
+	return bars
+}
+
+// ComputeScreenerValues evaluates the given columns over candles. Columns
+// that cannot be computed (unknown key, not enough data) are omitted.
+func ComputeScreenerValues(candles []Candle, keys []string) map[string]float64 {
+	values := make(map[string]float64, len(keys))
+	var rating *TechnicalRating
+	rated := false
+	for _, key := range keys {
+		col, ok := screenerColumns[key]
+		if !ok {
+			continue
+		}
+		if col.rating != nil {
+			if !rated {
+				rating, rated = closedRating(candles), true
+			}
+			if rating != nil {
+				values[key] = col.rating(*rating)
+			}
+			continue
+		}
+		if v, ok := col.compute(candles); ok {
+			values[key] = v
+		}
+	}
+	return values
+}
+
+// closedRating returns the technical rating of the candles before the
+// forming one, or nil when it cannot be computed.
+func closedRating(candles []Candle) *TechnicalRating {
+	if len(candles) < 2 {
+		return nil
+	}
+	r, err := RatingOf(candles[:len(candles)-1])
+	if err != nil {
+		return nil
+	}
+	return &r
+}
+
+func lastClosedPatternScore(candles []Candle) (bullish, bearish float64, ok bool) {
+	if len(candles) < 2 {
+		return 0, 0, false
+	}
+	closed := candles[:len(candles)-1]
+	bullish, bearish = PatternScore(DetectCandlestickPatterns(closed), len(closed)-1)
+	return bullish, bearish, true
+}
+
+// regimeColumn classifies the closed bars, before the forming one.
+func regimeColumn(value func(MarketRegime) float64) func([]Candle) (float64, bool) {
+	return func(candles []Candle) (float64, bool) {
+		if len(candles) < 2 {
+			return 0, false
+		}
+		r, err := ClassifyRegime(candles[:len(candles)-1])
+		if err != nil {
+			return 0, false
+		}
+		return value(r), true
+	}
+}
+
+// quoteVolume24h sums close × volume over the closed bars of the 24 hours
+// before the forming bar.
+func quoteVolume24h(candles []Candle) (float64, bool) {
+	if len(candles) < 3 {
+		return 0, false
+	}
+	closed := candles[:len(candles)-1]
+	from := candles[len(candles)-1].Time - 24*60*60
+	if closed[0].Time > from {
+		return 0, false
+	}
+	sum := 0.0
+	for _, c := range closed {
+		if c.Time >= from {
+			sum += c.Close * c.Volume
+		}
+	}
+	return sum, true
+}
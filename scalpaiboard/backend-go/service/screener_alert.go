@@ -0,0 +1,855 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/scalpaiboard/backend/models"
+)
+
+// Events a screener alert notifies on.
+const (
+	ScreenerNotifyEnter = "enter"
+	ScreenerNotifyExit  = "exit"
+	ScreenerNotifyBoth  = "both"
+)
+
+// ScreenerCustomColumnPrefix selects a saved custom indicator as a screener
+// column, e.g. custom:rsi_ema.
+const ScreenerCustomColumnPrefix = "custom:"
+
+const (
+	maxScreenerAlertsPerUser = 10
+	maxScreenerAlertName     = 100
+	// DefaultScreenerDedup is how long a market's entry (or exit) is not
+	// notified again after it was.
+	DefaultScreenerDedup = 15 * time.Minute
+	maxScreenerDedup     = 24 * time.Hour
+	// screenerAlertWorkers bounds the scans run concurrently; each scan
+	// fetches its markets in parallel itself.
+	screenerAlertWorkers = 2
+	// screenerAlertBudget is how many market candle fetches one pass may
+	// make across all users' scans. Scans that do not fit are deferred to
+	// the next pass, where they go first.
+	screenerAlertBudget = 1000
+	maxScreenerBars     = 500
+)
+
+// ErrScreenerAlertNotFound is returned when a user has no screener alert
+// with the requested id.
+var ErrScreenerAlertNotFound = errors.New("screener alert not found")
+
+// ErrInvalidScreenerAlert is returned for bad queries or settings, or when
+// the per-user limit is reached.
+var ErrInvalidScreenerAlert = errors.New("invalid screener alert")
+
+// ErrScreenerBudgetExhausted is returned by a scan whose markets do not fit
+// in what is left of the pass's request budget.
+var ErrScreenerBudgetExhausted = errors.New("screener request budget exhausted")
+
+// ScreenerBudget is what is left of a pass's market candle fetches.
+type ScreenerBudget struct {
+	mu   sync.Mutex
+	left int
+}
+
+// Take reserves n fetches. It reserves nothing and reports false when fewer
+// are left.
+func (b *ScreenerBudget) Take(n int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n > b.left {
+		return false
+	}
+	b.left -= n
+	return true
+}
+
+// ScreenerQuery selects markets like the screener endpoint: Markets, or else
+// the Exchange/Type/Query universe (default Binance perpetuals), scanned on
+// Interval candles. A market matches when its column values are within Min
+// and Max; Columns are reported with entries and exits besides the bounded
+// ones. For example "any USDT perp with 5m NATR > 3 and 24h volume > $20M"
+// is {"query":"USDT","interval":"5m","bars":300,
+// "min":{"natr14":3,"quote_volume_24h":20000000}}.
+type ScreenerQuery struct {
+	Markets  []string           `json:"markets,omitempty"`
+	Exchange string             `json:"exchange,omitempty"`
+	Type     string             `json:"type,omitempty"`
+	Query    string             `json:"query,omitempty"`
+	Interval string             `json:"interval,omitempty"`
+	Bars     int                `json:"bars,omitempty"`
+	Min      map[string]float64 `json:"min,omitempty"`
+	Max      map[string]float64 `json:"max,omitempty"`
+	Columns  []string           `json:"columns,omitempty"`
+}
+
+// ScreenerScanFunc computes columns for the markets of a query's universe
+// and returns their values by marketId. Markets that could not be scanned
+// are absent. userID resolves custom columns. The markets' fetches are taken
+// from budget, and ErrScreenerBudgetExhausted returned when they do not fit.
+type ScreenerScanFunc func(userID string, q ScreenerQuery, columns []string, budget *ScreenerBudget) (map[string]map[string]float64, error)
+
+// normalize fills in the defaults and validates the query.
+func (q *ScreenerQuery) normalize() error {
+	for i, id := range q.Markets {
+		id = strings.ToUpper(strings.TrimSpace(id))
+		if !isAlertMarketID(id) {
+			return fmt.Errorf("%w: invalid marketId %q", ErrInvalidScreenerAlert, id)
+		}
+		q.Markets[i] = id
+	}
+	q.Exchange = strings.ToLower(strings.TrimSpace(q.Exchange))
+	if q.Exchange == "" {
+		q.Exchange = "binance"
+	}
+	q.Type = strings.ToLower(strings.TrimSpace(q.Type))
+	if q.Type == "" {
+		q.Type = "perp"
+	}
+	q.Query = strings.ToUpper(strings.TrimSpace(q.Query))
+	if q.Interval == "" {
+		q.Interval = "5m"
+	}
+	if !isAlertInterval(q.Interval) {
+		return fmt.Errorf("%w: invalid interval %q", ErrInvalidScreenerAlert, q.Interval)
+	}
+	auto := q.Bars == 0
+	if auto {
+		q.Bars = 150
+	}
+	if q.Bars < 20 || q.Bars > maxScreenerBars {
+		return fmt.Errorf("%w: bars must be between 20 and %d", ErrInvalidScreenerAlert, maxScreenerBars)
+	}
+	if len(q.Min)+len(q.Max) == 0 {
+		return fmt.Errorf("%w: at least one min or max bound required", ErrInvalidScreenerAlert)
+	}
+	for _, bounds := range []map[string]float64{q.Min, q.Max} {
+		for key, v := range bounds {
+			if !isScreenerAlertColumn(key) {
+				return fmt.Errorf("%w: unknown column %q", ErrInvalidScreenerAlert, key)
+			}
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("%w: invalid bound for %s", ErrInvalidScreenerAlert, key)
+			}
+		}
+	}
+	for _, key := range q.Columns {
+		if !isScreenerAlertColumn(key) {
+			return fmt.Errorf("%w: unknown column %q", ErrInvalidScreenerAlert, key)
+		}
+	}
+
+	// A bound on a column the bars are too few for would never match: the
+	// default bars grow to fit, explicit ones are rejected.
+	barSeconds, _ := IntervalSeconds(q.Interval)
+	for _, bounds := range []map[string]float64{q.Min, q.Max} {
+		for key := range bounds {
+			need := ScreenerColumnBars(key, barSeconds)
+			switch {
+			case need <= q.Bars:
+			case need > maxScreenerBars:
+				return fmt.Errorf("%w: %s needs %d bars on %s, more than %d; use a longer interval",
+					ErrInvalidScreenerAlert, key, need, q.Interval, maxScreenerBars)
+			case auto:
+				q.Bars = need
+			default:
+				return fmt.Errorf("%w: %s needs at least %d bars on %s", ErrInvalidScreenerAlert, key, need, q.Interval)
+			}
+		}
+	}
+	return nil
+}
+
+func isScreenerAlertColumn(key string) bool {
+	if name := strings.TrimPrefix(key, ScreenerCustomColumnPrefix); name != key {
+		return name != ""
+	}
+	return IsScreenerColumn(key)
+}
+
+// columns returns the bounded and reported columns, sorted.
+func (q ScreenerQuery) columns() []string {
+	set := make(map[string]bool)
+	for _, key := range q.Columns {
+		set[key] = true
+	}
+	for key := range q.Min {
+		set[key] = true
+	}
+	for key := range q.Max {
+		set[key] = true
+	}
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// customNames returns the saved indicators the query's columns use.
+func (q ScreenerQuery) customNames() []string {
+	names := make([]string, 0)
+	for _, key := range q.columns() {
+		if name := strings.TrimPrefix(key, ScreenerCustomColumnPrefix); name != key {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Matches reports whether column values are within the query's bounds.
+// Missing values never match.
+func (q ScreenerQuery) Matches(values map[string]float64) bool {
+	for key, min := range q.Min {
+		v, ok := values[key]
+		if !ok || v < min {
+			return false
+		}
+	}
+	for key, max := range q.Max {
+		v, ok := values[key]
+		if !ok || v > max {
+			return false
+		}
+	}
+	return true
+}
+
+// report picks the query's columns out of the scanned values.
+func (q ScreenerQuery) report(values map[string]float64) map[string]float64 {
+	out := make(map[string]float64)
+	for _, key := range q.columns() {
+		if v, ok := values[key]; ok {
+			out[key] = roundTo(v, 8)
+		}
+	}
+	return out
+}
+
+// scanKey identifies the scan a query needs, so alerts on the same universe
+// share it. Custom columns are per user.
+func (q ScreenerQuery) scanKey(userID string) string {
+	key, _ := json.Marshal([]interface{}{q.Markets, q.Exchange, q.Type, q.Query, q.Interval, q.Bars})
+	if len(q.customNames()) > 0 {
+		return userID + string(key)
+	}
+	return string(key)
+}
+
+func parseScreenerQuery(raw []byte) (ScreenerQuery, error) {
+	var q ScreenerQuery
+	if err := json.Unmarshal(raw, &q); err != nil {
+		return q, err
+	}
+	return q, q.normalize()
+}
+
+type ScreenerAlertService struct {
+	db               *sql.DB
+	customIndicators *CustomIndicatorService
+	notification     *NotificationService
+	scan             ScreenerScanFunc
+
+	// running is held by the scheduled pass, so passes do not overlap.
+	running sync.Mutex
+	// deferred holds the scans the last pass had no budget for.
+	deferred map[string]bool
+}
+
+func NewScreenerAlertService(db *sql.DB, customIndicators *CustomIndicatorService, notification *NotificationService,
+	scan ScreenerScanFunc) *ScreenerAlertService {
+	return &ScreenerAlertService{db: db, customIndicators: customIndicators, notification: notification, scan: scan}
+}
+
+const screenerAlertColumns = `id, user_id, name, query, notify_on, dedup_seconds, COALESCE(notification_type, 'in_app'),
+	is_active, last_run_at, created_at, updated_at`
+
+func scanScreenerAlert(row rowScanner) (*models.ScreenerAlert, error) {
+	var a models.ScreenerAlert
+	var query []byte
+	var lastRun sql.NullTime
+	if err := row.Scan(&a.ID, &a.UserID, &a.Name, &query, &a.NotifyOn, &a.DedupSeconds, &a.NotificationType,
+		&a.IsActive, &lastRun, &a.CreatedAt, &a.UpdatedAt); err != nil {
+		return nil, err
+	}
+	a.Query = query
+	if lastRun.Valid {
+		a.LastRunAt = &lastRun.Time
+	}
+	return &a, nil
+}
+
+// List returns the user's screener alerts, newest first
+func (s *ScreenerAlertService) List(userID string) ([]models.ScreenerAlert, error) {
+	rows, err := s.db.Query(`SELECT `+screenerAlertColumns+` FROM screener_alerts WHERE user_id = $1 ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]models.ScreenerAlert, 0)
+	for rows.Next() {
+		a, err := scanScreenerAlert(rows)
+		if err != nil {
+			continue
+		}
+		items = append(items, *a)
+	}
+	return items, nil
+}
+
+// Get returns one of the user's screener alerts.
+func (s *ScreenerAlertService) Get(userID string, id int) (*models.ScreenerAlert, error) {
+	a, err := scanScreenerAlert(s.db.QueryRow(`SELECT `+screenerAlertColumns+` FROM screener_alerts WHERE id = $1 AND user_id = $2`, id, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrScreenerAlertNotFound
+	}
+	return a, err
+}
+
+// Create saves a new screener alert. dedupSeconds defaults to
+// DefaultScreenerDedup.
+func (s *ScreenerAlertService) Create(userID, name string, q ScreenerQuery, notifyOn string, dedupSeconds *int,
+	notificationType string) (*models.ScreenerAlert, error) {
+
+	name = strings.TrimSpace(name)
+	if notifyOn == "" {
+		notifyOn = ScreenerNotifyBoth
+	}
+	dedup := int(DefaultScreenerDedup / time.Second)
+	if dedupSeconds != nil {
+		dedup = *dedupSeconds
+	}
+	if err := validateScreenerSettings(name, notifyOn, dedup); err != nil {
+		return nil, err
+	}
+	if err := s.checkQuery(userID, &q); err != nil {
+		return nil, err
+	}
+
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM screener_alerts WHERE user_id = $1", userID).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count >= maxScreenerAlertsPerUser {
+		return nil, fmt.Errorf("%w: limit of %d screener alerts reached", ErrInvalidScreenerAlert, maxScreenerAlertsPerUser)
+	}
+
+	raw, err := json.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+	return scanScreenerAlert(s.db.QueryRow(`
+		INSERT INTO screener_alerts (user_id, name, query, notify_on, dedup_seconds, notification_type)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+screenerAlertColumns, userID, name, raw, notifyOn, dedup, notificationType))
+}
+
+// Update changes a screener alert; nil fields are left unchanged. Changing
+// the query or reactivating the alert clears its match state, so the next
+// evaluation records the result set again without notifying.
+func (s *ScreenerAlertService) Update(userID string, id int, name *string, q *ScreenerQuery, notifyOn *string,
+	dedupSeconds *int, notificationType *string, isActive *bool) (*models.ScreenerAlert, error) {
+
+	a, err := s.Get(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	reset := false
+	if name != nil {
+		a.Name = strings.TrimSpace(*name)
+	}
+	if notifyOn != nil {
+		a.NotifyOn = *notifyOn
+	}
+	if dedupSeconds != nil {
+		a.DedupSeconds = *dedupSeconds
+	}
+	if notificationType != nil {
+		a.NotificationType = *notificationType
+	}
+	if isActive != nil {
+		reset = *isActive && !a.IsActive
+		a.IsActive = *isActive
+	}
+	if err := validateScreenerSettings(a.Name, a.NotifyOn, a.DedupSeconds); err != nil {
+		return nil, err
+	}
+	if q != nil {
+		if err := s.checkQuery(userID, q); err != nil {
+			return nil, err
+		}
+		raw, err := json.Marshal(q)
+		if err != nil {
+			return nil, err
+		}
+		// Stored queries are normalized, but JSONB does not keep their
+		// layout.
+		prev, err := parseScreenerQuery(a.Query)
+		if err != nil {
+			reset = true
+		} else if old, err := json.Marshal(prev); err != nil || string(old) != string(raw) {
+			reset = true
+		}
+		a.Query = raw
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	a, err = scanScreenerAlert(tx.QueryRow(`
+		UPDATE screener_alerts
+		SET name = $1, query = $2, notify_on = $3, dedup_seconds = $4, notification_type = $5, is_active = $6,
+			last_run_at = CASE WHEN $7 THEN NULL ELSE last_run_at END, updated_at = NOW()
+		WHERE id = $8 AND user_id = $9
+		RETURNING `+screenerAlertColumns,
+		a.Name, []byte(a.Query), a.NotifyOn, a.DedupSeconds, a.NotificationType, a.IsActive, reset, id, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrScreenerAlertNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if reset {
+		if _, err := tx.Exec("DELETE FROM screener_alert_markets WHERE screener_alert_id = $1", id); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Delete removes a screener alert with its state and events
+func (s *ScreenerAlertService) Delete(userID string, id int) error {
+	_, err := s.db.Exec("DELETE FROM screener_alerts WHERE id = $1 AND user_id = $2", id, userID)
+	return err
+}
+
+// Matches returns the markets currently in a screener alert's result set.
+func (s *ScreenerAlertService) Matches(userID string, id int) ([]models.ScreenerMatch, error) {
+	if _, err := s.Get(userID, id); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`
+		SELECT market_id, "values", changed_at
+		FROM screener_alert_markets
+		WHERE screener_alert_id = $1 AND matched = true
+		ORDER BY market_id
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]models.ScreenerMatch, 0)
+	for rows.Next() {
+		var m models.ScreenerMatch
+		var values []byte
+		if err := rows.Scan(&m.MarketID, &values, &m.Since); err != nil {
+			continue
+		}
+		_ = json.Unmarshal(values, &m.Values)
+		items = append(items, m)
+	}
+	return items, nil
+}
+
+// Events returns a screener alert's most recent notified entries and exits.
+func (s *ScreenerAlertService) Events(userID string, id, limit int) ([]models.ScreenerAlertEvent, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	rows, err := s.db.Query(`
+		SELECT e.id, e.screener_alert_id, e.market_id, e.event, e."values", e.notification_status,
+			   COALESCE(e.notification_channel, ''), COALESCE(e.error_message, ''), e.created_at
+		FROM screener_alert_events e
+		JOIN screener_alerts a ON e.screener_alert_id = a.id
+		WHERE e.screener_alert_id = $1 AND a.user_id = $2
+		ORDER BY e.created_at DESC, e.id DESC
+		LIMIT $3
+	`, id, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]models.ScreenerAlertEvent, 0)
+	for rows.Next() {
+		var ev models.ScreenerAlertEvent
+		var values []byte
+		if err := rows.Scan(&ev.ID, &ev.ScreenerAlertID, &ev.MarketID, &ev.Event, &values, &ev.NotificationStatus,
+			&ev.NotificationChannel, &ev.ErrorMessage, &ev.CreatedAt); err != nil {
+			continue
+		}
+		_ = json.Unmarshal(values, &ev.Values)
+		items = append(items, ev)
+	}
+	return items, nil
+}
+
+func validateScreenerSettings(name, notifyOn string, dedupSeconds int) error {
+	if name == "" || len(name) > maxScreenerAlertName {
+		return fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalidScreenerAlert, maxScreenerAlertName)
+	}
+	switch notifyOn {
+	case ScreenerNotifyEnter, ScreenerNotifyExit, ScreenerNotifyBoth:
+	default:
+		return fmt.Errorf("%w: unknown notifyOn %q", ErrInvalidScreenerAlert, notifyOn)
+	}
+	if dedupSeconds < 0 || time.Duration(dedupSeconds)*time.Second > maxScreenerDedup {
+		return fmt.Errorf("%w: dedupSeconds must be between 0 and %d", ErrInvalidScreenerAlert, int(maxScreenerDedup/time.Second))
+	}
+	return nil
+}
+
+// checkQuery normalizes a query and checks that its custom columns are the
+// user's valid indicators.
+func (s *ScreenerAlertService) checkQuery(userID string, q *ScreenerQuery) error {
+	if err := q.normalize(); err != nil {
+		return err
+	}
+	if names := q.customNames(); len(names) > 0 {
+		if _, err := s.customIndicators.Formulas(userID, names); err != nil {
+			if errors.Is(err, ErrCustomIndicatorNotFound) || errors.Is(err, ErrInvalidFormula) {
+				return fmt.Errorf("%w: %v", ErrInvalidScreenerAlert, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// screenerRun is an active screener alert loaded for evaluation.
+type screenerRun struct {
+	alert models.ScreenerAlert
+	query ScreenerQuery
+}
+
+// screenerState is the stored match state of a market.
+type screenerState struct {
+	matched   bool
+	lastEntry sql.NullTime
+	lastExit  sql.NullTime
+}
+
+// screenerChange is a market entering (matched) or leaving a result set.
+type screenerChange struct {
+	marketID string
+	matched  bool
+	values   map[string]float64
+	notify   bool
+	eventID  int
+}
+
+// EvaluateAll runs the active screener alerts. Alerts on the same universe
+// share one scan, and scans share the pass's request budget.
+func (s *ScreenerAlertService) EvaluateAll() {
+	if !s.running.TryLock() {
+		log.Println("⏭️ Previous screener alert evaluation still running, skipping")
+		return
+	}
+	defer s.running.Unlock()
+
+	start := time.Now()
+	groups, err := s.loadRuns()
+	if err != nil {
+		log.Printf("❌ Failed to fetch screener alerts: %v", err)
+		return
+	}
+
+	// Scans deferred by the last pass go first.
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if s.deferred[keys[i]] != s.deferred[keys[j]] {
+			return s.deferred[keys[i]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	var mu sync.Mutex
+	alerts, notified := 0, 0
+	deferred := make(map[string]bool)
+	budget := &ScreenerBudget{left: screenerAlertBudget}
+	sem := make(chan struct{}, screenerAlertWorkers)
+	wg := sync.WaitGroup{}
+	for _, key := range keys {
+		key, runs := key, groups[key]
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			columns := make([]string, 0)
+			for _, r := range runs {
+				for _, key := range r.query.columns() {
+					columns = appendScreenerColumn(columns, key)
+				}
+			}
+			values, err := s.scan(runs[0].alert.UserID, runs[0].query, columns, budget)
+			if errors.Is(err, ErrScreenerBudgetExhausted) {
+				mu.Lock()
+				deferred[key] = true
+				mu.Unlock()
+				return
+			}
+			if err != nil {
+				log.Printf("⚠️ Screener alert scan failed: %v", err)
+				return
+			}
+			n := 0
+			for _, r := range runs {
+				n += s.evaluate(r, values, time.Now())
+			}
+			mu.Lock()
+			alerts += len(runs)
+			notified += n
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	s.deferred = deferred
+
+	if len(deferred) > 0 {
+		log.Printf("⏭️ %d screener alert scans deferred to the next pass, over the budget of %d fetches",
+			len(deferred), screenerAlertBudget)
+	}
+	if alerts > 0 {
+		log.Printf("✅ Evaluated %d screener alerts in %d scans (%s), %d entries/exits notified",
+			alerts, len(groups), time.Since(start).Round(time.Millisecond), notified)
+	}
+}
+
+func appendScreenerColumn(list []string, key string) []string {
+	for _, v := range list {
+		if v == key {
+			return list
+		}
+	}
+	return append(list, key)
+}
+
+// loadRuns loads the active screener alerts grouped by scan.
+func (s *ScreenerAlertService) loadRuns() (map[string][]screenerRun, error) {
+	rows, err := s.db.Query(`SELECT ` + screenerAlertColumns + ` FROM screener_alerts WHERE is_active = true ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make(map[string][]screenerRun)
+	for rows.Next() {
+		a, err := scanScreenerAlert(rows)
+		if err != nil {
+			log.Printf("⚠️ Failed to scan screener alert: %v", err)
+			continue
+		}
+		q, err := parseScreenerQuery(a.Query)
+		if err != nil {
+			log.Printf("⚠️ Screener alert %d has an invalid query: %v", a.ID, err)
+			continue
+		}
+		key := q.scanKey(a.UserID)
+		groups[key] = append(groups[key], screenerRun{alert: *a, query: q})
+	}
+	return groups, rows.Err()
+}
+
+// evaluate compares a screener alert's result set with its stored state,
+// saves the entries and exits and notifies them. The first evaluation only
+// records the result set. It returns the number of notified changes.
+func (s *ScreenerAlertService) evaluate(r screenerRun, values map[string]map[string]float64, now time.Time) int {
+	a := r.alert
+	state, err := s.loadState(a.ID)
+	if err != nil {
+		log.Printf("⚠️ Failed to load screener alert %d state: %v", a.ID, err)
+		return 0
+	}
+
+	baseline := a.LastRunAt == nil
+	changes := make([]screenerChange, 0)
+	// Markets missing from the scan keep their state: a failed fetch is
+	// not an exit.
+	for marketID, v := range values {
+		matched := r.query.Matches(v)
+		st, known := state[marketID]
+		if matched == (known && st.matched) {
+			continue
+		}
+		c := screenerChange{marketID: marketID, matched: matched, values: r.query.report(v)}
+		c.notify = !baseline && screenerShouldNotify(a, st, matched, now)
+		changes = append(changes, c)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].marketID < changes[j].marketID })
+
+	if err := s.saveChanges(a, changes, now); err != nil {
+		log.Printf("❌ Failed to update screener alert %d: %v", a.ID, err)
+		return 0
+	}
+
+	notified := make([]screenerChange, 0)
+	for _, c := range changes {
+		if c.notify {
+			notified = append(notified, c)
+		}
+	}
+	if len(notified) > 0 {
+		s.notify(a, notified)
+	}
+	return len(notified)
+}
+
+// screenerShouldNotify reports whether an entry (matched) or exit is
+// notified: the alert wants the event and the market's previous one of the
+// same kind is outside the dedup window.
+func screenerShouldNotify(a models.ScreenerAlert, st screenerState, matched bool, now time.Time) bool {
+	event, last := ScreenerNotifyEnter, st.lastEntry
+	if !matched {
+		event, last = ScreenerNotifyExit, st.lastExit
+	}
+	if a.NotifyOn != ScreenerNotifyBoth && a.NotifyOn != event {
+		return false
+	}
+	return !last.Valid || now.Sub(last.Time) >= time.Duration(a.DedupSeconds)*time.Second
+}
+
+func (s *ScreenerAlertService) loadState(alertID int) (map[string]screenerState, error) {
+	rows, err := s.db.Query(`
+		SELECT market_id, matched, last_entry_at, last_exit_at
+		FROM screener_alert_markets
+		WHERE screener_alert_id = $1
+	`, alertID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	state := make(map[string]screenerState)
+	for rows.Next() {
+		var marketID string
+		var st screenerState
+		if err := rows.Scan(&marketID, &st.matched, &st.lastEntry, &st.lastExit); err != nil {
+			return nil, err
+		}
+		state[marketID] = st
+	}
+	return state, rows.Err()
+}
+
+// saveChanges stores the new match state of the changed markets, records
+// the notified ones as events and marks the alert as run, in one
+// transaction. It sets the event IDs of changes.
+func (s *ScreenerAlertService) saveChanges(a models.ScreenerAlert, changes []screenerChange, now time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	upsert, err := tx.Prepare(`
+		INSERT INTO screener_alert_markets
+			(screener_alert_id, market_id, matched, "values", changed_at, last_entry_at, last_exit_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (screener_alert_id, market_id) DO UPDATE
+		SET matched = EXCLUDED.matched,
+			"values" = EXCLUDED."values",
+			changed_at = EXCLUDED.changed_at,
+			last_entry_at = COALESCE(EXCLUDED.last_entry_at, screener_alert_markets.last_entry_at),
+			last_exit_at = COALESCE(EXCLUDED.last_exit_at, screener_alert_markets.last_exit_at)
+	`)
+	if err != nil {
+		return err
+	}
+	defer upsert.Close()
+	event, err := tx.Prepare(`
+		INSERT INTO screener_alert_events (screener_alert_id, market_id, event, "values", notification_channel)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`)
+	if err != nil {
+		return err
+	}
+	defer event.Close()
+
+	for i := range changes {
+		c := &changes[i]
+		values, err := json.Marshal(c.values)
+		if err != nil {
+			return err
+		}
+		var lastEntry, lastExit sql.NullTime
+		kind := ScreenerNotifyExit
+		if c.matched {
+			kind = ScreenerNotifyEnter
+		}
+		if c.notify && c.matched {
+			lastEntry = sql.NullTime{Time: now, Valid: true}
+		} else if c.notify {
+			lastExit = sql.NullTime{Time: now, Valid: true}
+		}
+		if _, err := upsert.Exec(a.ID, c.marketID, c.matched, values, now, lastEntry, lastExit); err != nil {
+			return err
+		}
+		if c.notify {
+			if err := event.QueryRow(a.ID, c.marketID, kind, values, a.NotificationType).Scan(&c.eventID); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := tx.Exec("UPDATE screener_alerts SET last_run_at = $1 WHERE id = $2", now, a.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// notify sends one notification for the changes of an evaluation and
+// records the outcome on their events.
+func (s *ScreenerAlertService) notify(a models.ScreenerAlert, changes []screenerChange) {
+	message := s.notification.FormatScreenerMessage(a.Name, changes)
+	status, errMsg := "sent", ""
+	events := make([]map[string]interface{}, 0, len(changes))
+	for _, c := range changes {
+		event := ScreenerNotifyExit
+		if c.matched {
+			event = ScreenerNotifyEnter
+		}
+		events = append(events, map[string]interface{}{"marketId": c.marketID, "event": event, "values": c.values})
+	}
+	data := map[string]interface{}{"screenerAlertId": a.ID, "events": events}
+	if err := s.notification.Deliver(a.UserID, a.NotificationType, NotificationKindScreener, "Screener Alert: "+a.Name,
+		message, data); err != nil {
+		status, errMsg = "failed", err.Error()
+	}
+
+	ids := make([]int64, 0, len(changes))
+	for _, c := range changes {
+		ids = append(ids, int64(c.eventID))
+	}
+	_, err := s.db.Exec(`
+		UPDATE screener_alert_events
+		SET notification_status = $1, error_message = $2
+		WHERE id = ANY($3)
+	`, status, errMsg, pq.Array(ids))
+	if err != nil {
+		log.Printf("❌ Failed to update screener alert events: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/scalpaiboard/backend/models"
+)
+
+func TestScreenerShouldNotify(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) sql.NullTime { return sql.NullTime{Time: now.Add(-d), Valid: true} }
+
+	tests := []struct {
+		name     string
+		notifyOn string
+		state    screenerState
+		matched  bool
+		want     bool
+	}{
+		{"first entry", ScreenerNotifyEnter, screenerState{}, true, true},
+		{"exit not wanted", ScreenerNotifyEnter, screenerState{matched: true}, false, false},
+		{"entry not wanted", ScreenerNotifyExit, screenerState{}, true, false},
+		{"exit wanted", ScreenerNotifyExit, screenerState{matched: true}, false, true},
+		{"re-entry within dedup", ScreenerNotifyBoth, screenerState{lastEntry: ago(10 * time.Minute)}, true, false},
+		{"re-entry after dedup", ScreenerNotifyBoth, screenerState{lastEntry: ago(15 * time.Minute)}, true, true},
+		{"exit dedup ignores entries", ScreenerNotifyBoth,
+			screenerState{matched: true, lastEntry: ago(time.Minute), lastExit: ago(time.Hour)}, false, true},
+		{"exit within dedup", ScreenerNotifyBoth, screenerState{matched: true, lastExit: ago(5 * time.Minute)}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := models.ScreenerAlert{NotifyOn: tt.notifyOn, DedupSeconds: int(DefaultScreenerDedup / time.Second)}
+			if got := screenerShouldNotify(a, tt.state, tt.matched, now); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScreenerQueryBars(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    ScreenerQuery
+		wantBars int
+		invalid  bool
+	}{
+		{"default bars", ScreenerQuery{Min: map[string]float64{"rsi14": 70}}, 150, false},
+		{"24h volume raises default bars on 5m", ScreenerQuery{Min: map[string]float64{"quote_volume_24h": 1e7}}, 289, false},
+		{"24h volume fits default bars on 1h", ScreenerQuery{Interval: "1h", Min: map[string]float64{"quote_volume_24h": 1e7}}, 150, false},
+		{"explicit bars too few for 24h", ScreenerQuery{Bars: 150, Min: map[string]float64{"quote_volume_24h": 1e7}}, 0, true},
+		{"24h of 1m bars is over the limit", ScreenerQuery{Interval: "1m", Min: map[string]float64{"quote_volume_24h": 1e7}}, 0, true},
+		{"regime needs more than explicit bars", ScreenerQuery{Bars: 30, Max: map[string]float64{"adx14": 20}}, 0, true},
+		{"reported columns need nothing", ScreenerQuery{Bars: 30, Min: map[string]float64{"rsi14": 70}, Columns: []string{"quote_volume_24h"}}, 30, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.query.normalize()
+			if tt.invalid {
+				if !errors.Is(err, ErrInvalidScreenerAlert) {
+					t.Fatalf("got %v, want ErrInvalidScreenerAlert", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("normalize: %v", err)
+			}
+			if tt.query.Bars != tt.wantBars {
+				t.Fatalf("bars %d, want %d", tt.query.Bars, tt.wantBars)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package service
+
+import "testing"
+
+func TestComputeScreenerRatingColumns(t *testing.T) {
+	closes := make([]float64, 0, 260)
+	for i := 0; i < 260; i++ {
+		closes = append(closes, 100+float64(i%17)-float64(i%5)*0.7+float64(i)*0.05)
+	}
+	candles := closeCandles(closes...)
+	keys := []string{"rating_score", "rating_oscillators", "rating_moving_averages"}
+
+	want, err := RatingOf(candles[:len(candles)-1])
+	if err != nil {
+		t.Fatalf("RatingOf: %v", err)
+	}
+	spiked := append([]Candle(nil), candles...)
+	last := &spiked[len(spiked)-1]
+	last.High, last.Close = 1000, 1000
+
+	for _, tt := range []struct {
+		name    string
+		candles []Candle
+	}{
+		{"closed bars", candles},
+		{"forming bar is ignored", spiked},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			values := ComputeScreenerValues(tt.candles, keys)
+			if values["rating_score"] != want.Score || values["rating_oscillators"] != want.Oscillators.Score ||
+				values["rating_moving_averages"] != want.MovingAverages.Score {
+				t.Fatalf("got %v, want %v/%v/%v", values, want.Score, want.Oscillators.Score, want.MovingAverages.Score)
+			}
+		})
+	}
+
+	if values := ComputeScreenerValues(candles[:1], keys); len(values) != 0 {
+		t.Fatalf("got %v for a lone forming bar, want none", values)
+	}
+}
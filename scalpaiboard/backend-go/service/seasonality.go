@@ -0,0 +1,348 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	// The runtime image has no zoneinfo; embed it for user timezones and
+	// the session zones below.
+	_ "time/tzdata"
+)
+
+// periodCompleteRatio is the share of a period's expected bars that must be
+// present for it to count; it tolerates exchange gaps and 23-hour DST days.
+const periodCompleteRatio = 0.9
+
+// ErrInvalidSeasonality is returned for unsupported interval and timezone
+// combinations.
+var ErrInvalidSeasonality = errors.New("invalid seasonality request")
+
+// TradingSession is a session window in its own local time, so DST shifts
+// are followed. Start and End are minutes after local midnight.
+type TradingSession struct {
+	Name     string
+	Timezone string
+	Start    int
+	End      int
+}
+
+// TradingSessions are the Asia, London and New York sessions.
+var TradingSessions = []TradingSession{
+	{Name: "asia", Timezone: "Asia/Tokyo", Start: 9 * 60, End: 18 * 60},
+	{Name: "london", Timezone: "Europe/London", Start: 8 * 60, End: 17 * 60},
+	{Name: "new_york", Timezone: "America/New_York", Start: 8 * 60, End: 17 * 60},
+}
+
+// SeasonalityBucket aggregates the periods (hours or days) that fall into
+// one hour of day, weekday or day of month. Returns and ranges are percent
+// of the period open; MeanCI is the 95% interval of the mean return and
+// HitRateCI the 95% Wilson interval of the share of up periods.
+// RelVolume is the bucket's average volume over the average of all periods.
+type SeasonalityBucket struct {
+	Key          int        `json:"key"`
+	Label        string     `json:"label"`
+	Samples      int        `json:"samples"`
+	MeanReturn   float64    `json:"meanReturn"`
+	MeanCI       [2]float64 `json:"meanReturnCI"`
+	MedianReturn float64    `json:"medianReturn"`
+	StdDev       float64    `json:"stdDev"`
+	AvgRange     float64    `json:"avgRange"`
+	HitRate      float64    `json:"hitRate"`
+	HitRateCI    [2]float64 `json:"hitRateCI"`
+	AvgVolume    float64    `json:"avgVolume"`
+	RelVolume    float64    `json:"relVolume"`
+}
+
+// SessionStats describes when a session sets its high and low. HighByHour
+// and LowByHour count sessions by the hour after the open in which the
+// extreme formed; the median minutes use the open time of the extreme bar.
+// DailyHighPct and DailyLowPct are the share of days (in the report
+// timezone) whose high or low formed inside the session; sessions overlap,
+// so these can add up to more than 100.
+type SessionStats struct {
+	Name             string  `json:"name"`
+	Timezone         string  `json:"timezone"`
+	Start            string  `json:"start"`
+	End              string  `json:"end"`
+	Samples          int     `json:"samples"`
+	MeanReturn       float64 `json:"meanReturn"`
+	AvgRange         float64 `json:"avgRange"`
+	HighByHour       []int   `json:"highByHour"`
+	LowByHour        []int   `json:"lowByHour"`
+	MedianHighMinute float64 `json:"medianHighMinute"`
+	MedianLowMinute  float64 `json:"medianLowMinute"`
+	HighFirstPct     float64 `json:"highFirstPct"`
+	DailyHighPct     float64 `json:"dailyHighPct"`
+	DailyLowPct      float64 `json:"dailyLowPct"`
+}
+
+// SeasonalityReport is the seasonality analysis of a market. Hour-of-day
+// buckets and sessions need bars of 1h or less.
+type SeasonalityReport struct {
+	Timezone   string              `json:"timezone"`
+	From       int64               `json:"from"`
+	To         int64               `json:"to"`
+	Days       int                 `json:"days"`
+	HourOfDay  []SeasonalityBucket `json:"hourOfDay,omitempty"`
+	DayOfWeek  []SeasonalityBucket `json:"dayOfWeek"`
+	DayOfMonth []SeasonalityBucket `json:"dayOfMonth"`
+	Sessions   []SessionStats      `json:"sessions,omitempty"`
+}
+
+// seasonPeriod is an hour or day built from consecutive bars. HighTime and
+// LowTime are the open times of the bars that set the extremes.
+type seasonPeriod struct {
+	start    time.Time
+	open     float64
+	high     float64
+	low      float64
+	close    float64
+	volume   float64
+	bars     int
+	highTime int64
+	lowTime  int64
+}
+
+func (p seasonPeriod) returnPct() float64 { return 100 * (p.close/p.open - 1) }
+func (p seasonPeriod) rangePct() float64  { return 100 * (p.high - p.low) / p.open }
+
+// AnalyzeSeasonality aggregates closed candles into hour-of-day, weekday and
+// day-of-month statistics in loc, plus session high/low timing. Intervals
+// above 1h only line up with local days in UTC, so they are limited to 1d
+// bars in UTC.
+func AnalyzeSeasonality(candles []Candle, barSeconds int64, loc *time.Location) (SeasonalityReport, error) {
+	intraday := barSeconds > 0 && barSeconds <= 3600 && 3600%barSeconds == 0
+	if !intraday && !(barSeconds == 86400 && loc.String() == "UTC") {
+		return SeasonalityReport{}, fmt.Errorf("%w: use an interval of 1h or less, or 1d in UTC", ErrInvalidSeasonality)
+	}
+
+	days := groupPeriods(candles, loc, barSeconds, 86400, localMidnight)
+	if len(days) < 7 {
+		return SeasonalityReport{}, errors.New("not enough history: need at least a week of complete days")
+	}
+
+	report := SeasonalityReport{
+		Timezone: loc.String(),
+		From:     days[0].start.Unix(),
+		To:       days[len(days)-1].start.Unix(),
+		Days:     len(days),
+		DayOfWeek: bucketize(days, 7, func(p seasonPeriod) int { return int(p.start.Weekday()) }, func(k int) string {
+			return time.Weekday(k).String()
+		}),
+		DayOfMonth: bucketize(days, 32, func(p seasonPeriod) int { return p.start.Day() }, func(k int) string {
+			return fmt.Sprintf("%d", k)
+		}),
+	}
+
+	if intraday {
+		hours := groupPeriods(candles, loc, barSeconds, 3600, func(t time.Time) time.Time {
+			// Truncated in absolute time so the repeated DST hour stays separate.
+			return t.Add(-time.Duration(t.Minute()*60+t.Second()) * time.Second)
+		})
+		report.HourOfDay = bucketize(hours, 24, func(p seasonPeriod) int { return p.start.Hour() }, func(k int) string {
+			return fmt.Sprintf("%02d:00", k)
+		})
+
+		sessions, err := sessionStats(candles, barSeconds, days)
+		if err != nil {
+			return SeasonalityReport{}, err
+		}
+		report.Sessions = sessions
+	}
+	return report, nil
+}
+
+// groupPeriods folds ascending candles into periods identified by the start
+// that periodStart derives from each bar's local open time, and keeps the
+// complete ones.
+func groupPeriods(candles []Candle, loc *time.Location, barSeconds, periodSeconds int64, periodStart func(time.Time) time.Time) []seasonPeriod {
+	expected := float64(periodSeconds / barSeconds)
+	periods := make([]seasonPeriod, 0)
+	var cur *seasonPeriod
+
+	flush := func() {
+		if cur != nil && float64(cur.bars) >= math.Ceil(expected*periodCompleteRatio) {
+			periods = append(periods, *cur)
+		}
+	}
+
+	for _, c := range candles {
+		start := periodStart(time.Unix(c.Time, 0).In(loc))
+		if cur == nil || !start.Equal(cur.start) {
+			flush()
+			cur = &seasonPeriod{start: start, open: c.Open, high: c.High, low: c.Low, highTime: c.Time, lowTime: c.Time}
+		}
+		if c.High > cur.high {
+			cur.high, cur.highTime = c.High, c.Time
+		}
+		if c.Low < cur.low {
+			cur.low, cur.lowTime = c.Low, c.Time
+		}
+		cur.close = c.Close
+		cur.volume += c.Volume
+		cur.bars++
+	}
+	flush()
+	return periods
+}
+
+func bucketize(periods []seasonPeriod, size int, keyOf func(seasonPeriod) int, label func(int) string) []SeasonalityBucket {
+	groups := make([][]seasonPeriod, size)
+	totalVolume := 0.0
+	for _, p := range periods {
+		k := keyOf(p)
+		groups[k] = append(groups[k], p)
+		totalVolume += p.volume
+	}
+	avgVolume := totalVolume / float64(len(periods))
+
+	buckets := make([]SeasonalityBucket, 0, size)
+	for k, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		returns, ranges := make([]float64, len(group)), make([]float64, len(group))
+		up, volume := 0, 0.0
+		for i, p := range group {
+			returns[i], ranges[i] = p.returnPct(), p.rangePct()
+			if returns[i] > 0 {
+				up++
+			}
+			volume += p.volume
+		}
+
+		n := float64(len(group))
+		avg, sd := mean(returns), math.Sqrt(sampleVariance(returns))
+		margin := 1.96 * sd / math.Sqrt(n)
+		hitLow, hitHigh := wilsonInterval(up, len(group))
+		sorted := append([]float64(nil), returns...)
+		sort.Float64s(sorted)
+
+		b := SeasonalityBucket{
+			Key:          k,
+			Label:        label(k),
+			Samples:      len(group),
+			MeanReturn:   roundTo(avg, 4),
+			MeanCI:       [2]float64{roundTo(avg-margin, 4), roundTo(avg+margin, 4)},
+			MedianReturn: roundTo(quantile(sorted, 0.5), 4),
+			StdDev:       roundTo(sd, 4),
+			AvgRange:     roundTo(mean(ranges), 4),
+			HitRate:      roundTo(100*float64(up)/n, 1),
+			HitRateCI:    [2]float64{roundTo(100*hitLow, 1), roundTo(100*hitHigh, 1)},
+			AvgVolume:    roundTo(volume/n, 4),
+		}
+		if avgVolume > 0 {
+			b.RelVolume = roundTo(volume/n/avgVolume, 3)
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
+
+// wilsonInterval is the 95% Wilson score interval for k successes in n.
+func wilsonInterval(k, n int) (float64, float64) {
+	if n == 0 {
+		return 0, 0
+	}
+	const z = 1.96
+	p, fn := float64(k)/float64(n), float64(n)
+	denom := 1 + z*z/fn
+	center := (p + z*z/(2*fn)) / denom
+	half := z * math.Sqrt(p*(1-p)/fn+z*z/(4*fn*fn)) / denom
+	return math.Max(center-half, 0), math.Min(center+half, 1)
+}
+
+func sessionStats(candles []Candle, barSeconds int64, days []seasonPeriod) ([]SessionStats, error) {
+	out := make([]SessionStats, 0, len(TradingSessions))
+	for _, session := range TradingSessions {
+		loc, err := time.LoadLocation(session.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		inSession := func(ts int64) bool {
+			t := time.Unix(ts, 0).In(loc)
+			m := t.Hour()*60 + t.Minute()
+			return m >= session.Start && m < session.End
+		}
+
+		// Session instances are the in-session bars grouped by the
+		// session's local date.
+		bars := make([]Candle, 0, len(candles))
+		for _, c := range candles {
+			if inSession(c.Time) {
+				bars = append(bars, c)
+			}
+		}
+		length := int64(session.End-session.Start) * 60
+		periods := groupPeriods(bars, loc, barSeconds, length, func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), t.Day(), session.Start/60, session.Start%60, 0, 0, loc)
+		})
+
+		hours := int((length + 3599) / 3600)
+		stats := SessionStats{
+			Name:       session.Name,
+			Timezone:   session.Timezone,
+			Start:      fmt.Sprintf("%02d:%02d", session.Start/60, session.Start%60),
+			End:        fmt.Sprintf("%02d:%02d", session.End/60, session.End%60),
+			Samples:    len(periods),
+			HighByHour: make([]int, hours),
+			LowByHour:  make([]int, hours),
+		}
+		if len(periods) > 0 {
+			returns, ranges := make([]float64, len(periods)), make([]float64, len(periods))
+			highMinutes, lowMinutes := make([]float64, len(periods)), make([]float64, len(periods))
+			highFirst := 0
+			for i, p := range periods {
+				open := p.start.Unix()
+				returns[i], ranges[i] = p.returnPct(), p.rangePct()
+				highMinutes[i] = float64(p.highTime-open) / 60
+				lowMinutes[i] = float64(p.lowTime-open) / 60
+				stats.HighByHour[clampHour(highMinutes[i], hours)]++
+				stats.LowByHour[clampHour(lowMinutes[i], hours)]++
+				if p.highTime < p.lowTime {
+					highFirst++
+				}
+			}
+			sort.Float64s(highMinutes)
+			sort.Float64s(lowMinutes)
+			n := float64(len(periods))
+			stats.MeanReturn = roundTo(mean(returns), 4)
+			stats.AvgRange = roundTo(mean(ranges), 4)
+			stats.MedianHighMinute = roundTo(quantile(highMinutes, 0.5), 1)
+			stats.MedianLowMinute = roundTo(quantile(lowMinutes, 0.5), 1)
+			stats.HighFirstPct = roundTo(100*float64(highFirst)/n, 1)
+		}
+
+		dailyHigh, dailyLow := 0, 0
+		for _, d := range days {
+			if inSession(d.highTime) {
+				dailyHigh++
+			}
+			if inSession(d.lowTime) {
+				dailyLow++
+			}
+		}
+		stats.DailyHighPct = roundTo(100*float64(dailyHigh)/float64(len(days)), 1)
+		stats.DailyLowPct = roundTo(100*float64(dailyLow)/float64(len(days)), 1)
+		out = append(out, stats)
+	}
+	return out, nil
+}
+
+func localMidnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func clampHour(minutes float64, hours int) int {
+	h := int(minutes / 60)
+	if h < 0 {
+		return 0
+	}
+	if h >= hours {
+		return hours - 1
+	}
+	return h
+}
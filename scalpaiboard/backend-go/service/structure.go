@@ -0,0 +1,722 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// Swing kinds and structure labels.
+const (
+	SwingHigh = "high"
+	SwingLow  = "low"
+
+	LabelHigherHigh = "HH"
+	LabelLowerHigh  = "LH"
+	LabelHigherLow  = "HL"
+	LabelLowerLow   = "LL"
+
+	BreakOfStructure  = "bos"
+	ChangeOfCharacter = "choch"
+)
+
+// Chart pattern types reported in ChartPattern.Type.
+const (
+	ChartDoubleTop            = "double_top"
+	ChartDoubleBottom         = "double_bottom"
+	ChartHeadAndShoulders     = "head_and_shoulders"
+	ChartInverseHeadShoulders = "inverse_head_and_shoulders"
+	ChartAscendingTriangle    = "ascending_triangle"
+	ChartDescendingTriangle   = "descending_triangle"
+	ChartSymmetricalTriangle  = "symmetrical_triangle"
+	ChartRisingWedge          = "rising_wedge"
+	ChartFallingWedge         = "falling_wedge"
+	ChartBullFlag             = "bull_flag"
+	ChartBearFlag             = "bear_flag"
+)
+
+// Structure detection tunables. Price tolerances are multiples of ATR(14).
+const (
+	structureATRPeriod         = 14
+	structureMaxSwingWidth     = 10
+	structureMinConvergence    = 0.8
+	structureFlatSlopeATR      = 0.5
+	structureEqualLevelATR     = 0.5
+	structureMinDepthATR       = 1.5
+	structureFlagPoleATR       = 4.0
+	structureFlagMaxRetrace    = 0.5
+	structureFlagMinBars       = 5
+	structureFlagMaxBars       = 20
+	structureFlagMaxPoleBars   = 10
+	structureFlagMinPoleBars   = 3
+	structureShoulderTolerance = 1.0
+)
+
+// ChartPoint is a time/price anchor. Time is Unix seconds and matches the
+// DrawingPoint shape ({time, price}) used by the frontend drawings.
+type ChartPoint struct {
+	Time  int64   `json:"time"`
+	Price float64 `json:"price"`
+	Label string  `json:"label,omitempty"`
+}
+
+// ChartLine is a segment between two anchors, drawable as a trendline.
+type ChartLine struct {
+	Start ChartPoint `json:"start"`
+	End   ChartPoint `json:"end"`
+	Label string     `json:"label,omitempty"`
+}
+
+// SwingPoint is a fractal swing high or low. Label compares it with the
+// previous swing of the same kind (HH/LH for highs, HL/LL for lows) and is
+// empty for the first one.
+type SwingPoint struct {
+	Index int     `json:"index"`
+	Time  int64   `json:"time"`
+	Price float64 `json:"price"`
+	Kind  string  `json:"kind"`
+	Label string  `json:"label,omitempty"`
+}
+
+// StructureBreak is a close through the most recent confirmed swing. It is a
+// break of structure (bos) when it continues the prevailing trend and a
+// change of character (choch) when it reverses it.
+type StructureBreak struct {
+	Type      string    `json:"type"`
+	Direction string    `json:"direction"`
+	Index     int       `json:"index"`
+	Time      int64     `json:"time"`
+	Level     float64   `json:"level"`
+	Line      ChartLine `json:"line"`
+}
+
+// ChartPattern is a multi-swing formation with the geometry needed to draw
+// it: labelled anchor points and the pattern's lines (neckline, trendlines,
+// flag pole). Confirmed is set once price has closed beyond the trigger
+// line.
+type ChartPattern struct {
+	Type       string       `json:"type"`
+	Direction  string       `json:"direction"`
+	StartIndex int          `json:"startIndex"`
+	EndIndex   int          `json:"endIndex"`
+	Confirmed  bool         `json:"confirmed"`
+	Confidence float64      `json:"confidence"`
+	Points     []ChartPoint `json:"points"`
+	Lines      []ChartLine  `json:"lines"`
+}
+
+// MarketStructure is the result of AnalyzeMarketStructure.
+type MarketStructure struct {
+	SwingWidth int              `json:"swingWidth"`
+	Trend      string           `json:"trend"`
+	Swings     []SwingPoint     `json:"swings"`
+	Breaks     []StructureBreak `json:"breaks"`
+	Patterns   []ChartPattern   `json:"patterns"`
+}
+
+// AnalyzeMarketStructure finds swings with the given fractal width (bars on
+// each side), labels them, detects BOS/CHoCH and recognizes chart patterns.
+func AnalyzeMarketStructure(candles []Candle, width int) (MarketStructure, error) {
+	if width < 1 || width > structureMaxSwingWidth {
+		return MarketStructure{}, errors.New("swing width must be between 1 and 10")
+	}
+	if len(candles) < 2*width+structureATRPeriod+1 {
+		return MarketStructure{}, errors.New("not enough candles")
+	}
+
+	swings := FindSwings(candles, width)
+	breaks, trend := DetectStructureBreaks(candles, swings, width)
+
+	return MarketStructure{
+		SwingWidth: width,
+		Trend:      trend,
+		Swings:     swings,
+		Breaks:     breaks,
+		Patterns:   DetectChartPatterns(candles, swings),
+	}, nil
+}
+
+// FindSwings returns fractal swing highs and lows ordered by index. A swing
+// high is strictly above the width bars before it and at least as high as
+// the width bars after it; lows mirror this.
+func FindSwings(candles []Candle, width int) []SwingPoint {
+	swings := make([]SwingPoint, 0)
+	var lastHigh, lastLow SwingPoint
+	haveHigh, haveLow := false, false
+
+	for i := width; i < len(candles)-width; i++ {
+		isHigh, isLow := true, true
+		for k := 1; k <= width; k++ {
+			if candles[i-k].High >= candles[i].High || candles[i+k].High > candles[i].High {
+				isHigh = false
+			}
+			if candles[i-k].Low <= candles[i].Low || candles[i+k].Low < candles[i].Low {
+				isLow = false
+			}
+		}
+
+		if isHigh {
+			s := SwingPoint{Index: i, Time: candles[i].Time, Price: candles[i].High, Kind: SwingHigh}
+			if haveHigh {
+				if s.Price > lastHigh.Price {
+					s.Label = LabelHigherHigh
+				} else {
+					s.Label = LabelLowerHigh
+				}
+			}
+			swings = append(swings, s)
+			lastHigh, haveHigh = s, true
+		}
+		if isLow {
+			s := SwingPoint{Index: i, Time: candles[i].Time, Price: candles[i].Low, Kind: SwingLow}
+			if haveLow {
+				if s.Price > lastLow.Price {
+					s.Label = LabelHigherLow
+				} else {
+					s.Label = LabelLowerLow
+				}
+			}
+			swings = append(swings, s)
+			lastLow, haveLow = s, true
+		}
+	}
+
+	return swings
+}
+
+// DetectStructureBreaks walks the candles and records each close beyond the
+// latest confirmed, unbroken swing. A swing only counts once width bars
+// have printed after it, so breaks never use future information. It also
+// returns the trend implied by the last break (neutral if none).
+func DetectStructureBreaks(candles []Candle, swings []SwingPoint, width int) ([]StructureBreak, string) {
+	breaks := make([]StructureBreak, 0)
+	trend := DirectionNeutral
+
+	var activeHigh, activeLow *SwingPoint
+	next := 0
+
+	for i := range candles {
+		for next < len(swings) && swings[next].Index+width <= i {
+			s := swings[next]
+			if s.Kind == SwingHigh {
+				activeHigh = &s
+			} else {
+				activeLow = &s
+			}
+			next++
+		}
+
+		close := candles[i].Close
+		if activeHigh != nil && i > activeHigh.Index && close > activeHigh.Price {
+			breaks = append(breaks, newStructureBreak(candles[i], i, *activeHigh, DirectionBullish, trend))
+			trend = DirectionBullish
+			activeHigh = nil
+		}
+		if activeLow != nil && i > activeLow.Index && close < activeLow.Price {
+			breaks = append(breaks, newStructureBreak(candles[i], i, *activeLow, DirectionBearish, trend))
+			trend = DirectionBearish
+			activeLow = nil
+		}
+	}
+
+	return breaks, trend
+}
+
+func newStructureBreak(c Candle, index int, swing SwingPoint, direction, trend string) StructureBreak {
+	breakType := BreakOfStructure
+	if trend != DirectionNeutral && trend != direction {
+		breakType = ChangeOfCharacter
+	}
+	return StructureBreak{
+		Type:      breakType,
+		Direction: direction,
+		Index:     index,
+		Time:      c.Time,
+		Level:     swing.Price,
+		Line: ChartLine{
+			Start: ChartPoint{Time: swing.Time, Price: swing.Price},
+			End:   ChartPoint{Time: c.Time, Price: swing.Price},
+			Label: breakType,
+		},
+	}
+}
+
+// DetectChartPatterns recognizes double tops/bottoms and head-and-shoulders
+// across the whole series, and triangles, wedges and flags forming at its
+// end. Tolerances are expressed in multiples of ATR(14).
+func DetectChartPatterns(candles []Candle, swings []SwingPoint) []ChartPattern {
+	closes, highs, lows := CandleSeries(candles)
+	atr, err := ATR(highs, lows, closes, structureATRPeriod)
+	if err != nil {
+		return []ChartPattern{}
+	}
+	atrAt := func(i int) float64 {
+		k := i - structureATRPeriod
+		if k < 0 {
+			k = 0
+		}
+		if k >= len(atr) {
+			k = len(atr) - 1
+		}
+		return atr[k]
+	}
+
+	var swingHighs, swingLows []SwingPoint
+	for _, s := range swings {
+		if s.Kind == SwingHigh {
+			swingHighs = append(swingHighs, s)
+		} else {
+			swingLows = append(swingLows, s)
+		}
+	}
+
+	patterns := make([]ChartPattern, 0)
+	patterns = append(patterns, doubleTopsBottoms(candles, swingHighs, swingLows, atrAt)...)
+	patterns = append(patterns, headAndShoulders(candles, swingHighs, swingLows, atrAt)...)
+	if p, ok := convergingPattern(candles, swingHighs, swingLows, atrAt(len(candles)-1)); ok {
+		patterns = append(patterns, p)
+	}
+	if p, ok := flagPattern(candles, atrAt(len(candles)-1)); ok {
+		patterns = append(patterns, p)
+	}
+
+	sort.SliceStable(patterns, func(i, j int) bool { return patterns[i].EndIndex < patterns[j].EndIndex })
+	return patterns
+}
+
+func doubleTopsBottoms(candles []Candle, swingHighs, swingLows []SwingPoint, atrAt func(int) float64) []ChartPattern {
+	out := make([]ChartPattern, 0)
+	last := candles[len(candles)-1]
+
+	for k := 1; k < len(swingHighs); k++ {
+		a, b := swingHighs[k-1], swingHighs[k]
+		trough, ok := extremeBetween(candles, a.Index, b.Index, false)
+		if !ok {
+			continue
+		}
+		atr := atrAt(b.Index)
+		diff := math.Abs(a.Price - b.Price)
+		depth := math.Min(a.Price, b.Price) - trough.Price
+		if atr <= 0 || diff > structureEqualLevelATR*atr || depth < structureMinDepthATR*atr {
+			continue
+		}
+
+		confirmIdx := firstCloseBeyond(candles, b.Index+1, trough.Price, false)
+		end := last
+		if confirmIdx >= 0 {
+			end = candles[confirmIdx]
+		}
+		out = append(out, ChartPattern{
+			Type:       ChartDoubleTop,
+			Direction:  DirectionBearish,
+			StartIndex: a.Index,
+			EndIndex:   b.Index,
+			Confirmed:  confirmIdx >= 0,
+			Confidence: patternConfidence(1-diff/(structureEqualLevelATR*atr), confirmIdx >= 0),
+			Points: []ChartPoint{
+				{Time: a.Time, Price: a.Price, Label: "top1"},
+				{Time: trough.Time, Price: trough.Price, Label: "neckline"},
+				{Time: b.Time, Price: b.Price, Label: "top2"},
+			},
+			Lines: []ChartLine{{
+				Start: ChartPoint{Time: a.Time, Price: trough.Price},
+				End:   ChartPoint{Time: end.Time, Price: trough.Price},
+				Label: "neckline",
+			}},
+		})
+	}
+
+	for k := 1; k < len(swingLows); k++ {
+		a, b := swingLows[k-1], swingLows[k]
+		peak, ok := extremeBetween(candles, a.Index, b.Index, true)
+		if !ok {
+			continue
+		}
+		atr := atrAt(b.Index)
+		diff := math.Abs(a.Price - b.Price)
+		height := peak.Price - math.Max(a.Price, b.Price)
+		if atr <= 0 || diff > structureEqualLevelATR*atr || height < structureMinDepthATR*atr {
+			continue
+		}
+
+		confirmIdx := firstCloseBeyond(candles, b.Index+1, peak.Price, true)
+		end := last
+		if confirmIdx >= 0 {
+			end = candles[confirmIdx]
+		}
+		out = append(out, ChartPattern{
+			Type:       ChartDoubleBottom,
+			Direction:  DirectionBullish,
+			StartIndex: a.Index,
+			EndIndex:   b.Index,
+			Confirmed:  confirmIdx >= 0,
+			Confidence: patternConfidence(1-diff/(structureEqualLevelATR*atr), confirmIdx >= 0),
+			Points: []ChartPoint{
+				{Time: a.Time, Price: a.Price, Label: "bottom1"},
+				{Time: peak.Time, Price: peak.Price, Label: "neckline"},
+				{Time: b.Time, Price: b.Price, Label: "bottom2"},
+			},
+			Lines: []ChartLine{{
+				Start: ChartPoint{Time: a.Time, Price: peak.Price},
+				End:   ChartPoint{Time: end.Time, Price: peak.Price},
+				Label: "neckline",
+			}},
+		})
+	}
+
+	return out
+}
+
+func headAndShoulders(candles []Candle, swingHighs, swingLows []SwingPoint, atrAt func(int) float64) []ChartPattern {
+	out := make([]ChartPattern, 0)
+
+	build := func(l, h, r SwingPoint, inverse bool) (ChartPattern, bool) {
+		atr := atrAt(r.Index)
+		if atr <= 0 {
+			return ChartPattern{}, false
+		}
+		t1, ok1 := extremeBetween(candles, l.Index, h.Index, inverse)
+		t2, ok2 := extremeBetween(candles, h.Index, r.Index, inverse)
+		if !ok1 || !ok2 {
+			return ChartPattern{}, false
+		}
+
+		shoulderDiff := math.Abs(l.Price - r.Price)
+		var headMargin float64
+		if inverse {
+			headMargin = math.Min(l.Price, r.Price) - h.Price
+		} else {
+			headMargin = h.Price - math.Max(l.Price, r.Price)
+		}
+		if shoulderDiff > structureShoulderTolerance*atr || headMargin < structureEqualLevelATR*atr {
+			return ChartPattern{}, false
+		}
+
+		// Neckline through both troughs (peaks for the inverse pattern).
+		slope := (t2.Price - t1.Price) / float64(t2.Index-t1.Index)
+		neckAt := func(i int) float64 { return t1.Price + slope*float64(i-t1.Index) }
+
+		confirmIdx := -1
+		for i := r.Index + 1; i < len(candles); i++ {
+			if (!inverse && candles[i].Close < neckAt(i)) || (inverse && candles[i].Close > neckAt(i)) {
+				confirmIdx = i
+				break
+			}
+		}
+		endIdx := len(candles) - 1
+		if confirmIdx >= 0 {
+			endIdx = confirmIdx
+		}
+
+		p := ChartPattern{
+			Type:       ChartHeadAndShoulders,
+			Direction:  DirectionBearish,
+			StartIndex: l.Index,
+			EndIndex:   r.Index,
+			Confirmed:  confirmIdx >= 0,
+			Confidence: patternConfidence(1-shoulderDiff/(structureShoulderTolerance*atr), confirmIdx >= 0),
+			Points: []ChartPoint{
+				{Time: l.Time, Price: l.Price, Label: "left_shoulder"},
+				{Time: t1.Time, Price: t1.Price, Label: "neckline1"},
+				{Time: h.Time, Price: h.Price, Label: "head"},
+				{Time: t2.Time, Price: t2.Price, Label: "neckline2"},
+				{Time: r.Time, Price: r.Price, Label: "right_shoulder"},
+			},
+			Lines: []ChartLine{{
+				Start: ChartPoint{Time: candles[l.Index].Time, Price: neckAt(l.Index)},
+				End:   ChartPoint{Time: candles[endIdx].Time, Price: neckAt(endIdx)},
+				Label: "neckline",
+			}},
+		}
+		if inverse {
+			p.Type = ChartInverseHeadShoulders
+			p.Direction = DirectionBullish
+		}
+		return p, true
+	}
+
+	for k := 2; k < len(swingHighs); k++ {
+		if p, ok := build(swingHighs[k-2], swingHighs[k-1], swingHighs[k], false); ok {
+			out = append(out, p)
+		}
+	}
+	for k := 2; k < len(swingLows); k++ {
+		if p, ok := build(swingLows[k-2], swingLows[k-1], swingLows[k], true); ok {
+			out = append(out, p)
+		}
+	}
+
+	return out
+}
+
+// convergingPattern fits trendlines through the last three swing highs and
+// lows and classifies their slopes as a triangle or wedge.
+func convergingPattern(candles []Candle, swingHighs, swingLows []SwingPoint, atr float64) (ChartPattern, bool) {
+	if len(swingHighs) < 2 || len(swingLows) < 2 || atr <= 0 {
+		return ChartPattern{}, false
+	}
+	hs := lastSwings(swingHighs, 3)
+	ls := lastSwings(swingLows, 3)
+
+	start := hs[0].Index
+	if ls[0].Index < start {
+		start = ls[0].Index
+	}
+	end := len(candles) - 1
+	span := float64(end - start)
+	if span <= 0 {
+		return ChartPattern{}, false
+	}
+
+	uSlope, uIntercept := fitSwingLine(hs)
+	lSlope, lIntercept := fitSwingLine(ls)
+	upper := func(i int) float64 { return uIntercept + uSlope*float64(i) }
+	lower := func(i int) float64 { return lIntercept + lSlope*float64(i) }
+
+	widthStart := upper(start) - lower(start)
+	widthEnd := upper(end) - lower(end)
+	if widthStart <= 0 || widthEnd <= 0 || widthEnd > structureMinConvergence*widthStart {
+		return ChartPattern{}, false
+	}
+
+	// Slopes expressed as price change over the pattern in ATR units.
+	uMove := uSlope * span / atr
+	lMove := lSlope * span / atr
+	flat := func(m float64) bool { return math.Abs(m) < structureFlatSlopeATR }
+
+	var patternType, direction string
+	switch {
+	case flat(uMove) && lMove > 0:
+		patternType, direction = ChartAscendingTriangle, DirectionBullish
+	case uMove < 0 && flat(lMove):
+		patternType, direction = ChartDescendingTriangle, DirectionBearish
+	case uMove < 0 && lMove > 0:
+		patternType, direction = ChartSymmetricalTriangle, DirectionNeutral
+	case uMove > 0 && lMove > 0:
+		patternType, direction = ChartRisingWedge, DirectionBearish
+	case uMove < 0 && lMove < 0:
+		patternType, direction = ChartFallingWedge, DirectionBullish
+	default:
+		return ChartPattern{}, false
+	}
+
+	last := candles[end]
+	confirmed := last.Close > upper(end) || last.Close < lower(end)
+
+	points := make([]ChartPoint, 0, len(hs)+len(ls))
+	for _, s := range hs {
+		points = append(points, ChartPoint{Time: s.Time, Price: s.Price, Label: "upper"})
+	}
+	for _, s := range ls {
+		points = append(points, ChartPoint{Time: s.Time, Price: s.Price, Label: "lower"})
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Time < points[j].Time })
+
+	return ChartPattern{
+		Type:       patternType,
+		Direction:  direction,
+		StartIndex: start,
+		EndIndex:   end,
+		Confirmed:  confirmed,
+		Confidence: patternConfidence(1-widthEnd/widthStart, confirmed),
+		Points:     points,
+		Lines: []ChartLine{
+			{
+				Start: ChartPoint{Time: candles[start].Time, Price: upper(start)},
+				End:   ChartPoint{Time: last.Time, Price: upper(end)},
+				Label: "upper",
+			},
+			{
+				Start: ChartPoint{Time: candles[start].Time, Price: lower(start)},
+				End:   ChartPoint{Time: last.Time, Price: lower(end)},
+				Label: "lower",
+			},
+		},
+	}, true
+}
+
+// flagPattern looks for a sharp pole followed by a shallow, flat or
+// counter-trend consolidation that runs to the last candle.
+func flagPattern(candles []Candle, atr float64) (ChartPattern, bool) {
+	n := len(candles)
+	if atr <= 0 {
+		return ChartPattern{}, false
+	}
+
+	best := ChartPattern{}
+	bestMove := 0.0
+
+	for flagLen := structureFlagMinBars; flagLen <= structureFlagMaxBars; flagLen++ {
+		poleEnd := n - 1 - flagLen
+		for poleLen := structureFlagMinPoleBars; poleLen <= structureFlagMaxPoleBars; poleLen++ {
+			poleStart := poleEnd - poleLen
+			if poleStart < 0 {
+				break
+			}
+			move := candles[poleEnd].Close - candles[poleStart].Close
+			if math.Abs(move) < structureFlagPoleATR*atr || math.Abs(move) <= bestMove {
+				continue
+			}
+
+			flag := candles[poleEnd+1:]
+			hi, lo := flag[0].High, flag[0].Low
+			for _, c := range flag {
+				hi = math.Max(hi, c.High)
+				lo = math.Min(lo, c.Low)
+			}
+
+			bull := move > 0
+			var retrace float64
+			if bull {
+				retrace = (candles[poleEnd].Close - lo) / move
+				if hi > candles[poleEnd].High+structureEqualLevelATR*atr {
+					continue
+				}
+			} else {
+				retrace = (hi - candles[poleEnd].Close) / -move
+				if lo < candles[poleEnd].Low-structureEqualLevelATR*atr {
+					continue
+				}
+			}
+			if retrace > structureFlagMaxRetrace {
+				continue
+			}
+
+			// The channel must drift against the pole or stay flat.
+			hiSlope, hiIntercept := fitLine(flag, poleEnd+1, func(c Candle) float64 { return c.High })
+			loSlope, loIntercept := fitLine(flag, poleEnd+1, func(c Candle) float64 { return c.Low })
+			mid := (hiSlope + loSlope) / 2 * float64(flagLen) / atr
+			if (bull && mid > structureFlatSlopeATR) || (!bull && mid < -structureFlatSlopeATR) {
+				continue
+			}
+
+			first, last := poleEnd+1, n-1
+			p := ChartPattern{
+				Type:       ChartBullFlag,
+				Direction:  DirectionBullish,
+				StartIndex: poleStart,
+				EndIndex:   last,
+				Confidence: patternConfidence(1-retrace/structureFlagMaxRetrace, false),
+				Points: []ChartPoint{
+					{Time: candles[poleStart].Time, Price: candles[poleStart].Close, Label: "pole_start"},
+					{Time: candles[poleEnd].Time, Price: candles[poleEnd].Close, Label: "pole_end"},
+				},
+				Lines: []ChartLine{
+					{
+						Start: ChartPoint{Time: candles[poleStart].Time, Price: candles[poleStart].Close},
+						End:   ChartPoint{Time: candles[poleEnd].Time, Price: candles[poleEnd].Close},
+						Label: "pole",
+					},
+					{
+						Start: ChartPoint{Time: candles[first].Time, Price: hiIntercept + hiSlope*float64(first)},
+						End:   ChartPoint{Time: candles[last].Time, Price: hiIntercept + hiSlope*float64(last)},
+						Label: "upper",
+					},
+					{
+						Start: ChartPoint{Time: candles[first].Time, Price: loIntercept + loSlope*float64(first)},
+						End:   ChartPoint{Time: candles[last].Time, Price: loIntercept + loSlope*float64(last)},
+						Label: "lower",
+					},
+				},
+			}
+			if !bull {
+				p.Type = ChartBearFlag
+				p.Direction = DirectionBearish
+			}
+			best = p
+			bestMove = math.Abs(move)
+		}
+	}
+
+	return best, bestMove > 0
+}
+
+// extremeBetween returns the highest high (high=true) or lowest low strictly
+// between two indices.
+func extremeBetween(candles []Candle, from, to int, high bool) (SwingPoint, bool) {
+	if to-from < 2 {
+		return SwingPoint{}, false
+	}
+	best := SwingPoint{Index: -1}
+	for i := from + 1; i < to; i++ {
+		price := candles[i].Low
+		if high {
+			price = candles[i].High
+		}
+		if best.Index < 0 || (high && price > best.Price) || (!high && price < best.Price) {
+			best = SwingPoint{Index: i, Time: candles[i].Time, Price: price}
+		}
+	}
+	return best, best.Index >= 0
+}
+
+// firstCloseBeyond returns the first index from start whose close is above
+// (above=true) or below level, or -1.
+func firstCloseBeyond(candles []Candle, start int, level float64, above bool) int {
+	for i := start; i < len(candles); i++ {
+		if (above && candles[i].Close > level) || (!above && candles[i].Close < level) {
+			return i
+		}
+	}
+	return -1
+}
+
+func lastSwings(swings []SwingPoint, n int) []SwingPoint {
+	if len(swings) <= n {
+		return swings
+	}
+	return swings[len(swings)-n:]
+}
+
+// fitSwingLine is a least-squares line through swing prices by bar index.
+func fitSwingLine(swings []SwingPoint) (slope, intercept float64) {
+	xs := make([]float64, len(swings))
+	ys := make([]float64, len(swings))
+	for i, s := range swings {
+		xs[i] = float64(s.Index)
+		ys[i] = s.Price
+	}
+	return leastSquares(xs, ys)
+}
+
+// fitLine is a least-squares line through value(c) for candles starting at
+// bar index offset.
+func fitLine(candles []Candle, offset int, value func(Candle) float64) (slope, intercept float64) {
+	xs := make([]float64, len(candles))
+	ys := make([]float64, len(candles))
+	for i, c := range candles {
+		xs[i] = float64(offset + i)
+		ys[i] = value(c)
+	}
+	return leastSquares(xs, ys)
+}
+
+func leastSquares(xs, ys []float64) (slope, intercept float64) {
+	n := float64(len(xs))
+	if n == 0 {
+		return 0, 0
+	}
+	var sx, sy, sxx, sxy float64
+	for i := range xs {
+		sx += xs[i]
+		sy += ys[i]
+		sxx += xs[i] * xs[i]
+		sxy += xs[i] * ys[i]
+	}
+	den := n*sxx - sx*sx
+	if den == 0 {
+		return 0, sy / n
+	}
+	slope = (n*sxy - sx*sy) / den
+	intercept = (sy - slope*sx) / n
+	return slope, intercept
+}
+
+// patternConfidence blends a 0..1 shape score with confirmation.
+func patternConfidence(shape float64, confirmed bool) float64 {
+	c := 0.7 * clamp01(shape)
+	if confirmed {
+		c += 0.3
+	}
+	return roundStrength(c)
+}
@@ -53,7 +53,18 @@ type TechnicalAnalysis struct {
 		RecentLow  float64     `json:"recentLow"`
 		Pivots     PivotLevels `json:"pivots"`
 		Lookback   int         `json:"lookback"`
+
+		// Optional extras selected via GetAnalysis "levels".
+		Levels                []SRLevel        `json:"levels,omitempty"`
+		Fibonacci             *FibonacciLevels `json:"fibonacci,omitempty"`
+		HigherTimeframePivots *PivotPoints     `json:"higherTimeframePivots,omitempty"`
 	} `json:"supportResistance"`
+
+	Rating TechnicalRating `json:"rating"`
+
+	// Custom holds the last value of each requested user formula indicator;
+	// indicators undefined on the last bar are omitted.
+	Custom map[string]float64 `json:"custom,omitempty"`
 }
 
 func ComputeTechnicalAnalysis(symbol, interval string, closes, highs, lows []float64, limit int) (TechnicalAnalysis, error) {
@@ -127,23 +138,32 @@ func ComputeTechnicalAnalysis(symbol, interval string, closes, highs, lows []flo
 	analysis.SupportResistance.RecentLow = recentLow
 	analysis.SupportResistance.Lookback = lookback
 
-	// Classic pivot points from latest completed candle
-	lastHigh := highs[len(highs)-1]
-	lastLow := lows[len(lows)-1]
-	lastClose := closes[len(closes)-1]
-	pivot := (lastHigh + lastLow + lastClose) / 3.0
+	// Classic pivot points from the last candle; callers whose last candle
+	// is still forming replace them with the previous candle's.
+	n := len(closes) - 1
+	analysis.SupportResistance.Pivots = ClassicPivotLevels(highs[n], lows[n], closes[n])
 
-	analysis.SupportResistance.Pivots = PivotLevels{
-		Pivot: pivot,
-		R1:    2*pivot - lastLow,
-		S1:    2*pivot - lastHigh,
-		R2:    pivot + (lastHigh - lastLow),
-		S2:    pivot - (lastHigh - lastLow),
+	rating, err := ComputeTechnicalRating(analysis, closes, highs, lows)
+	if err != nil {
+		return TechnicalAnalysis{}, err
 	}
+	analysis.Rating = rating
 
 	return analysis, nil
 }
 
+// ClassicPivotLevels returns the classic pivot levels of a bar.
+func ClassicPivotLevels(high, low, close float64) PivotLevels {
+	pivot := (high + low + close) / 3.0
+	return PivotLevels{
+		Pivot: pivot,
+		R1:    2*pivot - low,
+		S1:    2*pivot - high,
+		R2:    pivot + (high - low),
+		S2:    pivot - (high - low),
+	}
+}
+
 func intToKey(p int) string {
 	return "p" + strconvItoa(p)
 }
@@ -220,11 +240,21 @@ func EMAValue(values []float64, period int) (float64, error) {
 }
 
 func RSI(closes []float64, period int) (float64, error) {
+	series, err := RSISeries(closes, period)
+	if err != nil {
+		return 0, err
+	}
+	return series[len(series)-1], nil
+}
+
+// RSISeries returns Wilder's RSI for every close from index period onward,
+// so series[i] belongs to closes[i+period].
+func RSISeries(closes []float64, period int) ([]float64, error) {
 	if period <= 0 {
-		return 0, errors.New("invalid period")
+		return nil, errors.New("invalid period")
 	}
 	if len(closes) < period+1 {
-		return 0, errors.New("not enough data")
+		return nil, errors.New("not enough data")
 	}
 
 	gain := 0.0
@@ -241,6 +271,9 @@ func RSI(closes []float64, period int) (float64, error) {
 	avgGain := gain / float64(period)
 	avgLoss := loss / float64(period)
 
+	series := make([]float64, 0, len(closes)-period)
+	series = append(series, rsiValue(avgGain, avgLoss))
+
 	for i := period + 1; i < len(closes); i++ {
 		delta := closes[i] - closes[i-1]
 		g := 0.0
@@ -252,34 +285,64 @@ func RSI(closes []float64, period int) (float64, error) {
 		}
 		avgGain = (avgGain*float64(period-1) + g) / float64(period)
 		avgLoss = (avgLoss*float64(period-1) + l) / float64(period)
+		series = append(series, rsiValue(avgGain, avgLoss))
 	}
 
+	return series, nil
+}
+
+func rsiValue(avgGain, avgLoss float64) float64 {
 	if avgLoss == 0 {
-		return 100, nil
+		return 100
 	}
 	rs := avgGain / avgLoss
-	rsi := 100 - (100 / (1 + rs))
-	return rsi, nil
+	return 100 - (100 / (1 + rs))
 }
 
 func MACD(closes []float64, fastPeriod, slowPeriod, signalPeriod int) (MACDResult, error) {
+	series, err := MACDSeries(closes, fastPeriod, slowPeriod, signalPeriod)
+	if err != nil {
+		return MACDResult{}, err
+	}
+
+	last := len(series.Histogram) - 1
+	return MACDResult{
+		MACD:         series.MACD[last],
+		Signal:       series.Signal[last],
+		Histogram:    series.Histogram[last],
+		FastPeriod:   fastPeriod,
+		SlowPeriod:   slowPeriod,
+		SignalPeriod: signalPeriod,
+	}, nil
+}
+
+// MACDSeriesResult holds MACD, signal and histogram series aligned to the
+// end of the input: the last element of each belongs to the last close, and
+// all three are len(closes)-slowPeriod-signalPeriod+2 long.
+type MACDSeriesResult struct {
+	MACD      []float64
+	Signal    []float64
+	Histogram []float64
+}
+
+func MACDSeries(closes []float64, fastPeriod, slowPeriod, signalPeriod int) (MACDSeriesResult, error) {
 	if fastPeriod <= 0 || slowPeriod <= 0 || signalPeriod <= 0 {
-		return MACDResult{}, errors.New("invalid period")
+		return MACDSeriesResult{}, errors.New("invalid period")
 	}
 	if fastPeriod >= slowPeriod {
-		return MACDResult{}, errors.New("fast period must be < slow period")
+		return MACDSeriesResult{}, errors.New("fast period must be < slow period")
 	}
 	if len(closes) < slowPeriod+signalPeriod {
-		return MACDResult{}, errors.New("not enough data")
+		return MACDSeriesResult{}, errors.New("not enough data")
 	}
 
 	fastEMA, err := EMA(closes, fastPeriod)
 	if err != nil {
-		return MACDResult{}, err
+		return MACDSeriesResult{}, err
 	}
 	slowEMA, err := EMA(closes, slowPeriod)
 	if err != nil {
-		return MACDResult{}, err
+		return MACDSeriesResult{}, err
 	}
 
 	// align by the end: slowEMA starts later
@@ -291,22 +354,46 @@ func MACD(closes []float64, fastPeriod, slowPeriod, signalPeriod int) (MACDResul
 
 	signalEMA, err := EMA(macdSeries, signalPeriod)
 	if err != nil {
-		return MACDResult{}, err
+		return MACDSeriesResult{}, err
 	}
 
-	macdValue := macdSeries[len(macdSeries)-1]
-	signalValue := signalEMA[len(signalEMA)-1]
+	// signalEMA starts signalPeriod-1 values into macdSeries
+	macdAligned := macdSeries[len(macdSeries)-len(signalEMA):]
+	histogram := make([]float64, len(signalEMA))
+	for i := range signalEMA {
+		histogram[i] = macdAligned[i] - signalEMA[i]
+	}
 
-	return MACDResult{
-		MACD:         macdValue,
-		Signal:       signalValue,
-		Histogram:    macdValue - signalValue,
-		FastPeriod:   fastPeriod,
-		SlowPeriod:   slowPeriod,
-		SignalPeriod: signalPeriod,
+	return MACDSeriesResult{
+		MACD:      macdAligned,
+		Signal:    signalEMA,
+		Histogram: histogram,
 	}, nil
 }
 
+// OBV returns on-balance volume for every candle, starting at zero.
+func OBV(closes, volumes []float64) ([]float64, error) {
+	if len(closes) != len(volumes) {
+		return nil, errors.New("series length mismatch")
+	}
+	if len(closes) == 0 {
+		return nil, errors.New("not enough data")
+	}
+
+	obv := make([]float64, len(closes))
+	for i := 1; i < len(closes); i++ {
+		switch {
+		case closes[i] > closes[i-1]:
+			obv[i] = obv[i-1] + volumes[i]
+		case closes[i] < closes[i-1]:
+			obv[i] = obv[i-1] - volumes[i]
+		default:
+			obv[i] = obv[i-1]
+		}
+	}
+	return obv, nil
+}
+
 func BollingerBands(closes []float64, period int, stdMult float64) (BollingerBandsResult, error) {
 	if period <= 0 {
 		return BollingerBandsResult{}, errors.New("invalid period")
@@ -335,3 +422,149 @@ func BollingerBands(closes []float64, period int, stdMult float64) (BollingerBan
 		StdMult: stdMult,
 	}, nil
 }
+
+// ATR returns Wilder's average true range series. The first value covers
+// candles 0..period, so the series is len(closes)-period long.
+func ATR(highs, lows, closes []float64, period int) ([]float64, error) {
+	if period <= 0 {
+		return nil, errors.New("invalid period")
+	}
+	if len(highs) != len(closes) || len(lows) != len(closes) {
+		return nil, errors.New("series length mismatch")
+	}
+	if len(closes) < period+1 {
+		return nil, errors.New("not enough data")
+	}
+
+	trs := make([]float64, 0, len(closes)-1)
+	for i := 1; i < len(closes); i++ {
+		hl := highs[i] - lows[i]
+		hc := math.Abs(highs[i] - closes[i-1])
+		lc := math.Abs(lows[i] - closes[i-1])
+		trs = append(trs, math.Max(hl, math.Max(hc, lc)))
+	}
+
+	atr := 0.0
+	for i := 0; i < period; i++ {
+		atr += trs[i]
+	}
+	atr /= float64(period)
+
+	series := make([]float64, 0, len(trs)-period+1)
+	series = append(series, atr)
+	for i := period; i < len(trs); i++ {
+		atr = (atr*float64(period-1) + trs[i]) / float64(period)
+		series = append(series, atr)
+	}
+	return series, nil
+}
+
+// NATR returns ATR as a percentage of the close, aligned like ATR.
+func NATR(highs, lows, closes []float64, period int) ([]float64, error) {
+	atr, err := ATR(highs, lows, closes, period)
+	if err != nil {
+		return nil, err
+	}
+	natr := make([]float64, len(atr))
+	for i, v := range atr {
+		c := closes[i+period]
+		if c != 0 {
+			natr[i] = 100 * v / c
+		}
+	}
+	return natr, nil
+}
+
+// ADXSeriesResult holds Wilder's ADX and directional indicators, end-aligned
+// and of equal length.
+type ADXSeriesResult struct {
+	ADX     []float64
+	PlusDI  []float64
+	MinusDI []float64
+}
+
+// ADX returns Wilder's average directional index. The series is
+// len(closes)-2*period+1 long.
+func ADX(highs, lows, closes []float64, period int) (ADXSeriesResult, error) {
+	if period <= 0 {
+		return ADXSeriesResult{}, errors.New("invalid period")
+	}
+	if len(highs) != len(closes) || len(lows) != len(closes) {
+		return ADXSeriesResult{}, errors.New("series length mismatch")
+	}
+	if len(closes) < 2*period+1 {
+		return ADXSeriesResult{}, errors.New("not enough data")
+	}
+
+	n := len(closes)
+	trs := make([]float64, 0, n-1)
+	plusDM := make([]float64, 0, n-1)
+	minusDM := make([]float64, 0, n-1)
+	for i := 1; i < n; i++ {
+		hl := highs[i] - lows[i]
+		hc := math.Abs(highs[i] - closes[i-1])
+		lc := math.Abs(lows[i] - closes[i-1])
+		trs = append(trs, math.Max(hl, math.Max(hc, lc)))
+
+		up := highs[i] - highs[i-1]
+		down := lows[i-1] - lows[i]
+		pdm, mdm := 0.0, 0.0
+		if up > down && up > 0 {
+			pdm = up
+		}
+		if down > up && down > 0 {
+			mdm = down
+		}
+		plusDM = append(plusDM, pdm)
+		minusDM = append(minusDM, mdm)
+	}
+
+	var smTR, smPlus, smMinus float64
+	for i := 0; i < period; i++ {
+		smTR += trs[i]
+		smPlus += plusDM[i]
+		smMinus += minusDM[i]
+	}
+
+	p := float64(period)
+	plusDI := make([]float64, 0, len(trs)-period+1)
+	minusDI := make([]float64, 0, len(trs)-period+1)
+	dx := make([]float64, 0, len(trs)-period+1)
+	for i := period - 1; i < len(trs); i++ {
+		if i >= period {
+			smTR = smTR - smTR/p + trs[i]
+			smPlus = smPlus - smPlus/p + plusDM[i]
+			smMinus = smMinus - smMinus/p + minusDM[i]
+		}
+		pdi, mdi := 0.0, 0.0
+		if smTR > 0 {
+			pdi = 100 * smPlus / smTR
+			mdi = 100 * smMinus / smTR
+		}
+		d := 0.0
+		if pdi+mdi > 0 {
+			d = 100 * math.Abs(pdi-mdi) / (pdi + mdi)
+		}
+		plusDI = append(plusDI, pdi)
+		minusDI = append(minusDI, mdi)
+		dx = append(dx, d)
+	}
+
+	adx := 0.0
+	for i := 0; i < period; i++ {
+		adx += dx[i]
+	}
+	adx /= p
+
+	out := ADXSeriesResult{
+		ADX:     make([]float64, 0, len(dx)-period+1),
+		PlusDI:  plusDI[period-1:],
+		MinusDI: minusDI[period-1:],
+	}
+	out.ADX = append(out.ADX, adx)
+	for i := period; i < len(dx); i++ {
+		adx = (adx*(p-1) + dx[i]) / p
+		out.ADX = append(out.ADX, adx)
+	}
+	return out, nil
+}
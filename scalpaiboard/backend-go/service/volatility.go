@@ -0,0 +1,294 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Realized volatility estimators.
+const (
+	// VolCloseToClose is the standard deviation of log close-to-close returns.
+	VolCloseToClose = "close_to_close"
+	// VolParkinson uses the high-low range.
+	VolParkinson = "parkinson"
+	// VolGarmanKlass uses open, high, low and close.
+	VolGarmanKlass = "garman_klass"
+	// VolYangZhang combines gap (open vs previous close), open-to-close and
+	// Rogers-Satchell variance; it is robust to drift and gaps.
+	VolYangZhang = "yang_zhang"
+)
+
+const volMaxHorizon = 500
+
+// ErrInvalidVolatility is returned for bad windows, estimators or horizons.
+var ErrInvalidVolatility = errors.New("invalid volatility request")
+
+// VolEstimate is the current annualised volatility (percent) of one window
+// under each estimator.
+type VolEstimate struct {
+	Window       int     `json:"window"`
+	CloseToClose float64 `json:"closeToClose"`
+	Parkinson    float64 `json:"parkinson"`
+	GarmanKlass  float64 `json:"garmanKlass"`
+	YangZhang    float64 `json:"yangZhang"`
+}
+
+// VolConeRow is the distribution of one window's rolling volatility over
+// the history, with the current value and its percentile rank (0..100).
+type VolConeRow struct {
+	Window     int     `json:"window"`
+	Min        float64 `json:"min"`
+	P10        float64 `json:"p10"`
+	P25        float64 `json:"p25"`
+	Median     float64 `json:"median"`
+	P75        float64 `json:"p75"`
+	P90        float64 `json:"p90"`
+	Max        float64 `json:"max"`
+	Current    float64 `json:"current"`
+	Percentile float64 `json:"percentile"`
+	Samples    int     `json:"samples"`
+}
+
+// ExpectedMove is the ±1σ and ±2σ price range over the next Bars bars,
+// assuming log-normal returns at Volatility (annualised percent).
+type ExpectedMove struct {
+	Bars       int     `json:"bars"`
+	Window     int     `json:"window"`
+	Volatility float64 `json:"volatility"`
+	MovePct    float64 `json:"movePct"`
+	Upper1     float64 `json:"upper1Sigma"`
+	Lower1     float64 `json:"lower1Sigma"`
+	Upper2     float64 `json:"upper2Sigma"`
+	Lower2     float64 `json:"lower2Sigma"`
+}
+
+// VolatilityReport is the realized volatility analysis of a market.
+type VolatilityReport struct {
+	Time         int64         `json:"time"`
+	Price        float64       `json:"price"`
+	Estimator    string        `json:"estimator"`
+	Estimates    []VolEstimate `json:"estimates"`
+	Cone         []VolConeRow  `json:"cone"`
+	ExpectedMove ExpectedMove  `json:"expectedMove"`
+}
+
+// IsVolEstimator reports whether name is a known estimator.
+func IsVolEstimator(name string) bool {
+	switch name {
+	case VolCloseToClose, VolParkinson, VolGarmanKlass, VolYangZhang:
+		return true
+	}
+	return false
+}
+
+// RealizedVolatility returns the rolling annualised volatility, in percent,
+// over window bars. The first candle only provides a previous close, so
+// element j covers candles j+1..j+window and the result has
+// len(candles)-window elements. Crypto trades around the clock, so
+// annualisation uses 365 days of barSeconds bars.
+func RealizedVolatility(candles []Candle, window int, estimator string, barSeconds int64) ([]float64, error) {
+	if window < 2 {
+		return nil, fmt.Errorf("%w: window must be at least 2", ErrInvalidVolatility)
+	}
+	if !IsVolEstimator(estimator) {
+		return nil, fmt.Errorf("%w: unknown estimator %q", ErrInvalidVolatility, estimator)
+	}
+	if barSeconds <= 0 {
+		return nil, fmt.Errorf("%w: unknown bar length", ErrInvalidVolatility)
+	}
+	n := len(candles)
+	if n < window+1 {
+		return nil, fmt.Errorf("not enough candles: need %d, got %d", window+1, n)
+	}
+
+	// Per-bar log terms for candles 1..n-1.
+	m := n - 1
+	ret, gap, oc, hl2, rs := make([]float64, m), make([]float64, m), make([]float64, m), make([]float64, m), make([]float64, m)
+	for i := 1; i < n; i++ {
+		c, prev := candles[i], candles[i-1]
+		if c.Open <= 0 || c.High <= 0 || c.Low <= 0 || c.Close <= 0 || prev.Close <= 0 {
+			return nil, errors.New("non-positive prices")
+		}
+		j := i - 1
+		ret[j] = math.Log(c.Close / prev.Close)
+		gap[j] = math.Log(c.Open / prev.Close)
+		oc[j] = math.Log(c.Close / c.Open)
+		hl := math.Log(c.High / c.Low)
+		hl2[j] = hl * hl
+		rs[j] = math.Log(c.High/c.Close)*math.Log(c.High/c.Open) + math.Log(c.Low/c.Close)*math.Log(c.Low/c.Open)
+	}
+
+	annual := 365 * 86400 / float64(barSeconds)
+	w := float64(window)
+	k := 0.34 / (1.34 + (w+1)/(w-1))
+
+	out := make([]float64, 0, m-window+1)
+	for end := window; end <= m; end++ {
+		start := end - window
+		var variance float64
+		switch estimator {
+		case VolCloseToClose:
+			variance = sampleVariance(ret[start:end])
+		case VolParkinson:
+			variance = mean(hl2[start:end]) / (4 * math.Ln2)
+		case VolGarmanKlass:
+			variance = 0.5*mean(hl2[start:end]) - (2*math.Ln2-1)*meanSquare(oc[start:end])
+		case VolYangZhang:
+			variance = sampleVariance(gap[start:end]) + k*sampleVariance(oc[start:end]) + (1-k)*mean(rs[start:end])
+		}
+		out = append(out, 100*math.Sqrt(math.Max(variance, 0)*annual))
+	}
+	return out, nil
+}
+
+// AnalyzeVolatility computes current estimates for each window, a
+// volatility cone for estimator and the expected move over horizon bars
+// from the first window's volatility. Candles should be closed bars.
+func AnalyzeVolatility(candles []Candle, barSeconds int64, windows []int, estimator string, horizon int) (VolatilityReport, error) {
+	if len(windows) == 0 {
+		return VolatilityReport{}, fmt.Errorf("%w: at least one window required", ErrInvalidVolatility)
+	}
+	if horizon < 1 || horizon > volMaxHorizon {
+		return VolatilityReport{}, fmt.Errorf("%w: horizon must be between 1 and %d bars", ErrInvalidVolatility, volMaxHorizon)
+	}
+	if len(candles) == 0 {
+		return VolatilityReport{}, errors.New("no candles")
+	}
+
+	last := candles[len(candles)-1]
+	report := VolatilityReport{
+		Time:      last.Time,
+		Price:     last.Close,
+		Estimator: estimator,
+		Estimates: make([]VolEstimate, 0, len(windows)),
+		Cone:      make([]VolConeRow, 0, len(windows)),
+	}
+
+	for _, window := range windows {
+		est := VolEstimate{Window: window}
+		for _, e := range []struct {
+			name string
+			dst  *float64
+		}{
+			{VolCloseToClose, &est.CloseToClose},
+			{VolParkinson, &est.Parkinson},
+			{VolGarmanKlass, &est.GarmanKlass},
+			{VolYangZhang, &est.YangZhang},
+		} {
+			series, err := RealizedVolatility(candles, window, e.name, barSeconds)
+			if err != nil {
+				return VolatilityReport{}, err
+			}
+			*e.dst = roundTo(series[len(series)-1], 2)
+			if e.name == estimator {
+				report.Cone = append(report.Cone, volCone(window, series))
+			}
+		}
+		report.Estimates = append(report.Estimates, est)
+	}
+
+	vol := report.Cone[0].Current
+	perBar := vol / 100 / math.Sqrt(365*86400/float64(barSeconds))
+	sigma := perBar * math.Sqrt(float64(horizon))
+	price := last.Close
+	report.ExpectedMove = ExpectedMove{
+		Bars:       horizon,
+		Window:     windows[0],
+		Volatility: vol,
+		MovePct:    roundTo(100*(math.Exp(sigma)-1), 4),
+		Upper1:     price * math.Exp(sigma),
+		Lower1:     price * math.Exp(-sigma),
+		Upper2:     price * math.Exp(2*sigma),
+		Lower2:     price * math.Exp(-2*sigma),
+	}
+	return report, nil
+}
+
+func volCone(window int, series []float64) VolConeRow {
+	sorted := append([]float64(nil), series...)
+	sort.Float64s(sorted)
+	current := series[len(series)-1]
+
+	below := sort.SearchFloat64s(sorted, current)
+	for below < len(sorted) && sorted[below] <= current {
+		below++
+	}
+	return VolConeRow{
+		Window:     window,
+		Min:        roundTo(sorted[0], 2),
+		P10:        roundTo(quantile(sorted, 0.10), 2),
+		P25:        roundTo(quantile(sorted, 0.25), 2),
+		Median:     roundTo(quantile(sorted, 0.50), 2),
+		P75:        roundTo(quantile(sorted, 0.75), 2),
+		P90:        roundTo(quantile(sorted, 0.90), 2),
+		Max:        roundTo(sorted[len(sorted)-1], 2),
+		Current:    roundTo(current, 2),
+		Percentile: roundTo(100*float64(below)/float64(len(sorted)), 1),
+		Samples:    len(sorted),
+	}
+}
+
+// quantile interpolates linearly between the closest ranks of sorted.
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+// CandleBarSeconds infers the bar length from the spacing of the last
+// candles, for callers that only have the series.
+func CandleBarSeconds(candles []Candle) int64 {
+	var best int64
+	for i := len(candles) - 1; i > 0 && i >= len(candles)-5; i-- {
+		d := candles[i].Time - candles[i-1].Time
+		if d > 0 && (best == 0 || d < best) {
+			best = d
+		}
+	}
+	return best
+}
+
+func sampleVariance(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	avg := mean(values)
+	sum := 0.0
+	for _, v := range values {
+		sum += (v - avg) * (v - avg)
+	}
+	return sum / float64(len(values)-1)
+}
+
+func meanSquare(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v * v
+	}
+	return sum / float64(len(values))
+}
+
+// volatilityColumn is a screener column of the current realized volatility
+// (or its percentile rank over the fetched history) for one window.
+func volatilityColumn(window int, estimator string, percentile bool) func([]Candle) (float64, bool) {
+	return func(candles []Candle) (float64, bool) {
+		if len(candles) < 2 {
+			return 0, false
+		}
+		closed := candles[:len(candles)-1]
+		series, err := RealizedVolatility(closed, window, estimator, CandleBarSeconds(closed))
+		if err != nil {
+			return 0, false
+		}
+		if percentile {
+			return volCone(window, series).Percentile, true
+		}
+		return roundTo(series[len(series)-1], 2), true
+	}
+}
@@ -0,0 +1,3 @@
+-- Parameters for alert conditions that need more than a single threshold
+-- (candle interval, pattern name, indicator settings, ...).
+ALTER TABLE alerts ADD COLUMN condition_params JSONB;
@@ -0,0 +1,13 @@
+-- User-defined formula indicators
+CREATE TABLE custom_indicators (
+    id SERIAL PRIMARY KEY,
+    user_id UUID REFERENCES users (id) ON DELETE CASCADE NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    formula TEXT NOT NULL,
+    description TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, name)
+);
+
+CREATE INDEX idx_custom_indicators_user ON custom_indicators (user_id);
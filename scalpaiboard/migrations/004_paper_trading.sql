@@ -0,0 +1,69 @@
+-- Paper trading accounts, orders, positions and fills
+CREATE TABLE paper_accounts (
+    id SERIAL PRIMARY KEY,
+    user_id UUID REFERENCES users (id) ON DELETE CASCADE NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    initial_balance DECIMAL(20, 8) NOT NULL,
+    balance DECIMAL(20, 8) NOT NULL,
+    maker_fee_pct DECIMAL(10, 6) NOT NULL DEFAULT 0.02,
+    taker_fee_pct DECIMAL(10, 6) NOT NULL DEFAULT 0.05,
+    funding_rate_pct DECIMAL(10, 6) NOT NULL DEFAULT 0.01,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, name)
+);
+
+CREATE TABLE paper_orders (
+    id SERIAL PRIMARY KEY,
+    account_id INTEGER REFERENCES paper_accounts (id) ON DELETE CASCADE NOT NULL,
+    market_id VARCHAR(50) NOT NULL,
+    side VARCHAR(4) NOT NULL,
+    order_type VARCHAR(20) NOT NULL,
+    quantity DECIMAL(30, 12) NOT NULL,
+    price DECIMAL(20, 8),
+    stop_price DECIMAL(20, 8),
+    leverage DECIMAL(10, 2) NOT NULL DEFAULT 1,
+    reduce_only BOOLEAN NOT NULL DEFAULT FALSE,
+    take_profit DECIMAL(20, 8),
+    stop_loss DECIMAL(20, 8),
+    parent_order_id INTEGER REFERENCES paper_orders (id) ON DELETE SET NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'open',
+    triggered BOOLEAN NOT NULL DEFAULT FALSE,
+    filled_price DECIMAL(20, 8),
+    reject_reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_paper_orders_open ON paper_orders (market_id) WHERE status = 'open';
+CREATE INDEX idx_paper_orders_account ON paper_orders (account_id, created_at DESC);
+
+CREATE TABLE paper_positions (
+    id SERIAL PRIMARY KEY,
+    account_id INTEGER REFERENCES paper_accounts (id) ON DELETE CASCADE NOT NULL,
+    market_id VARCHAR(50) NOT NULL,
+    quantity DECIMAL(30, 12) NOT NULL,
+    entry_price DECIMAL(20, 8) NOT NULL,
+    leverage DECIMAL(10, 2) NOT NULL DEFAULT 1,
+    realized_pnl DECIMAL(20, 8) NOT NULL DEFAULT 0,
+    funding_paid DECIMAL(20, 8) NOT NULL DEFAULT 0,
+    opened_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (account_id, market_id)
+);
+
+CREATE TABLE paper_fills (
+    id SERIAL PRIMARY KEY,
+    account_id INTEGER REFERENCES paper_accounts (id) ON DELETE CASCADE NOT NULL,
+    order_id INTEGER REFERENCES paper_orders (id) ON DELETE SET NULL,
+    market_id VARCHAR(50) NOT NULL,
+    side VARCHAR(4) NOT NULL,
+    quantity DECIMAL(30, 12) NOT NULL,
+    price DECIMAL(20, 8) NOT NULL,
+    fee DECIMAL(20, 8) NOT NULL,
+    realized_pnl DECIMAL(20, 8) NOT NULL DEFAULT 0,
+    liquidity VARCHAR(5) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_paper_fills_account ON paper_fills (account_id, created_at DESC);
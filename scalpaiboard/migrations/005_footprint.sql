@@ -0,0 +1,22 @@
+-- One-minute footprint bars built from aggregated trades. Levels holds the
+-- per-price-bucket bid/ask volume as JSON; coarser intervals and buckets
+-- are aggregated on read.
+CREATE TABLE footprint_bars (
+    market_id VARCHAR(50) NOT NULL,
+    open_time TIMESTAMP NOT NULL,
+    bucket DECIMAL(30, 12) NOT NULL,
+    open DECIMAL(20, 8) NOT NULL,
+    high DECIMAL(20, 8) NOT NULL,
+    low DECIMAL(20, 8) NOT NULL,
+    close DECIMAL(20, 8) NOT NULL,
+    buy_volume DECIMAL(30, 12) NOT NULL,
+    sell_volume DECIMAL(30, 12) NOT NULL,
+    min_delta DECIMAL(30, 12) NOT NULL,
+    max_delta DECIMAL(30, 12) NOT NULL,
+    trades INTEGER NOT NULL,
+    partial BOOLEAN NOT NULL DEFAULT FALSE,
+    levels JSONB NOT NULL,
+    PRIMARY KEY (market_id, open_time)
+);
+
+CREATE INDEX idx_footprint_bars_time ON footprint_bars (open_time);
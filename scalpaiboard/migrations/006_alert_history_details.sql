@@ -0,0 +1,3 @@
+-- Evaluated conditions of each trigger (market, observed value, threshold
+-- and outcome), e.g. the sub-conditions of a compound alert.
+ALTER TABLE alert_history ADD COLUMN details JSONB;
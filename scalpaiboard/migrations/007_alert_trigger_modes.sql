@@ -0,0 +1,14 @@
+-- Alert trigger behaviour. trigger_mode is every_time (at most once per
+-- cooldown_seconds), once (then deactivated), once_per_bar (per bar of
+-- bar_interval) or rearm (fires again only after the condition released by
+-- rearm_band). last_met is the outcome of the previous evaluation, used to
+-- detect true crosses; armed is false while a rearm alert waits for release.
+ALTER TABLE alerts
+    ADD COLUMN trigger_mode VARCHAR(20) NOT NULL DEFAULT 'every_time',
+    ADD COLUMN cooldown_seconds INTEGER NOT NULL DEFAULT 300,
+    ADD COLUMN bar_interval VARCHAR(10),
+    ADD COLUMN rearm_band DECIMAL(20, 8),
+    ADD COLUMN expires_at TIMESTAMP,
+    ADD COLUMN max_triggers INTEGER,
+    ADD COLUMN armed BOOLEAN NOT NULL DEFAULT TRUE,
+    ADD COLUMN last_met BOOLEAN;
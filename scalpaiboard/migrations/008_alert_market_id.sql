@@ -0,0 +1,17 @@
+-- Alerts are bound to a marketId (exchange and market type, e.g.
+-- BY:PERP:BTCUSDT) rather than a coin; existing alerts were evaluated on
+-- Binance spot. price_source selects the last or (perpetuals only) mark
+-- price for price conditions.
+ALTER TABLE alerts
+    ADD COLUMN market_id VARCHAR(64),
+    ADD COLUMN price_source VARCHAR(10) NOT NULL DEFAULT 'last';
+
+UPDATE alerts a SET market_id = 'BI:SPOT:' || c.symbol
+FROM coins c
+WHERE c.id = a.coin_id;
+
+ALTER TABLE alerts
+    ALTER COLUMN market_id SET NOT NULL,
+    ALTER COLUMN coin_id DROP NOT NULL;
+
+CREATE INDEX idx_alerts_active_market ON alerts (is_active, market_id);
@@ -0,0 +1,16 @@
+-- Chart drawings per user and market. points holds the time/price anchors
+-- ([{"time": <unix seconds>, "price": <price>}, ...]); style is opaque to
+-- the backend. Alerts attach to a drawing through
+-- condition_params.drawingId.
+CREATE TABLE drawings (
+    id SERIAL PRIMARY KEY,
+    user_id UUID REFERENCES users (id) ON DELETE CASCADE NOT NULL,
+    market_id VARCHAR(64) NOT NULL,
+    type VARCHAR(20) NOT NULL,
+    points JSONB NOT NULL,
+    style JSONB,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_drawings_user_market ON drawings (user_id, market_id);
@@ -0,0 +1,49 @@
+-- Saved screener queries that notify when a market enters or leaves their
+-- result set. query holds the universe, interval and column bounds
+-- ({"exchange":"binance","type":"perp","interval":"5m","min":{"natr14":3}});
+-- last_run_at is NULL until the first evaluation, which records the
+-- initial matches without notifying.
+CREATE TABLE screener_alerts (
+    id SERIAL PRIMARY KEY,
+    user_id UUID REFERENCES users (id) ON DELETE CASCADE NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    query JSONB NOT NULL,
+    notify_on VARCHAR(10) NOT NULL DEFAULT 'both',
+    dedup_seconds INTEGER NOT NULL DEFAULT 900,
+    notification_type VARCHAR(20) DEFAULT 'in_app',
+    is_active BOOLEAN DEFAULT TRUE,
+    last_run_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_screener_alerts_user ON screener_alerts (user_id);
+
+-- Match state per screener alert and market: whether the market is in the
+-- result set, its column values at the last change and when entries and
+-- exits were last notified, for the dedup window.
+CREATE TABLE screener_alert_markets (
+    screener_alert_id INTEGER REFERENCES screener_alerts (id) ON DELETE CASCADE NOT NULL,
+    market_id VARCHAR(64) NOT NULL,
+    matched BOOLEAN NOT NULL,
+    "values" JSONB,
+    changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_entry_at TIMESTAMP,
+    last_exit_at TIMESTAMP,
+    PRIMARY KEY (screener_alert_id, market_id)
+);
+
+-- Notified entries and exits with the values that caused them.
+CREATE TABLE screener_alert_events (
+    id SERIAL PRIMARY KEY,
+    screener_alert_id INTEGER REFERENCES screener_alerts (id) ON DELETE CASCADE NOT NULL,
+    market_id VARCHAR(64) NOT NULL,
+    event VARCHAR(10) NOT NULL,
+    "values" JSONB,
+    notification_status VARCHAR(20) DEFAULT 'pending',
+    notification_channel VARCHAR(20),
+    error_message TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_screener_alert_events_alert ON screener_alert_events (screener_alert_id, created_at DESC);
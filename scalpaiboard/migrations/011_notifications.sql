@@ -0,0 +1,16 @@
+-- In-app notification inbox. kind is the source (alert, screener) and data
+-- holds its references (alert ID, markets, values); read_at is NULL while
+-- unread.
+CREATE TABLE notifications (
+    id SERIAL PRIMARY KEY,
+    user_id UUID REFERENCES users (id) ON DELETE CASCADE NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    title VARCHAR(200) NOT NULL,
+    message TEXT NOT NULL,
+    data JSONB,
+    read_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_notifications_user ON notifications (user_id, id DESC);
+CREATE INDEX idx_notifications_unread ON notifications (user_id) WHERE read_at IS NULL;